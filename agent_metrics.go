@@ -0,0 +1,205 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apm // import "go.elastic.co/apm/v2"
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"go.elastic.co/apm/v2/internal/ringbuffer"
+)
+
+const (
+	// Agent self-observability metric names.
+	agentEventsBufferFillMetricName          = "agent.events.buffer.fill.pct"
+	agentEventsBufferMaxFillMetricName       = "agent.events.buffer.max_fill.pct"
+	agentRequestsCountMetricName             = "agent.events.requests.count"
+	agentRequestsErrorsMetricName            = "agent.events.requests.errors"
+	agentRequestsBytesMetricName             = "agent.events.requests.bytes"
+	agentRequestsUncompressedBytesMetricName = "agent.events.requests.uncompressed.bytes"
+	agentRequestsLatencyMetricName           = "agent.events.requests.latency.us"
+	agentConfigReloadsMetricName             = "agent.config.reloads"
+)
+
+// requestLatencyBuckets holds the upper bounds of the request latency
+// histogram buckets, in microseconds. Latencies greater than the last
+// bound are recorded in an additional, unbounded bucket.
+var requestLatencyBuckets = [...]float64{
+	1e3, 5e3, 10e3, 25e3, 50e3, 100e3, 250e3, 500e3, 1e6, 2.5e6, 5e6, 10e6,
+}
+
+// agentStats holds agent self-observability statistics recorded by
+// the tracer loop since metrics were last gathered.
+type agentStats struct {
+	transactionsQueued uint64
+	spansQueued        uint64
+	errorsQueued       uint64
+
+	// bufferFill and bufferMaxFill hold the most recent and maximum
+	// fill level of the event ring buffer, as a fraction of its size.
+	bufferFill    float64
+	bufferMaxFill float64
+
+	requests                 uint64
+	requestBytes             uint64
+	requestUncompressedBytes uint64
+	requestLatency           [len(requestLatencyBuckets) + 1]uint64
+
+	// requestErrors holds the number of failed requests, keyed by
+	// HTTP status code. Errors other than HTTP errors have the key 0.
+	requestErrors map[int]uint64
+
+	configReloads uint64
+}
+
+// observeBuffer records the fill level of the event ring buffer.
+func (s *agentStats) observeBuffer(b *ringbuffer.Buffer) {
+	s.bufferFill = float64(b.Len()) / float64(b.Cap())
+	if s.bufferFill > s.bufferMaxFill {
+		s.bufferMaxFill = s.bufferFill
+	}
+}
+
+// observeRequestLatency records the latency of a request to the server.
+func (s *agentStats) observeRequestLatency(d time.Duration) {
+	us := float64(d / time.Microsecond)
+	i := 0
+	for i < len(requestLatencyBuckets) && us > requestLatencyBuckets[i] {
+		i++
+	}
+	s.requestLatency[i]++
+}
+
+// observeRequestError records a failed request, with the HTTP status
+// code of the response or 0 if the request did not receive a response.
+func (s *agentStats) observeRequestError(statusCode int) {
+	if s.requestErrors == nil {
+		s.requestErrors = make(map[int]uint64)
+	}
+	s.requestErrors[statusCode]++
+}
+
+// agentMetrics holds the agent self-observability metrics, which are
+// recorded by the tracer loop and reported by builtinMetricsGatherer.
+//
+// Counters are reported as deltas since the previous report.
+type agentMetrics struct {
+	mu        sync.Mutex
+	stats     agentStats
+	lastStats TracerStats
+}
+
+func newAgentMetrics() *agentMetrics {
+	return &agentMetrics{}
+}
+
+// update merges the statistics recorded by the tracer loop into m,
+// and then resets s.
+func (m *agentMetrics) update(s *agentStats) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.stats.transactionsQueued += s.transactionsQueued
+	m.stats.spansQueued += s.spansQueued
+	m.stats.errorsQueued += s.errorsQueued
+	m.stats.bufferFill = s.bufferFill
+	if s.bufferMaxFill > m.stats.bufferMaxFill {
+		m.stats.bufferMaxFill = s.bufferMaxFill
+	}
+	m.stats.requests += s.requests
+	m.stats.requestBytes += s.requestBytes
+	m.stats.requestUncompressedBytes += s.requestUncompressedBytes
+	for i, n := range s.requestLatency {
+		m.stats.requestLatency[i] += n
+	}
+	for statusCode, n := range s.requestErrors {
+		if m.stats.requestErrors == nil {
+			m.stats.requestErrors = make(map[int]uint64)
+		}
+		m.stats.requestErrors[statusCode] += n
+	}
+	m.stats.configReloads += s.configReloads
+	*s = agentStats{bufferFill: s.bufferFill, bufferMaxFill: s.bufferFill}
+}
+
+// gather is called by builtinMetricsGatherer to gather agent metrics.
+// The sent and dropped event counts are derived from the tracer stats.
+func (m *agentMetrics) gather(out *Metrics, tracerStats TracerStats) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	addEventMetrics := func(eventType string, queued, sent, dropped uint64) {
+		prefix := "agent.events." + eventType
+		out.Add(prefix+".queued", nil, float64(queued))
+		out.Add(prefix+".sent", nil, float64(sent))
+		out.Add(prefix+".dropped", nil, float64(dropped))
+	}
+	last := m.lastStats
+	addEventMetrics("transactions", m.stats.transactionsQueued,
+		tracerStats.TransactionsSent-last.TransactionsSent,
+		tracerStats.TransactionsDropped-last.TransactionsDropped,
+	)
+	addEventMetrics("spans", m.stats.spansQueued,
+		tracerStats.SpansSent-last.SpansSent,
+		tracerStats.SpansDropped-last.SpansDropped,
+	)
+	addEventMetrics("errors", m.stats.errorsQueued,
+		tracerStats.ErrorsSent-last.ErrorsSent,
+		tracerStats.ErrorsDropped-last.ErrorsDropped,
+	)
+
+	out.Add(agentEventsBufferFillMetricName, nil, m.stats.bufferFill)
+	out.Add(agentEventsBufferMaxFillMetricName, nil, m.stats.bufferMaxFill)
+	out.Add(agentRequestsCountMetricName, nil, float64(m.stats.requests))
+	out.Add(agentRequestsBytesMetricName, nil, float64(m.stats.requestBytes))
+	out.Add(agentRequestsUncompressedBytesMetricName, nil, float64(m.stats.requestUncompressedBytes))
+	out.Add(agentConfigReloadsMetricName, nil, float64(m.stats.configReloads))
+	// Failed requests are reported in total, and broken
+	// down by HTTP status code where there was a response.
+	var requestErrors uint64
+	for statusCode, n := range m.stats.requestErrors {
+		requestErrors += n
+		if statusCode != 0 {
+			labels := []MetricLabel{{Name: "status_code", Value: strconv.Itoa(statusCode)}}
+			out.Add(agentRequestsErrorsMetricName, labels, float64(n))
+		}
+	}
+	out.Add(agentRequestsErrorsMetricName, nil, float64(requestErrors))
+
+	var values []float64
+	var counts []uint64
+	for i, n := range m.stats.requestLatency {
+		if n == 0 {
+			continue
+		}
+		// Observations in the unbounded bucket are given the
+		// value of the final bucket's upper bound.
+		value := requestLatencyBuckets[len(requestLatencyBuckets)-1]
+		if i < len(requestLatencyBuckets) {
+			value = requestLatencyBuckets[i]
+		}
+		values = append(values, value)
+		counts = append(counts, n)
+	}
+	if len(counts) > 0 {
+		out.AddHistogram(agentRequestsLatencyMetricName, nil, values, counts)
+	}
+
+	m.stats = agentStats{bufferFill: m.stats.bufferFill, bufferMaxFill: m.stats.bufferFill}
+	m.lastStats = tracerStats
+}
@@ -0,0 +1,83 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apm
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"go.elastic.co/apm/v2/model"
+)
+
+func TestAgentMetricsRequests(t *testing.T) {
+	var stats agentStats
+	stats.requests = 4
+	stats.observeRequestLatency(500 * time.Microsecond)
+	stats.observeRequestLatency(3 * time.Millisecond)
+	stats.observeRequestLatency(4 * time.Millisecond)
+	stats.observeRequestLatency(time.Minute)
+	stats.observeRequestError(503)
+	stats.observeRequestError(503)
+	stats.observeRequestError(0)
+
+	m := newAgentMetrics()
+	m.update(&stats)
+	assert.Equal(t, agentStats{}, stats)
+
+	var out Metrics
+	m.gather(&out, TracerStats{})
+	assert.Len(t, out.metrics, 2)
+
+	unlabeled := out.metrics[0]
+	assert.Nil(t, unlabeled.Labels)
+	assert.Equal(t, model.Metric{Value: 4}, unlabeled.Samples["agent.events.requests.count"])
+	assert.Equal(t, model.Metric{Value: 3}, unlabeled.Samples["agent.events.requests.errors"])
+	assert.Equal(t, model.Metric{
+		Type:   "histogram",
+		Values: []float64{1e3, 5e3, 10e6},
+		Counts: []uint64{1, 2, 1},
+	}, unlabeled.Samples["agent.events.requests.latency.us"])
+
+	labeled := out.metrics[1]
+	assert.Equal(t, model.StringMap{{Key: "status_code", Value: "503"}}, labeled.Labels)
+	assert.Equal(t, map[string]model.Metric{
+		"agent.events.requests.errors": {Value: 2},
+	}, labeled.Samples)
+
+	// Counters are reset after gathering.
+	out.reset()
+	m.gather(&out, TracerStats{})
+	assert.Len(t, out.metrics, 1)
+	assert.Equal(t, model.Metric{Value: 0}, out.metrics[0].Samples["agent.events.requests.count"])
+	assert.NotContains(t, out.metrics[0].Samples, "agent.events.requests.latency.us")
+}
+
+func TestAgentMetricsTracerStatsDelta(t *testing.T) {
+	m := newAgentMetrics()
+	var out Metrics
+	m.gather(&out, TracerStats{TransactionsSent: 3, SpansDropped: 2})
+	assert.Equal(t, model.Metric{Value: 3}, out.metrics[0].Samples["agent.events.transactions.sent"])
+	assert.Equal(t, model.Metric{Value: 2}, out.metrics[0].Samples["agent.events.spans.dropped"])
+
+	out.reset()
+	m.gather(&out, TracerStats{TransactionsSent: 5, SpansDropped: 2})
+	assert.Equal(t, model.Metric{Value: 2}, out.metrics[0].Samples["agent.events.transactions.sent"])
+	assert.Equal(t, model.Metric{Value: 0}, out.metrics[0].Samples["agent.events.spans.dropped"])
+}
@@ -0,0 +1,248 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apm // import "go.elastic.co/apm/v2"
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"go.elastic.co/apm/v2/internal/wildcard"
+)
+
+const (
+	// maxBaggageMembers is the maximum number of baggage members
+	// that may be propagated, per the W3C Baggage specification.
+	maxBaggageMembers = 180
+
+	// maxBaggageBytes is the maximum length of the encoded baggage,
+	// per the W3C Baggage specification.
+	maxBaggageBytes = 8192
+
+	// baggageLabelPrefix is the prefix for labels recorded for baggage
+	// members matching the configured baggage_to_attach patterns.
+	baggageLabelPrefix = "baggage."
+)
+
+// Baggage holds W3C Baggage: a set of user-defined key/value pairs
+// which are propagated along with the trace context.
+//
+// Baggage is immutable; methods which modify baggage return a new
+// value, leaving the original unmodified.
+type Baggage struct {
+	head *BaggageMember
+}
+
+// NewBaggage returns a Baggage based on members. If multiple members
+// have the same key, the last one is kept in the position of the first.
+func NewBaggage(members ...BaggageMember) Baggage {
+	var out Baggage
+	var last *BaggageMember
+	for _, m := range members {
+		if existing := out.member(m.Key); existing != nil {
+			existing.Value = m.Value
+			existing.Properties = m.Properties
+			continue
+		}
+		m := m // copy
+		m.next = nil
+		if last == nil {
+			out.head = &m
+		} else {
+			last.next = &m
+		}
+		last = &m
+	}
+	return out
+}
+
+func (b Baggage) member(key string) *BaggageMember {
+	for m := b.head; m != nil; m = m.next {
+		if m.Key == key {
+			return m
+		}
+	}
+	return nil
+}
+
+// Len returns the number of members in b.
+func (b Baggage) Len() int {
+	var n int
+	for m := b.head; m != nil; m = m.next {
+		n++
+	}
+	return n
+}
+
+// Members returns a copy of the members of b, in order.
+func (b Baggage) Members() []BaggageMember {
+	var out []BaggageMember
+	for m := b.head; m != nil; m = m.next {
+		out = append(out, BaggageMember{Key: m.Key, Value: m.Value, Properties: m.Properties})
+	}
+	return out
+}
+
+// Member returns the member with the given key, and a boolean
+// indicating whether or not it was found.
+func (b Baggage) Member(key string) (BaggageMember, bool) {
+	if m := b.member(key); m != nil {
+		return BaggageMember{Key: m.Key, Value: m.Value, Properties: m.Properties}, true
+	}
+	return BaggageMember{}, false
+}
+
+// WithMember returns a copy of b with m added, replacing any existing
+// member with the same key.
+func (b Baggage) WithMember(m BaggageMember) Baggage {
+	return NewBaggage(append(b.Members(), m)...)
+}
+
+// String returns b encoded as a W3C baggage header value.
+func (b Baggage) String() string {
+	if b.head == nil {
+		return ""
+	}
+	var buf bytes.Buffer
+	b.head.writeBuf(&buf)
+	for m := b.head.next; m != nil; m = m.next {
+		buf.WriteByte(',')
+		m.writeBuf(&buf)
+	}
+	return buf.String()
+}
+
+// Validate validates the baggage.
+//
+// This will return non-nil if any members are invalid, if there are
+// too many members, or if the encoded baggage is too long.
+func (b Baggage) Validate() error {
+	if b.head == nil {
+		return nil
+	}
+	var i int
+	for m := b.head; m != nil; m = m.next {
+		if i == maxBaggageMembers {
+			return fmt.Errorf("baggage contains more than the maximum allowed number of members, %d", maxBaggageMembers)
+		}
+		if err := m.Validate(); err != nil {
+			return errors.Wrapf(err, "invalid baggage member at position %d", i)
+		}
+		i++
+	}
+	if n := len(b.String()); n > maxBaggageBytes {
+		return fmt.Errorf("baggage is %d bytes, maximum allowed is %d", n, maxBaggageBytes)
+	}
+	return nil
+}
+
+// setLabels records a label for each member of b with a key matching
+// any of the matchers, calling setLabel with the label key and value.
+func (b Baggage) setLabels(matchers wildcard.Matchers, setLabel func(key string, value interface{})) {
+	if len(matchers) == 0 {
+		return
+	}
+	for m := b.head; m != nil; m = m.next {
+		if matchers.MatchAny(m.Key) {
+			setLabel(baggageLabelPrefix+m.Key, m.Value)
+		}
+	}
+}
+
+// BaggageMember holds a baggage member: a key/value pair, with
+// optional metadata properties.
+type BaggageMember struct {
+	next *BaggageMember
+
+	// Key holds the baggage member's key.
+	Key string
+
+	// Value holds the baggage member's value, which may hold any
+	// UTF-8 string. The value is percent-encoded for propagation
+	// as necessary.
+	Value string
+
+	// Properties holds the member's properties, if any, in their
+	// encoded form: a semicolon-separated list of keys or key/value
+	// pairs. Properties are propagated unmodified.
+	Properties string
+}
+
+func (m *BaggageMember) writeBuf(buf *bytes.Buffer) {
+	buf.WriteString(m.Key)
+	buf.WriteByte('=')
+	for i := 0; i < len(m.Value); i++ {
+		c := m.Value[i]
+		if isBaggageOctet(c) && c != '%' {
+			buf.WriteByte(c)
+		} else {
+			fmt.Fprintf(buf, "%%%02X", c)
+		}
+	}
+	if m.Properties != "" {
+		buf.WriteByte(';')
+		buf.WriteString(m.Properties)
+	}
+}
+
+// Validate validates the baggage member.
+//
+// This will return non-nil if the key is empty or contains characters
+// that are not valid in an HTTP token, or if the properties contain
+// characters that are not valid in baggage.
+func (m *BaggageMember) Validate() error {
+	if m.Key == "" {
+		return errors.New("key is empty")
+	}
+	for i := 0; i < len(m.Key); i++ {
+		if !isTokenChar(m.Key[i]) {
+			return fmt.Errorf("key %q contains invalid character %q", m.Key, m.Key[i])
+		}
+	}
+	if strings.ContainsAny(m.Properties, ",\r\n") {
+		return fmt.Errorf("properties for key %q contain invalid characters", m.Key)
+	}
+	return nil
+}
+
+// isBaggageOctet reports whether c may appear unencoded in a baggage
+// value, per the W3C Baggage specification:
+//
+//	baggage-octet = %x21 / %x23-2B / %x2D-3A / %x3C-5B / %x5D-7E
+func isBaggageOctet(c byte) bool {
+	switch {
+	case c == 0x21,
+		c >= 0x23 && c <= 0x2B,
+		c >= 0x2D && c <= 0x3A,
+		c >= 0x3C && c <= 0x5B,
+		c >= 0x5D && c <= 0x7E:
+		return true
+	}
+	return false
+}
+
+// isTokenChar reports whether c is valid in an RFC 7230 token.
+func isTokenChar(c byte) bool {
+	switch {
+	case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		return true
+	}
+	return strings.IndexByte("!#$%&'*+-.^_`|~", c) != -1
+}
@@ -0,0 +1,142 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apm_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.elastic.co/apm/v2"
+	"go.elastic.co/apm/v2/apmtest"
+	"go.elastic.co/apm/v2/model"
+)
+
+func TestBaggageDuplicateKey(t *testing.T) {
+	b := apm.NewBaggage(
+		apm.BaggageMember{Key: "a", Value: "1"},
+		apm.BaggageMember{Key: "b", Value: "2"},
+		apm.BaggageMember{Key: "a", Value: "3", Properties: "p"},
+	)
+	assert.Equal(t, 2, b.Len())
+	assert.Equal(t, "a=3;p,b=2", b.String())
+}
+
+func TestBaggageWithMember(t *testing.T) {
+	b1 := apm.NewBaggage(apm.BaggageMember{Key: "a", Value: "1"})
+	b2 := b1.WithMember(apm.BaggageMember{Key: "b", Value: "2"})
+	b3 := b2.WithMember(apm.BaggageMember{Key: "a", Value: "3"})
+	assert.Equal(t, "a=1", b1.String())
+	assert.Equal(t, "a=1,b=2", b2.String())
+	assert.Equal(t, "a=3,b=2", b3.String())
+
+	m, ok := b3.Member("a")
+	assert.True(t, ok)
+	assert.Equal(t, "3", m.Value)
+	_, ok = b3.Member("c")
+	assert.False(t, ok)
+}
+
+func TestBaggageStringEncoding(t *testing.T) {
+	b := apm.NewBaggage(apm.BaggageMember{Key: "k", Value: `a b,c;d%e"f\ü`})
+	assert.Equal(t, `k=a%20b%2Cc%3Bd%25e%22f%5C%C3%BC`, b.String())
+}
+
+func TestBaggageValidate(t *testing.T) {
+	assert.NoError(t, apm.Baggage{}.Validate())
+	assert.EqualError(t,
+		apm.NewBaggage(apm.BaggageMember{Key: "a b", Value: "c"}).Validate(),
+		`invalid baggage member at position 0: key "a b" contains invalid character ' '`,
+	)
+	assert.EqualError(t,
+		apm.NewBaggage(apm.BaggageMember{Value: "c"}).Validate(),
+		`invalid baggage member at position 0: key is empty`,
+	)
+
+	const maxMembers = 180
+	members := make([]apm.BaggageMember, 0, maxMembers+1)
+	for i := 0; i < maxMembers; i++ {
+		members = append(members, apm.BaggageMember{Key: fmt.Sprintf("k%d", i), Value: "v"})
+	}
+	assert.NoError(t, apm.NewBaggage(members...).Validate())
+	members = append(members, apm.BaggageMember{Key: "straw", Value: "camel's back"})
+	assert.EqualError(t, apm.NewBaggage(members...).Validate(),
+		"baggage contains more than the maximum allowed number of members, 180",
+	)
+
+	b := apm.NewBaggage(apm.BaggageMember{Key: "k", Value: strings.Repeat("v", 8192)})
+	assert.EqualError(t, b.Validate(), "baggage is 8194 bytes, maximum allowed is 8192")
+}
+
+func TestBaggageToAttach(t *testing.T) {
+	tracer := apmtest.NewRecordingTracer()
+	defer tracer.Close()
+	tracer.SetBaggageToAttach("user.*", "(?-i)Tenant")
+
+	tx := tracer.StartTransactionOptions("name", "type", apm.TransactionOptions{
+		TraceContext: apm.TraceContext{
+			Baggage: apm.NewBaggage(
+				apm.BaggageMember{Key: "user.id", Value: "123"},
+				apm.BaggageMember{Key: "Tenant", Value: "acme"},
+				apm.BaggageMember{Key: "tenant", Value: "ignored"},
+				apm.BaggageMember{Key: "secret", Value: "ignored"},
+			),
+		},
+	})
+	ctx := apm.ContextWithTransaction(context.Background(), tx)
+	span, ctx := apm.StartSpan(ctx, "span", "type")
+	apm.CaptureError(ctx, errors.New("boom")).Send()
+	span.End()
+	tx.End()
+	tracer.Flush(nil)
+
+	expected := model.IfaceMap{
+		{Key: "baggage_Tenant", Value: "acme"},
+		{Key: "baggage_user_id", Value: "123"},
+	}
+	payloads := tracer.Payloads()
+	require.Len(t, payloads.Transactions, 1)
+	require.Len(t, payloads.Spans, 1)
+	require.Len(t, payloads.Errors, 1)
+	assert.Equal(t, expected, payloads.Transactions[0].Context.Tags)
+	assert.Equal(t, expected, payloads.Spans[0].Context.Tags)
+	assert.Equal(t, expected, payloads.Errors[0].Context.Tags)
+	assert.Equal(t, "user.id=123,Tenant=acme,tenant=ignored,secret=ignored", span.TraceContext().Baggage.String())
+}
+
+func TestBaggageToAttachUnconfigured(t *testing.T) {
+	tx, spans, errs := apmtest.WithTransactionOptions(apm.TransactionOptions{
+		TraceContext: apm.TraceContext{
+			Baggage: apm.NewBaggage(apm.BaggageMember{Key: "user.id", Value: "123"}),
+		},
+	}, func(ctx context.Context) {
+		span, ctx := apm.StartSpan(ctx, "span", "type")
+		defer span.End()
+		apm.CaptureError(ctx, errors.New("boom")).Send()
+	})
+	require.Len(t, spans, 1)
+	require.Len(t, errs, 1)
+	assert.Nil(t, tx.Context)
+	assert.Nil(t, spans[0].Context)
+	assert.Nil(t, errs[0].Context)
+}
@@ -92,8 +92,6 @@ func (m spanTimingsMap) reset() {
 // breakdownMetrics may be written to concurrently by the tracer, and any
 // number of other goroutines when a transaction cannot be enqueued.
 type breakdownMetrics struct {
-	enabled bool
-
 	mu               sync.RWMutex
 	active, inactive *breakdownMetricsMap
 }
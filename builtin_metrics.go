@@ -29,6 +29,7 @@ import (
 //   - goroutines
 //   - memstats (allocations, usage, GC, etc.)
 //   - system and process CPU and memory usage
+//   - agent self-observability (events, buffer, and requests)
 type builtinMetricsGatherer struct {
 	tracer         *Tracer
 	lastSysMetrics sysMetrics
@@ -48,6 +49,7 @@ func (g *builtinMetricsGatherer) GatherMetrics(ctx context.Context, m *Metrics)
 	g.gatherSystemMetrics(m)
 	g.gatherMemStatsMetrics(m)
 	g.tracer.breakdownMetrics.gather(m)
+	g.tracer.agentMetrics.gather(m, g.tracer.Stats())
 	return nil
 }
 
@@ -24,6 +24,7 @@ import (
 	"path/filepath"
 	"regexp"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"sync/atomic"
@@ -35,6 +36,7 @@ import (
 	"go.elastic.co/apm/v2/internal/apmlog"
 	"go.elastic.co/apm/v2/internal/configutil"
 	"go.elastic.co/apm/v2/internal/wildcard"
+	"go.elastic.co/apm/v2/model"
 	"go.elastic.co/apm/v2/transport"
 )
 
@@ -42,7 +44,9 @@ const (
 	envMetricsInterval             = "ELASTIC_APM_METRICS_INTERVAL"
 	envMaxSpans                    = "ELASTIC_APM_TRANSACTION_MAX_SPANS"
 	envTransactionSampleRate       = "ELASTIC_APM_TRANSACTION_SAMPLE_RATE"
+	envTransactionSampleRules      = "ELASTIC_APM_TRANSACTION_SAMPLE_RULES"
 	envSanitizeFieldNames          = "ELASTIC_APM_SANITIZE_FIELD_NAMES"
+	envBaggageToAttach             = "ELASTIC_APM_BAGGAGE_TO_ATTACH"
 	envCaptureHeaders              = "ELASTIC_APM_CAPTURE_HEADERS"
 	envCaptureBody                 = "ELASTIC_APM_CAPTURE_BODY"
 	envServiceName                 = "ELASTIC_APM_SERVICE_NAME"
@@ -65,6 +69,13 @@ const (
 	envUseElasticTraceparentHeader = "ELASTIC_APM_USE_ELASTIC_TRACEPARENT_HEADER"
 	envCloudProvider               = "ELASTIC_APM_CLOUD_PROVIDER"
 	envContinuationStrategy        = "ELASTIC_APM_TRACE_CONTINUATION_STRATEGY"
+	envTransport                   = "ELASTIC_APM_TRANSPORT"
+	envSpoolDir                    = "ELASTIC_APM_SPOOL_DIR"
+	envSpoolMaxSize                = "ELASTIC_APM_SPOOL_MAX_SIZE"
+	envTailSamplingBufferSize      = "ELASTIC_APM_TAIL_SAMPLING_BUFFER_SIZE"
+	envRedactFields                = "ELASTIC_APM_REDACT_FIELDS"
+	envRedactPatterns              = "ELASTIC_APM_REDACT_PATTERNS"
+	envObfuscateSQL                = "ELASTIC_APM_OBFUSCATE_SQL"
 
 	// span_compression (default `true`)
 	envSpanCompressionEnabled = "ELASTIC_APM_SPAN_COMPRESSION_ENABLED"
@@ -90,10 +101,13 @@ const (
 	defaultMetricsInterval           = 30 * time.Second
 	defaultMaxSpans                  = 500
 	defaultCaptureHeaders            = true
+	defaultObfuscateSQL              = false
 	defaultCaptureBody               = CaptureBodyOff
 	defaultSpanStackTraceMinDuration = 5 * time.Millisecond
 	defaultStackTraceLimit           = 50
 	defaultContinuationStrategy      = "continue"
+	defaultSpoolMaxSize              = 100 * configutil.MByte
+	defaultTailSamplingBufferSize    = 10 * configutil.MByte
 
 	defaultExitSpanMinDuration = time.Millisecond
 
@@ -104,6 +118,9 @@ const (
 	minMetricsBufferSize = 10 * configutil.KByte
 	maxMetricsBufferSize = 100 * configutil.MByte
 
+	minTailSamplingBufferSize = 10 * configutil.KByte
+	maxTailSamplingBufferSize = 1 * configutil.GByte
+
 	// Span Compressions default setting values
 	defaultSpanCompressionEnabled               = true
 	defaultSpanCompressionExactMatchMaxDuration = 50 * time.Millisecond
@@ -138,6 +155,19 @@ func initialTransport(serviceName, serviceVersion string) (transport.Transport,
 		service += " " + httpComment.ReplaceAllString(serviceVersion, "_")
 	}
 	userAgent := fmt.Sprintf("%s (%s)", transport.DefaultUserAgent(), service)
+	switch value := strings.TrimSpace(strings.ToLower(os.Getenv(envTransport))); value {
+	case "", "intake":
+	case "otlp":
+		otlpTransport, err := transport.NewOTLPTransport(transport.OTLPTransportOptions{
+			UserAgent: userAgent,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return otlpTransport, nil
+	default:
+		return nil, errors.Errorf("invalid %s value %q", envTransport, value)
+	}
 	httpTransport, err := transport.NewHTTPTransport(transport.HTTPTransportOptions{
 		UserAgent: userAgent,
 	})
@@ -147,6 +177,25 @@ func initialTransport(serviceName, serviceVersion string) (transport.Transport,
 	return httpTransport, nil
 }
 
+// initialSpool opens the disk spool if ELASTIC_APM_SPOOL_DIR is set,
+// returning nil if the spool is disabled. The spool is shared by all
+// tracers in the process using the same directory.
+func initialSpool() (*sharedSpool, error) {
+	dir := os.Getenv(envSpoolDir)
+	if dir == "" {
+		return nil, nil
+	}
+	maxSize, err := configutil.ParseSizeEnv(envSpoolMaxSize, defaultSpoolMaxSize)
+	if err != nil {
+		return nil, err
+	}
+	s, err := openSharedSpool(dir, maxSize.Bytes())
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open %s", envSpoolDir)
+	}
+	return s, nil
+}
+
 func initialRequestDuration() (time.Duration, error) {
 	return configutil.ParseDurationEnv(envAPIRequestTime, defaultAPIRequestTime)
 }
@@ -155,6 +204,20 @@ func initialMetricsInterval() (time.Duration, error) {
 	return configutil.ParseDurationEnv(envMetricsInterval, defaultMetricsInterval)
 }
 
+func initialTailSamplingBufferSize() (int, error) {
+	size, err := configutil.ParseSizeEnv(envTailSamplingBufferSize, defaultTailSamplingBufferSize)
+	if err != nil {
+		return 0, err
+	}
+	if size < minTailSamplingBufferSize || size > maxTailSamplingBufferSize {
+		return 0, errors.Errorf(
+			"%s must be at least %s and less than %s, got %s",
+			envTailSamplingBufferSize, minTailSamplingBufferSize, maxTailSamplingBufferSize, size,
+		)
+	}
+	return int(size), nil
+}
+
 func initialMetricsBufferSize() (int, error) {
 	size, err := configutil.ParseSizeEnv(envMetricsBufferSize, defaultMetricsBufferSize)
 	if err != nil {
@@ -233,10 +296,24 @@ func parseSampleRate(name, value string) (Sampler, error) {
 	return NewRatioSampler(ratio), nil
 }
 
+// initialSampleRules returns the transaction sampling rules defined
+// by ELASTIC_APM_TRANSACTION_SAMPLE_RULES, if any.
+func initialSampleRules() (sampleRules, error) {
+	return parseSampleRules(envTransactionSampleRules, os.Getenv(envTransactionSampleRules))
+}
+
+func initialGlobalLabels() model.StringMap {
+	return parseGlobalLabels(os.Getenv(envGlobalLabels))
+}
+
 func initialSanitizedFieldNames() wildcard.Matchers {
 	return configutil.ParseWildcardPatternsEnv(envSanitizeFieldNames, defaultSanitizedFieldNames)
 }
 
+func initialBaggageToAttach() wildcard.Matchers {
+	return configutil.ParseWildcardPatternsEnv(envBaggageToAttach, nil)
+}
+
 func initContinuationStrategy() (string, error) {
 	value := os.Getenv(envContinuationStrategy)
 	if value == "" {
@@ -258,6 +335,10 @@ func initialCaptureHeaders() (bool, error) {
 	return configutil.ParseBoolEnv(envCaptureHeaders, defaultCaptureHeaders)
 }
 
+func initialObfuscateSQL() (bool, error) {
+	return configutil.ParseBoolEnv(envObfuscateSQL, defaultObfuscateSQL)
+}
+
 func initialCaptureBody() (CaptureBodyMode, error) {
 	value := os.Getenv(envCaptureBody)
 	if value == "" {
@@ -385,11 +466,13 @@ func initialExitSpanMinDuration() (time.Duration, error) {
 	)
 }
 
-// updateRemoteConfig updates t and cfg with changes held in "attrs", and reverts to local
+// updateRemoteConfig updates t with changes held in "attrs", and reverts to local
 // config for config attributes that have been removed (exist in old but not in attrs).
+// Changes to tracerConfig are returned as a tracerConfigCommand, which must be applied
+// by the tracer loop.
 //
 // On return from updateRemoteConfig, unapplied config will have been removed from attrs.
-func (t *Tracer) updateRemoteConfig(logger Logger, old, attrs map[string]string) {
+func (t *Tracer) updateRemoteConfig(logger Logger, old, attrs map[string]string) tracerConfigCommand {
 	warningf := func(string, ...interface{}) {}
 	debugf := func(string, ...interface{}) {}
 	errorf := func(string, ...interface{}) {}
@@ -403,6 +486,12 @@ func (t *Tracer) updateRemoteConfig(logger Logger, old, attrs map[string]string)
 	}
 
 	var updates []func(cfg *instrumentationConfig)
+	var tracerUpdates []tracerConfigCommand
+	var applied, rejected []string
+	for k := range attrs {
+		// Keys are removed from attrs below if they are rejected.
+		rejected = append(rejected, k)
+	}
 	for k, v := range attrs {
 		if oldv, ok := old[k]; ok && oldv == v {
 			continue
@@ -505,6 +594,16 @@ func (t *Tracer) updateRemoteConfig(logger Logger, old, attrs map[string]string)
 					cfg.sampler = sampler
 				})
 			}
+		case envTransactionSampleRules:
+			rules, err := parseSampleRules(k, v)
+			if err != nil {
+				errorf("central config failure: %s", err)
+				delete(attrs, k)
+				continue
+			}
+			updates = append(updates, func(cfg *instrumentationConfig) {
+				cfg.sampleRules = rules
+			})
 		case apmlog.EnvLogLevel:
 			level, err := apmlog.ParseLogLevel(v)
 			if err != nil {
@@ -551,29 +650,153 @@ func (t *Tracer) updateRemoteConfig(logger Logger, old, attrs map[string]string)
 			updates = append(updates, func(cfg *instrumentationConfig) {
 				cfg.compressionOptions.sameKindMaxDuration = duration
 			})
+		case envCaptureHeaders:
+			captureHeaders, err := strconv.ParseBool(v)
+			if err != nil {
+				errorf("central config failure: failed to parse %s: %s", k, err)
+				delete(attrs, k)
+				continue
+			}
+			updates = append(updates, func(cfg *instrumentationConfig) {
+				cfg.captureHeaders = captureHeaders
+			})
+		case envObfuscateSQL:
+			obfuscateSQL, err := strconv.ParseBool(v)
+			if err != nil {
+				errorf("central config failure: failed to parse %s: %s", k, err)
+				delete(attrs, k)
+				continue
+			}
+			updates = append(updates, func(cfg *instrumentationConfig) {
+				cfg.obfuscateSQL = obfuscateSQL
+			})
+		case envBreakdownMetrics:
+			breakdownMetrics, err := strconv.ParseBool(v)
+			if err != nil {
+				errorf("central config failure: failed to parse %s: %s", k, err)
+				delete(attrs, k)
+				continue
+			}
+			updates = append(updates, func(cfg *instrumentationConfig) {
+				cfg.breakdownMetrics = breakdownMetrics
+			})
+		case envMetricsInterval:
+			duration, err := configutil.ParseDuration(v)
+			if err != nil {
+				errorf("central config failure: failed to parse %s: %s", k, err)
+				delete(attrs, k)
+				continue
+			}
+			tracerUpdates = append(tracerUpdates, func(cfg *tracerConfig) {
+				cfg.metricsInterval = duration
+			})
+		case envAPIRequestTime:
+			duration, err := configutil.ParseDuration(v)
+			if err != nil {
+				errorf("central config failure: failed to parse %s: %s", k, err)
+				delete(attrs, k)
+				continue
+			}
+			tracerUpdates = append(tracerUpdates, func(cfg *tracerConfig) {
+				cfg.requestDuration = duration
+			})
+		case envCPUProfileInterval:
+			duration, err := configutil.ParseDuration(v)
+			if err != nil {
+				errorf("central config failure: failed to parse %s: %s", k, err)
+				delete(attrs, k)
+				continue
+			}
+			tracerUpdates = append(tracerUpdates, func(cfg *tracerConfig) {
+				cfg.cpuProfileInterval = duration
+			})
+		case envCPUProfileDuration:
+			duration, err := configutil.ParseDuration(v)
+			if err != nil {
+				errorf("central config failure: failed to parse %s: %s", k, err)
+				delete(attrs, k)
+				continue
+			}
+			tracerUpdates = append(tracerUpdates, func(cfg *tracerConfig) {
+				cfg.cpuProfileDuration = duration
+			})
+		case envHeapProfileInterval:
+			duration, err := configutil.ParseDuration(v)
+			if err != nil {
+				errorf("central config failure: failed to parse %s: %s", k, err)
+				delete(attrs, k)
+				continue
+			}
+			tracerUpdates = append(tracerUpdates, func(cfg *tracerConfig) {
+				cfg.heapProfileInterval = duration
+			})
+		case envAPIRequestSize:
+			size, err := configutil.ParseSize(v)
+			if err != nil {
+				errorf("central config failure: failed to parse %s: %s", k, err)
+				delete(attrs, k)
+				continue
+			}
+			if size < minAPIRequestSize || size > maxAPIRequestSize {
+				errorf(
+					"central config failure: %s must be at least %s and less than %s, got %s",
+					k, minAPIRequestSize, maxAPIRequestSize, size,
+				)
+				delete(attrs, k)
+				continue
+			}
+			tracerUpdates = append(tracerUpdates, func(cfg *tracerConfig) {
+				cfg.requestSize = int(size)
+			})
+		case envDisableMetrics:
+			matchers := configutil.ParseWildcardPatterns(v)
+			tracerUpdates = append(tracerUpdates, func(cfg *tracerConfig) {
+				cfg.disabledMetrics = matchers
+			})
+		case envGlobalLabels:
+			labels := parseGlobalLabels(v)
+			tracerUpdates = append(tracerUpdates, func(cfg *tracerConfig) {
+				cfg.globalLabels = labels
+			})
 		default:
 			warningf("central config failure: unsupported config: %s", k)
 			delete(attrs, k)
 			continue
 		}
 		debugf("central config update: updated %s to %s", k, v)
+		applied = append(applied, k)
+	}
+	rejected = removeStrings(rejected, attrs)
+	if len(applied) > 0 {
+		sort.Strings(applied)
+		debugf("central config update: applied %s", strings.Join(applied, ", "))
+	}
+	if len(rejected) > 0 {
+		sort.Strings(rejected)
+		warningf("central config update: rejected %s", strings.Join(rejected, ", "))
 	}
 	for k := range old {
 		if _, ok := attrs[k]; ok {
 			continue
 		}
+		envKey := envName(k)
 		updates = append(updates, func(cfg *instrumentationConfig) {
-			if f, ok := cfg.local[envName(k)]; ok {
+			if f, ok := cfg.local[envKey]; ok {
 				f(&cfg.instrumentationConfigValues)
 			}
 		})
+		tracerUpdates = append(tracerUpdates, func(cfg *tracerConfig) {
+			if f, ok := cfg.local[envKey]; ok {
+				f(cfg)
+			}
+		})
 		debugf("central config update: reverted %s to local config", k)
 	}
+	remote := make(map[string]struct{})
+	for k := range attrs {
+		remote[envName(k)] = struct{}{}
+	}
 	if updates != nil {
-		remote := make(map[string]struct{})
-		for k := range attrs {
-			remote[envName(k)] = struct{}{}
-		}
 		t.updateInstrumentationConfig(func(cfg *instrumentationConfig) {
 			cfg.remote = remote
 			for _, update := range updates {
@@ -581,6 +804,23 @@ func (t *Tracer) updateRemoteConfig(logger Logger, old, attrs map[string]string)
 			}
 		})
 	}
+	return func(cfg *tracerConfig) {
+		cfg.remote = remote
+		for _, update := range tracerUpdates {
+			update(cfg)
+		}
+	}
+}
+
+// removeStrings returns keys, excluding those that exist in m.
+func removeStrings(keys []string, m map[string]string) []string {
+	out := keys[:0]
+	for _, k := range keys {
+		if _, ok := m[k]; !ok {
+			out = append(out, k)
+		}
+	}
+	return out
 }
 
 // instrumentationConfig returns the current instrumentationConfig.
@@ -647,12 +887,16 @@ type instrumentationConfigValues struct {
 	captureHeaders            bool
 	maxSpans                  int
 	sampler                   Sampler
+	sampleRules               sampleRules
+	breakdownMetrics          bool
 	spanStackTraceMinDuration time.Duration
 	exitSpanMinDuration       time.Duration
 	continuationStrategy      string
 	stackTraceLimit           int
 	propagateLegacyHeader     bool
+	obfuscateSQL              bool
 	sanitizedFieldNames       wildcard.Matchers
 	ignoreTransactionURLs     wildcard.Matchers
+	baggageToAttach           wildcard.Matchers
 	compressionOptions        compressionOptions
 }
@@ -0,0 +1,103 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apm
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.elastic.co/apm/v2/model"
+	"go.elastic.co/apm/v2/transport"
+)
+
+func TestUpdateRemoteConfigTracerConfig(t *testing.T) {
+	tracer, err := NewTracerOptions(TracerOptions{
+		ServiceName: "tracer_testing",
+		Transport:   transport.NewDiscardTransport(nil),
+	})
+	require.NoError(t, err)
+	defer tracer.Close()
+
+	var logger recordingLogger
+	var cfg tracerConfig
+	cfg.setLocal(envMetricsInterval, func(cfg *tracerConfig) {
+		cfg.metricsInterval = time.Second
+	})
+	cfg.setLocal(envAPIRequestSize, func(cfg *tracerConfig) {
+		cfg.requestSize = 1024
+	})
+	cfg.setLocal(envGlobalLabels, func(cfg *tracerConfig) {
+		cfg.globalLabels = nil
+	})
+
+	attrs := map[string]string{
+		"metrics_interval":  "5s",
+		"global_labels":     "a=b",
+		"capture_headers":   "false",
+		"breakdown_metrics": "false",
+		"api_request_size":  "1B",
+		"unknown":           "x",
+	}
+	cmd := tracer.updateRemoteConfig(&logger, nil, attrs)
+	cmd(&cfg)
+	assert.Equal(t, 5*time.Second, cfg.metricsInterval)
+	assert.Equal(t, 1024, cfg.requestSize)
+	assert.Equal(t, model.StringMap{{Key: "a", Value: "b"}}, cfg.globalLabels)
+	assert.False(t, tracer.instrumentationConfig().captureHeaders)
+	assert.False(t, tracer.instrumentationConfig().breakdownMetrics)
+	assert.Contains(t, logger.warnings, "central config update: rejected api_request_size, unknown")
+	assert.Contains(t, logger.debugs, "central config update: applied breakdown_metrics, capture_headers, global_labels, metrics_interval")
+
+	// Local config changes have no effect while remote config is in effect.
+	cfg.setLocal(envMetricsInterval, func(cfg *tracerConfig) {
+		cfg.metricsInterval = 2 * time.Second
+	})
+	tracer.SetCaptureHeaders(true)
+	assert.Equal(t, 5*time.Second, cfg.metricsInterval)
+	assert.False(t, tracer.instrumentationConfig().captureHeaders)
+
+	// Removing remote config reverts to the most recent local config.
+	cmd = tracer.updateRemoteConfig(&logger, attrs, map[string]string{})
+	cmd(&cfg)
+	assert.Equal(t, 2*time.Second, cfg.metricsInterval)
+	assert.Nil(t, cfg.globalLabels)
+	assert.True(t, tracer.instrumentationConfig().captureHeaders)
+	assert.True(t, tracer.instrumentationConfig().breakdownMetrics)
+}
+
+type recordingLogger struct {
+	debugs   []string
+	errors   []string
+	warnings []string
+}
+
+func (l *recordingLogger) Debugf(format string, args ...interface{}) {
+	l.debugs = append(l.debugs, fmt.Sprintf(format, args...))
+}
+
+func (l *recordingLogger) Errorf(format string, args ...interface{}) {
+	l.errors = append(l.errors, fmt.Sprintf(format, args...))
+}
+
+func (l *recordingLogger) Warningf(format string, args ...interface{}) {
+	l.warnings = append(l.warnings, fmt.Sprintf(format, args...))
+}
@@ -54,6 +54,21 @@ func TestTracerCentralConfigUpdate(t *testing.T) {
 	run("transaction_sample_rate", "0", func(tracer *apmtest.RecordingTracer) bool {
 		return !tracer.StartTransaction("name", "type").Sampled()
 	})
+	run("transaction_sample_rules", "(?-i)name=0", func(tracer *apmtest.RecordingTracer) bool {
+		return !tracer.StartTransaction("name", "type").Sampled() && tracer.StartTransaction("NAME", "type").Sampled()
+	})
+	run("capture_headers", "false", func(tracer *apmtest.RecordingTracer) bool {
+		tracer.ResetPayloads()
+		req, _ := http.NewRequest("GET", "http://server.testing/", nil)
+		req.Header.Set("foo", "bar")
+		tx := tracer.StartTransaction("name", "type")
+		tx.Context.SetHTTPRequest(req)
+		tx.End()
+		tracer.Flush(nil)
+		payloads := tracer.Payloads()
+		require.Len(t, payloads.Transactions, 1)
+		return payloads.Transactions[0].Context.Request.Headers == nil
+	})
 	run("transaction_max_spans", "0", func(tracer *apmtest.RecordingTracer) bool {
 		return tracer.StartTransaction("name", "type").StartSpan("name", "type", nil).Dropped()
 	})
@@ -208,6 +223,11 @@ func TestTracerCentralConfigUpdate(t *testing.T) {
 		tracer.Flush(nil)
 		return len(tracer.Payloads().Spans) == 2
 	})
+	run("obfuscate_sql", "true", func(tracer *apmtest.RecordingTracer) bool {
+		tx := tracer.StartTransaction("name", "type")
+		defer tx.End()
+		return tx.ShouldObfuscateSQL()
+	})
 }
 
 func testTracerCentralConfigUpdate(t *testing.T, logger apm.Logger, serverResponse string, isRemote func(*apmtest.RecordingTracer) bool) {
@@ -20,6 +20,7 @@ package apm // import "go.elastic.co/apm/v2"
 import (
 	"fmt"
 	"net/http"
+	"time"
 
 	"go.elastic.co/apm/v2/internal/apmhttputil"
 	"go.elastic.co/apm/v2/internal/wildcard"
@@ -39,7 +40,11 @@ type Context struct {
 	user                model.User
 	service             model.Service
 	serviceFramework    model.Framework
+	message             model.MessageContext
+	messageQueue        model.MessageQueueSpanContext
+	messageAge          model.MessageAgeContext
 	otel                *model.OTel
+	faas                *model.FAAS
 	captureHeaders      bool
 	captureBodyMask     CaptureBodyMode
 	sanitizedFieldNames wildcard.Matchers
@@ -51,6 +56,7 @@ func (c *Context) build() *model.Context {
 	case c.model.Response != nil:
 	case c.model.User != nil:
 	case c.model.Service != nil:
+	case c.model.Message != nil:
 	case len(c.model.Tags) != 0:
 	case len(c.model.Custom) != 0:
 	default:
@@ -285,6 +291,87 @@ func (c *Context) SetUsername(username string) {
 	}
 }
 
+// SetMessage sets details of the message received by a messaging transaction.
+//
+// message.QueueName is required. If it is empty, then SetMessage is a no-op.
+func (c *Context) SetMessage(message MessageContext) {
+	if message.QueueName == "" {
+		return
+	}
+	c.messageQueue.Name = truncateString(message.QueueName)
+	c.message = model.MessageContext{
+		Queue:      &c.messageQueue,
+		RoutingKey: truncateString(message.RoutingKey),
+	}
+	if message.Age > 0 {
+		c.messageAge.Millis = int64(message.Age / time.Millisecond)
+		c.message.Age = &c.messageAge
+	}
+	c.model.Message = &c.message
+}
+
+// MessageContext holds contextual information about a message
+// received by a messaging transaction.
+type MessageContext struct {
+	// QueueName holds the name of the queue or topic from which
+	// the message was received.
+	QueueName string
+
+	// RoutingKey holds the routing key of the message, if any.
+	RoutingKey string
+
+	// Age holds the age of the message, which is the time elapsed
+	// since the message was sent, if known. Age is ignored if it is
+	// not positive, e.g. due to clock skew.
+	Age time.Duration
+}
+
+// SetFAAS sets Function-as-a-Service properties of the transaction,
+// such as the function name and the trigger of the invocation.
+func (c *Context) SetFAAS(faas FAASContext) {
+	c.faas = &model.FAAS{
+		ID:        truncateString(faas.ID),
+		Name:      truncateString(faas.Name),
+		Version:   truncateString(faas.Version),
+		Execution: truncateString(faas.Execution),
+		Coldstart: faas.Coldstart,
+	}
+	if faas.TriggerType != "" || faas.TriggerRequestID != "" {
+		c.faas.Trigger = &model.FAASTrigger{
+			Type:      truncateString(faas.TriggerType),
+			RequestID: truncateString(faas.TriggerRequestID),
+		}
+	}
+}
+
+// FAASContext holds Function-as-a-Service properties of a transaction.
+type FAASContext struct {
+	// ID holds the unique identifier of the invoked function,
+	// such as its ARN.
+	ID string
+
+	// Name holds the name of the invoked function.
+	Name string
+
+	// Version holds the version of the invoked function.
+	Version string
+
+	// Execution holds the request ID of the function invocation.
+	Execution string
+
+	// Coldstart indicates whether the invocation was the first
+	// in a newly initialized function instance.
+	Coldstart bool
+
+	// TriggerType holds the type of trigger of the invocation:
+	// "http", "pubsub", "datasource", "timer", or "other".
+	TriggerType string
+
+	// TriggerRequestID holds the ID of the request or message
+	// which triggered the invocation, if any.
+	TriggerRequestID string
+}
+
 // outcome returns the outcome to assign to the associated transaction,
 // based on context (e.g. HTTP status code).
 func (c *Context) outcome() string {
@@ -22,6 +22,7 @@ import (
 	"net/http"
 	"net/url"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -71,6 +72,69 @@ func TestContextUser(t *testing.T) {
 	})
 }
 
+func TestContextMessage(t *testing.T) {
+	tx := testSendTransaction(t, func(tx *apm.Transaction) {
+		tx.Context.SetMessage(apm.MessageContext{
+			QueueName:  "queue",
+			RoutingKey: "key",
+			Age:        1500 * time.Millisecond,
+		})
+	})
+	assert.Equal(t, &model.MessageContext{
+		Queue:      &model.MessageQueueSpanContext{Name: "queue"},
+		Age:        &model.MessageAgeContext{Millis: 1500},
+		RoutingKey: "key",
+	}, tx.Context.Message)
+
+	tx = testSendTransaction(t, func(tx *apm.Transaction) {
+		tx.Context.SetMessage(apm.MessageContext{QueueName: "queue", Age: -time.Second})
+	})
+	assert.Equal(t, &model.MessageContext{
+		Queue: &model.MessageQueueSpanContext{Name: "queue"},
+	}, tx.Context.Message)
+
+	tx = testSendTransaction(t, func(tx *apm.Transaction) {
+		tx.Context.SetMessage(apm.MessageContext{})
+	})
+	assert.Nil(t, tx.Context)
+}
+
+func TestContextFAAS(t *testing.T) {
+	tracer := apmtest.NewRecordingTracer()
+	defer tracer.Close()
+
+	tx := tracer.StartTransaction("name", "type")
+	tx.Context.SetFAAS(apm.FAASContext{
+		ID:               "arn:aws:lambda:us-east-1:123456789012:function:fn",
+		Name:             "fn",
+		Version:          "$LATEST",
+		Execution:        "execution",
+		Coldstart:        true,
+		TriggerType:      "http",
+		TriggerRequestID: "request",
+	})
+	tx.End()
+	tx = tracer.StartTransaction("name", "type")
+	tx.Context.SetFAAS(apm.FAASContext{Name: "fn"})
+	tx.End()
+	tracer.Flush(nil)
+
+	payloads := tracer.Payloads()
+	require.Len(t, payloads.Transactions, 2)
+	assert.Equal(t, &model.FAAS{
+		ID:        "arn:aws:lambda:us-east-1:123456789012:function:fn",
+		Name:      "fn",
+		Version:   "$LATEST",
+		Execution: "execution",
+		Coldstart: true,
+		Trigger: &model.FAASTrigger{
+			Type:      "http",
+			RequestID: "request",
+		},
+	}, payloads.Transactions[0].FAAS)
+	assert.Equal(t, &model.FAAS{Name: "fn"}, payloads.Transactions[1].FAAS)
+}
+
 func TestContextFramework(t *testing.T) {
 	t.Run("name_unspecified", func(t *testing.T) {
 		tx := testSendTransaction(t, func(tx *apm.Transaction) {
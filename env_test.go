@@ -171,6 +171,35 @@ func testTracerTransactionRateEnv(t *testing.T, envValue string, ratio float64)
 	assert.InDelta(t, N*ratio, sampled, N*0.02) // allow 2% error
 }
 
+func TestTracerTransactionSampleRulesEnv(t *testing.T) {
+	os.Setenv("ELASTIC_APM_TRANSACTION_SAMPLE_RATE", "0")
+	defer os.Unsetenv("ELASTIC_APM_TRANSACTION_SAMPLE_RATE")
+	os.Setenv("ELASTIC_APM_TRANSACTION_SAMPLE_RULES", "GET /health*=0, request|POST /checkout=1.0, messaging|*=1")
+	defer os.Unsetenv("ELASTIC_APM_TRANSACTION_SAMPLE_RULES")
+
+	tracer := apmtest.NewDiscardTracer()
+	defer tracer.Close()
+
+	sampled := func(name, transactionType string) bool {
+		tx := tracer.StartTransaction(name, transactionType)
+		defer tx.End()
+		return tx.Sampled()
+	}
+	assert.False(t, sampled("GET /healthz", "request"))
+	assert.True(t, sampled("POST /checkout", "request"))
+	assert.False(t, sampled("POST /checkout", "other"))
+	assert.True(t, sampled("orders", "messaging"))
+	assert.False(t, sampled("GET /", "request"))
+}
+
+func TestTracerTransactionSampleRulesEnvInvalid(t *testing.T) {
+	os.Setenv("ELASTIC_APM_TRANSACTION_SAMPLE_RULES", "GET /health*=2")
+	defer os.Unsetenv("ELASTIC_APM_TRANSACTION_SAMPLE_RULES")
+
+	_, err := apm.NewTracer("tracer_testing", "")
+	assert.EqualError(t, err, `invalid rule "GET /health*=2" in ELASTIC_APM_TRANSACTION_SAMPLE_RULES: rate out of range [0,1.0]`)
+}
+
 func TestTracerSanitizeFieldNamesEnv(t *testing.T) {
 	testTracerSanitizeFieldNamesEnv(t, "secRet", "[REDACTED]")
 	testTracerSanitizeFieldNamesEnv(t, "nada", "top")
@@ -411,3 +440,30 @@ func testUseElasticTraceparentHeader(t *testing.T, envValue string, expectPropag
 	tx.Discard()
 	assert.Equal(t, expectPropagate, propagate)
 }
+
+func TestTracerTransportEnvOTLP(t *testing.T) {
+	requests := make(chan *http.Request, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		io.Copy(ioutil.Discard, req.Body)
+		select {
+		case requests <- req:
+		default:
+		}
+	}))
+	defer server.Close()
+
+	os.Setenv("ELASTIC_APM_TRANSPORT", "otlp")
+	defer os.Unsetenv("ELASTIC_APM_TRANSPORT")
+	os.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", server.URL)
+	defer os.Unsetenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+
+	tracer, err := apm.NewTracer("tracer_testing", "")
+	require.NoError(t, err)
+	defer tracer.Close()
+	tracer.StartTransaction("name", "type").End()
+	tracer.Flush(nil)
+
+	req := <-requests
+	assert.Equal(t, "/v1/traces", req.URL.Path)
+	assert.Equal(t, "application/x-protobuf", req.Header.Get("Content-Type"))
+}
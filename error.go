@@ -26,6 +26,7 @@ import (
 	"syscall"
 	"time"
 
+	"go.elastic.co/apm/v2/internal/wildcard"
 	"go.elastic.co/apm/v2/model"
 	"go.elastic.co/apm/v2/stacktrace"
 )
@@ -156,6 +157,7 @@ func (t *Tracer) newError() *Error {
 		e.Context.captureHeaders = instrumentationConfig.captureHeaders
 		e.Context.sanitizedFieldNames = instrumentationConfig.sanitizedFieldNames
 		e.stackTraceLimit = instrumentationConfig.stackTraceLimit
+		e.baggageToAttach = instrumentationConfig.baggageToAttach
 	}
 
 	return &Error{ErrorData: e}
@@ -189,6 +191,7 @@ type ErrorData struct {
 	transactionSampled bool
 	transactionName    string
 	transactionType    string
+	baggageToAttach    wildcard.Matchers
 
 	// ID is the unique identifier of the error. This is set by
 	// the various error constructors, and is exposed only so
@@ -319,6 +322,7 @@ func (e *Error) setSpanData(
 	e.ParentID = traceContext.Span
 	e.TransactionID = transactionID
 	e.transactionSampled = traceContext.Options.Recorded()
+	traceContext.Baggage.setLabels(e.baggageToAttach, e.Context.SetLabel)
 	if e.transactionSampled {
 		e.transactionName = transactionName
 		e.transactionType = transactionType
@@ -0,0 +1,62 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apm_test
+
+import (
+	"go.elastic.co/apm/v2"
+	"go.elastic.co/apm/v2/model"
+	"go.elastic.co/fastjson"
+)
+
+func ExampleTracer_SetProcessors() {
+	// Encoded events are sent to a channel, which may be consumed
+	// by another goroutine for sending to a second destination.
+	tee := make(chan []byte, 100)
+
+	apm.DefaultTracer().SetProcessors(
+		// Drop spans for cache operations.
+		apm.ProcessorFunc(func(e *apm.Event) bool {
+			return e.Span == nil || e.Span.Type != "cache"
+		}),
+		// Add a label to all transactions.
+		apm.ProcessorFunc(func(e *apm.Event) bool {
+			if e.Transaction != nil {
+				if e.Transaction.Context == nil {
+					e.Transaction.Context = &model.Context{}
+				}
+				e.Transaction.Context.Tags = append(e.Transaction.Context.Tags, model.IfaceMapItem{
+					Key: "region", Value: "eu-west-1",
+				})
+			}
+			return true
+		}),
+		// Encode transactions and send them to the tee channel,
+		// without blocking the tracer if the channel is full.
+		apm.ProcessorFunc(func(e *apm.Event) bool {
+			if e.Transaction != nil {
+				var w fastjson.Writer
+				e.Transaction.MarshalFastJSON(&w)
+				select {
+				case tee <- w.Bytes():
+				default:
+				}
+			}
+			return true
+		}),
+	)
+}
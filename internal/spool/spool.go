@@ -0,0 +1,277 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package spool
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ErrTooLarge is returned by Spool.Write when the data to write
+// is larger than the spool's maximum size.
+var ErrTooLarge = errors.New("data exceeds maximum spool size")
+
+// ErrClosed is returned by Spool.Write and Spool.Replay when the
+// spool has been closed.
+var ErrClosed = errors.New("spool closed")
+
+const (
+	segmentSuffix = ".seg"
+	tempSuffix    = ".tmp"
+)
+
+// Spool is a size-capped, disk-backed queue of segments.
+//
+// A Spool directory must not be shared by multiple Spools, whether in
+// the same process or in different processes: each Spool allocates
+// segment sequence numbers independently, so Spools sharing a directory
+// may overwrite or replay each other's segments.
+type Spool struct {
+	dir     string
+	maxSize int64
+
+	// replayMu serializes calls to Replay, so that segments
+	// are not replayed more than once.
+	replayMu sync.Mutex
+
+	mu       sync.Mutex
+	closed   bool
+	segments []segment
+	size     int64
+	nextSeq  uint64
+}
+
+type segment struct {
+	seq  uint64
+	size int64
+}
+
+// Open opens the spool in dir, creating the directory if it does not
+// exist, and loading any segments left by a previous process. Segments
+// that were not completely written are removed.
+//
+// The total size of segments in the spool will not exceed maxSize.
+func Open(dir string, maxSize int64) (*Spool, error) {
+	if maxSize <= 0 {
+		return nil, fmt.Errorf("invalid spool size %d, must be positive", maxSize)
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	s := &Spool{dir: dir, maxSize: maxSize}
+	for _, entry := range entries {
+		name := entry.Name()
+		switch {
+		case strings.HasSuffix(name, tempSuffix):
+			// Left behind by a crash while writing a segment.
+			os.Remove(filepath.Join(dir, name))
+		case strings.HasSuffix(name, segmentSuffix):
+			seq, err := strconv.ParseUint(strings.TrimSuffix(name, segmentSuffix), 10, 64)
+			if err != nil {
+				continue
+			}
+			s.segments = append(s.segments, segment{seq: seq, size: entry.Size()})
+			s.size += entry.Size()
+			if seq >= s.nextSeq {
+				s.nextSeq = seq + 1
+			}
+		}
+	}
+	sort.Slice(s.segments, func(i, j int) bool {
+		return s.segments[i].seq < s.segments[j].seq
+	})
+	return s, nil
+}
+
+// Len returns the number of segments in the spool.
+func (s *Spool) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.segments)
+}
+
+// Size returns the total size of the segments in the spool, in bytes.
+func (s *Spool) Size() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.size
+}
+
+// Write writes data to the spool as a new segment. If adding the segment
+// would exceed the spool's maximum size, the oldest segments are discarded
+// to make room. If data is larger than the spool's maximum size, Write
+// returns ErrTooLarge.
+//
+// Write returns the total size of the segments discarded to make room.
+func (s *Spool) Write(data []byte) (discarded int64, err error) {
+	size := int64(len(data))
+	if size > s.maxSize {
+		return 0, ErrTooLarge
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return 0, ErrClosed
+	}
+	for len(s.segments) > 0 && s.size+size > s.maxSize {
+		discarded += s.removeOldest()
+	}
+	seq := s.nextSeq
+	if err := s.writeSegment(seq, data); err != nil {
+		return discarded, err
+	}
+	s.nextSeq++
+	s.segments = append(s.segments, segment{seq: seq, size: size})
+	s.size += size
+	return discarded, nil
+}
+
+// writeSegment writes data to a temporary file, syncs it, and then
+// renames it into place, so that segments are never partially written.
+func (s *Spool) writeSegment(seq uint64, data []byte) error {
+	tempPath := s.path(seq) + tempSuffix
+	f, err := os.OpenFile(tempPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tempPath, s.path(seq)+segmentSuffix)
+	}
+	if err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+	syncDir(s.dir)
+	return nil
+}
+
+// Replay calls f with the contents of each segment in the spool, oldest
+// first. Each segment is removed from the spool once f returns without
+// error. If f returns an error, Replay stops and returns the error, leaving
+// the segment in the spool.
+//
+// The spool is not locked while f is called, so segments may be written
+// concurrently; they will be replayed by the same call if f continues to
+// succeed. If a segment is discarded by Write to make room while it is
+// being replayed, it is not counted as replayed.
+//
+// Replay returns the total size of the segments that were replayed.
+func (s *Spool) Replay(f func(io.Reader) error) (replayed int64, err error) {
+	s.replayMu.Lock()
+	defer s.replayMu.Unlock()
+	for {
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			return replayed, ErrClosed
+		}
+		if len(s.segments) == 0 {
+			s.mu.Unlock()
+			return replayed, nil
+		}
+		seg := s.segments[0]
+		s.mu.Unlock()
+
+		file, err := os.Open(s.path(seg.seq) + segmentSuffix)
+		if os.IsNotExist(err) {
+			// The segment was discarded by Write since the lock was
+			// released, or was removed from outside the spool. Drop
+			// it if it has not been already, so it is not retried.
+			s.mu.Lock()
+			if len(s.segments) > 0 && s.segments[0].seq == seg.seq {
+				s.removeOldest()
+			}
+			s.mu.Unlock()
+			continue
+		} else if err != nil {
+			return replayed, err
+		}
+		err = f(file)
+		file.Close()
+		if err != nil {
+			return replayed, err
+		}
+		s.mu.Lock()
+		if len(s.segments) > 0 && s.segments[0].seq == seg.seq {
+			replayed += s.removeOldest()
+		}
+		s.mu.Unlock()
+	}
+}
+
+// DiscardOldest removes the oldest segment from the spool without
+// replaying it, returning its size in bytes.
+func (s *Spool) DiscardOldest() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.segments) == 0 {
+		return 0
+	}
+	return s.removeOldest()
+}
+
+// Close closes the spool. Segments remain on disk, and will be loaded
+// when the spool directory is next opened. Subsequent calls to Write
+// and Replay will return ErrClosed.
+func (s *Spool) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return nil
+}
+
+func (s *Spool) removeOldest() int64 {
+	seg := s.segments[0]
+	os.Remove(s.path(seg.seq) + segmentSuffix)
+	s.segments = s.segments[1:]
+	s.size -= seg.size
+	return seg.size
+}
+
+func (s *Spool) path(seq uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%020d", seq))
+}
+
+// syncDir syncs the directory, ensuring a renamed segment is persisted.
+// Errors are ignored, as not all platforms support syncing directories.
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
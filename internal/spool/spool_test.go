@@ -0,0 +1,204 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package spool
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSpoolWriteReplay(t *testing.T) {
+	s, err := Open(t.TempDir(), 100)
+	require.NoError(t, err)
+
+	for _, data := range []string{"abc", "defg", "hi"} {
+		discarded, err := s.Write([]byte(data))
+		require.NoError(t, err)
+		assert.Zero(t, discarded)
+	}
+	assert.Equal(t, 3, s.Len())
+	assert.Equal(t, int64(9), s.Size())
+
+	var replayed []string
+	n, err := s.Replay(func(r io.Reader) error {
+		data, err := ioutil.ReadAll(r)
+		replayed = append(replayed, string(data))
+		return err
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int64(9), n)
+	assert.Equal(t, []string{"abc", "defg", "hi"}, replayed)
+	assert.Zero(t, s.Len())
+	assert.Zero(t, s.Size())
+}
+
+func TestSpoolReplayError(t *testing.T) {
+	s, err := Open(t.TempDir(), 100)
+	require.NoError(t, err)
+	s.Write([]byte("abc"))
+	s.Write([]byte("def"))
+
+	var calls int
+	n, err := s.Replay(func(r io.Reader) error {
+		calls++
+		if calls == 2 {
+			return errors.New("boom")
+		}
+		return nil
+	})
+	assert.EqualError(t, err, "boom")
+	assert.Equal(t, int64(3), n)
+	assert.Equal(t, 1, s.Len())
+
+	assert.Equal(t, int64(3), s.DiscardOldest())
+	assert.Zero(t, s.Len())
+	assert.Zero(t, s.DiscardOldest())
+}
+
+func TestSpoolWriteDuringReplay(t *testing.T) {
+	s, err := Open(t.TempDir(), 100)
+	require.NoError(t, err)
+	s.Write([]byte("abc"))
+
+	// The spool is not locked while replaying a segment, so
+	// segments may be written by the replay function; they
+	// are replayed in the same call.
+	var replayed []string
+	n, err := s.Replay(func(r io.Reader) error {
+		data, err := ioutil.ReadAll(r)
+		replayed = append(replayed, string(data))
+		if len(replayed) == 1 {
+			_, err := s.Write([]byte("def"))
+			require.NoError(t, err)
+		}
+		return err
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int64(6), n)
+	assert.Equal(t, []string{"abc", "def"}, replayed)
+	assert.Zero(t, s.Len())
+}
+
+func TestSpoolReplayRemovedSegment(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir, 100)
+	require.NoError(t, err)
+	s.Write([]byte("abc"))
+	s.Write([]byte("def"))
+
+	// Remove the oldest segment from outside the spool. Replay
+	// should skip it, rather than retrying it indefinitely.
+	require.NoError(t, os.Remove(filepath.Join(dir, "00000000000000000000.seg")))
+
+	var replayed []string
+	n, err := s.Replay(func(r io.Reader) error {
+		data, err := ioutil.ReadAll(r)
+		replayed = append(replayed, string(data))
+		return err
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), n)
+	assert.Equal(t, []string{"def"}, replayed)
+	assert.Zero(t, s.Len())
+	assert.Zero(t, s.Size())
+}
+
+func TestSpoolClose(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir, 100)
+	require.NoError(t, err)
+	s.Write([]byte("abc"))
+	require.NoError(t, s.Close())
+
+	_, err = s.Write([]byte("def"))
+	assert.Equal(t, ErrClosed, err)
+	_, err = s.Replay(func(r io.Reader) error { return nil })
+	assert.Equal(t, ErrClosed, err)
+
+	// Segments remain on disk after closing.
+	s, err = Open(dir, 100)
+	require.NoError(t, err)
+	assert.Equal(t, 1, s.Len())
+}
+
+func TestSpoolMaxSize(t *testing.T) {
+	s, err := Open(t.TempDir(), 10)
+	require.NoError(t, err)
+
+	discarded, err := s.Write([]byte("0123"))
+	require.NoError(t, err)
+	assert.Zero(t, discarded)
+	discarded, err = s.Write([]byte("4567"))
+	require.NoError(t, err)
+	assert.Zero(t, discarded)
+
+	// Writing another 4 bytes exceeds the maximum size,
+	// so the oldest segment must be discarded.
+	discarded, err = s.Write([]byte("89ab"))
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), discarded)
+	assert.Equal(t, 2, s.Len())
+	assert.Equal(t, int64(8), s.Size())
+
+	// Segments larger than the maximum size are rejected.
+	discarded, err = s.Write([]byte("0123456789abcdef"))
+	assert.Equal(t, ErrTooLarge, err)
+	assert.Zero(t, discarded)
+	assert.Equal(t, 2, s.Len())
+}
+
+func TestSpoolReopen(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir, 100)
+	require.NoError(t, err)
+	s.Write([]byte("abc"))
+	s.Write([]byte("def"))
+
+	// Simulate a crash while writing a segment.
+	err = ioutil.WriteFile(filepath.Join(dir, "00000000000000000002.seg.tmp"), []byte("gh"), 0600)
+	require.NoError(t, err)
+
+	s, err = Open(dir, 100)
+	require.NoError(t, err)
+	assert.Equal(t, 2, s.Len())
+	assert.Equal(t, int64(6), s.Size())
+	_, err = os.Stat(filepath.Join(dir, "00000000000000000002.seg.tmp"))
+	assert.True(t, os.IsNotExist(err))
+
+	s.Write([]byte("ij"))
+	var replayed []string
+	_, err = s.Replay(func(r io.Reader) error {
+		data, err := ioutil.ReadAll(r)
+		replayed = append(replayed, string(data))
+		return err
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"abc", "def", "ij"}, replayed)
+}
+
+func TestOpenInvalidSize(t *testing.T) {
+	_, err := Open(t.TempDir(), 0)
+	assert.EqualError(t, err, "invalid spool size 0, must be positive")
+}
@@ -20,6 +20,7 @@ package apm_test
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -96,6 +97,23 @@ func TestTracerMetricsBuiltin(t *testing.T) {
 		"system.process.cpu.total.norm.pct",
 		"system.process.memory.size",
 		"system.process.memory.rss.bytes",
+
+		"agent.events.transactions.queued",
+		"agent.events.transactions.sent",
+		"agent.events.transactions.dropped",
+		"agent.events.spans.queued",
+		"agent.events.spans.sent",
+		"agent.events.spans.dropped",
+		"agent.events.errors.queued",
+		"agent.events.errors.sent",
+		"agent.events.errors.dropped",
+		"agent.events.buffer.fill.pct",
+		"agent.events.buffer.max_fill.pct",
+		"agent.events.requests.count",
+		"agent.events.requests.errors",
+		"agent.events.requests.bytes",
+		"agent.events.requests.uncompressed.bytes",
+		"agent.config.reloads",
 	}
 	sort.Strings(expected)
 	for name := range builtinMetrics.Samples {
@@ -288,7 +306,7 @@ func TestTracerMetricsBuffered(t *testing.T) {
 }
 
 func TestTracerDisableMetrics(t *testing.T) {
-	os.Setenv("ELASTIC_APM_DISABLE_METRICS", "golang.heap.*, system.memory.*, system.process.*")
+	os.Setenv("ELASTIC_APM_DISABLE_METRICS", "golang.heap.*, system.memory.*, system.process.*, agent.*")
 	defer os.Unsetenv("ELASTIC_APM_DISABLE_METRICS")
 
 	tracer, transport := transporttest.NewRecorderTracer()
@@ -308,6 +326,39 @@ func TestTracerDisableMetrics(t *testing.T) {
 	assert.EqualValues(t, expected, actual)
 }
 
+func TestTracerAgentMetrics(t *testing.T) {
+	tracer, transport := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+
+	tracer.StartTransaction("name", "type").End()
+	tracer.NewError(errors.New("boom")).Send()
+	tracer.Flush(nil)
+	tracer.SendMetrics(nil)
+
+	// Skip the breakdown metrics, which are reported first.
+	var samples map[string]model.Metric
+	for _, m := range transport.Payloads().Metrics {
+		if m.Transaction.Type == "" {
+			samples = m.Samples
+			break
+		}
+	}
+	for _, eventType := range []string{"transactions", "errors"} {
+		assert.Equal(t, float64(1), samples["agent.events."+eventType+".queued"].Value, eventType)
+		assert.Equal(t, float64(1), samples["agent.events."+eventType+".sent"].Value, eventType)
+		assert.Equal(t, float64(0), samples["agent.events."+eventType+".dropped"].Value, eventType)
+	}
+	assert.Equal(t, float64(0), samples["agent.events.spans.queued"].Value)
+	assert.NotZero(t, samples["agent.events.requests.count"].Value)
+	assert.Zero(t, samples["agent.events.requests.errors"].Value)
+	assert.NotZero(t, samples["agent.events.requests.bytes"].Value)
+	assert.Greater(t,
+		samples["agent.events.requests.uncompressed.bytes"].Value,
+		samples["agent.events.requests.bytes"].Value,
+	)
+	assert.Equal(t, "histogram", samples["agent.events.requests.latency.us"].Type)
+}
+
 func TestTracerMetricsNotRecording(t *testing.T) {
 	tracer := apmtest.NewRecordingTracer()
 	defer tracer.Close()
@@ -949,12 +949,29 @@ func (v *DestinationCloudSpanContext) MarshalFastJSON(w *fastjson.Writer) error
 func (v *MessageSpanContext) MarshalFastJSON(w *fastjson.Writer) error {
 	var firstErr error
 	w.RawByte('{')
+	first := true
 	if v.Queue != nil {
-		w.RawString("\"queue\":")
+		const prefix = ",\"queue\":"
+		if first {
+			first = false
+			w.RawString(prefix[1:])
+		} else {
+			w.RawString(prefix)
+		}
 		if err := v.Queue.MarshalFastJSON(w); err != nil && firstErr == nil {
 			firstErr = err
 		}
 	}
+	if v.RoutingKey != "" {
+		const prefix = ",\"routing_key\":"
+		if first {
+			first = false
+			w.RawString(prefix[1:])
+		} else {
+			w.RawString(prefix)
+		}
+		w.String(v.RoutingKey)
+	}
 	w.RawByte('}')
 	return firstErr
 }
@@ -1054,6 +1071,18 @@ func (v *Context) MarshalFastJSON(w *fastjson.Writer) error {
 			firstErr = err
 		}
 	}
+	if v.Message != nil {
+		const prefix = ",\"message\":"
+		if first {
+			first = false
+			w.RawString(prefix[1:])
+		} else {
+			w.RawString(prefix)
+		}
+		if err := v.Message.MarshalFastJSON(w); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
 	if v.Request != nil {
 		const prefix = ",\"request\":"
 		if first {
@@ -1118,6 +1147,56 @@ func (v *Context) MarshalFastJSON(w *fastjson.Writer) error {
 	return firstErr
 }
 
+func (v *MessageContext) MarshalFastJSON(w *fastjson.Writer) error {
+	var firstErr error
+	w.RawByte('{')
+	first := true
+	if v.Age != nil {
+		const prefix = ",\"age\":"
+		if first {
+			first = false
+			w.RawString(prefix[1:])
+		} else {
+			w.RawString(prefix)
+		}
+		if err := v.Age.MarshalFastJSON(w); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if v.Queue != nil {
+		const prefix = ",\"queue\":"
+		if first {
+			first = false
+			w.RawString(prefix[1:])
+		} else {
+			w.RawString(prefix)
+		}
+		if err := v.Queue.MarshalFastJSON(w); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if v.RoutingKey != "" {
+		const prefix = ",\"routing_key\":"
+		if first {
+			first = false
+			w.RawString(prefix[1:])
+		} else {
+			w.RawString(prefix)
+		}
+		w.String(v.RoutingKey)
+	}
+	w.RawByte('}')
+	return firstErr
+}
+
+func (v *MessageAgeContext) MarshalFastJSON(w *fastjson.Writer) error {
+	w.RawByte('{')
+	w.RawString("\"ms\":")
+	w.Int64(v.Millis)
+	w.RawByte('}')
+	return nil
+}
+
 func (v *User) MarshalFastJSON(w *fastjson.Writer) error {
 	w.RawByte('{')
 	first := true
@@ -484,6 +484,9 @@ type DestinationCloudSpanContext struct {
 type MessageSpanContext struct {
 	// Queue holds the destination cloud region.
 	Queue *MessageQueueSpanContext `json:"queue,omitempty"`
+
+	// RoutingKey holds the routing key of the message, if any.
+	RoutingKey string `json:"routing_key,omitempty"`
 }
 
 // MessageQueueSpanContext holds contextual information about a message queue.
@@ -558,6 +561,29 @@ type Context struct {
 
 	// Service holds values to overrides service-level metadata.
 	Service *Service `json:"service,omitempty"`
+
+	// Message holds details of the message received by a
+	// messaging transaction, if relevant.
+	Message *MessageContext `json:"message,omitempty"`
+}
+
+// MessageContext holds contextual information for transactions
+// which receive messages from a messaging system.
+type MessageContext struct {
+	// Queue holds details of the queue from which the message was received.
+	Queue *MessageQueueSpanContext `json:"queue,omitempty"`
+
+	// Age holds the age of the message, if known.
+	Age *MessageAgeContext `json:"age,omitempty"`
+
+	// RoutingKey holds the routing key of the message, if any.
+	RoutingKey string `json:"routing_key,omitempty"`
+}
+
+// MessageAgeContext holds the age of a received message.
+type MessageAgeContext struct {
+	// Millis holds the age of the message in milliseconds.
+	Millis int64 `json:"ms"`
 }
 
 // User holds information about an authenticated user.
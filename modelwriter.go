@@ -43,36 +43,128 @@ type modelWriter struct {
 	stats           *TracerStats
 	json            fastjson.Writer
 	modelStacktrace []model.StacktraceFrame
+
+	// tailSampling holds the encoded spans of in-flight transactions,
+	// when a tail sampler is configured. It is initialised lazily.
+	tailSampling *tailSamplingBuffer
 }
 
 // writeTransaction encodes tx as JSON to the buffer, and then resets tx.
+//
+// If the transaction's spans have been buffered for tail-based sampling,
+// the tail sampler decides whether the spans are written after the
+// transaction, or discarded. If the transaction is dropped by one of the
+// configured processors, its buffered spans are written without consulting
+// the tail sampler.
 func (w *modelWriter) writeTransaction(tx *Transaction, td *TransactionData) {
 	var modelTx model.Transaction
 	w.buildModelTransaction(&modelTx, tx, td)
-	w.json.RawString(`{"transaction":`)
-	modelTx.MarshalFastJSON(&w.json)
-	w.json.RawByte('}')
-	w.buffer.WriteBlock(w.json.Bytes(), transactionBlockTag)
-	w.json.Reset()
+	if w.cfg.redaction.fields != 0 {
+		w.cfg.redaction.redactTransaction(&modelTx, td.Context.sanitizedFieldNames)
+	}
+	dropped := len(w.cfg.processors) != 0 && !processEvent(w.cfg.processors, &Event{Transaction: &modelTx})
+	if dropped {
+		w.stats.Processors.TransactionsDropped++
+	}
+
+	var spans [][]byte
+	if w.tailSampling != nil {
+		var evicted bool
+		spans, evicted = w.tailSampling.remove(tx.traceContext.Span)
+		if w.cfg.tailSampler != nil && !evicted && !dropped && tx.traceContext.Options.Recorded() {
+			if w.sampleTail(tx, td, &modelTx, len(spans)) {
+				w.stats.TailSampling.TransactionsKept++
+			} else {
+				w.stats.TailSampling.TransactionsDiscarded++
+				w.stats.TailSampling.SpansDiscarded += uint64(len(spans))
+				modelTx.SpanCount.Dropped += len(spans)
+				spans = nil
+			}
+		}
+	}
+
+	if !dropped {
+		w.json.RawString(`{"transaction":`)
+		modelTx.MarshalFastJSON(&w.json)
+		w.json.RawByte('}')
+		w.buffer.WriteBlock(w.json.Bytes(), transactionBlockTag)
+		w.json.Reset()
+	}
+	for _, span := range spans {
+		w.buffer.WriteBlock(span, spanBlockTag)
+	}
 	td.reset(tx.tracer)
 }
 
+// sampleTail calls the tail sampler with the details of the ended transaction.
+func (w *modelWriter) sampleTail(tx *Transaction, td *TransactionData, modelTx *model.Transaction, spans int) bool {
+	var labels model.IfaceMap
+	if modelTx.Context != nil {
+		labels = modelTx.Context.Tags
+	}
+	return w.cfg.tailSampler.SampleTail(TailSampleParams{
+		TraceContext: tx.traceContext,
+		Name:         modelTx.Name,
+		Type:         modelTx.Type,
+		Result:       modelTx.Result,
+		Outcome:      modelTx.Outcome,
+		Duration:     td.Duration,
+		Labels:       labels,
+		Spans:        spans,
+	})
+}
+
 // writeSpan encodes s as JSON to the buffer, and then resets s.
-func (w *modelWriter) writeSpan(s *Span, sd *SpanData) {
+//
+// If a tail sampler is configured and the span's transaction is still
+// active, the encoded span is held back until the transaction ends.
+func (w *modelWriter) writeSpan(s *Span, sd *SpanData, transactionActive bool) {
 	var modelSpan model.Span
 	w.buildModelSpan(&modelSpan, s, sd)
+	if w.cfg.redaction.fields != 0 {
+		w.cfg.redaction.redactSpan(&modelSpan, s.tracer.instrumentationConfig().sanitizedFieldNames)
+	}
+	if len(w.cfg.processors) != 0 && !processEvent(w.cfg.processors, &Event{Span: &modelSpan}) {
+		w.stats.Processors.SpansDropped++
+		sd.reset(s.tracer)
+		return
+	}
 	w.json.RawString(`{"span":`)
 	modelSpan.MarshalFastJSON(&w.json)
 	w.json.RawByte('}')
-	w.buffer.WriteBlock(w.json.Bytes(), spanBlockTag)
+	if !transactionActive || w.cfg.tailSampler == nil || !w.bufferSpan(s.transactionID, w.json.Bytes()) {
+		w.buffer.WriteBlock(w.json.Bytes(), spanBlockTag)
+	}
 	w.json.Reset()
 	sd.reset(s.tracer)
 }
 
+// bufferSpan buffers the encoded span for tail-based sampling, returning
+// false if the span could not be buffered and should be written directly.
+func (w *modelWriter) bufferSpan(transactionID SpanID, span []byte) bool {
+	if w.tailSampling == nil {
+		w.tailSampling = newTailSamplingBuffer()
+	}
+	return w.tailSampling.add(transactionID, span, w.cfg.tailSamplingBufferSize, func(spans [][]byte) {
+		w.stats.TailSampling.SpansEvicted += uint64(len(spans))
+		for _, span := range spans {
+			w.buffer.WriteBlock(span, spanBlockTag)
+		}
+	})
+}
+
 // writeError encodes e as JSON to the buffer, and then resets e.
 func (w *modelWriter) writeError(e *ErrorData) {
 	var modelError model.Error
 	w.buildModelError(&modelError, e)
+	if w.cfg.redaction.fields != 0 {
+		w.cfg.redaction.redactError(&modelError, e.Context.sanitizedFieldNames)
+	}
+	if len(w.cfg.processors) != 0 && !processEvent(w.cfg.processors, &Event{Error: &modelError}) {
+		w.stats.Processors.ErrorsDropped++
+		e.reset()
+		return
+	}
 	w.json.RawString(`{"error":`)
 	modelError.MarshalFastJSON(&w.json)
 	w.json.RawByte('}')
@@ -124,6 +216,7 @@ func (w *modelWriter) buildModelTransaction(out *model.Transaction, tx *Transact
 	out.SpanCount.Started = td.spansCreated
 	out.SpanCount.Dropped = td.spansDropped
 	out.OTel = td.Context.otel
+	out.FAAS = td.Context.faas
 	for _, sl := range td.links {
 		out.Links = append(out.Links, model.SpanLink{TraceID: model.TraceID(sl.Trace), SpanID: model.SpanID(sl.Span)})
 	}
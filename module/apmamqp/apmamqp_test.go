@@ -0,0 +1,202 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apmamqp_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.elastic.co/apm/module/apmamqp/v2"
+	"go.elastic.co/apm/module/apmhttp/v2"
+	"go.elastic.co/apm/v2"
+	"go.elastic.co/apm/v2/apmtest"
+	"go.elastic.co/apm/v2/model"
+)
+
+var _ apmamqp.Publisher = (*amqp.Channel)(nil)
+
+func TestPublishWithContext(t *testing.T) {
+	var publisher recordingPublisher
+	ch := apmamqp.WrapChannel(&publisher)
+
+	headers := amqp.Table{"foo": "bar"}
+	tracer := apmtest.NewRecordingTracer()
+	defer tracer.Close()
+	tx, spans, errs := tracer.WithTransaction(func(ctx context.Context) {
+		err := ch.PublishWithContext(ctx, "exchange", "key", false, false, amqp.Publishing{
+			Headers: headers,
+			Body:    []byte("body"),
+		})
+		require.NoError(t, err)
+	})
+	require.Len(t, spans, 1)
+	assert.Empty(t, errs)
+
+	span := spans[0]
+	assert.Equal(t, tx.ID, span.ParentID)
+	assert.Equal(t, "RabbitMQ SEND to exchange", span.Name)
+	assert.Equal(t, "messaging", span.Type)
+	assert.Equal(t, "rabbitmq", span.Subtype)
+	assert.Equal(t, "send", span.Action)
+	assert.Equal(t, "success", span.Outcome)
+	assert.Equal(t, &model.SpanContext{
+		Message: &model.MessageSpanContext{
+			Queue:      &model.MessageQueueSpanContext{Name: "exchange"},
+			RoutingKey: "key",
+		},
+		Destination: &model.DestinationSpanContext{
+			Service: &model.DestinationServiceSpanContext{
+				Type:     "messaging",
+				Name:     "rabbitmq",
+				Resource: "rabbitmq/exchange",
+			},
+		},
+		Service: &model.ServiceSpanContext{
+			Target: &model.ServiceTargetSpanContext{
+				Type: "rabbitmq",
+				Name: "exchange",
+			},
+		},
+	}, span.Context)
+
+	require.Len(t, publisher.msgs, 1)
+	traceparent := formatTraceparent(tx.TraceID, span.ID)
+	assert.Equal(t, amqp.Table{
+		"foo":                     "bar",
+		"traceparent":             traceparent,
+		"elastic-apm-traceparent": traceparent,
+		"tracestate":              "es=s:1",
+	}, publisher.msgs[0].Headers)
+
+	// The caller's headers must not be modified.
+	assert.Equal(t, amqp.Table{"foo": "bar"}, headers)
+}
+
+func TestPublishWithContextDefaultExchange(t *testing.T) {
+	var publisher recordingPublisher
+	ch := apmamqp.WrapChannel(&publisher)
+
+	tracer := apmtest.NewRecordingTracer()
+	defer tracer.Close()
+	_, spans, _ := tracer.WithTransaction(func(ctx context.Context) {
+		err := ch.PublishWithContext(ctx, "", "queue", false, false, amqp.Publishing{})
+		require.NoError(t, err)
+	})
+	require.Len(t, spans, 1)
+	assert.Equal(t, "RabbitMQ SEND to <default>", spans[0].Name)
+	assert.Equal(t, "rabbitmq/<default>", spans[0].Context.Destination.Service.Resource)
+	assert.Equal(t, "queue", spans[0].Context.Message.RoutingKey)
+}
+
+func TestPublishWithContextError(t *testing.T) {
+	ch := apmamqp.WrapChannel(&recordingPublisher{err: errors.New("boom")})
+
+	tracer := apmtest.NewRecordingTracer()
+	defer tracer.Close()
+	_, spans, errs := tracer.WithTransaction(func(ctx context.Context) {
+		err := ch.PublishWithContext(ctx, "exchange", "key", false, false, amqp.Publishing{})
+		assert.EqualError(t, err, "boom")
+	})
+	require.Len(t, spans, 1)
+	require.Len(t, errs, 1)
+	assert.Equal(t, "failure", spans[0].Outcome)
+	assert.Equal(t, spans[0].ID, errs[0].ParentID)
+	assert.Equal(t, "boom", errs[0].Exception.Message)
+}
+
+func TestPublishWithContextNoTransaction(t *testing.T) {
+	var publisher recordingPublisher
+	ch := apmamqp.WrapChannel(&publisher)
+	err := ch.PublishWithContext(context.Background(), "exchange", "key", false, false, amqp.Publishing{})
+	require.NoError(t, err)
+	require.Len(t, publisher.msgs, 1)
+	assert.Nil(t, publisher.msgs[0].Headers)
+}
+
+func TestStartTransaction(t *testing.T) {
+	tracer := apmtest.NewRecordingTracer()
+	defer tracer.Close()
+
+	traceID := model.TraceID{0: 1, 15: 1}
+	spanID := model.SpanID{0: 2, 7: 2}
+	d := amqp.Delivery{
+		Exchange:   "exchange",
+		RoutingKey: "key",
+		Timestamp:  time.Now().Add(-time.Second),
+		Headers: amqp.Table{
+			// Header values may be received as byte slices.
+			"traceparent": []byte(formatTraceparent(traceID, spanID)),
+		},
+	}
+	tx, ctx := apmamqp.StartTransaction(context.Background(), tracer.Tracer, "queue", d)
+	assert.Equal(t, tx, apm.TransactionFromContext(ctx))
+	tx.End()
+	tracer.Flush(nil)
+
+	payloads := tracer.Payloads()
+	require.Len(t, payloads.Transactions, 1)
+	transaction := payloads.Transactions[0]
+	assert.Equal(t, "RabbitMQ RECEIVE from queue", transaction.Name)
+	assert.Equal(t, "messaging", transaction.Type)
+	assert.Equal(t, traceID, transaction.TraceID)
+	assert.Equal(t, spanID, transaction.ParentID)
+	require.NotNil(t, transaction.Context)
+	require.NotNil(t, transaction.Context.Message)
+	assert.Equal(t, "queue", transaction.Context.Message.Queue.Name)
+	assert.Equal(t, "key", transaction.Context.Message.RoutingKey)
+	require.NotNil(t, transaction.Context.Message.Age)
+	assert.InDelta(t, 1000, transaction.Context.Message.Age.Millis, 500)
+}
+
+func TestStartTransactionNoTraceContext(t *testing.T) {
+	tracer := apmtest.NewRecordingTracer()
+	defer tracer.Close()
+
+	tx, _ := apmamqp.StartTransaction(context.Background(), tracer.Tracer, "queue", amqp.Delivery{})
+	tx.End()
+	tracer.Flush(nil)
+
+	payloads := tracer.Payloads()
+	require.Len(t, payloads.Transactions, 1)
+	assert.Zero(t, payloads.Transactions[0].ParentID)
+	assert.Nil(t, payloads.Transactions[0].Context.Message.Age)
+}
+
+type recordingPublisher struct {
+	msgs []amqp.Publishing
+	err  error
+}
+
+func (p *recordingPublisher) PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	p.msgs = append(p.msgs, msg)
+	return p.err
+}
+
+func formatTraceparent(traceID model.TraceID, spanID model.SpanID) string {
+	return apmhttp.FormatTraceparentHeader(apm.TraceContext{
+		Trace:   apm.TraceID(traceID),
+		Span:    apm.SpanID(spanID),
+		Options: apm.TraceOptions(0).WithRecorded(true),
+	})
+}
@@ -0,0 +1,114 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apmamqp // import "go.elastic.co/apm/module/apmamqp/v2"
+
+import (
+	"context"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+
+	"go.elastic.co/apm/v2"
+)
+
+// defaultExchangeName is used in place of the empty name
+// of the default exchange when reporting spans.
+const defaultExchangeName = "<default>"
+
+// Publisher is the interface for publishing messages implemented
+// by *amqp.Channel.
+type Publisher interface {
+	PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error
+}
+
+// WrapChannel returns a Channel wrapping p, reporting spans for
+// messages published within the transaction or span contained in
+// the context passed to PublishWithContext.
+//
+// p will usually be an *amqp.Channel.
+func WrapChannel(p Publisher) *Channel {
+	return &Channel{p: p}
+}
+
+// Channel wraps a Publisher, such as *amqp.Channel, to report spans for
+// published messages and propagate the trace context in the message headers.
+type Channel struct {
+	p Publisher
+}
+
+// PublishWithContext publishes msg using the wrapped publisher, reporting
+// a span if ctx contains a transaction, and adding trace context headers
+// to msg.
+//
+// The span measures only the time taken to send the message to the broker;
+// publisher confirms are not awaited.
+func (c *Channel) PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	span := startSpan(ctx, exchange, key, &msg)
+	err := c.p.PublishWithContext(ctx, exchange, key, mandatory, immediate, msg)
+	if span != nil {
+		if err != nil {
+			span.Outcome = "failure"
+			apm.CaptureError(apm.ContextWithSpan(ctx, span), err).Send()
+		} else {
+			span.Outcome = "success"
+		}
+		span.End()
+	}
+	return err
+}
+
+// startSpan starts a span for publishing msg to exchange, if ctx contains
+// a transaction, and sets trace context headers in msg. If the transaction
+// is not sampled or the span is dropped, the transaction's trace context
+// is propagated and startSpan returns nil.
+func startSpan(ctx context.Context, exchange, key string, msg *amqp.Publishing) *apm.Span {
+	tx := apm.TransactionFromContext(ctx)
+	if tx == nil {
+		return nil
+	}
+
+	var span *apm.Span
+	traceContext := tx.TraceContext()
+	if traceContext.Options.Recorded() {
+		if exchange == "" {
+			exchange = defaultExchangeName
+		}
+		span, _ = apm.StartSpanOptions(ctx, "RabbitMQ SEND to "+exchange, "messaging", apm.SpanOptions{ExitSpan: true})
+		if !span.Dropped() {
+			traceContext = span.TraceContext()
+			span.Subtype = "rabbitmq"
+			span.Action = "send"
+			span.Context.SetMessage(apm.MessageSpanContext{
+				QueueName:  exchange,
+				RoutingKey: key,
+			})
+			span.Context.SetDestinationService(apm.DestinationServiceSpanContext{
+				Name:     "rabbitmq",
+				Resource: "rabbitmq/" + exchange,
+			})
+			span.Context.SetServiceTarget(apm.ServiceTargetSpanContext{
+				Type: "rabbitmq",
+				Name: exchange,
+			})
+		} else {
+			span.End()
+			span = nil
+		}
+	}
+	msg.Headers = setHeaders(msg.Headers, traceContext, tx.ShouldPropagateLegacyHeader())
+	return span
+}
@@ -0,0 +1,52 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apmamqp // import "go.elastic.co/apm/module/apmamqp/v2"
+
+import (
+	"context"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+
+	"go.elastic.co/apm/v2"
+)
+
+// StartTransaction starts a messaging transaction for processing d,
+// received from the named queue, continuing the trace propagated in
+// the message headers, if any, and returns the transaction and a
+// context containing it.
+//
+// The caller is responsible for ending the transaction once the
+// delivery has been processed.
+func StartTransaction(ctx context.Context, tracer *apm.Tracer, queue string, d amqp.Delivery) (*apm.Transaction, context.Context) {
+	var opts apm.TransactionOptions
+	if traceContext, ok := traceContextFromHeaders(d.Headers); ok {
+		opts.TraceContext = traceContext
+	}
+	tx := tracer.StartTransactionOptions("RabbitMQ RECEIVE from "+queue, "messaging", opts)
+	var age time.Duration
+	if !d.Timestamp.IsZero() {
+		age = time.Since(d.Timestamp)
+	}
+	tx.Context.SetMessage(apm.MessageContext{
+		QueueName:  queue,
+		RoutingKey: d.RoutingKey,
+		Age:        age,
+	})
+	return tx, apm.ContextWithTransaction(ctx, tx)
+}
@@ -0,0 +1,88 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apmamqp // import "go.elastic.co/apm/module/apmamqp/v2"
+
+import (
+	amqp "github.com/rabbitmq/amqp091-go"
+
+	"go.elastic.co/apm/module/apmhttp/v2"
+	"go.elastic.co/apm/v2"
+)
+
+const (
+	// traceparentHeader is the message header for W3C trace propagation.
+	traceparentHeader = "traceparent"
+
+	// elasticTraceparentHeader is the legacy message header for trace
+	// propagation. AMQP table keys are case-sensitive, so this is the
+	// lower-cased form of the legacy HTTP header name.
+	elasticTraceparentHeader = "elastic-apm-traceparent"
+
+	// tracestateHeader is the message header for W3C tracestate.
+	tracestateHeader = "tracestate"
+)
+
+// setHeaders returns a copy of headers with the trace context
+// headers set, replacing any existing values.
+//
+// The headers are copied because amqp.Publishing is passed by value,
+// and callers may reuse the same table for many messages.
+func setHeaders(headers amqp.Table, traceContext apm.TraceContext, propagateLegacyHeader bool) amqp.Table {
+	out := make(amqp.Table, len(headers)+3)
+	for k, v := range headers {
+		out[k] = v
+	}
+	traceparent := apmhttp.FormatTraceparentHeader(traceContext)
+	out[traceparentHeader] = traceparent
+	if propagateLegacyHeader {
+		out[elasticTraceparentHeader] = traceparent
+	}
+	if tracestate := traceContext.State.String(); tracestate != "" {
+		out[tracestateHeader] = tracestate
+	}
+	return out
+}
+
+// traceContextFromHeaders returns the trace context propagated in the
+// given message headers, and a boolean indicating whether it was found.
+func traceContextFromHeaders(headers amqp.Table) (apm.TraceContext, bool) {
+	traceparent := headerString(headers, traceparentHeader)
+	if traceparent == "" {
+		traceparent = headerString(headers, elasticTraceparentHeader)
+	}
+	traceContext, err := apmhttp.ParseTraceparentHeader(traceparent)
+	if err != nil {
+		return apm.TraceContext{}, false
+	}
+	if tracestate := headerString(headers, tracestateHeader); tracestate != "" {
+		traceContext.State, _ = apmhttp.ParseTracestateHeader(tracestate)
+	}
+	return traceContext, true
+}
+
+// headerString returns the value of the given header as a string, if
+// it is a string or byte slice; otherwise it returns an empty string.
+func headerString(headers amqp.Table, key string) string {
+	switch v := headers[key].(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	}
+	return ""
+}
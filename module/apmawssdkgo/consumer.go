@@ -0,0 +1,166 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apmawssdkgo // import "go.elastic.co/apm/module/apmawssdkgo/v2"
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.elastic.co/apm/module/apmhttp/v2"
+	"go.elastic.co/apm/v2"
+
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// maxBatchLinks is the maximum number of span links added to a
+// transaction started by StartSQSBatchTransaction.
+const maxBatchLinks = 1000
+
+// StartSQSTransaction starts a messaging transaction for processing msg,
+// received from the SQS queue with the given URL, continuing the trace
+// propagated in the message attributes, if any. StartSQSTransaction
+// returns the transaction and a context containing it.
+//
+// The message age and receive count are recorded if the message was
+// received with the "SentTimestamp" and "ApproximateReceiveCount"
+// attributes, the latter as the "delivery_count" label. The trace
+// context is only available if the message was received with its
+// message attributes. For example:
+//
+//	out, err := svc.ReceiveMessageWithContext(ctx, &sqs.ReceiveMessageInput{
+//		QueueUrl:              aws.String(queueURL),
+//		AttributeNames:        aws.StringSlice([]string{"All"}),
+//		MessageAttributeNames: aws.StringSlice([]string{"All"}),
+//	})
+//
+// The caller is responsible for ending the transaction once the message
+// has been processed.
+func StartSQSTransaction(ctx context.Context, tracer *apm.Tracer, queueURL string, msg *sqs.Message) (*apm.Transaction, context.Context) {
+	queueName := queueNameFromURL(queueURL)
+	var opts apm.TransactionOptions
+	if traceContext, ok := traceContextFromMessageAttributes(msg.MessageAttributes); ok {
+		opts.TraceContext = traceContext
+	}
+	tx := tracer.StartTransactionOptions(sqsReceiveName(queueName), "messaging", opts)
+
+	var age time.Duration
+	if sent, ok := int64Attribute(msg.Attributes, sqs.MessageSystemAttributeNameSentTimestamp); ok {
+		age = time.Since(time.Unix(0, sent*int64(time.Millisecond)))
+		if age < 0 {
+			age = 0
+		}
+	}
+	tx.Context.SetMessage(apm.MessageContext{QueueName: queueName, Age: age})
+	if count, ok := int64Attribute(msg.Attributes, sqs.MessageSystemAttributeNameApproximateReceiveCount); ok {
+		tx.Context.SetLabel("delivery_count", count)
+	}
+	return tx, apm.ContextWithTransaction(ctx, tx)
+}
+
+// StartSQSBatchTransaction starts a messaging transaction for processing
+// a batch of messages received from the SQS queue with the given URL,
+// and returns the transaction and a context containing it.
+//
+// The transaction starts a new trace, with links to the traces
+// propagated in the message attributes. See StartSQSTransaction for
+// the attributes which must be requested when receiving messages.
+//
+// The caller is responsible for ending the transaction once the
+// messages have been processed.
+func StartSQSBatchTransaction(ctx context.Context, tracer *apm.Tracer, queueURL string, msgs []*sqs.Message) (*apm.Transaction, context.Context) {
+	var links []apm.SpanLink
+	for _, msg := range msgs {
+		if len(links) == maxBatchLinks {
+			break
+		}
+		if traceContext, ok := traceContextFromMessageAttributes(msg.MessageAttributes); ok {
+			links = append(links, apm.SpanLink{
+				Trace: traceContext.Trace,
+				Span:  traceContext.Span,
+			})
+		}
+	}
+	queueName := queueNameFromURL(queueURL)
+	tx := tracer.StartTransactionOptions(sqsReceiveName(queueName), "messaging", apm.TransactionOptions{Links: links})
+	if queueName != "" {
+		tx.Context.SetMessage(apm.MessageContext{QueueName: queueName})
+	}
+	return tx, apm.ContextWithTransaction(ctx, tx)
+}
+
+func sqsReceiveName(queueName string) string {
+	name := "SQS RECEIVE"
+	if queueName != "" {
+		name += " from " + queueName
+	}
+	return name
+}
+
+// queueNameFromURL returns the queue name from an SQS queue URL.
+//
+// format: https://sqs.us-east-2.amazonaws.com/123456789012/MyQueue
+// should return MyQueue
+func queueNameFromURL(queueURL string) string {
+	return queueURL[strings.LastIndex(queueURL, "/")+1:]
+}
+
+// traceContextFromMessageAttributes returns the trace context propagated
+// in the given SQS message attributes, and a boolean indicating whether
+// it was found. Attribute names are matched case-insensitively, as other
+// agents may use lower case names.
+func traceContextFromMessageAttributes(attrs map[string]*sqs.MessageAttributeValue) (apm.TraceContext, bool) {
+	var traceparent, elasticTraceparent, tracestate string
+	for name, value := range attrs {
+		if value == nil || value.StringValue == nil {
+			continue
+		}
+		switch {
+		case strings.EqualFold(name, apmhttp.W3CTraceparentHeader):
+			traceparent = *value.StringValue
+		case strings.EqualFold(name, apmhttp.ElasticTraceparentHeader):
+			elasticTraceparent = *value.StringValue
+		case strings.EqualFold(name, apmhttp.TracestateHeader):
+			tracestate = *value.StringValue
+		}
+	}
+	if traceparent == "" {
+		traceparent = elasticTraceparent
+	}
+	traceContext, err := apmhttp.ParseTraceparentHeader(traceparent)
+	if err != nil {
+		return apm.TraceContext{}, false
+	}
+	if tracestate != "" {
+		traceContext.State, _ = apmhttp.ParseTracestateHeader(tracestate)
+	}
+	return traceContext, true
+}
+
+func int64Attribute(attrs map[string]*string, name string) (int64, bool) {
+	value, ok := attrs[name]
+	if !ok || value == nil {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(*value, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return n, true
+}
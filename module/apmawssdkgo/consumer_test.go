@@ -0,0 +1,141 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apmawssdkgo // import "go.elastic.co/apm/module/apmawssdkgo/v2"
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"go.elastic.co/apm/module/apmhttp/v2"
+	"go.elastic.co/apm/v2"
+	"go.elastic.co/apm/v2/apmtest"
+	"go.elastic.co/apm/v2/model"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testQueueURL = "https://sqs.us-east-2.amazonaws.com/123456789012/MyQueue"
+
+func TestStartSQSTransaction(t *testing.T) {
+	tracer := apmtest.NewRecordingTracer()
+	defer tracer.Close()
+
+	traceContext := apm.TraceContext{
+		Trace:   apm.TraceID{0: 1, 15: 1},
+		Span:    apm.SpanID{0: 2, 7: 2},
+		Options: apm.TraceOptions(0).WithRecorded(true),
+	}
+	sent := time.Now().Add(-time.Second).UnixNano() / int64(time.Millisecond)
+	msg := &sqs.Message{
+		Attributes: map[string]*string{
+			sqs.MessageSystemAttributeNameSentTimestamp:           aws.String(strconv.FormatInt(sent, 10)),
+			sqs.MessageSystemAttributeNameApproximateReceiveCount: aws.String("3"),
+		},
+		MessageAttributes: map[string]*sqs.MessageAttributeValue{
+			// Other agents use lower case attribute names.
+			"traceparent": {
+				DataType:    aws.String("String"),
+				StringValue: aws.String(apmhttp.FormatTraceparentHeader(traceContext)),
+			},
+		},
+	}
+	tx, ctx := StartSQSTransaction(context.Background(), tracer.Tracer, testQueueURL, msg)
+	assert.Equal(t, tx, apm.TransactionFromContext(ctx))
+	tx.End()
+	tracer.Flush(nil)
+
+	payloads := tracer.Payloads()
+	require.Len(t, payloads.Transactions, 1)
+	transaction := payloads.Transactions[0]
+	assert.Equal(t, "SQS RECEIVE from MyQueue", transaction.Name)
+	assert.Equal(t, "messaging", transaction.Type)
+	assert.Equal(t, model.TraceID(traceContext.Trace), transaction.TraceID)
+	assert.Equal(t, model.SpanID(traceContext.Span), transaction.ParentID)
+	require.NotNil(t, transaction.Context)
+	require.NotNil(t, transaction.Context.Message)
+	assert.Equal(t, "MyQueue", transaction.Context.Message.Queue.Name)
+	require.NotNil(t, transaction.Context.Message.Age)
+	assert.InDelta(t, 1000, transaction.Context.Message.Age.Millis, 500)
+	assert.Equal(t, model.IfaceMap{{Key: "delivery_count", Value: 3.0}}, transaction.Context.Tags)
+}
+
+func TestStartSQSTransactionNoAttributes(t *testing.T) {
+	tracer := apmtest.NewRecordingTracer()
+	defer tracer.Close()
+
+	tx, _ := StartSQSTransaction(context.Background(), tracer.Tracer, testQueueURL, &sqs.Message{})
+	tx.End()
+	tracer.Flush(nil)
+
+	payloads := tracer.Payloads()
+	require.Len(t, payloads.Transactions, 1)
+	transaction := payloads.Transactions[0]
+	assert.Zero(t, transaction.ParentID)
+	assert.Nil(t, transaction.Context.Message.Age)
+	assert.Empty(t, transaction.Context.Tags)
+}
+
+func TestStartSQSBatchTransaction(t *testing.T) {
+	tracer := apmtest.NewRecordingTracer()
+	defer tracer.Close()
+
+	traceContexts := []apm.TraceContext{{
+		Trace: apm.TraceID{0: 1},
+		Span:  apm.SpanID{0: 1},
+	}, {
+		Trace: apm.TraceID{0: 2},
+		Span:  apm.SpanID{0: 2},
+	}}
+	var msgs []*sqs.Message
+	for _, traceContext := range traceContexts {
+		traceparent := &sqs.MessageAttributeValue{
+			DataType:    aws.String("String"),
+			StringValue: aws.String(apmhttp.FormatTraceparentHeader(traceContext)),
+		}
+		msgs = append(msgs, &sqs.Message{
+			MessageAttributes: map[string]*sqs.MessageAttributeValue{
+				apmhttp.W3CTraceparentHeader: traceparent,
+			},
+		})
+	}
+	// Messages without a trace context are not linked.
+	msgs = append(msgs, &sqs.Message{})
+
+	tx, ctx := StartSQSBatchTransaction(context.Background(), tracer.Tracer, testQueueURL, msgs)
+	assert.Equal(t, tx, apm.TransactionFromContext(ctx))
+	tx.End()
+	tracer.Flush(nil)
+
+	payloads := tracer.Payloads()
+	require.Len(t, payloads.Transactions, 1)
+	transaction := payloads.Transactions[0]
+	assert.Equal(t, "SQS RECEIVE from MyQueue", transaction.Name)
+	assert.Equal(t, "messaging", transaction.Type)
+	assert.Zero(t, transaction.ParentID)
+	assert.Equal(t, []model.SpanLink{
+		{TraceID: model.TraceID{0: 1}, SpanID: model.SpanID{0: 1}},
+		{TraceID: model.TraceID{0: 2}, SpanID: model.SpanID{0: 2}},
+	}, transaction.Links)
+	require.NotNil(t, transaction.Context.Message)
+	assert.Equal(t, "MyQueue", transaction.Context.Message.Queue.Name)
+}
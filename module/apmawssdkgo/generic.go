@@ -0,0 +1,45 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apmawssdkgo // import "go.elastic.co/apm/module/apmawssdkgo/v2"
+
+import (
+	"go.elastic.co/apm/v2"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+// apmGeneric describes requests to AWS services without
+// service-specific instrumentation.
+type apmGeneric struct {
+	name, serviceName string
+}
+
+func newGeneric(req *request.Request) *apmGeneric {
+	return &apmGeneric{
+		name:        req.ClientInfo.ServiceID + " " + req.Operation.Name,
+		serviceName: req.ClientInfo.ServiceName,
+	}
+}
+
+func (g *apmGeneric) spanName() string { return g.name }
+
+func (g *apmGeneric) resource() string { return g.serviceName }
+
+func (g *apmGeneric) targetName() string { return "" }
+
+func (g *apmGeneric) setAdditional(*apm.Span) {}
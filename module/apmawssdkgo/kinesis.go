@@ -0,0 +1,75 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apmawssdkgo // import "go.elastic.co/apm/module/apmawssdkgo/v2"
+
+import (
+	"strings"
+
+	"go.elastic.co/apm/v2"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+type apmKinesis struct {
+	name, streamName string
+}
+
+func newKinesis(req *request.Request) *apmKinesis {
+	streamName := getStreamName(req)
+	name := req.ClientInfo.ServiceID + " " + req.Operation.Name
+	if streamName != "" {
+		name += " " + streamName
+	}
+	return &apmKinesis{name: name, streamName: streamName}
+}
+
+func (k *apmKinesis) spanName() string { return k.name }
+
+func (k *apmKinesis) resource() string {
+	if k.streamName == "" {
+		return serviceKinesis
+	}
+	return serviceKinesis + "/" + k.streamName
+}
+
+func (k *apmKinesis) targetName() string { return k.streamName }
+
+func (k *apmKinesis) setAdditional(span *apm.Span) {
+	if k.streamName != "" {
+		span.Context.SetMessage(apm.MessageSpanContext{
+			QueueName: k.streamName,
+		})
+	}
+}
+
+// getStreamName returns the stream name from the request parameters,
+// falling back to the stream ARN if the name is not set. GetRecords
+// identifies the stream only by its shard iterator, unless the SDK
+// version in use supports the StreamARN parameter.
+func getStreamName(req *request.Request) string {
+	if streamName := stringParam(req, "StreamName"); streamName != "" {
+		return streamName
+	}
+	// format: arn:aws:kinesis:us-east-1:123456789012:stream/my-stream
+	// should return my-stream
+	streamARN := stringParam(req, "StreamARN")
+	if idx := strings.LastIndex(streamARN, "stream/"); idx != -1 {
+		return streamARN[idx+len("stream/"):]
+	}
+	return ""
+}
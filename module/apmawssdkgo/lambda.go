@@ -0,0 +1,137 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apmawssdkgo // import "go.elastic.co/apm/module/apmawssdkgo/v2"
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+
+	"go.elastic.co/apm/module/apmhttp/v2"
+	"go.elastic.co/apm/v2"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/lambda"
+)
+
+// maxClientContextLength is the maximum length of the base64-encoded
+// client context accepted by the Lambda Invoke API.
+const maxClientContextLength = 3583
+
+type apmLambda struct {
+	name, opName, functionName string
+}
+
+func newLambda(req *request.Request) *apmLambda {
+	functionName := getFunctionName(stringParam(req, "FunctionName"))
+	name := req.ClientInfo.ServiceID + " " + req.Operation.Name
+	if functionName != "" {
+		name += " " + functionName
+	}
+	opName := req.Operation.Name
+	if opName == "Invoke" {
+		opName = "invoke"
+	}
+	return &apmLambda{name: name, opName: opName, functionName: functionName}
+}
+
+func (l *apmLambda) spanName() string { return l.name }
+
+func (l *apmLambda) resource() string {
+	if l.functionName == "" {
+		return serviceLambda
+	}
+	return serviceLambda + "/" + l.functionName
+}
+
+func (l *apmLambda) targetName() string { return l.functionName }
+
+func (l *apmLambda) setAdditional(span *apm.Span) {
+	span.Action = l.opName
+}
+
+// getFunctionName returns the function name from a Lambda function
+// name, ARN, or partial ARN, with any version or alias qualifier
+// removed. All of the following return my-function:
+//
+//   - my-function
+//   - my-function:v1
+//   - 123456789012:function:my-function
+//   - arn:aws:lambda:us-west-2:123456789012:function:my-function:alias
+func getFunctionName(name string) string {
+	if idx := strings.Index(name, "function:"); idx != -1 {
+		name = name[idx+len("function:"):]
+	}
+	if idx := strings.IndexByte(name, ':'); idx != -1 {
+		name = name[:idx]
+	}
+	return name
+}
+
+// addClientContextLambda adds the trace context to the "custom" object
+// of the client context of `Invoke` RPC calls, preserving any existing
+// client context. Other Lambda RPC calls are ignored.
+//
+// The client context is left unmodified if it cannot be decoded, or if
+// adding the trace context would exceed the maximum allowed length.
+func addClientContextLambda(req *request.Request, span *apm.Span, propagateLegacyHeader bool) {
+	input, ok := req.Params.(*lambda.InvokeInput)
+	if !ok {
+		return
+	}
+
+	clientContext := make(map[string]interface{})
+	if input.ClientContext != nil && *input.ClientContext != "" {
+		data, err := base64.StdEncoding.DecodeString(*input.ClientContext)
+		if err != nil {
+			return
+		}
+		if err := json.Unmarshal(data, &clientContext); err != nil {
+			return
+		}
+	}
+	custom, ok := clientContext["custom"].(map[string]interface{})
+	if !ok {
+		if clientContext["custom"] != nil {
+			return
+		}
+		custom = make(map[string]interface{})
+	}
+
+	traceContext := span.TraceContext()
+	traceparent := apmhttp.FormatTraceparentHeader(traceContext)
+	custom[apmhttp.W3CTraceparentHeader] = traceparent
+	if propagateLegacyHeader {
+		custom[apmhttp.ElasticTraceparentHeader] = traceparent
+	}
+	if tracestate := traceContext.State.String(); tracestate != "" {
+		custom[apmhttp.TracestateHeader] = tracestate
+	}
+	clientContext["custom"] = custom
+
+	data, err := json.Marshal(clientContext)
+	if err != nil {
+		return
+	}
+	encoded := base64.StdEncoding.EncodeToString(data)
+	if len(encoded) > maxClientContextLength {
+		return
+	}
+	input.ClientContext = aws.String(encoded)
+}
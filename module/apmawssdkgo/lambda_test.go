@@ -0,0 +1,139 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apmawssdkgo // import "go.elastic.co/apm/module/apmawssdkgo/v2"
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.elastic.co/apm/module/apmhttp/v2"
+	"go.elastic.co/apm/v2"
+	"go.elastic.co/apm/v2/apmtest"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLambda(t *testing.T) {
+	var clientContext string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		clientContext = r.Header.Get("X-Amz-Client-Context")
+		w.Write([]byte("{}"))
+	}))
+	defer ts.Close()
+
+	region := "us-west-2"
+	cfg := aws.NewConfig().
+		WithEndpoint(ts.URL).
+		WithRegion(region).
+		WithDisableSSL(true).
+		WithCredentials(credentials.AnonymousCredentials)
+
+	svc := lambda.New(WrapSession(session.Must(session.NewSession(cfg))))
+
+	for _, tc := range []struct {
+		fn                                   func(context.Context)
+		name, action, resource, functionName string
+		custom                               map[string]interface{}
+	}{
+		{
+			name:         "Lambda Invoke my-function",
+			action:       "invoke",
+			resource:     "lambda/my-function",
+			functionName: "my-function",
+			custom:       map[string]interface{}{},
+			fn: func(ctx context.Context) {
+				svc.InvokeWithContext(ctx, &lambda.InvokeInput{
+					FunctionName: aws.String("arn:aws:lambda:us-west-2:123456789012:function:my-function:alias"),
+				})
+			},
+		},
+		{
+			name:         "Lambda Invoke my-function",
+			action:       "invoke",
+			resource:     "lambda/my-function",
+			functionName: "my-function",
+			custom:       map[string]interface{}{"foo": "bar"},
+			fn: func(ctx context.Context) {
+				svc.InvokeWithContext(ctx, &lambda.InvokeInput{
+					ClientContext: aws.String(base64.StdEncoding.EncodeToString(
+						[]byte(`{"custom":{"foo":"bar"}}`),
+					)),
+					FunctionName: aws.String("my-function"),
+				})
+			},
+		},
+		{
+			name:     "Lambda ListFunctions",
+			action:   "ListFunctions",
+			resource: "lambda",
+			fn: func(ctx context.Context) {
+				svc.ListFunctionsWithContext(ctx, &lambda.ListFunctionsInput{})
+			},
+		},
+	} {
+		clientContext = ""
+		tx, spans, _ := apmtest.WithTransaction(tc.fn)
+		require.Len(t, spans, 1)
+		span := spans[0]
+
+		assert.Equal(t, tc.name, span.Name)
+		assert.Equal(t, "external", span.Type)
+		assert.Equal(t, "lambda", span.Subtype)
+		assert.Equal(t, tc.action, span.Action)
+
+		service := span.Context.Destination.Service
+		assert.Equal(t, "lambda", service.Name)
+		assert.Equal(t, "external", service.Type)
+		assert.Equal(t, tc.resource, service.Resource)
+
+		assert.Equal(t, region, span.Context.Destination.Cloud.Region)
+		assert.Equal(t, "lambda", span.Context.Service.Target.Type)
+		assert.Equal(t, tc.functionName, span.Context.Service.Target.Name)
+		assert.Equal(t, tx.ID, span.ParentID)
+
+		if tc.custom == nil {
+			assert.Empty(t, clientContext)
+			continue
+		}
+		data, err := base64.StdEncoding.DecodeString(clientContext)
+		require.NoError(t, err)
+		var decoded struct {
+			Custom map[string]interface{} `json:"custom"`
+		}
+		require.NoError(t, json.Unmarshal(data, &decoded))
+
+		traceparent := apmhttp.FormatTraceparentHeader(apm.TraceContext{
+			Trace:   apm.TraceID(tx.TraceID),
+			Span:    apm.SpanID(span.ID),
+			Options: apm.TraceOptions(0).WithRecorded(true),
+		})
+		tc.custom[apmhttp.W3CTraceparentHeader] = traceparent
+		tc.custom[apmhttp.ElasticTraceparentHeader] = traceparent
+		tc.custom[apmhttp.TracestateHeader] = "es=s:1"
+		assert.Equal(t, tc.custom, decoded.Custom)
+	}
+}
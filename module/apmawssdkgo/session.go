@@ -19,6 +19,7 @@ package apmawssdkgo // import "go.elastic.co/apm/module/apmawssdkgo/v2"
 
 import (
 	"context"
+	"reflect"
 
 	"go.elastic.co/apm/v2"
 	"go.elastic.co/apm/v2/stacktrace"
@@ -34,8 +35,9 @@ func init() {
 }
 
 // WrapSession wraps the provided AWS session with handlers that hook into the
-// AWS SDK's request lifecycle. Supported services are listed in serviceTypeMap
-// variable below.
+// AWS SDK's request lifecycle. Services with specific instrumentation are listed
+// in the serviceTypeMap variable below; requests to other services are reported
+// as generic spans of type "external".
 func WrapSession(s *session.Session) *session.Session {
 	s.Handlers.Build.PushFrontNamed(request.NamedHandler{
 		Name: "go.elastic.co/apm/module/apmawssdkgo/build",
@@ -58,18 +60,30 @@ func WrapSession(s *session.Session) *session.Session {
 type awsSpanKey struct{}
 
 const (
-	serviceS3       = "s3"
-	serviceDynamoDB = "dynamodb"
-	serviceSQS      = "sqs"
-	serviceSNS      = "sns"
+	serviceS3             = "s3"
+	serviceDynamoDB       = "dynamodb"
+	serviceSQS            = "sqs"
+	serviceSNS            = "sns"
+	serviceKinesis        = "kinesis"
+	serviceLambda         = "lambda"
+	serviceSecretsManager = "secretsmanager"
+	serviceSTS            = "sts"
+
+	// genericSpanType is the span type for services
+	// not listed in serviceTypeMap.
+	genericSpanType = "external"
 )
 
 var (
 	serviceTypeMap = map[string]string{
-		serviceS3:       "storage",
-		serviceDynamoDB: "db",
-		serviceSQS:      "messaging",
-		serviceSNS:      "messaging",
+		serviceS3:             "storage",
+		serviceDynamoDB:       "db",
+		serviceSQS:            "messaging",
+		serviceSNS:            "messaging",
+		serviceKinesis:        "messaging",
+		serviceLambda:         "external",
+		serviceSecretsManager: "storage",
+		serviceSTS:            "external",
 	}
 )
 
@@ -84,8 +98,7 @@ func build(req *request.Request) {
 	spanSubtype := req.ClientInfo.ServiceName
 	spanType, ok := serviceTypeMap[spanSubtype]
 	if !ok {
-		// Not a supported service.
-		return
+		spanType = genericSpanType
 	}
 	if spanSubtype == serviceSNS && !supportedSNSMethod(req) {
 		return
@@ -113,6 +126,8 @@ func build(req *request.Request) {
 		addMessageAttributesSQS(req, span, tx.ShouldPropagateLegacyHeader())
 	case serviceSNS:
 		addMessageAttributesSNS(req, span, tx.ShouldPropagateLegacyHeader())
+	case serviceLambda:
+		addClientContextLambda(req, span, tx.ShouldPropagateLegacyHeader())
 	}
 
 	ctx = apm.ContextWithSpan(ctx, span)
@@ -150,9 +165,16 @@ func send(req *request.Request) {
 			// Unsupported method type or queue name.
 			return
 		}
+	case serviceKinesis:
+		svc = newKinesis(req)
+	case serviceLambda:
+		svc = newLambda(req)
+	case serviceSecretsManager:
+		svc = newSecretsManager(req)
+	case serviceSTS:
+		svc = newSTS(req)
 	default:
-		// Unsupported type
-		return
+		svc = newGeneric(req)
 	}
 
 	span.Name = svc.spanName()
@@ -193,3 +215,23 @@ func complete(req *request.Request) {
 		apm.CaptureError(ctx, err).Send()
 	}
 }
+
+// stringParam returns the value of the named *string field of the
+// request parameters, or the empty string if there is no such field
+// or it is nil.
+func stringParam(req *request.Request, name string) string {
+	params := reflect.ValueOf(req.Params)
+	if params.Kind() != reflect.Ptr || params.IsNil() {
+		return ""
+	}
+	params = params.Elem()
+	if params.Kind() != reflect.Struct {
+		return ""
+	}
+	if v := params.FieldByName(name); v.IsValid() {
+		if s, ok := v.Interface().(*string); ok && s != nil {
+			return *s
+		}
+	}
+	return ""
+}
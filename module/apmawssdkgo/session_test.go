@@ -20,19 +20,22 @@ package apmawssdkgo // import "go.elastic.co/apm/module/apmawssdkgo/v2"
 import (
 	"bytes"
 	"context"
+	"net/http"
+	"net/http/httptest"
 	"testing"
-	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/athena"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/kinesis"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/sts"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
-	"go.elastic.co/apm/v2"
 	"go.elastic.co/apm/v2/apmtest"
 )
 
@@ -178,32 +181,144 @@ func TestDynamoDB(t *testing.T) {
 
 }
 
-func TestUnsupportedServices(t *testing.T) {
+func TestServices(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("{}"))
+	}))
+	defer ts.Close()
+
 	region := "us-west-2"
 	cfg := aws.NewConfig().
+		WithEndpoint(ts.URL).
 		WithRegion(region).
 		WithDisableSSL(true).
 		WithCredentials(credentials.AnonymousCredentials)
 
-	session := session.Must(session.NewSession(cfg))
-	wrapped := WrapSession(session)
-	svc := athena.New(wrapped)
-
-	tx := apm.DefaultTracer().StartTransaction("send-email", "test-tx")
-	span := tx.StartSpan("test-span", "send-email", nil)
-	defer span.End()
+	session := WrapSession(session.Must(session.NewSession(cfg)))
+	kinesisClient := kinesis.New(session)
+	secretsManagerClient := secretsmanager.New(session)
+	stsClient := sts.New(session)
+	athenaClient := athena.New(session)
 
-	ctx := apm.ContextWithSpan(context.Background(), span)
-	namedQuery := &athena.BatchGetNamedQueryInput{
-		NamedQueryIds: []*string{aws.String("query")},
+	for _, tc := range []struct {
+		fn                              func(context.Context)
+		name, spanType, subtype, action string
+		resource, targetName, queueName string
+	}{
+		{
+			name:       "Kinesis PutRecord my-stream",
+			spanType:   "messaging",
+			subtype:    "kinesis",
+			action:     "PutRecord",
+			resource:   "kinesis/my-stream",
+			targetName: "my-stream",
+			queueName:  "my-stream",
+			fn: func(ctx context.Context) {
+				kinesisClient.PutRecordWithContext(ctx, &kinesis.PutRecordInput{
+					Data:         []byte("data"),
+					PartitionKey: aws.String("key"),
+					StreamName:   aws.String("my-stream"),
+				})
+			},
+		},
+		{
+			name:       "Kinesis PutRecords my-stream",
+			spanType:   "messaging",
+			subtype:    "kinesis",
+			action:     "PutRecords",
+			resource:   "kinesis/my-stream",
+			targetName: "my-stream",
+			queueName:  "my-stream",
+			fn: func(ctx context.Context) {
+				kinesisClient.PutRecordsWithContext(ctx, &kinesis.PutRecordsInput{
+					Records: []*kinesis.PutRecordsRequestEntry{{
+						Data:         []byte("data"),
+						PartitionKey: aws.String("key"),
+					}},
+					StreamName: aws.String("my-stream"),
+				})
+			},
+		},
+		{
+			name:     "Kinesis GetRecords",
+			spanType: "messaging",
+			subtype:  "kinesis",
+			action:   "GetRecords",
+			resource: "kinesis",
+			fn: func(ctx context.Context) {
+				kinesisClient.GetRecordsWithContext(ctx, &kinesis.GetRecordsInput{
+					ShardIterator: aws.String("shard-iterator"),
+				})
+			},
+		},
+		{
+			name:       "Secrets Manager GetSecretValue my-secret-AbCdEf",
+			spanType:   "storage",
+			subtype:    "secretsmanager",
+			action:     "GetSecretValue",
+			resource:   "secretsmanager/my-secret-AbCdEf",
+			targetName: "my-secret-AbCdEf",
+			fn: func(ctx context.Context) {
+				secretsManagerClient.GetSecretValueWithContext(ctx, &secretsmanager.GetSecretValueInput{
+					SecretId: aws.String("arn:aws:secretsmanager:us-west-2:123456789012:secret:my-secret-AbCdEf"),
+				})
+			},
+		},
+		{
+			name:     "STS AssumeRole my-role",
+			spanType: "external",
+			subtype:  "sts",
+			action:   "AssumeRole",
+			resource: "sts",
+			fn: func(ctx context.Context) {
+				stsClient.AssumeRoleWithContext(ctx, &sts.AssumeRoleInput{
+					RoleArn:         aws.String("arn:aws:iam::123456789012:role/path/my-role"),
+					RoleSessionName: aws.String("session"),
+				})
+			},
+		},
+		{
+			name:     "Athena BatchGetNamedQuery",
+			spanType: "external",
+			subtype:  "athena",
+			action:   "BatchGetNamedQuery",
+			resource: "athena",
+			fn: func(ctx context.Context) {
+				athenaClient.BatchGetNamedQueryWithContext(ctx, &athena.BatchGetNamedQueryInput{
+					NamedQueryIds: []*string{aws.String("query")},
+				})
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tx, spans, _ := apmtest.WithTransaction(tc.fn)
+			require.Len(t, spans, 1)
+			span := spans[0]
+
+			assert.Equal(t, tc.name, span.Name)
+			assert.Equal(t, tc.spanType, span.Type)
+			assert.Equal(t, tc.subtype, span.Subtype)
+			assert.Equal(t, tc.action, span.Action)
+
+			service := span.Context.Destination.Service
+			assert.Equal(t, tc.subtype, service.Name)
+			assert.Equal(t, tc.spanType, service.Type)
+			assert.Equal(t, tc.resource, service.Resource)
+
+			assert.Equal(t, region, span.Context.Destination.Cloud.Region)
+			assert.Equal(t, tc.subtype, span.Context.Service.Target.Type)
+			assert.Equal(t, tc.targetName, span.Context.Service.Target.Name)
+
+			if tc.queueName != "" {
+				require.NotNil(t, span.Context.Message)
+				assert.Equal(t, tc.queueName, span.Context.Message.Queue.Name)
+			} else {
+				assert.Nil(t, span.Context.Message)
+			}
+
+			assert.Equal(t, tx.ID, span.ParentID)
+		})
 	}
-	// Setting a timeout so the request fails fast. This doesn't affect
-	// testing that we noop on unsupported services.
-	ctx, cancel := context.WithTimeout(ctx, time.Millisecond)
-	defer cancel()
-
-	svc.BatchGetNamedQueryWithContext(ctx, namedQuery)
-	assert.NotPanics(t, func() { svc.BatchGetNamedQueryWithContext(ctx, namedQuery) })
 }
 
 func TestSpanDropped(t *testing.T) {
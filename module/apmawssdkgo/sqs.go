@@ -150,6 +150,5 @@ func operationDirection(operationName string) string {
 }
 
 func getQueueName(req *request.Request) string {
-	parts := strings.Split(req.HTTPRequest.FormValue("QueueUrl"), "/")
-	return parts[len(parts)-1]
+	return queueNameFromURL(req.HTTPRequest.FormValue("QueueUrl"))
 }
@@ -0,0 +1,58 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apmawssdkgo // import "go.elastic.co/apm/module/apmawssdkgo/v2"
+
+import (
+	"strings"
+
+	"go.elastic.co/apm/v2"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+type apmSTS struct {
+	name string
+}
+
+func newSTS(req *request.Request) *apmSTS {
+	name := req.ClientInfo.ServiceID + " " + req.Operation.Name
+	if roleName := getRoleName(stringParam(req, "RoleArn")); roleName != "" {
+		name += " " + roleName
+	}
+	return &apmSTS{name: name}
+}
+
+func (s *apmSTS) spanName() string { return s.name }
+
+func (s *apmSTS) resource() string { return serviceSTS }
+
+func (s *apmSTS) targetName() string { return "" }
+
+func (s *apmSTS) setAdditional(*apm.Span) {}
+
+// getRoleName returns the role name from an IAM role ARN, as passed
+// to the AssumeRole family of operations.
+//
+// format: arn:aws:iam::123456789012:role/path/my-role
+// should return my-role
+func getRoleName(roleARN string) string {
+	if !strings.Contains(roleARN, ":role/") {
+		return ""
+	}
+	return roleARN[strings.LastIndex(roleARN, "/")+1:]
+}
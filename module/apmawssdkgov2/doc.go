@@ -0,0 +1,34 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// Package apmawssdkgov2 provides middleware for instrumenting AWS SDK for
+// Go v2 clients, so that AWS requests are reported as spans within the
+// current transaction.
+//
+// The following services are supported:
+//
+//   - S3
+//   - DynamoDB
+//   - SQS
+//   - SNS
+//   - Kinesis
+//   - Lambda (Invoke)
+//   - Secrets Manager
+//
+// Messages sent to SQS and SNS carry the trace context in their
+// message attributes.
+package apmawssdkgov2 // import "go.elastic.co/apm/module/apmawssdkgov2/v2"
@@ -0,0 +1,66 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apmawssdkgov2 // import "go.elastic.co/apm/module/apmawssdkgov2/v2"
+
+import (
+	"go.elastic.co/apm/v2"
+)
+
+type dynamoDB struct {
+	tableName string
+	// keyConditionExpression is only available on Query operations.
+	keyConditionExpression string
+
+	name, region string
+}
+
+func newDynamoDB(op operation) *dynamoDB {
+	db := &dynamoDB{
+		tableName:              stringField(op.params, "TableName"),
+		keyConditionExpression: stringField(op.params, "KeyConditionExpression"),
+		region:                 op.region,
+	}
+	db.name = op.serviceID + " " + op.name
+	if db.tableName != "" {
+		db.name += " " + db.tableName
+	}
+	return db
+}
+
+func (d *dynamoDB) spanName() string {
+	return d.name
+}
+
+func (d *dynamoDB) resource() string {
+	return d.tableName
+}
+
+func (d *dynamoDB) targetName() string {
+	return d.region
+}
+
+func (d *dynamoDB) setAdditional(span *apm.Span) {
+	dbSpanCtx := apm.DatabaseSpanContext{
+		Instance: d.region,
+		Type:     serviceDynamoDB,
+	}
+	if span.Action == "Query" {
+		dbSpanCtx.Statement = d.keyConditionExpression
+	}
+	span.Context.SetDatabase(dbSpanCtx)
+}
@@ -0,0 +1,73 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apmawssdkgov2 // import "go.elastic.co/apm/module/apmawssdkgov2/v2"
+
+import (
+	"strings"
+
+	"go.elastic.co/apm/v2"
+)
+
+type apmLambda struct {
+	name, functionName string
+}
+
+func newLambda(op operation) (*apmLambda, error) {
+	if op.name != "Invoke" {
+		return nil, errMethodNotSupported
+	}
+	functionName := getFunctionName(stringField(op.params, "FunctionName"))
+	name := op.serviceID + " " + op.name
+	if functionName != "" {
+		name += " " + functionName
+	}
+	return &apmLambda{name: name, functionName: functionName}, nil
+}
+
+func (l *apmLambda) spanName() string { return l.name }
+
+func (l *apmLambda) resource() string {
+	if l.functionName == "" {
+		return serviceLambda
+	}
+	return serviceLambda + "/" + l.functionName
+}
+
+func (l *apmLambda) targetName() string { return l.functionName }
+
+func (l *apmLambda) setAdditional(span *apm.Span) {
+	span.Action = "invoke"
+}
+
+// getFunctionName returns the function name from a Lambda function
+// name, ARN, or partial ARN, with any version or alias qualifier
+// removed. All of the following return my-function:
+//
+//   - my-function
+//   - my-function:v1
+//   - 123456789012:function:my-function
+//   - arn:aws:lambda:us-west-2:123456789012:function:my-function:alias
+func getFunctionName(name string) string {
+	if idx := strings.Index(name, "function:"); idx != -1 {
+		name = name[idx+len("function:"):]
+	}
+	if idx := strings.IndexByte(name, ':'); idx != -1 {
+		name = name[:idx]
+	}
+	return name
+}
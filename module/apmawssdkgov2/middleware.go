@@ -0,0 +1,272 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apmawssdkgov2 // import "go.elastic.co/apm/module/apmawssdkgov2/v2"
+
+import (
+	"context"
+	"errors"
+	"reflect"
+
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+
+	"go.elastic.co/apm/v2"
+	"go.elastic.co/apm/v2/stacktrace"
+)
+
+func init() {
+	stacktrace.RegisterLibraryPackage(
+		"github.com/aws/aws-sdk-go-v2",
+		"github.com/aws/smithy-go",
+	)
+}
+
+const (
+	initializeMiddlewareID = "go.elastic.co/apm/module/apmawssdkgov2/initialize"
+	finalizeMiddlewareID   = "go.elastic.co/apm/module/apmawssdkgov2/finalize"
+)
+
+// AppendMiddlewares appends to apiOptions a function which adds middleware
+// to the AWS SDK's middleware stack, reporting requests to supported services
+// as spans within the transaction contained in the request context.
+//
+// AppendMiddlewares is typically called with the APIOptions of the aws.Config
+// returned by config.LoadDefaultConfig, so that all clients created from the
+// config are instrumented:
+//
+//	cfg, err := config.LoadDefaultConfig(ctx)
+//	apmawssdkgov2.AppendMiddlewares(&cfg.APIOptions)
+//	client := s3.NewFromConfig(cfg)
+func AppendMiddlewares(apiOptions *[]func(*middleware.Stack) error) {
+	*apiOptions = append(*apiOptions, addMiddlewares)
+}
+
+func addMiddlewares(stack *middleware.Stack) error {
+	if err := stack.Initialize.Add(
+		middleware.InitializeMiddlewareFunc(initializeMiddlewareID, initialize),
+		middleware.After,
+	); err != nil {
+		return err
+	}
+	return stack.Finalize.Add(
+		middleware.FinalizeMiddlewareFunc(finalizeMiddlewareID, finalize),
+		middleware.After,
+	)
+}
+
+// We add AWS spans to context using a separate context key, to avoid
+// modifying spans not created by the initialize middleware.
+type awsSpanKey struct{}
+
+const (
+	serviceS3             = "s3"
+	serviceDynamoDB       = "dynamodb"
+	serviceSQS            = "sqs"
+	serviceSNS            = "sns"
+	serviceKinesis        = "kinesis"
+	serviceLambda         = "lambda"
+	serviceSecretsManager = "secretsmanager"
+)
+
+var (
+	errMethodNotSupported = errors.New("method not supported")
+
+	// serviceSubtypeMap maps AWS service IDs to span subtypes.
+	serviceSubtypeMap = map[string]string{
+		"S3":              serviceS3,
+		"DynamoDB":        serviceDynamoDB,
+		"SQS":             serviceSQS,
+		"SNS":             serviceSNS,
+		"Kinesis":         serviceKinesis,
+		"Lambda":          serviceLambda,
+		"Secrets Manager": serviceSecretsManager,
+	}
+
+	// serviceTypeMap maps span subtypes to span types.
+	serviceTypeMap = map[string]string{
+		serviceS3:             "storage",
+		serviceDynamoDB:       "db",
+		serviceSQS:            "messaging",
+		serviceSNS:            "messaging",
+		serviceKinesis:        "messaging",
+		serviceLambda:         "external",
+		serviceSecretsManager: "storage",
+	}
+)
+
+type service interface {
+	spanName() string
+	resource() string
+	targetName() string
+	setAdditional(*apm.Span)
+}
+
+// propagator is implemented by services which propagate
+// the trace context in the request parameters.
+type propagator interface {
+	propagate(params interface{}, traceContext apm.TraceContext, propagateLegacyHeader bool)
+}
+
+// operation holds information about an AWS operation,
+// used for constructing services.
+type operation struct {
+	serviceID string
+	name      string
+	region    string
+	params    interface{}
+}
+
+func newService(spanSubtype string, op operation) (service, error) {
+	switch spanSubtype {
+	case serviceS3:
+		return newS3(op), nil
+	case serviceDynamoDB:
+		return newDynamoDB(op), nil
+	case serviceSQS:
+		return newSQS(op)
+	case serviceSNS:
+		return newSNS(op)
+	case serviceKinesis:
+		return newKinesis(op), nil
+	case serviceLambda:
+		return newLambda(op)
+	case serviceSecretsManager:
+		return newSecretsManager(op), nil
+	}
+	return nil, errMethodNotSupported
+}
+
+func initialize(
+	ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler,
+) (middleware.InitializeOutput, middleware.Metadata, error) {
+	tx := apm.TransactionFromContext(ctx)
+	if tx == nil {
+		return next.HandleInitialize(ctx, in)
+	}
+	serviceID := awsmiddleware.GetServiceID(ctx)
+	spanSubtype, ok := serviceSubtypeMap[serviceID]
+	if !ok {
+		// Not a supported service.
+		return next.HandleInitialize(ctx, in)
+	}
+	op := operation{
+		serviceID: serviceID,
+		name:      awsmiddleware.GetOperationName(ctx),
+		region:    awsmiddleware.GetRegion(ctx),
+		params:    in.Parameters,
+	}
+	svc, err := newService(spanSubtype, op)
+	if err != nil {
+		// Unsupported operation.
+		return next.HandleInitialize(ctx, in)
+	}
+
+	traceContext := tx.TraceContext()
+	var span *apm.Span
+	if traceContext.Options.Recorded() {
+		span, _ = apm.StartSpanOptions(ctx, svc.spanName(), serviceTypeMap[spanSubtype], apm.SpanOptions{
+			ExitSpan: true,
+		})
+		if span.Dropped() {
+			span.End()
+			span = nil
+		} else {
+			traceContext = span.TraceContext()
+		}
+	}
+	if p, ok := svc.(propagator); ok {
+		p.propagate(in.Parameters, traceContext, tx.ShouldPropagateLegacyHeader())
+	}
+	if span == nil {
+		return next.HandleInitialize(ctx, in)
+	}
+	defer span.End()
+
+	span.Subtype = spanSubtype
+	span.Action = op.name
+	span.Context.SetServiceTarget(apm.ServiceTargetSpanContext{
+		Type: spanSubtype,
+		Name: svc.targetName(),
+	})
+	if op.region != "" {
+		span.Context.SetDestinationCloud(apm.DestinationCloudSpanContext{
+			Region: op.region,
+		})
+	}
+	svc.setAdditional(span)
+
+	ctx = apm.ContextWithSpan(ctx, span)
+	ctx = context.WithValue(ctx, awsSpanKey{}, span)
+	out, metadata, err := next.HandleInitialize(ctx, in)
+
+	// The destination service is set after the request has been made,
+	// as the finalize middleware's call to SetHTTPRequest overrides it.
+	span.Context.SetDestinationService(apm.DestinationServiceSpanContext{
+		Name:     spanSubtype,
+		Resource: svc.resource(),
+	})
+	if resp, ok := awsmiddleware.GetRawResponse(metadata).(*smithyhttp.Response); ok {
+		span.Context.SetHTTPStatusCode(resp.StatusCode)
+	}
+	if err != nil {
+		var respErr *smithyhttp.ResponseError
+		if errors.As(err, &respErr) {
+			span.Context.SetHTTPStatusCode(respErr.HTTPStatusCode())
+		}
+		span.Outcome = "failure"
+		apm.CaptureError(ctx, err).Send()
+	} else {
+		span.Outcome = "success"
+	}
+	return out, metadata, err
+}
+
+// finalize records the HTTP request in the span created by initialize.
+// The finalize middleware is called for each attempt, so the span
+// records the request for the final attempt.
+func finalize(
+	ctx context.Context, in middleware.FinalizeInput, next middleware.FinalizeHandler,
+) (middleware.FinalizeOutput, middleware.Metadata, error) {
+	if span, ok := ctx.Value(awsSpanKey{}).(*apm.Span); ok {
+		if req, ok := in.Request.(*smithyhttp.Request); ok && req.URL != nil {
+			span.Context.SetHTTPRequest(req.Request)
+		}
+	}
+	return next.HandleFinalize(ctx, in)
+}
+
+// stringField returns the value of the named *string field of the
+// struct pointed to by params, or the empty string if there is no
+// such field or its value is nil.
+func stringField(params interface{}, name string) string {
+	v := reflect.ValueOf(params)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return ""
+	}
+	v = v.Elem()
+	if v.Kind() != reflect.Struct {
+		return ""
+	}
+	if f := v.FieldByName(name); f.IsValid() {
+		if s, ok := f.Interface().(*string); ok && s != nil {
+			return *s
+		}
+	}
+	return ""
+}
@@ -0,0 +1,285 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apmawssdkgov2 // import "go.elastic.co/apm/module/apmawssdkgov2/v2"
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis"
+	lambda "github.com/aws/aws-sdk-go-v2/service/lambda"
+	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.elastic.co/apm/v2/apmtest"
+	"go.elastic.co/apm/v2/model"
+)
+
+const testRegion = "us-west-2"
+
+func TestS3(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	defer server.Close()
+	client := awss3.NewFromConfig(newTestConfig(server), func(o *awss3.Options) {
+		o.UsePathStyle = true
+	})
+
+	tx, spans, errs := apmtest.WithTransaction(func(ctx context.Context) {
+		_, err := client.PutObject(ctx, &awss3.PutObjectInput{
+			Bucket: aws.String("bucket"),
+			Key:    aws.String("some key"),
+			Body:   bytes.NewReader([]byte("some random body")),
+		})
+		require.NoError(t, err)
+	})
+	require.Len(t, spans, 1)
+	assert.Empty(t, errs)
+
+	span := spans[0]
+	assert.Equal(t, tx.ID, span.ParentID)
+	assert.Equal(t, "S3 PutObject bucket", span.Name)
+	assert.Equal(t, "storage", span.Type)
+	assert.Equal(t, "s3", span.Subtype)
+	assert.Equal(t, "PutObject", span.Action)
+	assert.Equal(t, "success", span.Outcome)
+
+	serverURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	service := span.Context.Destination.Service
+	assert.Equal(t, "s3", service.Name)
+	assert.Equal(t, "bucket", service.Resource)
+	assert.Equal(t, "storage", service.Type)
+	assert.Equal(t, serverURL.Hostname(), span.Context.Destination.Address)
+	require.NotNil(t, span.Context.Destination.Cloud)
+	assert.Equal(t, testRegion, span.Context.Destination.Cloud.Region)
+	assert.Equal(t, "s3", span.Context.Service.Target.Type)
+	assert.Equal(t, "bucket", span.Context.Service.Target.Name)
+	require.NotNil(t, span.Context.HTTP)
+	assert.Equal(t, http.StatusOK, span.Context.HTTP.StatusCode)
+	assert.Equal(t, "/bucket/some key", span.Context.HTTP.URL.Path)
+}
+
+func TestS3Error(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+	client := awss3.NewFromConfig(newTestConfig(server), func(o *awss3.Options) {
+		o.UsePathStyle = true
+	})
+
+	tx, spans, errs := apmtest.WithTransaction(func(ctx context.Context) {
+		_, err := client.GetObject(ctx, &awss3.GetObjectInput{
+			Bucket: aws.String("bucket"),
+			Key:    aws.String("key"),
+		})
+		require.Error(t, err)
+	})
+	require.Len(t, spans, 1)
+	require.Len(t, errs, 1)
+
+	span := spans[0]
+	assert.Equal(t, "S3 GetObject bucket", span.Name)
+	assert.Equal(t, "failure", span.Outcome)
+	assert.Equal(t, http.StatusNotFound, span.Context.HTTP.StatusCode)
+	assert.Equal(t, tx.ID, errs[0].TransactionID)
+	assert.Equal(t, span.ID, errs[0].ParentID)
+}
+
+func TestDynamoDB(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("{}"))
+	}))
+	defer server.Close()
+	client := dynamodb.NewFromConfig(newTestConfig(server))
+
+	for _, tc := range []struct {
+		fn                      func(context.Context) error
+		name, action, statement string
+	}{{
+		name:      "DynamoDB Query Music",
+		statement: "Artist = :v1",
+		action:    "Query",
+		fn: func(ctx context.Context) error {
+			_, err := client.Query(ctx, &dynamodb.QueryInput{
+				KeyConditionExpression: aws.String("Artist = :v1"),
+				TableName:              aws.String("Music"),
+			})
+			return err
+		},
+	}, {
+		name:   "DynamoDB ListTables",
+		action: "ListTables",
+		fn: func(ctx context.Context) error {
+			_, err := client.ListTables(ctx, &dynamodb.ListTablesInput{})
+			return err
+		},
+	}} {
+		_, spans, _ := apmtest.WithTransaction(func(ctx context.Context) {
+			require.NoError(t, tc.fn(ctx))
+		})
+		require.Len(t, spans, 1)
+		span := spans[0]
+		assert.Equal(t, tc.name, span.Name)
+		assert.Equal(t, "db", span.Type)
+		assert.Equal(t, "dynamodb", span.Subtype)
+		assert.Equal(t, tc.action, span.Action)
+		assert.Equal(t, &model.DatabaseSpanContext{
+			Instance:  testRegion,
+			Type:      "dynamodb",
+			Statement: tc.statement,
+		}, span.Context.Database)
+		assert.Equal(t, "dynamodb", span.Context.Service.Target.Type)
+		assert.Equal(t, testRegion, span.Context.Service.Target.Name)
+	}
+}
+
+func TestKinesis(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("{}"))
+	}))
+	defer server.Close()
+	client := kinesis.NewFromConfig(newTestConfig(server))
+
+	for _, input := range []*kinesis.PutRecordInput{{
+		StreamName: aws.String("my-stream"),
+	}, {
+		StreamARN: aws.String("arn:aws:kinesis:us-west-2:123456789012:stream/my-stream"),
+	}} {
+		input.Data = []byte("data")
+		input.PartitionKey = aws.String("key")
+		_, spans, _ := apmtest.WithTransaction(func(ctx context.Context) {
+			_, err := client.PutRecord(ctx, input)
+			require.NoError(t, err)
+		})
+		require.Len(t, spans, 1)
+		span := spans[0]
+		assert.Equal(t, "Kinesis PutRecord my-stream", span.Name)
+		assert.Equal(t, "messaging", span.Type)
+		assert.Equal(t, "kinesis", span.Subtype)
+		assert.Equal(t, "PutRecord", span.Action)
+		assert.Equal(t, "kinesis/my-stream", span.Context.Destination.Service.Resource)
+		assert.Equal(t, "my-stream", span.Context.Message.Queue.Name)
+		assert.Equal(t, "my-stream", span.Context.Service.Target.Name)
+	}
+}
+
+func TestLambda(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+	client := lambda.NewFromConfig(newTestConfig(server))
+
+	_, spans, _ := apmtest.WithTransaction(func(ctx context.Context) {
+		_, err := client.Invoke(ctx, &lambda.InvokeInput{
+			FunctionName: aws.String("arn:aws:lambda:us-west-2:123456789012:function:my-function:alias"),
+		})
+		require.NoError(t, err)
+		_, err = client.ListFunctions(ctx, &lambda.ListFunctionsInput{})
+		require.NoError(t, err)
+	})
+
+	// Only Invoke is instrumented.
+	require.Len(t, spans, 1)
+	span := spans[0]
+	assert.Equal(t, "Lambda Invoke my-function", span.Name)
+	assert.Equal(t, "external", span.Type)
+	assert.Equal(t, "lambda", span.Subtype)
+	assert.Equal(t, "invoke", span.Action)
+	assert.Equal(t, "lambda/my-function", span.Context.Destination.Service.Resource)
+	assert.Equal(t, "lambda", span.Context.Service.Target.Type)
+	assert.Equal(t, "my-function", span.Context.Service.Target.Name)
+}
+
+func TestSecretsManager(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("{}"))
+	}))
+	defer server.Close()
+	client := secretsmanager.NewFromConfig(newTestConfig(server))
+
+	_, spans, _ := apmtest.WithTransaction(func(ctx context.Context) {
+		_, err := client.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+			SecretId: aws.String("arn:aws:secretsmanager:us-west-2:123456789012:secret:my-secret-AbCdEf"),
+		})
+		require.NoError(t, err)
+	})
+	require.Len(t, spans, 1)
+	span := spans[0]
+	assert.Equal(t, "Secrets Manager GetSecretValue my-secret-AbCdEf", span.Name)
+	assert.Equal(t, "storage", span.Type)
+	assert.Equal(t, "secretsmanager", span.Subtype)
+	assert.Equal(t, "GetSecretValue", span.Action)
+	assert.Equal(t, "secretsmanager/my-secret-AbCdEf", span.Context.Destination.Service.Resource)
+}
+
+func TestNoTransaction(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("{}"))
+	}))
+	defer server.Close()
+	client := dynamodb.NewFromConfig(newTestConfig(server))
+
+	tracer := apmtest.NewRecordingTracer()
+	defer tracer.Close()
+	_, err := client.ListTables(context.Background(), &dynamodb.ListTablesInput{})
+	require.NoError(t, err)
+	tracer.Flush(nil)
+	assert.Empty(t, tracer.Payloads().Spans)
+}
+
+func TestSpanDropped(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("{}"))
+	}))
+	defer server.Close()
+	client := dynamodb.NewFromConfig(newTestConfig(server))
+
+	tracer := apmtest.NewRecordingTracer()
+	defer tracer.Close()
+	tracer.SetMaxSpans(1)
+
+	tx, spans, _ := tracer.WithTransaction(func(ctx context.Context) {
+		for i := 0; i < 2; i++ {
+			_, err := client.ListTables(ctx, &dynamodb.ListTablesInput{})
+			require.NoError(t, err)
+		}
+	})
+	require.Len(t, spans, 1)
+	assert.Equal(t, 1, tx.SpanCount.Dropped)
+}
+
+func newTestConfig(server *httptest.Server) aws.Config {
+	cfg := aws.Config{
+		Region:           testRegion,
+		Credentials:      aws.AnonymousCredentials{},
+		BaseEndpoint:     aws.String(server.URL),
+		RetryMaxAttempts: 1,
+	}
+	AppendMiddlewares(&cfg.APIOptions)
+	return cfg
+}
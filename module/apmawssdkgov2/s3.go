@@ -0,0 +1,49 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apmawssdkgov2 // import "go.elastic.co/apm/module/apmawssdkgov2/v2"
+
+import (
+	"go.elastic.co/apm/v2"
+)
+
+type s3 struct {
+	name, bucketName string
+}
+
+func newS3(op operation) *s3 {
+	bucketName := stringField(op.params, "Bucket")
+	name := op.serviceID + " " + op.name
+	if bucketName != "" {
+		name += " " + bucketName
+	}
+	return &s3{name: name, bucketName: bucketName}
+}
+
+func (s *s3) spanName() string {
+	return s.name
+}
+
+func (s *s3) resource() string {
+	return s.bucketName
+}
+
+func (s *s3) targetName() string {
+	return s.bucketName
+}
+
+func (s *s3) setAdditional(*apm.Span) {}
@@ -0,0 +1,61 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apmawssdkgov2 // import "go.elastic.co/apm/module/apmawssdkgov2/v2"
+
+import (
+	"strings"
+
+	"go.elastic.co/apm/v2"
+)
+
+type apmSecretsManager struct {
+	name, secretName string
+}
+
+func newSecretsManager(op operation) *apmSecretsManager {
+	secretName := getSecretName(stringField(op.params, "SecretId"))
+	name := op.serviceID + " " + op.name
+	if secretName != "" {
+		name += " " + secretName
+	}
+	return &apmSecretsManager{name: name, secretName: secretName}
+}
+
+func (s *apmSecretsManager) spanName() string { return s.name }
+
+func (s *apmSecretsManager) resource() string {
+	if s.secretName == "" {
+		return serviceSecretsManager
+	}
+	return serviceSecretsManager + "/" + s.secretName
+}
+
+func (s *apmSecretsManager) targetName() string { return s.secretName }
+
+func (s *apmSecretsManager) setAdditional(*apm.Span) {}
+
+// getSecretName returns the secret name from a secret name or ARN.
+//
+// format: arn:aws:secretsmanager:us-east-1:123456789012:secret:my-secret-AbCdEf
+// should return my-secret-AbCdEf
+func getSecretName(secretID string) string {
+	if idx := strings.Index(secretID, ":secret:"); idx != -1 {
+		return secretID[idx+len(":secret:"):]
+	}
+	return secretID
+}
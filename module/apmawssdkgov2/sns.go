@@ -0,0 +1,150 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apmawssdkgov2 // import "go.elastic.co/apm/module/apmawssdkgov2/v2"
+
+import (
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go-v2/service/sns/types"
+
+	"go.elastic.co/apm/module/apmhttp/v2"
+	"go.elastic.co/apm/v2"
+)
+
+// maxSNSMessageAttributes is the maximum number of message
+// attributes that SNS accepts for a single message.
+const maxSNSMessageAttributes = 10
+
+type apmSNS struct {
+	name, opName, resourceName, topicName string
+}
+
+func newSNS(op operation) (*apmSNS, error) {
+	if op.name != "Publish" {
+		return nil, errMethodNotSupported
+	}
+	name := op.serviceID + " PUBLISH"
+	resourceName := serviceSNS
+
+	topicName := getTopicName(op.params)
+	if topicName != "" {
+		name += " to " + topicName
+		resourceName += "/" + topicName
+	}
+	return &apmSNS{
+		name:         name,
+		opName:       "publish",
+		resourceName: resourceName,
+		topicName:    topicName,
+	}, nil
+}
+
+func (s *apmSNS) spanName() string { return s.name }
+
+func (s *apmSNS) resource() string { return s.resourceName }
+
+func (s *apmSNS) targetName() string { return s.topicName }
+
+func (s *apmSNS) setAdditional(span *apm.Span) {
+	span.Action = s.opName
+	// According to the spec:
+	// Wherever the broker terminology uses "topic", this field will
+	// contain the topic name.
+	if s.topicName != "" {
+		span.Context.SetMessage(apm.MessageSpanContext{
+			QueueName: s.topicName,
+		})
+	}
+}
+
+// propagate adds trace context message attributes to messages sent
+// by Publish operations, unless adding the attributes would exceed
+// the SNS message attribute limit.
+func (s *apmSNS) propagate(params interface{}, traceContext apm.TraceContext, propagateLegacyHeader bool) {
+	input, ok := params.(*sns.PublishInput)
+	if !ok {
+		return
+	}
+	traceparent := types.MessageAttributeValue{
+		DataType:    aws.String("String"),
+		StringValue: aws.String(apmhttp.FormatTraceparentHeader(traceContext)),
+	}
+	tracestate := traceContext.State.String()
+
+	attrs := make(map[string]types.MessageAttributeValue, len(input.MessageAttributes)+3)
+	for k, v := range input.MessageAttributes {
+		attrs[k] = v
+	}
+	attrs[apmhttp.W3CTraceparentHeader] = traceparent
+	if propagateLegacyHeader {
+		attrs[apmhttp.ElasticTraceparentHeader] = traceparent
+	}
+	if tracestate != "" {
+		attrs[apmhttp.TracestateHeader] = types.MessageAttributeValue{
+			DataType:    aws.String("String"),
+			StringValue: aws.String(tracestate),
+		}
+	}
+	if len(attrs) > maxSNSMessageAttributes {
+		return
+	}
+	input.MessageAttributes = attrs
+}
+
+func getTopicName(params interface{}) string {
+	// format: arn:aws:sns:us-east-2:123456789012:My-Topic
+	// should return My-Topic
+	if topicArn := stringField(params, "TopicArn"); topicArn != "" {
+		idx := strings.LastIndex(topicArn, ":")
+		if idx == -1 {
+			return ""
+		}
+
+		// special check for format: arn:aws:sns:us-east-2:123456789012/MyTopic
+		if slashIdx := strings.LastIndex(topicArn, "/"); slashIdx != -1 {
+			return topicArn[slashIdx+1:]
+		}
+
+		return topicArn[idx+1:]
+	}
+
+	// format: arn:aws:sns:us-west-2:123456789012:endpoint/GCM/gcmpushapp/5e3e9847-3183-3f18-a7e8-671c3a57d4b3
+	// should return endpoint/GCM/gcmpushapp
+	if targetArn := stringField(params, "TargetArn"); targetArn != "" {
+		idx := strings.LastIndex(targetArn, ":")
+		if idx == -1 {
+			return ""
+		}
+
+		endIdx := strings.LastIndex(targetArn, "/")
+		if endIdx == -1 {
+			return ""
+		}
+
+		return targetArn[idx+1 : endIdx]
+	}
+
+	// The actual phone number MUST NOT be included because it is PII and cardinality is too high.
+	if phoneNumber := stringField(params, "PhoneNumber"); phoneNumber != "" {
+		return "[PHONENUMBER]"
+	}
+
+	return ""
+}
@@ -0,0 +1,135 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apmawssdkgov2 // import "go.elastic.co/apm/module/apmawssdkgov2/v2"
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go-v2/service/sns/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.elastic.co/apm/module/apmhttp/v2"
+	"go.elastic.co/apm/v2"
+	"go.elastic.co/apm/v2/apmtest"
+	"go.elastic.co/apm/v2/model"
+)
+
+func TestSNS(t *testing.T) {
+	var form map[string][]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		req.ParseForm()
+		form = req.PostForm
+		w.Header().Set("Content-Type", "text/xml")
+		w.Write([]byte(`<PublishResponse><PublishResult><MessageId>1</MessageId></PublishResult></PublishResponse>`))
+	}))
+	defer server.Close()
+	client := sns.NewFromConfig(newTestConfig(server))
+
+	for _, tc := range []struct {
+		input     *sns.PublishInput
+		name      string
+		topicName string
+	}{{
+		input:     &sns.PublishInput{TopicArn: aws.String("arn:aws:sns:us-east-2:123456789012:My-Topic")},
+		name:      "SNS PUBLISH to My-Topic",
+		topicName: "My-Topic",
+	}, {
+		input:     &sns.PublishInput{TargetArn: aws.String("arn:aws:sns:us-west-2:123456789012:endpoint/GCM/gcmpushapp/5e3e9847-3183-3f18-a7e8-671c3a57d4b3")},
+		name:      "SNS PUBLISH to endpoint/GCM/gcmpushapp",
+		topicName: "endpoint/GCM/gcmpushapp",
+	}, {
+		input:     &sns.PublishInput{PhoneNumber: aws.String("123-456-7890")},
+		name:      "SNS PUBLISH to [PHONENUMBER]",
+		topicName: "[PHONENUMBER]",
+	}} {
+		tc.input.Message = aws.String("message")
+		tx, spans, errs := apmtest.WithTransaction(func(ctx context.Context) {
+			_, err := client.Publish(ctx, tc.input)
+			require.NoError(t, err)
+		})
+		require.Len(t, spans, 1)
+		assert.Empty(t, errs)
+
+		span := spans[0]
+		assert.Equal(t, tc.name, span.Name)
+		assert.Equal(t, "messaging", span.Type)
+		assert.Equal(t, "sns", span.Subtype)
+		assert.Equal(t, "publish", span.Action)
+		assert.Equal(t, "sns/"+tc.topicName, span.Context.Destination.Service.Resource)
+		assert.Equal(t, tc.topicName, span.Context.Message.Queue.Name)
+		assert.Equal(t, tc.topicName, span.Context.Service.Target.Name)
+
+		traceparent := apmhttp.FormatTraceparentHeader(traceContext(tx, span))
+		attrs := tc.input.MessageAttributes
+		require.Contains(t, attrs, apmhttp.W3CTraceparentHeader)
+		assert.Equal(t, traceparent, *attrs[apmhttp.W3CTraceparentHeader].StringValue)
+		require.Contains(t, attrs, apmhttp.ElasticTraceparentHeader)
+		require.Contains(t, attrs, apmhttp.TracestateHeader)
+
+		// The message attributes should have been sent.
+		assert.Contains(t, form["MessageAttributes.entry.1.Value.StringValue"], traceparent)
+	}
+}
+
+func TestSNSMessageAttributeLimit(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/xml")
+		w.Write([]byte(`<PublishResponse><PublishResult><MessageId>1</MessageId></PublishResult></PublishResponse>`))
+	}))
+	defer server.Close()
+	client := sns.NewFromConfig(newTestConfig(server))
+
+	attrs := make(map[string]types.MessageAttributeValue)
+	for i := 0; i < maxSNSMessageAttributes; i++ {
+		attrs["attr"+strconv.Itoa(i)] = types.MessageAttributeValue{
+			DataType:    aws.String("String"),
+			StringValue: aws.String("value"),
+		}
+	}
+	input := &sns.PublishInput{
+		TopicArn:          aws.String("arn:aws:sns:us-east-2:123456789012:My-Topic"),
+		Message:           aws.String("message"),
+		MessageAttributes: attrs,
+	}
+	_, spans, errs := apmtest.WithTransaction(func(ctx context.Context) {
+		_, err := client.Publish(ctx, input)
+		require.NoError(t, err)
+	})
+	require.Len(t, spans, 1)
+	assert.Empty(t, errs)
+
+	// Adding the trace context attributes would exceed
+	// the limit, so the message attributes are unchanged.
+	assert.Len(t, input.MessageAttributes, maxSNSMessageAttributes)
+	assert.NotContains(t, input.MessageAttributes, apmhttp.W3CTraceparentHeader)
+}
+
+func traceContext(tx model.Transaction, span model.Span) apm.TraceContext {
+	return apm.TraceContext{
+		Trace:   apm.TraceID(tx.TraceID),
+		Span:    apm.SpanID(span.ID),
+		Options: apm.TraceOptions(0).WithRecorded(true),
+	}
+}
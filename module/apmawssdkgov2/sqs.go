@@ -0,0 +1,149 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apmawssdkgov2 // import "go.elastic.co/apm/module/apmawssdkgov2/v2"
+
+import (
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+
+	"go.elastic.co/apm/module/apmhttp/v2"
+	"go.elastic.co/apm/v2"
+)
+
+// maxSQSMessageAttributes is the maximum number of message
+// attributes that SQS accepts for a single message.
+const maxSQSMessageAttributes = 10
+
+var sqsOperationName = map[string]string{
+	"SendMessage":        "send",
+	"SendMessageBatch":   "send_batch",
+	"DeleteMessage":      "delete",
+	"DeleteMessageBatch": "delete_batch",
+	"ReceiveMessage":     "poll",
+}
+
+type apmSQS struct {
+	name, opName, resourceName, queueName string
+}
+
+func newSQS(op operation) (*apmSQS, error) {
+	opName, ok := sqsOperationName[op.name]
+	if !ok {
+		return nil, errMethodNotSupported
+	}
+	name := op.serviceID + " " + strings.ToUpper(opName)
+	resourceName := serviceSQS
+
+	queueName := queueNameFromURL(stringField(op.params, "QueueUrl"))
+	if queueName != "" {
+		name += " " + sqsOperationDirection(op.name) + " " + queueName
+		resourceName += "/" + queueName
+	}
+	return &apmSQS{
+		name:         name,
+		opName:       opName,
+		resourceName: resourceName,
+		queueName:    queueName,
+	}, nil
+}
+
+func (s *apmSQS) spanName() string { return s.name }
+
+func (s *apmSQS) resource() string { return s.resourceName }
+
+func (s *apmSQS) targetName() string { return s.queueName }
+
+func (s *apmSQS) setAdditional(span *apm.Span) {
+	span.Action = s.opName
+	if s.queueName != "" {
+		span.Context.SetMessage(apm.MessageSpanContext{
+			QueueName: s.queueName,
+		})
+	}
+}
+
+// propagate adds trace context message attributes to the messages
+// sent by SendMessage and SendMessageBatch operations. Messages which
+// would exceed the message attribute limit are left unmodified.
+func (s *apmSQS) propagate(params interface{}, traceContext apm.TraceContext, propagateLegacyHeader bool) {
+	switch input := params.(type) {
+	case *sqs.SendMessageInput:
+		input.MessageAttributes = setSQSTracingAttributes(
+			input.MessageAttributes, traceContext, propagateLegacyHeader,
+		)
+	case *sqs.SendMessageBatchInput:
+		for i := range input.Entries {
+			entry := &input.Entries[i]
+			entry.MessageAttributes = setSQSTracingAttributes(
+				entry.MessageAttributes, traceContext, propagateLegacyHeader,
+			)
+		}
+	}
+}
+
+// setSQSTracingAttributes returns a copy of attrs with the trace
+// context attributes added, or attrs if adding the attributes would
+// exceed the SQS message attribute limit.
+func setSQSTracingAttributes(
+	attrs map[string]types.MessageAttributeValue,
+	traceContext apm.TraceContext,
+	propagateLegacyHeader bool,
+) map[string]types.MessageAttributeValue {
+	traceparent := types.MessageAttributeValue{
+		DataType:    aws.String("String"),
+		StringValue: aws.String(apmhttp.FormatTraceparentHeader(traceContext)),
+	}
+	tracestate := traceContext.State.String()
+
+	out := make(map[string]types.MessageAttributeValue, len(attrs)+3)
+	for k, v := range attrs {
+		out[k] = v
+	}
+	out[apmhttp.W3CTraceparentHeader] = traceparent
+	if propagateLegacyHeader {
+		out[apmhttp.ElasticTraceparentHeader] = traceparent
+	}
+	if tracestate != "" {
+		out[apmhttp.TracestateHeader] = types.MessageAttributeValue{
+			DataType:    aws.String("String"),
+			StringValue: aws.String(tracestate),
+		}
+	}
+	if len(out) > maxSQSMessageAttributes {
+		return attrs
+	}
+	return out
+}
+
+func sqsOperationDirection(operationName string) string {
+	switch operationName {
+	case "SendMessage", "SendMessageBatch":
+		return "to"
+	default:
+		return "from"
+	}
+}
+
+// queueNameFromURL returns the queue name from an SQS queue URL,
+// e.g. https://sqs.us-east-1.amazonaws.com/123456789012/MyQueue.
+func queueNameFromURL(queueURL string) string {
+	return queueURL[strings.LastIndexByte(queueURL, '/')+1:]
+}
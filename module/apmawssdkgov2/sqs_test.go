@@ -0,0 +1,204 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apmawssdkgov2 // import "go.elastic.co/apm/module/apmawssdkgov2/v2"
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.elastic.co/apm/module/apmhttp/v2"
+	"go.elastic.co/apm/v2/apmtest"
+)
+
+func TestSQS(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("{}"))
+	}))
+	defer server.Close()
+	client := sqs.NewFromConfig(newTestConfig(server), func(o *sqs.Options) {
+		o.DisableMessageChecksumValidation = true
+	})
+
+	attrs := func() map[string]types.MessageAttributeValue {
+		return map[string]types.MessageAttributeValue{
+			"attr": {
+				DataType:    aws.String("String"),
+				StringValue: aws.String("string attr"),
+			},
+		}
+	}
+	sendMessageInput := &sqs.SendMessageInput{
+		MessageAttributes: attrs(),
+		MessageBody:       aws.String("msg body"),
+	}
+	sendMessageBatchInput := &sqs.SendMessageBatchInput{
+		Entries: []types.SendMessageBatchRequestEntry{{
+			Id:                aws.String("1"),
+			MessageAttributes: attrs(),
+			MessageBody:       aws.String("msg body"),
+		}},
+	}
+
+	for _, tc := range []struct {
+		fn                     func(context.Context, string) error
+		name, action, resource string
+		queueName              string
+		traceAttributes        func() []map[string]types.MessageAttributeValue
+	}{{
+		name:      "SQS POLL from MyQueue",
+		action:    "poll",
+		resource:  "sqs/MyQueue",
+		queueName: "MyQueue",
+		fn: func(ctx context.Context, queueURL string) error {
+			_, err := client.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{QueueUrl: &queueURL})
+			return err
+		},
+	}, {
+		name:      "SQS SEND to OtherQueue",
+		action:    "send",
+		resource:  "sqs/OtherQueue",
+		queueName: "OtherQueue",
+		fn: func(ctx context.Context, queueURL string) error {
+			sendMessageInput.QueueUrl = &queueURL
+			_, err := client.SendMessage(ctx, sendMessageInput)
+			return err
+		},
+		traceAttributes: func() []map[string]types.MessageAttributeValue {
+			return []map[string]types.MessageAttributeValue{sendMessageInput.MessageAttributes}
+		},
+	}, {
+		name:      "SQS SEND_BATCH to OtherQueue",
+		action:    "send_batch",
+		resource:  "sqs/OtherQueue",
+		queueName: "OtherQueue",
+		fn: func(ctx context.Context, queueURL string) error {
+			sendMessageBatchInput.QueueUrl = &queueURL
+			_, err := client.SendMessageBatch(ctx, sendMessageBatchInput)
+			return err
+		},
+		traceAttributes: func() []map[string]types.MessageAttributeValue {
+			return []map[string]types.MessageAttributeValue{sendMessageBatchInput.Entries[0].MessageAttributes}
+		},
+	}, {
+		name:      "SQS DELETE from ThatQueue",
+		action:    "delete",
+		resource:  "sqs/ThatQueue",
+		queueName: "ThatQueue",
+		fn: func(ctx context.Context, queueURL string) error {
+			_, err := client.DeleteMessage(ctx, &sqs.DeleteMessageInput{
+				QueueUrl:      &queueURL,
+				ReceiptHandle: aws.String("receipt-handle"),
+			})
+			return err
+		},
+	}} {
+		t.Run(tc.action, func(t *testing.T) {
+			queueURL := server.URL + "/123456789012/" + tc.queueName
+			tx, spans, errs := apmtest.WithTransaction(func(ctx context.Context) {
+				require.NoError(t, tc.fn(ctx, queueURL))
+			})
+			require.Len(t, spans, 1)
+			assert.Empty(t, errs)
+
+			span := spans[0]
+			assert.Equal(t, tc.name, span.Name)
+			assert.Equal(t, "messaging", span.Type)
+			assert.Equal(t, "sqs", span.Subtype)
+			assert.Equal(t, tc.action, span.Action)
+			assert.Equal(t, tx.ID, span.ParentID)
+
+			service := span.Context.Destination.Service
+			assert.Equal(t, "sqs", service.Name)
+			assert.Equal(t, tc.resource, service.Resource)
+			assert.Equal(t, "messaging", service.Type)
+			assert.Equal(t, tc.queueName, span.Context.Message.Queue.Name)
+			assert.Equal(t, "sqs", span.Context.Service.Target.Type)
+			assert.Equal(t, tc.queueName, span.Context.Service.Target.Name)
+			assert.Equal(t, testRegion, span.Context.Destination.Cloud.Region)
+
+			if tc.traceAttributes == nil {
+				return
+			}
+			traceparent := apmhttp.FormatTraceparentHeader(traceContext(tx, span))
+			for _, attrs := range tc.traceAttributes() {
+				assert.Contains(t, attrs, "attr")
+				require.Contains(t, attrs, apmhttp.W3CTraceparentHeader)
+				assert.Equal(t, traceparent, *attrs[apmhttp.W3CTraceparentHeader].StringValue)
+				require.Contains(t, attrs, apmhttp.ElasticTraceparentHeader)
+				assert.Equal(t, traceparent, *attrs[apmhttp.ElasticTraceparentHeader].StringValue)
+				require.Contains(t, attrs, apmhttp.TracestateHeader)
+				assert.Equal(t, "es=s:1", *attrs[apmhttp.TracestateHeader].StringValue)
+			}
+		})
+	}
+}
+
+func TestSQSMessageAttributeLimit(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("{}"))
+	}))
+	defer server.Close()
+	client := sqs.NewFromConfig(newTestConfig(server), func(o *sqs.Options) {
+		o.DisableMessageChecksumValidation = true
+	})
+
+	attrs := make(map[string]types.MessageAttributeValue)
+	for i := 0; i < maxSQSMessageAttributes-1; i++ {
+		attrs["attr"+strconv.Itoa(i)] = types.MessageAttributeValue{
+			DataType:    aws.String("String"),
+			StringValue: aws.String("value"),
+		}
+	}
+	input := &sqs.SendMessageInput{
+		QueueUrl:          aws.String(server.URL + "/123456789012/MyQueue"),
+		MessageAttributes: attrs,
+		MessageBody:       aws.String("msg body"),
+	}
+	_, spans, _ := apmtest.WithTransaction(func(ctx context.Context) {
+		_, err := client.SendMessage(ctx, input)
+		require.NoError(t, err)
+	})
+	require.Len(t, spans, 1)
+
+	// Adding the trace context attributes would exceed
+	// the limit, so the message attributes are unchanged.
+	assert.Len(t, input.MessageAttributes, maxSQSMessageAttributes-1)
+}
+
+func TestSQSUnsupportedOperation(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("{}"))
+	}))
+	defer server.Close()
+	client := sqs.NewFromConfig(newTestConfig(server))
+
+	_, spans, _ := apmtest.WithTransaction(func(ctx context.Context) {
+		_, err := client.ListQueues(ctx, &sqs.ListQueuesInput{})
+		require.NoError(t, err)
+	})
+	assert.Empty(t, spans)
+}
@@ -0,0 +1,159 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apmazure // import "go.elastic.co/apm/module/apmazure/v2"
+
+import (
+	"context"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azeventhubs"
+
+	"go.elastic.co/apm/v2"
+)
+
+// EventDataBatchSender is the interface for sending event batches
+// implemented by *azeventhubs.ProducerClient.
+type EventDataBatchSender interface {
+	SendEventDataBatch(ctx context.Context, batch *azeventhubs.EventDataBatch, options *azeventhubs.SendEventDataBatchOptions) error
+}
+
+// EventReceiver is the interface for receiving events implemented
+// by *azeventhubs.PartitionClient.
+type EventReceiver interface {
+	ReceiveEvents(ctx context.Context, count int, options *azeventhubs.ReceiveEventsOptions) ([]*azeventhubs.ReceivedEventData, error)
+}
+
+// WrapEventHubsProducer returns an EventHubsProducer wrapping p, reporting
+// spans for event batches sent to the named event hub within the
+// transaction or span contained in the context.
+//
+// p will usually be an *azeventhubs.ProducerClient.
+func WrapEventHubsProducer(p EventDataBatchSender, eventHub string) *EventHubsProducer {
+	return &EventHubsProducer{
+		p: p,
+		m: eventHubsSpan(eventHub, "send"),
+	}
+}
+
+// EventHubsProducer wraps an EventDataBatchSender, such as
+// *azeventhubs.ProducerClient, to report spans for sent events.
+type EventHubsProducer struct {
+	p EventDataBatchSender
+	m messagingSpan
+}
+
+// SendEventDataBatch sends batch using the wrapped producer, reporting
+// a span if ctx contains a transaction.
+//
+// Events are encoded when they are added to a batch, so the trace context
+// cannot be propagated by SendEventDataBatch. Use AddEventData to add events
+// to the batch with the trace context of the enclosing transaction or span.
+func (p *EventHubsProducer) SendEventDataBatch(ctx context.Context, batch *azeventhubs.EventDataBatch, options *azeventhubs.SendEventDataBatchOptions) error {
+	span, _, _ := p.m.start(ctx)
+	err := p.p.SendEventDataBatch(ctx, batch, options)
+	endMessagingSpan(ctx, span, err)
+	return err
+}
+
+// AddEventData adds event to batch, first adding trace context properties
+// for the transaction or span contained in ctx to the event's properties.
+func (p *EventHubsProducer) AddEventData(ctx context.Context, batch *azeventhubs.EventDataBatch, event *azeventhubs.EventData, options *azeventhubs.AddEventDataOptions) error {
+	if traceContext, propagateLegacyHeader, ok := traceContextFromContext(ctx); ok {
+		event.Properties = setTraceContextProperties(event.Properties, traceContext, propagateLegacyHeader)
+	}
+	return batch.AddEventData(event, options)
+}
+
+// WrapEventHubsPartitionClient returns an EventHubsPartitionClient wrapping
+// c, reporting spans for events received from the named event hub within
+// the transaction or span contained in the context.
+//
+// c will usually be an *azeventhubs.PartitionClient.
+func WrapEventHubsPartitionClient(c EventReceiver, eventHub string) *EventHubsPartitionClient {
+	return &EventHubsPartitionClient{
+		c: c,
+		m: eventHubsSpan(eventHub, "receive"),
+	}
+}
+
+// EventHubsPartitionClient wraps an EventReceiver, such as
+// *azeventhubs.PartitionClient, to report spans for received events.
+type EventHubsPartitionClient struct {
+	c EventReceiver
+	m messagingSpan
+}
+
+// ReceiveEvents receives events using the wrapped client, reporting
+// a span if ctx contains a transaction.
+//
+// To process each received event in its own transaction, continuing the
+// producer's trace, use StartEventHubsTransaction. To process the events
+// in a single transaction, use StartEventHubsBatchTransaction.
+func (c *EventHubsPartitionClient) ReceiveEvents(ctx context.Context, count int, options *azeventhubs.ReceiveEventsOptions) ([]*azeventhubs.ReceivedEventData, error) {
+	span, _, _ := c.m.start(ctx)
+	events, err := c.c.ReceiveEvents(ctx, count, options)
+	endMessagingSpan(ctx, span, err)
+	return events, err
+}
+
+// StartEventHubsTransaction starts a messaging transaction for processing
+// event, received from the named event hub, continuing the trace propagated
+// in the event properties, if any. StartEventHubsTransaction returns the
+// transaction and a context containing it.
+//
+// The caller is responsible for ending the transaction once the event
+// has been processed.
+func StartEventHubsTransaction(ctx context.Context, tracer *apm.Tracer, eventHub string, event *azeventhubs.ReceivedEventData) (*apm.Transaction, context.Context) {
+	var opts apm.TransactionOptions
+	if traceContext, ok := traceContextFromProperties(event.Properties); ok {
+		opts.TraceContext = traceContext
+	}
+	tx := tracer.StartTransactionOptions(eventHubsSpan(eventHub, "receive").name(), "messaging", opts)
+	tx.Context.SetMessage(apm.MessageContext{
+		QueueName: eventHub,
+		Age:       messageAge(event.EnqueuedTime),
+	})
+	return tx, apm.ContextWithTransaction(ctx, tx)
+}
+
+// StartEventHubsBatchTransaction starts a messaging transaction for
+// processing a batch of events received from the named event hub, and
+// returns the transaction and a context containing it.
+//
+// The transaction starts a new trace, with links to the traces
+// propagated in the event properties.
+//
+// The caller is responsible for ending the transaction once the
+// events have been processed.
+func StartEventHubsBatchTransaction(ctx context.Context, tracer *apm.Tracer, eventHub string, events []*azeventhubs.ReceivedEventData) (*apm.Transaction, context.Context) {
+	var links []apm.SpanLink
+	for _, event := range events {
+		links = appendLink(links, event.Properties)
+	}
+	tx := tracer.StartTransactionOptions(eventHubsSpan(eventHub, "receive").name(), "messaging", apm.TransactionOptions{Links: links})
+	tx.Context.SetMessage(apm.MessageContext{QueueName: eventHub})
+	return tx, apm.ContextWithTransaction(ctx, tx)
+}
+
+func eventHubsSpan(entity, action string) messagingSpan {
+	return messagingSpan{
+		service: "AzureEventHubs",
+		subtype: "azureeventhubs",
+		entity:  entity,
+		action:  action,
+	}
+}
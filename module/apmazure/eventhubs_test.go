@@ -0,0 +1,178 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apmazure // import "go.elastic.co/apm/module/apmazure/v2"
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azeventhubs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.elastic.co/apm/module/apmhttp/v2"
+	"go.elastic.co/apm/v2"
+	"go.elastic.co/apm/v2/apmtest"
+	"go.elastic.co/apm/v2/model"
+)
+
+func TestEventHubsSendEventDataBatch(t *testing.T) {
+	var producer recordingProducer
+	p := WrapEventHubsProducer(&producer, "myhub")
+
+	tx, spans, errs := apmtest.WithTransaction(func(ctx context.Context) {
+		event := &azeventhubs.EventData{Body: []byte("hello")}
+		// The zero EventDataBatch has no capacity, so AddEventData
+		// fails; the properties are set regardless.
+		p.AddEventData(ctx, &azeventhubs.EventDataBatch{}, event, nil)
+		traceparent := apmhttp.FormatTraceparentHeader(apm.TransactionFromContext(ctx).TraceContext())
+		assert.Equal(t, traceparent, event.Properties["traceparent"])
+		assert.Equal(t, traceparent, event.Properties["Diagnostic-Id"])
+		require.NoError(t, p.SendEventDataBatch(ctx, &azeventhubs.EventDataBatch{}, nil))
+	})
+	require.Empty(t, errs)
+	require.Len(t, spans, 1)
+	span := spans[0]
+
+	assert.Equal(t, "AzureEventHubs SEND to myhub", span.Name)
+	assert.Equal(t, "messaging", span.Type)
+	assert.Equal(t, "azureeventhubs", span.Subtype)
+	assert.Equal(t, "send", span.Action)
+	assert.Equal(t, "success", span.Outcome)
+	assert.Equal(t, tx.ID, span.ParentID)
+	assert.Equal(t, "myhub", span.Context.Message.Queue.Name)
+	assert.Equal(t, "azureeventhubs/myhub", span.Context.Destination.Service.Resource)
+	assert.Equal(t, "azureeventhubs", span.Context.Service.Target.Type)
+	assert.Equal(t, "myhub", span.Context.Service.Target.Name)
+	assert.Equal(t, 1, producer.batches)
+}
+
+func TestEventHubsSendEventDataBatchError(t *testing.T) {
+	producer := recordingProducer{err: errors.New("boom")}
+	p := WrapEventHubsProducer(&producer, "myhub")
+
+	_, spans, errs := apmtest.WithTransaction(func(ctx context.Context) {
+		assert.Error(t, p.SendEventDataBatch(ctx, &azeventhubs.EventDataBatch{}, nil))
+	})
+	require.Len(t, spans, 1)
+	require.Len(t, errs, 1)
+	assert.Equal(t, "failure", spans[0].Outcome)
+}
+
+func TestEventHubsReceiveEvents(t *testing.T) {
+	client := fakePartitionClient{events: []*azeventhubs.ReceivedEventData{{}, {}}}
+	c := WrapEventHubsPartitionClient(&client, "myhub")
+
+	_, spans, _ := apmtest.WithTransaction(func(ctx context.Context) {
+		events, err := c.ReceiveEvents(ctx, 10, nil)
+		require.NoError(t, err)
+		assert.Len(t, events, 2)
+	})
+	require.Len(t, spans, 1)
+	assert.Equal(t, "AzureEventHubs RECEIVE from myhub", spans[0].Name)
+	assert.Equal(t, "receive", spans[0].Action)
+}
+
+func TestStartEventHubsTransaction(t *testing.T) {
+	tracer := apmtest.NewRecordingTracer()
+	defer tracer.Close()
+
+	traceContext := apm.TraceContext{
+		Trace:   apm.TraceID{1, 2, 3},
+		Span:    apm.SpanID{4, 5, 6},
+		Options: apm.TraceOptions(0).WithRecorded(true),
+	}
+	enqueued := time.Now().Add(-time.Second)
+	event := &azeventhubs.ReceivedEventData{
+		EventData: azeventhubs.EventData{
+			Properties: map[string]interface{}{
+				"traceparent": apmhttp.FormatTraceparentHeader(traceContext),
+				"tracestate":  "es=s:0.5",
+			},
+		},
+		EnqueuedTime: &enqueued,
+	}
+	tx, ctx := StartEventHubsTransaction(context.Background(), tracer.Tracer, "myhub", event)
+	assert.Equal(t, tx, apm.TransactionFromContext(ctx))
+	tx.End()
+	tracer.Flush(nil)
+
+	payloads := tracer.Payloads()
+	require.Len(t, payloads.Transactions, 1)
+	transaction := payloads.Transactions[0]
+	assert.Equal(t, "AzureEventHubs RECEIVE from myhub", transaction.Name)
+	assert.Equal(t, "messaging", transaction.Type)
+	assert.Equal(t, model.TraceID(traceContext.Trace), transaction.TraceID)
+	assert.Equal(t, model.SpanID(traceContext.Span), transaction.ParentID)
+	assert.Equal(t, "myhub", transaction.Context.Message.Queue.Name)
+	require.NotNil(t, transaction.Context.Message.Age)
+	assert.InDelta(t, 1000, transaction.Context.Message.Age.Millis, 500)
+	require.NotNil(t, transaction.SampleRate)
+	assert.Equal(t, 0.5, *transaction.SampleRate)
+}
+
+func TestStartEventHubsBatchTransaction(t *testing.T) {
+	tracer := apmtest.NewRecordingTracer()
+	defer tracer.Close()
+
+	var events []*azeventhubs.ReceivedEventData
+	for i := 0; i < maxBatchLinks+1; i++ {
+		traceContext := apm.TraceContext{
+			Trace: apm.TraceID{1, 2, 3},
+			Span:  apm.SpanID{byte(i), byte(i >> 8)},
+		}
+		events = append(events, &azeventhubs.ReceivedEventData{
+			EventData: azeventhubs.EventData{
+				Properties: map[string]interface{}{
+					"Diagnostic-Id": apmhttp.FormatTraceparentHeader(traceContext),
+				},
+			},
+		})
+	}
+	tx, _ := StartEventHubsBatchTransaction(context.Background(), tracer.Tracer, "myhub", events)
+	tx.End()
+	tracer.Flush(nil)
+
+	payloads := tracer.Payloads()
+	require.Len(t, payloads.Transactions, 1)
+	transaction := payloads.Transactions[0]
+	assert.Equal(t, "AzureEventHubs RECEIVE from myhub", transaction.Name)
+	assert.Zero(t, transaction.ParentID)
+	assert.Len(t, transaction.Links, maxBatchLinks)
+}
+
+type recordingProducer struct {
+	batches int
+	err     error
+}
+
+func (p *recordingProducer) SendEventDataBatch(ctx context.Context, batch *azeventhubs.EventDataBatch, options *azeventhubs.SendEventDataBatchOptions) error {
+	p.batches++
+	return p.err
+}
+
+type fakePartitionClient struct {
+	events []*azeventhubs.ReceivedEventData
+	err    error
+}
+
+func (c *fakePartitionClient) ReceiveEvents(ctx context.Context, count int, options *azeventhubs.ReceiveEventsOptions) ([]*azeventhubs.ReceivedEventData, error) {
+	return c.events, c.err
+}
@@ -0,0 +1,204 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apmazure // import "go.elastic.co/apm/module/apmazure/v2"
+
+import (
+	"context"
+
+	"go.elastic.co/apm/module/apmhttp/v2"
+	"go.elastic.co/apm/v2"
+)
+
+const (
+	// traceparentProperty is the application property for W3C trace propagation.
+	traceparentProperty = "traceparent"
+
+	// elasticTraceparentProperty is the legacy application property
+	// for trace propagation.
+	elasticTraceparentProperty = "elastic-apm-traceparent"
+
+	// tracestateProperty is the application property for W3C tracestate.
+	tracestateProperty = "tracestate"
+
+	// diagnosticIDProperty is the application property used by the
+	// Azure SDKs for trace propagation. Its value is a W3C traceparent.
+	diagnosticIDProperty = "Diagnostic-Id"
+
+	// maxBatchLinks is the maximum number of span links added to a
+	// transaction started for processing a batch of messages.
+	maxBatchLinks = 1000
+)
+
+// messagingSpan describes a span for sending to, or receiving from,
+// an Azure messaging entity.
+type messagingSpan struct {
+	// service is the service name used in span names, e.g. "AzureServiceBus".
+	service string
+
+	// subtype is the span subtype, e.g. "azureservicebus".
+	subtype string
+
+	// entity is the name of the queue, topic, or event hub.
+	entity string
+
+	// action is the span action, "send" or "receive".
+	action string
+}
+
+func (m messagingSpan) name() string {
+	if m.action == "receive" {
+		return m.service + " RECEIVE from " + m.entity
+	}
+	return m.service + " SEND to " + m.entity
+}
+
+// start starts a span if ctx contains a transaction, returning the span
+// and the trace context to propagate. If the transaction is not sampled
+// or the span is dropped, the transaction's trace context is returned
+// along with a nil span. If ctx does not contain a transaction, start
+// returns a nil span and false.
+func (m messagingSpan) start(ctx context.Context) (*apm.Span, apm.TraceContext, bool) {
+	tx := apm.TransactionFromContext(ctx)
+	if tx == nil {
+		return nil, apm.TraceContext{}, false
+	}
+	traceContext := tx.TraceContext()
+	if !traceContext.Options.Recorded() {
+		return nil, traceContext, true
+	}
+	span, _ := apm.StartSpanOptions(ctx, m.name(), "messaging", apm.SpanOptions{ExitSpan: true})
+	if span.Dropped() {
+		span.End()
+		return nil, traceContext, true
+	}
+	span.Subtype = m.subtype
+	span.Action = m.action
+	span.Context.SetMessage(apm.MessageSpanContext{QueueName: m.entity})
+	span.Context.SetDestinationService(apm.DestinationServiceSpanContext{
+		Name:     m.subtype,
+		Resource: m.subtype + "/" + m.entity,
+	})
+	span.Context.SetServiceTarget(apm.ServiceTargetSpanContext{
+		Type: m.subtype,
+		Name: m.entity,
+	})
+	return span, span.TraceContext(), true
+}
+
+// endMessagingSpan sets the outcome of span according to err, reporting
+// err if non-nil, and ends the span. endMessagingSpan is a no-op if span
+// is nil.
+func endMessagingSpan(ctx context.Context, span *apm.Span, err error) {
+	if span == nil {
+		return
+	}
+	if err != nil {
+		span.Outcome = "failure"
+		apm.CaptureError(apm.ContextWithSpan(ctx, span), err).Send()
+	} else {
+		span.Outcome = "success"
+	}
+	span.End()
+}
+
+// traceContextFromContext returns the trace context of the span or
+// transaction in ctx, and a boolean indicating whether the legacy
+// traceparent property should be propagated. If ctx contains neither,
+// traceContextFromContext returns false.
+func traceContextFromContext(ctx context.Context) (apm.TraceContext, bool, bool) {
+	tx := apm.TransactionFromContext(ctx)
+	if tx == nil {
+		return apm.TraceContext{}, false, false
+	}
+	if span := apm.SpanFromContext(ctx); span != nil && !span.Dropped() {
+		return span.TraceContext(), tx.ShouldPropagateLegacyHeader(), true
+	}
+	return tx.TraceContext(), tx.ShouldPropagateLegacyHeader(), true
+}
+
+// setTraceContextProperties returns a copy of props with the trace
+// context properties set, replacing any existing values.
+//
+// The properties are copied because callers may reuse the same map
+// for many messages, possibly sent concurrently.
+func setTraceContextProperties(props map[string]interface{}, traceContext apm.TraceContext, propagateLegacyHeader bool) map[string]interface{} {
+	out := make(map[string]interface{}, len(props)+4)
+	for k, v := range props {
+		out[k] = v
+	}
+	props = out
+	traceparent := apmhttp.FormatTraceparentHeader(traceContext)
+	props[traceparentProperty] = traceparent
+	props[diagnosticIDProperty] = traceparent
+	if propagateLegacyHeader {
+		props[elasticTraceparentProperty] = traceparent
+	}
+	if tracestate := traceContext.State.String(); tracestate != "" {
+		props[tracestateProperty] = tracestate
+	} else {
+		delete(props, tracestateProperty)
+	}
+	return props
+}
+
+// traceContextFromProperties returns the trace context propagated in the
+// given application properties, and a boolean indicating whether it was
+// found. The traceparent property is preferred, followed by the legacy
+// elastic-apm-traceparent property, and then the Diagnostic-Id property
+// set by the Azure SDKs.
+func traceContextFromProperties(props map[string]interface{}) (apm.TraceContext, bool) {
+	for _, name := range [...]string{traceparentProperty, elasticTraceparentProperty, diagnosticIDProperty} {
+		traceContext, err := apmhttp.ParseTraceparentHeader(propertyString(props, name))
+		if err != nil {
+			continue
+		}
+		if tracestate := propertyString(props, tracestateProperty); tracestate != "" {
+			traceContext.State, _ = apmhttp.ParseTracestateHeader(tracestate)
+		}
+		return traceContext, true
+	}
+	return apm.TraceContext{}, false
+}
+
+// propertyString returns the value of the given property as a string,
+// if it is a string or byte slice; otherwise it returns an empty string.
+func propertyString(props map[string]interface{}, name string) string {
+	switch v := props[name].(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	}
+	return ""
+}
+
+// appendLink appends to links a span link for the trace context
+// propagated in props, if any, unless links already holds
+// maxBatchLinks links.
+func appendLink(links []apm.SpanLink, props map[string]interface{}) []apm.SpanLink {
+	if len(links) == maxBatchLinks {
+		return links
+	}
+	if traceContext, ok := traceContextFromProperties(props); ok {
+		links = append(links, apm.SpanLink{
+			Trace: traceContext.Trace,
+			Span:  traceContext.Span,
+		})
+	}
+	return links
+}
@@ -0,0 +1,197 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apmazure // import "go.elastic.co/apm/module/apmazure/v2"
+
+import (
+	"context"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+
+	"go.elastic.co/apm/v2"
+)
+
+// MessageSender is the interface for sending messages implemented
+// by *azservicebus.Sender.
+type MessageSender interface {
+	SendMessage(ctx context.Context, message *azservicebus.Message, options *azservicebus.SendMessageOptions) error
+	SendMessageBatch(ctx context.Context, batch *azservicebus.MessageBatch, options *azservicebus.SendMessageBatchOptions) error
+}
+
+// MessageReceiver is the interface for receiving messages implemented
+// by *azservicebus.Receiver.
+type MessageReceiver interface {
+	ReceiveMessages(ctx context.Context, maxMessages int, options *azservicebus.ReceiveMessagesOptions) ([]*azservicebus.ReceivedMessage, error)
+}
+
+// WrapServiceBusSender returns a ServiceBusSender wrapping s, reporting
+// spans for messages sent to the named queue or topic within the
+// transaction or span contained in the context.
+//
+// s will usually be an *azservicebus.Sender.
+func WrapServiceBusSender(s MessageSender, queueOrTopic string) *ServiceBusSender {
+	return &ServiceBusSender{
+		s: s,
+		m: serviceBusSpan(queueOrTopic, "send"),
+	}
+}
+
+// ServiceBusSender wraps a MessageSender, such as *azservicebus.Sender,
+// to report spans for sent messages and propagate the trace context in
+// the message application properties.
+type ServiceBusSender struct {
+	s MessageSender
+	m messagingSpan
+}
+
+// SendMessage sends message using the wrapped sender, reporting a span if
+// ctx contains a transaction, and adding trace context properties to the
+// message's application properties.
+func (s *ServiceBusSender) SendMessage(ctx context.Context, message *azservicebus.Message, options *azservicebus.SendMessageOptions) error {
+	span, traceContext, ok := s.m.start(ctx)
+	if ok {
+		message.ApplicationProperties = setTraceContextProperties(
+			message.ApplicationProperties, traceContext,
+			apm.TransactionFromContext(ctx).ShouldPropagateLegacyHeader(),
+		)
+	}
+	err := s.s.SendMessage(ctx, message, options)
+	endMessagingSpan(ctx, span, err)
+	return err
+}
+
+// SendMessageBatch sends batch using the wrapped sender, reporting a span
+// if ctx contains a transaction.
+//
+// Messages are encoded when they are added to a batch, so the trace context
+// cannot be propagated by SendMessageBatch. Use AddMessage to add messages
+// to the batch with the trace context of the enclosing transaction or span.
+func (s *ServiceBusSender) SendMessageBatch(ctx context.Context, batch *azservicebus.MessageBatch, options *azservicebus.SendMessageBatchOptions) error {
+	span, _, _ := s.m.start(ctx)
+	err := s.s.SendMessageBatch(ctx, batch, options)
+	endMessagingSpan(ctx, span, err)
+	return err
+}
+
+// AddMessage adds message to batch, first adding trace context properties
+// for the transaction or span contained in ctx to the message's application
+// properties.
+func (s *ServiceBusSender) AddMessage(ctx context.Context, batch *azservicebus.MessageBatch, message *azservicebus.Message, options *azservicebus.AddMessageOptions) error {
+	if traceContext, propagateLegacyHeader, ok := traceContextFromContext(ctx); ok {
+		message.ApplicationProperties = setTraceContextProperties(
+			message.ApplicationProperties, traceContext, propagateLegacyHeader,
+		)
+	}
+	return batch.AddMessage(message, options)
+}
+
+// WrapServiceBusReceiver returns a ServiceBusReceiver wrapping r, reporting
+// spans for messages received from the named queue or subscription within
+// the transaction or span contained in the context.
+//
+// r will usually be an *azservicebus.Receiver.
+func WrapServiceBusReceiver(r MessageReceiver, queueOrSubscription string) *ServiceBusReceiver {
+	return &ServiceBusReceiver{
+		r: r,
+		m: serviceBusSpan(queueOrSubscription, "receive"),
+	}
+}
+
+// ServiceBusReceiver wraps a MessageReceiver, such as *azservicebus.Receiver,
+// to report spans for received messages.
+type ServiceBusReceiver struct {
+	r MessageReceiver
+	m messagingSpan
+}
+
+// ReceiveMessages receives messages using the wrapped receiver, reporting
+// a span if ctx contains a transaction.
+//
+// To process each received message in its own transaction, continuing the
+// sender's trace, use StartServiceBusTransaction. To process the messages
+// in a single transaction, use StartServiceBusBatchTransaction.
+func (r *ServiceBusReceiver) ReceiveMessages(ctx context.Context, maxMessages int, options *azservicebus.ReceiveMessagesOptions) ([]*azservicebus.ReceivedMessage, error) {
+	span, _, _ := r.m.start(ctx)
+	msgs, err := r.r.ReceiveMessages(ctx, maxMessages, options)
+	endMessagingSpan(ctx, span, err)
+	return msgs, err
+}
+
+// StartServiceBusTransaction starts a messaging transaction for processing
+// msg, received from the named queue or subscription, continuing the trace
+// propagated in the message application properties, if any.
+// StartServiceBusTransaction returns the transaction and a context
+// containing it.
+//
+// The caller is responsible for ending the transaction once the message
+// has been processed.
+func StartServiceBusTransaction(ctx context.Context, tracer *apm.Tracer, queueOrSubscription string, msg *azservicebus.ReceivedMessage) (*apm.Transaction, context.Context) {
+	var opts apm.TransactionOptions
+	if traceContext, ok := traceContextFromProperties(msg.ApplicationProperties); ok {
+		opts.TraceContext = traceContext
+	}
+	tx := tracer.StartTransactionOptions(serviceBusSpan(queueOrSubscription, "receive").name(), "messaging", opts)
+	tx.Context.SetMessage(apm.MessageContext{
+		QueueName: queueOrSubscription,
+		Age:       messageAge(msg.EnqueuedTime),
+	})
+	if msg.DeliveryCount > 0 {
+		tx.Context.SetLabel("delivery_count", msg.DeliveryCount)
+	}
+	return tx, apm.ContextWithTransaction(ctx, tx)
+}
+
+// StartServiceBusBatchTransaction starts a messaging transaction for
+// processing a batch of messages received from the named queue or
+// subscription, and returns the transaction and a context containing it.
+//
+// The transaction starts a new trace, with links to the traces
+// propagated in the message application properties.
+//
+// The caller is responsible for ending the transaction once the
+// messages have been processed.
+func StartServiceBusBatchTransaction(ctx context.Context, tracer *apm.Tracer, queueOrSubscription string, msgs []*azservicebus.ReceivedMessage) (*apm.Transaction, context.Context) {
+	var links []apm.SpanLink
+	for _, msg := range msgs {
+		links = appendLink(links, msg.ApplicationProperties)
+	}
+	tx := tracer.StartTransactionOptions(serviceBusSpan(queueOrSubscription, "receive").name(), "messaging", apm.TransactionOptions{Links: links})
+	tx.Context.SetMessage(apm.MessageContext{QueueName: queueOrSubscription})
+	return tx, apm.ContextWithTransaction(ctx, tx)
+}
+
+func serviceBusSpan(entity, action string) messagingSpan {
+	return messagingSpan{
+		service: "AzureServiceBus",
+		subtype: "azureservicebus",
+		entity:  entity,
+		action:  action,
+	}
+}
+
+// messageAge returns the time elapsed since enqueuedTime,
+// or zero if enqueuedTime is nil or in the future.
+func messageAge(enqueuedTime *time.Time) time.Duration {
+	if enqueuedTime == nil {
+		return 0
+	}
+	if age := time.Since(*enqueuedTime); age > 0 {
+		return age
+	}
+	return 0
+}
@@ -0,0 +1,230 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apmazure // import "go.elastic.co/apm/module/apmazure/v2"
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.elastic.co/apm/module/apmhttp/v2"
+	"go.elastic.co/apm/v2"
+	"go.elastic.co/apm/v2/apmtest"
+	"go.elastic.co/apm/v2/model"
+)
+
+func TestServiceBusSendMessage(t *testing.T) {
+	var sender recordingSender
+	s := WrapServiceBusSender(&sender, "myqueue")
+
+	// The caller's properties must not be modified, as
+	// they may be shared by other messages.
+	appProps := map[string]interface{}{"key": "value", "tracestate": "foo=bar"}
+	tx, spans, errs := apmtest.WithTransaction(func(ctx context.Context) {
+		msg := &azservicebus.Message{
+			Body:                  []byte("hello"),
+			ApplicationProperties: appProps,
+		}
+		require.NoError(t, s.SendMessage(ctx, msg, nil))
+	})
+	assert.Equal(t, map[string]interface{}{"key": "value", "tracestate": "foo=bar"}, appProps)
+	require.Empty(t, errs)
+	require.Len(t, spans, 1)
+	span := spans[0]
+
+	assert.Equal(t, "AzureServiceBus SEND to myqueue", span.Name)
+	assert.Equal(t, "messaging", span.Type)
+	assert.Equal(t, "azureservicebus", span.Subtype)
+	assert.Equal(t, "send", span.Action)
+	assert.Equal(t, "success", span.Outcome)
+	assert.Equal(t, "myqueue", span.Context.Message.Queue.Name)
+	assert.Equal(t, "azureservicebus/myqueue", span.Context.Destination.Service.Resource)
+	assert.Equal(t, "azureservicebus", span.Context.Service.Target.Type)
+	assert.Equal(t, "myqueue", span.Context.Service.Target.Name)
+
+	require.Len(t, sender.messages, 1)
+	props := sender.messages[0].ApplicationProperties
+	traceparent := formatTraceparent(tx.TraceID, span.ID)
+	assert.Equal(t, map[string]interface{}{
+		"key":                     "value",
+		"traceparent":             traceparent,
+		"elastic-apm-traceparent": traceparent,
+		"Diagnostic-Id":           traceparent,
+		"tracestate":              "es=s:1",
+	}, props)
+}
+
+func TestServiceBusSendMessageError(t *testing.T) {
+	sender := recordingSender{err: errors.New("boom")}
+	s := WrapServiceBusSender(&sender, "mytopic")
+
+	_, spans, errs := apmtest.WithTransaction(func(ctx context.Context) {
+		assert.Error(t, s.SendMessage(ctx, &azservicebus.Message{}, nil))
+	})
+	require.Len(t, spans, 1)
+	require.Len(t, errs, 1)
+	assert.Equal(t, "failure", spans[0].Outcome)
+	assert.Equal(t, spans[0].ID, errs[0].ParentID)
+}
+
+func TestServiceBusSendMessageNoTransaction(t *testing.T) {
+	var sender recordingSender
+	s := WrapServiceBusSender(&sender, "myqueue")
+	msg := &azservicebus.Message{}
+	require.NoError(t, s.SendMessage(context.Background(), msg, nil))
+	assert.Nil(t, msg.ApplicationProperties)
+}
+
+func TestServiceBusSendMessageBatch(t *testing.T) {
+	var sender recordingSender
+	s := WrapServiceBusSender(&sender, "myqueue")
+
+	tx, spans, _ := apmtest.WithTransaction(func(ctx context.Context) {
+		msg := &azservicebus.Message{}
+		// The zero MessageBatch has no capacity, so AddMessage
+		// fails; the properties are set regardless.
+		s.AddMessage(ctx, &azservicebus.MessageBatch{}, msg, nil)
+		traceparent := apmhttp.FormatTraceparentHeader(apm.TransactionFromContext(ctx).TraceContext())
+		assert.Equal(t, traceparent, msg.ApplicationProperties["traceparent"])
+		require.NoError(t, s.SendMessageBatch(ctx, &azservicebus.MessageBatch{}, nil))
+	})
+	require.Len(t, spans, 1)
+	assert.Equal(t, "AzureServiceBus SEND to myqueue", spans[0].Name)
+	assert.Equal(t, "send", spans[0].Action)
+	assert.Equal(t, tx.ID, spans[0].ParentID)
+	assert.Equal(t, 1, sender.batches)
+}
+
+func TestServiceBusReceiveMessages(t *testing.T) {
+	receiver := fakeReceiver{msgs: []*azservicebus.ReceivedMessage{{}}}
+	r := WrapServiceBusReceiver(&receiver, "myqueue")
+
+	_, spans, _ := apmtest.WithTransaction(func(ctx context.Context) {
+		msgs, err := r.ReceiveMessages(ctx, 10, nil)
+		require.NoError(t, err)
+		assert.Len(t, msgs, 1)
+	})
+	require.Len(t, spans, 1)
+	assert.Equal(t, "AzureServiceBus RECEIVE from myqueue", spans[0].Name)
+	assert.Equal(t, "receive", spans[0].Action)
+	assert.Equal(t, "azureservicebus/myqueue", spans[0].Context.Destination.Service.Resource)
+}
+
+func TestStartServiceBusTransaction(t *testing.T) {
+	tracer := apmtest.NewRecordingTracer()
+	defer tracer.Close()
+
+	traceContext := apm.TraceContext{
+		Trace:   apm.TraceID{1, 2, 3},
+		Span:    apm.SpanID{4, 5, 6},
+		Options: apm.TraceOptions(0).WithRecorded(true),
+	}
+	enqueued := time.Now().Add(-time.Second)
+	msg := &azservicebus.ReceivedMessage{
+		ApplicationProperties: map[string]interface{}{
+			"Diagnostic-Id": apmhttp.FormatTraceparentHeader(traceContext),
+		},
+		EnqueuedTime:  &enqueued,
+		DeliveryCount: 2,
+	}
+	tx, ctx := StartServiceBusTransaction(context.Background(), tracer.Tracer, "myqueue", msg)
+	assert.Equal(t, tx, apm.TransactionFromContext(ctx))
+	tx.End()
+	tracer.Flush(nil)
+
+	payloads := tracer.Payloads()
+	require.Len(t, payloads.Transactions, 1)
+	transaction := payloads.Transactions[0]
+	assert.Equal(t, "AzureServiceBus RECEIVE from myqueue", transaction.Name)
+	assert.Equal(t, "messaging", transaction.Type)
+	assert.Equal(t, model.TraceID(traceContext.Trace), transaction.TraceID)
+	assert.Equal(t, model.SpanID(traceContext.Span), transaction.ParentID)
+	assert.Equal(t, "myqueue", transaction.Context.Message.Queue.Name)
+	require.NotNil(t, transaction.Context.Message.Age)
+	assert.InDelta(t, 1000, transaction.Context.Message.Age.Millis, 500)
+	assert.Equal(t, model.IfaceMap{{Key: "delivery_count", Value: 2.0}}, transaction.Context.Tags)
+}
+
+func TestStartServiceBusBatchTransaction(t *testing.T) {
+	tracer := apmtest.NewRecordingTracer()
+	defer tracer.Close()
+
+	traceContext := apm.TraceContext{
+		Trace:   apm.TraceID{1, 2, 3},
+		Span:    apm.SpanID{4, 5, 6},
+		Options: apm.TraceOptions(0).WithRecorded(true),
+	}
+	msgs := []*azservicebus.ReceivedMessage{{
+		ApplicationProperties: map[string]interface{}{
+			"traceparent": apmhttp.FormatTraceparentHeader(traceContext),
+		},
+	}, {
+		// No trace context.
+	}}
+	tx, _ := StartServiceBusBatchTransaction(context.Background(), tracer.Tracer, "myqueue", msgs)
+	tx.End()
+	tracer.Flush(nil)
+
+	payloads := tracer.Payloads()
+	require.Len(t, payloads.Transactions, 1)
+	transaction := payloads.Transactions[0]
+	assert.Equal(t, "AzureServiceBus RECEIVE from myqueue", transaction.Name)
+	assert.Zero(t, transaction.ParentID)
+	assert.Equal(t, []model.SpanLink{{
+		TraceID: model.TraceID(traceContext.Trace),
+		SpanID:  model.SpanID(traceContext.Span),
+	}}, transaction.Links)
+}
+
+type recordingSender struct {
+	messages []*azservicebus.Message
+	batches  int
+	err      error
+}
+
+func (s *recordingSender) SendMessage(ctx context.Context, message *azservicebus.Message, options *azservicebus.SendMessageOptions) error {
+	s.messages = append(s.messages, message)
+	return s.err
+}
+
+func (s *recordingSender) SendMessageBatch(ctx context.Context, batch *azservicebus.MessageBatch, options *azservicebus.SendMessageBatchOptions) error {
+	s.batches++
+	return s.err
+}
+
+type fakeReceiver struct {
+	msgs []*azservicebus.ReceivedMessage
+	err  error
+}
+
+func (r *fakeReceiver) ReceiveMessages(ctx context.Context, maxMessages int, options *azservicebus.ReceiveMessagesOptions) ([]*azservicebus.ReceivedMessage, error) {
+	return r.msgs, r.err
+}
+
+func formatTraceparent(traceID model.TraceID, spanID model.SpanID) string {
+	return apmhttp.FormatTraceparentHeader(apm.TraceContext{
+		Trace:   apm.TraceID(traceID),
+		Span:    apm.SpanID(spanID),
+		Options: apm.TraceOptions(0).WithRecorded(true),
+	})
+}
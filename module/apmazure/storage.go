@@ -32,6 +32,8 @@ import (
 func init() {
 	stacktrace.RegisterLibraryPackage(
 		"github.com/Azure/azure-pipeline-go",
+		"github.com/Azure/azure-sdk-for-go/sdk/messaging/azeventhubs",
+		"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus",
 		"github.com/Azure/azure-storage-blob-go/azblob",
 		"github.com/Azure/azure-storage-file-go/azfile",
 		"github.com/Azure/azure-storage-queue-go/azqueue",
@@ -0,0 +1,12 @@
+module go.elastic.co/apm/module/apmconfigfile/v2
+
+require (
+	github.com/pkg/errors v0.9.1
+	github.com/stretchr/testify v1.6.1
+	go.elastic.co/apm/v2 v2.1.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+replace go.elastic.co/apm/v2 => ../..
+
+go 1.15
@@ -0,0 +1,153 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apmconfigfile // import "go.elastic.co/apm/module/apmconfigfile/v2"
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// parseYAML parses a YAML (or JSON) document holding either a mapping of
+// config attributes, or a sequence of sections.
+func parseYAML(data []byte) ([]section, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if len(doc.Content) == 0 {
+		// Empty document.
+		return nil, nil
+	}
+	root := doc.Content[0]
+	switch root.Kind {
+	case yaml.MappingNode:
+		settings, err := parseYAMLSettings(root)
+		if err != nil {
+			return nil, err
+		}
+		return []section{{settings: settings}}, nil
+	case yaml.SequenceNode:
+		sections := make([]section, len(root.Content))
+		for i, node := range root.Content {
+			if err := parseYAMLSection(node, &sections[i]); err != nil {
+				return nil, errors.Wrapf(err, "invalid section %d", i)
+			}
+		}
+		return sections, nil
+	}
+	return nil, errors.Errorf("line %d: expected mapping or sequence", root.Line)
+}
+
+func parseYAMLSection(node *yaml.Node, out *section) error {
+	if node.Kind != yaml.MappingNode {
+		return errors.Errorf("line %d: expected mapping", node.Line)
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		switch key.Value {
+		case "service":
+			if value.Kind != yaml.MappingNode {
+				return errors.Errorf("line %d: expected mapping for service", value.Line)
+			}
+			service, err := parseYAMLSettings(value)
+			if err != nil {
+				return err
+			}
+			for k, v := range service {
+				switch k {
+				case "name":
+					out.name = v
+				case "environment":
+					out.environment = v
+				default:
+					return errors.Errorf("line %d: unknown service field %q", value.Line, k)
+				}
+			}
+		case "settings":
+			settings, err := parseYAMLSettings(value)
+			if err != nil {
+				return err
+			}
+			out.settings = settings
+		default:
+			return errors.Errorf("line %d: unknown section field %q", key.Line, key.Value)
+		}
+	}
+	return nil
+}
+
+// parseYAMLSettings parses a mapping of scalars. Scalar values are taken
+// verbatim, so that e.g. "0.10" is not reformatted as "0.1".
+func parseYAMLSettings(node *yaml.Node) (map[string]string, error) {
+	if node.Kind != yaml.MappingNode {
+		return nil, errors.Errorf("line %d: expected mapping", node.Line)
+	}
+	settings := make(map[string]string, len(node.Content)/2)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		if value.Kind != yaml.ScalarNode {
+			return nil, errors.Errorf("line %d: expected scalar value for %q", value.Line, key.Value)
+		}
+		settings[key.Value] = value.Value
+	}
+	return settings, nil
+}
+
+// parseProperties parses "key=value" lines, with optional "[name]"
+// or "[name/environment]" section headers.
+func parseProperties(data []byte) ([]section, error) {
+	sections := []section{{settings: make(map[string]string)}}
+	current := &sections[0]
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line[0] == '!' {
+			continue
+		}
+		if line[0] == '[' {
+			if line[len(line)-1] != ']' {
+				return nil, errors.Errorf("line %d: invalid section header %q", lineno, line)
+			}
+			header := strings.TrimSpace(line[1 : len(line)-1])
+			s := section{settings: make(map[string]string)}
+			if i := strings.IndexByte(header, '/'); i >= 0 {
+				s.name = strings.TrimSpace(header[:i])
+				s.environment = strings.TrimSpace(header[i+1:])
+			} else {
+				s.name = header
+			}
+			sections = append(sections, s)
+			current = &sections[len(sections)-1]
+			continue
+		}
+		i := strings.IndexAny(line, "=:")
+		if i <= 0 {
+			return nil, errors.Errorf("line %d: expected key=value", lineno)
+		}
+		key := strings.TrimSpace(line[:i])
+		current.settings[key] = strings.TrimSpace(line[i+1:])
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return sections, nil
+}
@@ -0,0 +1,194 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apmconfigfile // import "go.elastic.co/apm/module/apmconfigfile/v2"
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"go.elastic.co/apm/v2/apmconfig"
+)
+
+// DefaultInterval is the default interval at which the config file is polled.
+const DefaultInterval = 10 * time.Second
+
+// Watcher is an apmconfig.Watcher which watches a file for changes.
+type Watcher struct {
+	path     string
+	interval time.Duration
+}
+
+// NewWatcher returns a new Watcher which watches the file at path,
+// polling it for changes at the interval set with WithInterval,
+// or DefaultInterval if unspecified. Polling is used so that files
+// which are replaced by renaming, as with Kubernetes ConfigMap volume
+// mounts, are reliably reloaded.
+//
+// The file format is determined by its extension: ".yaml" or ".yml"
+// for YAML, ".json" for JSON, and anything else for properties.
+//
+// YAML and JSON files may hold either a mapping of config attributes
+// to scalar values, which apply to all services, or a sequence of
+// sections, each with a "settings" mapping of config attributes and
+// an optional "service" mapping with "name" and "environment". The
+// settings of all sections matching the watched service are merged
+// in order, so that later sections override earlier ones. A section
+// matches if its service name and environment are empty, or equal to
+// those of the watched service. For example:
+//
+//	# Sample 10% of transactions, and all of those for "checkout"
+//	# in the "production" environment.
+//	- settings:
+//	    transaction_sample_rate: 0.1
+//	- service:
+//	    name: checkout
+//	    environment: production
+//	  settings:
+//	    transaction_sample_rate: 1.0
+//
+// Properties files hold "key=value" lines, with comments beginning with
+// "#" or "!". Lines following a "[name]" or "[name/environment]" section
+// header apply only to the named service, and environment if specified;
+// an empty name matches all services, as in "[/production]".
+func NewWatcher(path string, opts ...Option) *Watcher {
+	w := &Watcher{path: path, interval: DefaultInterval}
+	for _, opt := range opts {
+		opt(w)
+	}
+	return w
+}
+
+// Option sets options for a Watcher.
+type Option func(*Watcher)
+
+// WithInterval returns an Option which sets the interval at which
+// the config file is polled for changes.
+func WithInterval(d time.Duration) Option {
+	if d <= 0 {
+		panic("d <= 0")
+	}
+	return func(w *Watcher) {
+		w.interval = d
+	}
+}
+
+// WatchConfig watches the config file for changes, sending a Change
+// with the config attributes matching args whenever the file content
+// changes. The first Change is sent once the file has been read.
+//
+// If the file cannot be read or parsed, a Change with the Err field
+// set is sent, and the watcher continues watching for changes.
+func (w *Watcher) WatchConfig(ctx context.Context, args apmconfig.WatchParams) <-chan apmconfig.Change {
+	changes := make(chan apmconfig.Change)
+	go func() {
+		defer close(changes)
+
+		var content []byte
+		var lastErr string
+		var out chan apmconfig.Change
+		var change apmconfig.Change
+		ticker := time.NewTicker(w.interval)
+		defer ticker.Stop()
+		for first := true; ; first = false {
+			if !first {
+				select {
+				case <-ctx.Done():
+					return
+				case out <- change:
+					out = nil
+					change = apmconfig.Change{}
+					continue
+				case <-ticker.C:
+				}
+			}
+
+			data, err := ioutil.ReadFile(w.path)
+			if err == nil && content != nil && bytes.Equal(data, content) {
+				continue
+			}
+			var attrs map[string]string
+			if err == nil {
+				attrs, err = w.parse(data, args)
+			}
+			if err != nil {
+				// Only report each distinct error once, rather
+				// than on every poll of a missing or bad file.
+				if err.Error() != lastErr {
+					lastErr = err.Error()
+					change = apmconfig.Change{Err: err}
+					out = changes
+				}
+				content = nil
+				continue
+			}
+			lastErr = ""
+			content = data
+			change = apmconfig.Change{Attrs: attrs}
+			out = changes
+		}
+	}()
+	return changes
+}
+
+func (w *Watcher) parse(data []byte, args apmconfig.WatchParams) (map[string]string, error) {
+	var sections []section
+	var err error
+	switch strings.ToLower(filepath.Ext(w.path)) {
+	case ".yaml", ".yml", ".json":
+		// JSON is a subset of YAML, so we use the same parser.
+		sections, err = parseYAML(data)
+	default:
+		sections, err = parseProperties(data)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse %s", w.path)
+	}
+	attrs := make(map[string]string)
+	for _, s := range sections {
+		if s.matches(args) {
+			for k, v := range s.settings {
+				attrs[k] = v
+			}
+		}
+	}
+	return attrs, nil
+}
+
+// section holds config attributes for services matching
+// the service name and environment, if non-empty.
+type section struct {
+	name        string
+	environment string
+	settings    map[string]string
+}
+
+func (s *section) matches(args apmconfig.WatchParams) bool {
+	if s.name != "" && s.name != args.Service.Name {
+		return false
+	}
+	if s.environment != "" && s.environment != args.Service.Environment {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,190 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apmconfigfile_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.elastic.co/apm/module/apmconfigfile/v2"
+	"go.elastic.co/apm/v2/apmconfig"
+	"go.elastic.co/apm/v2/apmtest"
+)
+
+func TestWatcherYAML(t *testing.T) {
+	path := writeFile(t, "config.yaml", `
+- settings:
+    transaction_sample_rate: 0.10
+    capture_body: all
+- service:
+    name: checkout
+  settings:
+    transaction_sample_rate: 1
+- service:
+    name: checkout
+    environment: staging
+  settings:
+    capture_body: "off"
+`)
+	assert.Equal(t, map[string]string{
+		"transaction_sample_rate": "0.10",
+		"capture_body":            "all",
+	}, watchOnce(t, path, "other", ""))
+	assert.Equal(t, map[string]string{
+		"transaction_sample_rate": "1",
+		"capture_body":            "all",
+	}, watchOnce(t, path, "checkout", "production"))
+	assert.Equal(t, map[string]string{
+		"transaction_sample_rate": "1",
+		"capture_body":            "off",
+	}, watchOnce(t, path, "checkout", "staging"))
+}
+
+func TestWatcherJSON(t *testing.T) {
+	path := writeFile(t, "config.json", `{"transaction_sample_rate": 0.5, "recording": false}`)
+	assert.Equal(t, map[string]string{
+		"transaction_sample_rate": "0.5",
+		"recording":               "false",
+	}, watchOnce(t, path, "service", ""))
+}
+
+func TestWatcherProperties(t *testing.T) {
+	path := writeFile(t, "config.properties", `
+# comment
+transaction_sample_rate=0.1
+! another comment
+transaction_max_spans: 100
+
+[checkout]
+transaction_sample_rate = 1
+
+[/production]
+capture_body=all
+`)
+	assert.Equal(t, map[string]string{
+		"transaction_sample_rate": "0.1",
+		"transaction_max_spans":   "100",
+	}, watchOnce(t, path, "other", "staging"))
+	assert.Equal(t, map[string]string{
+		"transaction_sample_rate": "1",
+		"transaction_max_spans":   "100",
+		"capture_body":            "all",
+	}, watchOnce(t, path, "checkout", "production"))
+}
+
+func TestWatcherReload(t *testing.T) {
+	path := writeFile(t, "config.yaml", "transaction_sample_rate: 0.1\n")
+	w := apmconfigfile.NewWatcher(path, apmconfigfile.WithInterval(time.Millisecond))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changes := w.WatchConfig(ctx, apmconfig.WatchParams{})
+
+	change := receiveChange(t, changes)
+	require.NoError(t, change.Err)
+	assert.Equal(t, map[string]string{"transaction_sample_rate": "0.1"}, change.Attrs)
+
+	// Replace the file by renaming, as with Kubernetes ConfigMaps.
+	tmp := path + ".tmp"
+	require.NoError(t, ioutil.WriteFile(tmp, []byte("transaction_sample_rate: [\n"), 0644))
+	require.NoError(t, os.Rename(tmp, path))
+	change = receiveChange(t, changes)
+	assert.Error(t, change.Err)
+	assert.Nil(t, change.Attrs)
+
+	require.NoError(t, ioutil.WriteFile(path, []byte("transaction_sample_rate: 0.2\n"), 0644))
+	change = receiveChange(t, changes)
+	require.NoError(t, change.Err)
+	assert.Equal(t, map[string]string{"transaction_sample_rate": "0.2"}, change.Attrs)
+
+	require.NoError(t, os.Remove(path))
+	change = receiveChange(t, changes)
+	assert.True(t, os.IsNotExist(change.Err))
+
+	cancel()
+	for range changes {
+	}
+}
+
+func TestWatcherInvalid(t *testing.T) {
+	for name, content := range map[string]string{
+		"scalar.yaml":       "foo",
+		"nested.yaml":       "foo: {bar: baz}",
+		"section.yaml":      "- foo: bar",
+		"service.yaml":      "- service: {version: 1}",
+		"header.properties": "[foo",
+		"line.properties":   "foo",
+	} {
+		path := writeFile(t, name, content)
+		w := apmconfigfile.NewWatcher(path)
+		ctx, cancel := context.WithCancel(context.Background())
+		change := receiveChange(t, w.WatchConfig(ctx, apmconfig.WatchParams{}))
+		assert.Error(t, change.Err, name)
+		cancel()
+	}
+}
+
+func watchOnce(t *testing.T, path, serviceName, serviceEnvironment string) map[string]string {
+	var params apmconfig.WatchParams
+	params.Service.Name = serviceName
+	params.Service.Environment = serviceEnvironment
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	change := receiveChange(t, apmconfigfile.NewWatcher(path).WatchConfig(ctx, params))
+	require.NoError(t, change.Err)
+	return change.Attrs
+}
+
+func receiveChange(t *testing.T, changes <-chan apmconfig.Change) apmconfig.Change {
+	select {
+	case change, ok := <-changes:
+		require.True(t, ok)
+		return change
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for config change")
+	}
+	panic("unreachable")
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+	return path
+}
+
+func TestWatcherTracer(t *testing.T) {
+	path := writeFile(t, "config.yaml", "transaction_sample_rate: 0\n")
+	tracer := apmtest.NewRecordingTracer()
+	defer tracer.Close()
+	tracer.SetConfigWatcher(apmconfigfile.NewWatcher(path))
+
+	deadline := time.Now().Add(10 * time.Second)
+	for tracer.StartTransaction("name", "type").Sampled() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for config update")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -99,6 +99,9 @@ func StartTransactionWithBody(
 		tracestateHeader := string(ctx.Request.Header.Peek(apmhttp.TracestateHeader))
 		traceContext.State, _ = apmhttp.ParseTracestateHeader(strings.Split(tracestateHeader, ",")...)
 	}
+	if baggageHeader := ctx.Request.Header.Peek(apmhttp.BaggageHeader); len(baggageHeader) != 0 {
+		traceContext.Baggage, _ = apmhttp.ParseBaggageHeader(string(baggageHeader))
+	}
 
 	tx := tracer.StartTransactionOptions(name, "request", apm.TransactionOptions{TraceContext: traceContext})
 
@@ -0,0 +1,165 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apmgcp // import "go.elastic.co/apm/module/apmgcp/v2"
+
+import (
+	"strings"
+
+	"cloud.google.com/go/firestore/apiv1/firestorepb"
+
+	"go.elastic.co/apm/v2"
+)
+
+const (
+	firestoreService = "google.firestore.v1.Firestore"
+	firestoreSubtype = "firestore"
+)
+
+// firestoreStreamingMethods holds the streaming Firestore methods for
+// which spans are reported. The long-lived Listen and Write streams
+// are not traced.
+var firestoreStreamingMethods = map[string]bool{
+	"BatchGetDocuments":   true,
+	"RunQuery":            true,
+	"RunAggregationQuery": true,
+}
+
+// firestoreOperation is an operation for a Firestore RPC.
+type firestoreOperation struct {
+	method     string
+	database   string
+	collection string
+}
+
+func newFirestoreOperation(method string, req interface{}) (operation, bool) {
+	switch method {
+	case "Listen", "Write":
+		return nil, false
+	}
+	op := &firestoreOperation{method: method}
+	if req == nil {
+		if !firestoreStreamingMethods[method] {
+			return nil, false
+		}
+	} else {
+		op.setRequest(req)
+	}
+	return op, true
+}
+
+// setRequest records the database and collection from the request.
+func (op *firestoreOperation) setRequest(req interface{}) {
+	var path string
+	switch req := req.(type) {
+	case interface{ GetDatabase() string }:
+		path = req.GetDatabase()
+	case interface{ GetParent() string }:
+		path = req.GetParent()
+	case interface{ GetName() string }:
+		path = req.GetName()
+	case *firestorepb.UpdateDocumentRequest:
+		path = req.GetDocument().GetName()
+	}
+	op.database, op.collection = parseFirestorePath(path)
+
+	switch req := req.(type) {
+	case *firestorepb.RunQueryRequest:
+		op.setQueryCollection(req.GetStructuredQuery())
+	case *firestorepb.RunAggregationQueryRequest:
+		op.setQueryCollection(req.GetStructuredAggregationQuery().GetStructuredQuery())
+	case *firestorepb.PartitionQueryRequest:
+		op.setQueryCollection(req.GetStructuredQuery())
+	case interface{ GetCollectionId() string }:
+		if id := req.GetCollectionId(); id != "" {
+			op.collection = id
+		}
+	}
+}
+
+func (op *firestoreOperation) setQueryCollection(query *firestorepb.StructuredQuery) {
+	if from := query.GetFrom(); len(from) > 0 {
+		op.collection = from[0].GetCollectionId()
+	}
+}
+
+func (op *firestoreOperation) spanName() string {
+	name := "Firestore " + op.method
+	if op.collection != "" {
+		name += " " + op.collection
+	}
+	return name
+}
+
+func (op *firestoreOperation) spanType() string {
+	return "db"
+}
+
+func (op *firestoreOperation) spanSubtype() string {
+	return firestoreSubtype
+}
+
+func (op *firestoreOperation) action() string {
+	return op.method
+}
+
+func (op *firestoreOperation) resource() string {
+	if op.database == "" {
+		return firestoreSubtype
+	}
+	return firestoreSubtype + "/" + op.database
+}
+
+func (op *firestoreOperation) targetName() string {
+	return op.database
+}
+
+func (op *firestoreOperation) setAdditional(span *apm.Span) {
+	span.Name = op.spanName()
+	span.Context.SetDatabase(apm.DatabaseSpanContext{
+		Instance: op.database,
+		Type:     firestoreSubtype,
+	})
+	span.Context.SetServiceTarget(apm.ServiceTargetSpanContext{
+		Type: firestoreSubtype,
+		Name: op.database,
+	})
+	span.Context.SetDestinationService(apm.DestinationServiceSpanContext{
+		Name:     firestoreSubtype,
+		Resource: op.resource(),
+	})
+}
+
+// parseFirestorePath returns the database ID and, for document paths,
+// the ID of the innermost collection from a Firestore resource name:
+//
+//	projects/{project}/databases/{database}/documents/{collection}/{document}
+func parseFirestorePath(path string) (database, collection string) {
+	parts := strings.Split(path, "/")
+	if len(parts) < 4 || parts[0] != "projects" || parts[2] != "databases" {
+		return "", ""
+	}
+	database = parts[3]
+	if docs := parts[4:]; len(docs) > 1 && docs[0] == "documents" {
+		// documents/{collection}/{document}/{collection}/...
+		// The innermost collection is the last odd-indexed
+		// component when counting from the "documents" prefix.
+		docs = docs[1:]
+		collection = docs[(len(docs)-1)&^1]
+	}
+	return database, collection
+}
@@ -0,0 +1,154 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apmgcp_test
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/firestore/apiv1/firestorepb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"go.elastic.co/apm/module/apmgcp/v2"
+	"go.elastic.co/apm/v2/apmtest"
+	"go.elastic.co/apm/v2/model"
+)
+
+func TestFirestore(t *testing.T) {
+	client := newFirestoreClient(t, &fakeFirestore{})
+
+	_, spans, errs := apmtest.WithTransaction(func(ctx context.Context) {
+		snap, err := client.Collection("users").Doc("alice").Get(ctx)
+		require.NoError(t, err)
+		assert.True(t, snap.Exists())
+
+		docs, err := client.Collection("users").Where("age", ">", 21).Documents(ctx).GetAll()
+		require.NoError(t, err)
+		assert.Len(t, docs, 1)
+
+		_, err = client.Collection("users").Doc("bob").Delete(ctx)
+		require.NoError(t, err)
+	})
+	require.Empty(t, errs)
+	require.Len(t, spans, 3)
+
+	names := make([]string, len(spans))
+	for i, span := range spans {
+		names[i] = span.Name
+		assert.Equal(t, "db", span.Type)
+		assert.Equal(t, "firestore", span.Subtype)
+		assert.Equal(t, "success", span.Outcome)
+		assert.Equal(t, &model.DatabaseSpanContext{Instance: "(default)", Type: "firestore"}, span.Context.Database)
+		assert.Equal(t, "firestore/(default)", span.Context.Destination.Service.Resource)
+		assert.Equal(t, &model.ServiceTargetSpanContext{Type: "firestore", Name: "(default)"}, span.Context.Service.Target)
+	}
+	assert.ElementsMatch(t, []string{
+		"Firestore BatchGetDocuments",
+		"Firestore RunQuery users",
+		"Firestore Commit",
+	}, names)
+}
+
+func TestFirestoreError(t *testing.T) {
+	client := newFirestoreClient(t, &fakeFirestore{commitErr: status.Error(codes.PermissionDenied, "denied")})
+
+	_, spans, errs := apmtest.WithTransaction(func(ctx context.Context) {
+		_, err := client.Collection("users").Doc("bob").Delete(ctx)
+		assert.Error(t, err)
+	})
+	require.Len(t, spans, 1)
+	require.Len(t, errs, 1)
+	assert.Equal(t, "Firestore Commit", spans[0].Name)
+	assert.Equal(t, "Commit", spans[0].Action)
+	assert.Equal(t, "failure", spans[0].Outcome)
+	assert.Equal(t, spans[0].ID, errs[0].ParentID)
+}
+
+type fakeFirestore struct {
+	firestorepb.UnimplementedFirestoreServer
+	commitErr error
+}
+
+func (*fakeFirestore) BatchGetDocuments(req *firestorepb.BatchGetDocumentsRequest, stream firestorepb.Firestore_BatchGetDocumentsServer) error {
+	for _, name := range req.Documents {
+		if err := stream.Send(&firestorepb.BatchGetDocumentsResponse{
+			Result: &firestorepb.BatchGetDocumentsResponse_Found{
+				Found: &firestorepb.Document{
+					Name:       name,
+					CreateTime: timestamppb.Now(),
+					UpdateTime: timestamppb.Now(),
+				},
+			},
+			ReadTime: timestamppb.Now(),
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (*fakeFirestore) RunQuery(req *firestorepb.RunQueryRequest, stream firestorepb.Firestore_RunQueryServer) error {
+	return stream.Send(&firestorepb.RunQueryResponse{
+		Document: &firestorepb.Document{
+			Name:       req.Parent + "/users/carol",
+			CreateTime: timestamppb.Now(),
+			UpdateTime: timestamppb.Now(),
+		},
+		ReadTime: timestamppb.Now(),
+	})
+}
+
+func (f *fakeFirestore) Commit(ctx context.Context, req *firestorepb.CommitRequest) (*firestorepb.CommitResponse, error) {
+	if f.commitErr != nil {
+		return nil, f.commitErr
+	}
+	results := make([]*firestorepb.WriteResult, len(req.Writes))
+	for i := range results {
+		results[i] = &firestorepb.WriteResult{UpdateTime: timestamppb.Now()}
+	}
+	return &firestorepb.CommitResponse{WriteResults: results, CommitTime: timestamppb.Now()}, nil
+}
+
+func newFirestoreClient(t testing.TB, srv firestorepb.FirestoreServer) *firestore.Client {
+	lis, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	s := grpc.NewServer()
+	firestorepb.RegisterFirestoreServer(s, srv)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.Dial(lis.Addr().String(),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithUnaryInterceptor(apmgcp.NewUnaryClientInterceptor()),
+		grpc.WithStreamInterceptor(apmgcp.NewStreamClientInterceptor()),
+	)
+	require.NoError(t, err)
+	client, err := firestore.NewClient(context.Background(), "project", option.WithGRPCConn(conn))
+	require.NoError(t, err)
+	t.Cleanup(func() { client.Close() })
+	return client
+}
@@ -0,0 +1,290 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apmgcp // import "go.elastic.co/apm/module/apmgcp/v2"
+
+import (
+	"context"
+	"io"
+	"strings"
+	"sync"
+
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"go.elastic.co/apm/v2"
+	"go.elastic.co/apm/v2/stacktrace"
+)
+
+func init() {
+	stacktrace.RegisterLibraryPackage(
+		"cloud.google.com/go",
+		"google.golang.org/api",
+		"google.golang.org/grpc",
+	)
+}
+
+// GRPCClientOptions returns client options for instrumenting the gRPC
+// connections of Google Cloud clients, such as those for Pub/Sub and
+// Firestore. For example:
+//
+//	client, err := firestore.NewClient(ctx, projectID, apmgcp.GRPCClientOptions()...)
+//
+// GRPCClientOptions has no effect for clients created with an existing
+// connection, using option.WithGRPCConn. In that case, the connection
+// should be dialled with the interceptors returned by
+// NewUnaryClientInterceptor and NewStreamClientInterceptor.
+func GRPCClientOptions() []option.ClientOption {
+	return []option.ClientOption{
+		option.WithGRPCDialOption(grpc.WithChainUnaryInterceptor(NewUnaryClientInterceptor())),
+		option.WithGRPCDialOption(grpc.WithChainStreamInterceptor(NewStreamClientInterceptor())),
+	}
+}
+
+// NewUnaryClientInterceptor returns a grpc.UnaryClientInterceptor that
+// reports spans for Google Cloud unary RPCs made with a context containing
+// a transaction.
+//
+// Spans are reported for Pub/Sub Publish and Pull, and for Firestore
+// document and query operations. Pub/Sub messages published with the
+// Publish RPC have the trace context added to their attributes.
+func NewUnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(
+		ctx context.Context,
+		method string,
+		req, resp interface{},
+		cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker,
+		opts ...grpc.CallOption,
+	) error {
+		op, ok := newOperation(method, req)
+		if !ok {
+			return invoker(ctx, method, req, resp, cc, opts...)
+		}
+		span, ctx := startOperationSpan(ctx, op)
+		err := invoker(ctx, method, req, resp, cc, opts...)
+		endOperationSpan(ctx, span, err)
+		return err
+	}
+}
+
+// NewStreamClientInterceptor returns a grpc.StreamClientInterceptor that
+// reports spans for Google Cloud streaming RPCs made with a context
+// containing a transaction.
+//
+// Spans are reported for Firestore queries and batched document reads,
+// and are ended when the stream is closed.
+func NewStreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(
+		ctx context.Context,
+		desc *grpc.StreamDesc,
+		cc *grpc.ClientConn,
+		method string,
+		streamer grpc.Streamer,
+		opts ...grpc.CallOption,
+	) (grpc.ClientStream, error) {
+		// The request message for a stream is not available
+		// to the interceptor, so it is captured by the
+		// clientStream wrapper when it is sent.
+		op, ok := newOperation(method, nil)
+		if !ok {
+			return streamer(ctx, desc, cc, method, opts...)
+		}
+		span, spanCtx := startOperationSpan(ctx, op)
+		if span == nil {
+			return streamer(ctx, desc, cc, method, opts...)
+		}
+		stream, err := streamer(spanCtx, desc, cc, method, opts...)
+		if err != nil {
+			endOperationSpan(spanCtx, span, err)
+			return nil, err
+		}
+		wrapped := &clientStream{ClientStream: stream, span: span, op: op}
+		wrapped.end = func() { endOperationSpan(spanCtx, span, wrapped.getError()) }
+		go func() {
+			// The stream's context is done once the stream has
+			// finished, including when it is abandoned by the
+			// client before reading all responses.
+			<-stream.Context().Done()
+			wrapped.endSpan()
+		}()
+		return wrapped, nil
+	}
+}
+
+// operation describes a Google Cloud RPC for which a span is reported.
+type operation interface {
+	spanName() string
+	spanType() string
+	spanSubtype() string
+	action() string
+	resource() string
+	targetName() string
+	setAdditional(*apm.Span)
+}
+
+// requestSetter is implemented by operations for streaming RPCs,
+// whose request message is only available once it has been sent.
+type requestSetter interface {
+	setRequest(req interface{})
+}
+
+// propagator is implemented by operations which propagate the
+// trace context in the request message.
+type propagator interface {
+	propagateTraceContext(traceContext apm.TraceContext, propagateLegacyHeader bool)
+}
+
+// newOperation returns an operation for the given gRPC method and
+// request, and a boolean indicating whether the method is supported.
+func newOperation(method string, req interface{}) (operation, bool) {
+	method = strings.TrimPrefix(method, "/")
+	i := strings.LastIndex(method, "/")
+	if i < 0 {
+		return nil, false
+	}
+	service, name := method[:i], method[i+1:]
+	switch service {
+	case pubsubPublisherService, pubsubSubscriberService:
+		return newPubSubOperation(name, req)
+	case firestoreService:
+		return newFirestoreOperation(name, req)
+	}
+	return nil, false
+}
+
+// startOperationSpan starts a span for op if ctx contains a transaction,
+// returning the span and a context containing it. If the transaction is
+// not sampled or the span is dropped, the transaction's trace context is
+// propagated, if supported by op, and startOperationSpan returns a nil span.
+func startOperationSpan(ctx context.Context, op operation) (*apm.Span, context.Context) {
+	tx := apm.TransactionFromContext(ctx)
+	if tx == nil {
+		return nil, ctx
+	}
+	traceContext := tx.TraceContext()
+	var span *apm.Span
+	if traceContext.Options.Recorded() {
+		span, _ = apm.StartSpanOptions(ctx, op.spanName(), op.spanType(), apm.SpanOptions{ExitSpan: true})
+		if span.Dropped() {
+			span.End()
+			span = nil
+		} else {
+			traceContext = span.TraceContext()
+		}
+	}
+	if p, ok := op.(propagator); ok {
+		p.propagateTraceContext(traceContext, tx.ShouldPropagateLegacyHeader())
+	}
+	if span == nil {
+		return nil, ctx
+	}
+	span.Subtype = op.spanSubtype()
+	span.Action = op.action()
+	op.setAdditional(span)
+	span.Context.SetDestinationService(apm.DestinationServiceSpanContext{
+		Name:     op.spanSubtype(),
+		Resource: op.resource(),
+	})
+	span.Context.SetServiceTarget(apm.ServiceTargetSpanContext{
+		Type: op.spanSubtype(),
+		Name: op.targetName(),
+	})
+	return span, apm.ContextWithSpan(ctx, span)
+}
+
+// endOperationSpan sets the outcome of span according to err, reporting
+// err if non-nil, and ends the span. endOperationSpan is a no-op if span
+// is nil.
+func endOperationSpan(ctx context.Context, span *apm.Span, err error) {
+	if span == nil {
+		return
+	}
+	if err != nil {
+		span.Outcome = "failure"
+		apm.CaptureError(ctx, err).Send()
+	} else {
+		span.Outcome = "success"
+	}
+	span.End()
+}
+
+// clientStream wraps grpc.ClientStream to record the first request
+// message in the span, to intercept errors, and to end the span when
+// the stream has finished.
+type clientStream struct {
+	grpc.ClientStream
+	span *apm.Span
+	op   operation
+	end  func()
+	once sync.Once
+
+	mu   sync.RWMutex
+	sent bool
+	err  error
+}
+
+func (s *clientStream) SendMsg(m interface{}) error {
+	s.mu.Lock()
+	if !s.sent {
+		s.sent = true
+		if r, ok := s.op.(requestSetter); ok {
+			r.setRequest(m)
+			s.op.setAdditional(s.span)
+		}
+	}
+	s.mu.Unlock()
+	err := s.ClientStream.SendMsg(m)
+	s.setError(err)
+	return err
+}
+
+// RecvMsg receives a message, ending the span once RecvMsg
+// returns an error, including io.EOF at the end of the stream.
+func (s *clientStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err != nil {
+		s.setError(err)
+		s.endSpan()
+	}
+	return err
+}
+
+func (s *clientStream) endSpan() {
+	s.once.Do(s.end)
+}
+
+func (s *clientStream) getError() error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.err
+}
+
+// setError records err, unless it is nil or indicates the end of the
+// stream. Cancellation of the stream by the client after it has read
+// the results it needs, for example when iterating over a subset of
+// query results, is not treated as an error.
+func (s *clientStream) setError(err error) {
+	if err == nil || err == io.EOF || status.Code(err) == codes.Canceled {
+		return
+	}
+	s.mu.Lock()
+	s.err = err
+	s.mu.Unlock()
+}
@@ -0,0 +1,240 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apmgcp // import "go.elastic.co/apm/module/apmgcp/v2"
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"cloud.google.com/go/pubsub/apiv1/pubsubpb"
+
+	"go.elastic.co/apm/module/apmhttp/v2"
+	"go.elastic.co/apm/v2"
+)
+
+const (
+	pubsubPublisherService  = "google.pubsub.v1.Publisher"
+	pubsubSubscriberService = "google.pubsub.v1.Subscriber"
+	pubsubSubtype           = "pubsub"
+
+	// traceparentAttribute is the message attribute for W3C trace propagation.
+	traceparentAttribute = "traceparent"
+
+	// elasticTraceparentAttribute is the legacy message attribute
+	// for trace propagation.
+	elasticTraceparentAttribute = "elastic-apm-traceparent"
+
+	// tracestateAttribute is the message attribute for W3C tracestate.
+	tracestateAttribute = "tracestate"
+)
+
+// WrapTopic returns a Topic wrapping t, reporting spans for messages
+// published within the transaction or span contained in the context
+// passed to Publish.
+func WrapTopic(t *pubsub.Topic) *Topic {
+	return &Topic{Topic: t}
+}
+
+// Topic wraps a *pubsub.Topic to report spans for published messages
+// and propagate the trace context in the message attributes.
+type Topic struct {
+	*pubsub.Topic
+}
+
+// Publish publishes msg using the wrapped topic, reporting a span if ctx
+// contains a transaction, and adding trace context attributes to msg.
+//
+// Messages are published asynchronously in batches, so the span measures
+// the time from the call to Publish until the result is ready, including
+// the time the message spent waiting to be sent.
+func (t *Topic) Publish(ctx context.Context, msg *pubsub.Message) *pubsub.PublishResult {
+	op := &pubsubOperation{
+		name:   "Publish",
+		entity: t.ID(),
+		propagate: func(traceContext apm.TraceContext, propagateLegacyHeader bool) {
+			msg.Attributes = setTraceContextAttributes(msg.Attributes, traceContext, propagateLegacyHeader)
+		},
+	}
+	span, spanCtx := startOperationSpan(ctx, op)
+	result := t.Topic.Publish(ctx, msg)
+	if span != nil {
+		go func() {
+			<-result.Ready()
+			_, err := result.Get(context.Background())
+			endOperationSpan(spanCtx, span, err)
+		}()
+	}
+	return result
+}
+
+// StartPubSubTransaction starts a messaging transaction for processing msg,
+// received from the named subscription, continuing the trace propagated
+// in the message attributes, if any. StartPubSubTransaction returns the
+// transaction and a context containing it.
+//
+// The caller is responsible for ending the transaction once the message
+// has been processed.
+func StartPubSubTransaction(ctx context.Context, tracer *apm.Tracer, subscription string, msg *pubsub.Message) (*apm.Transaction, context.Context) {
+	var opts apm.TransactionOptions
+	if traceContext, ok := traceContextFromAttributes(msg.Attributes); ok {
+		opts.TraceContext = traceContext
+	}
+	tx := tracer.StartTransactionOptions("PubSub RECEIVE from "+subscription, "messaging", opts)
+	var age time.Duration
+	if !msg.PublishTime.IsZero() {
+		if age = time.Since(msg.PublishTime); age < 0 {
+			age = 0
+		}
+	}
+	tx.Context.SetMessage(apm.MessageContext{QueueName: subscription, Age: age})
+	if msg.DeliveryAttempt != nil {
+		tx.Context.SetLabel("delivery_count", *msg.DeliveryAttempt)
+	}
+	return tx, apm.ContextWithTransaction(ctx, tx)
+}
+
+// WrapReceiveFunc returns a function for passing to pubsub.Subscription.Receive,
+// which calls f with a context containing a transaction started with
+// StartPubSubTransaction, ending the transaction when f returns.
+//
+//	err := sub.Receive(ctx, apmgcp.WrapReceiveFunc(apm.DefaultTracer(), sub.ID(), handle))
+func WrapReceiveFunc(tracer *apm.Tracer, subscription string, f func(context.Context, *pubsub.Message)) func(context.Context, *pubsub.Message) {
+	return func(ctx context.Context, msg *pubsub.Message) {
+		tx, ctx := StartPubSubTransaction(ctx, tracer, subscription, msg)
+		defer tx.End()
+		f(ctx, msg)
+	}
+}
+
+// pubsubOperation is an operation for a Pub/Sub RPC.
+type pubsubOperation struct {
+	name      string
+	entity    string
+	propagate func(apm.TraceContext, bool)
+}
+
+func newPubSubOperation(name string, req interface{}) (operation, bool) {
+	switch req := req.(type) {
+	case *pubsubpb.PublishRequest:
+		return &pubsubOperation{
+			name:   name,
+			entity: resourceID(req.GetTopic()),
+			propagate: func(traceContext apm.TraceContext, propagateLegacyHeader bool) {
+				for _, msg := range req.GetMessages() {
+					if _, ok := msg.Attributes[traceparentAttribute]; ok {
+						// Already propagated by Topic.Publish.
+						continue
+					}
+					msg.Attributes = setTraceContextAttributes(msg.Attributes, traceContext, propagateLegacyHeader)
+				}
+			},
+		}, true
+	case *pubsubpb.PullRequest:
+		return &pubsubOperation{name: name, entity: resourceID(req.GetSubscription())}, true
+	}
+	return nil, false
+}
+
+func (op *pubsubOperation) spanName() string {
+	if op.name == "Pull" {
+		return "PubSub RECEIVE from " + op.entity
+	}
+	return "PubSub SEND to " + op.entity
+}
+
+func (op *pubsubOperation) spanType() string {
+	return "messaging"
+}
+
+func (op *pubsubOperation) spanSubtype() string {
+	return pubsubSubtype
+}
+
+func (op *pubsubOperation) action() string {
+	if op.name == "Pull" {
+		return "receive"
+	}
+	return "send"
+}
+
+func (op *pubsubOperation) resource() string {
+	return pubsubSubtype + "/" + op.entity
+}
+
+func (op *pubsubOperation) targetName() string {
+	return op.entity
+}
+
+func (op *pubsubOperation) setAdditional(span *apm.Span) {
+	span.Context.SetMessage(apm.MessageSpanContext{QueueName: op.entity})
+}
+
+func (op *pubsubOperation) propagateTraceContext(traceContext apm.TraceContext, propagateLegacyHeader bool) {
+	if op.propagate != nil {
+		op.propagate(traceContext, propagateLegacyHeader)
+	}
+}
+
+// resourceID returns the final component of a Google Cloud resource
+// name, e.g. "my-topic" for "projects/my-project/topics/my-topic".
+func resourceID(name string) string {
+	return name[strings.LastIndex(name, "/")+1:]
+}
+
+// setTraceContextAttributes returns a copy of attrs with the trace
+// context attributes set, replacing any existing values.
+//
+// The attributes are copied because callers may reuse the same map
+// for many messages, and the client library may refer to the map
+// from other goroutines while publishing.
+func setTraceContextAttributes(attrs map[string]string, traceContext apm.TraceContext, propagateLegacyHeader bool) map[string]string {
+	out := make(map[string]string, len(attrs)+3)
+	for k, v := range attrs {
+		out[k] = v
+	}
+	traceparent := apmhttp.FormatTraceparentHeader(traceContext)
+	out[traceparentAttribute] = traceparent
+	if propagateLegacyHeader {
+		out[elasticTraceparentAttribute] = traceparent
+	}
+	if tracestate := traceContext.State.String(); tracestate != "" {
+		out[tracestateAttribute] = tracestate
+	} else {
+		delete(out, tracestateAttribute)
+	}
+	return out
+}
+
+// traceContextFromAttributes returns the trace context propagated in the
+// given message attributes, and a boolean indicating whether it was found.
+func traceContextFromAttributes(attrs map[string]string) (apm.TraceContext, bool) {
+	traceparent := attrs[traceparentAttribute]
+	if traceparent == "" {
+		traceparent = attrs[elasticTraceparentAttribute]
+	}
+	traceContext, err := apmhttp.ParseTraceparentHeader(traceparent)
+	if err != nil {
+		return apm.TraceContext{}, false
+	}
+	if tracestate := attrs[tracestateAttribute]; tracestate != "" {
+		traceContext.State, _ = apmhttp.ParseTracestateHeader(tracestate)
+	}
+	return traceContext, true
+}
@@ -0,0 +1,211 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apmgcp_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	pubsubapi "cloud.google.com/go/pubsub/apiv1"
+	"cloud.google.com/go/pubsub/apiv1/pubsubpb"
+	"cloud.google.com/go/pubsub/pstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+
+	"go.elastic.co/apm/module/apmgcp/v2"
+	"go.elastic.co/apm/module/apmhttp/v2"
+	"go.elastic.co/apm/v2"
+	"go.elastic.co/apm/v2/apmtest"
+	"go.elastic.co/apm/v2/model"
+)
+
+func TestTopicPublish(t *testing.T) {
+	srv, conn := newPubSubServer(t)
+	client, err := pubsub.NewClient(context.Background(), "project", option.WithGRPCConn(conn))
+	require.NoError(t, err)
+	defer client.Close()
+	topic, err := client.CreateTopic(context.Background(), "mytopic")
+	require.NoError(t, err)
+	defer topic.Stop()
+
+	tracer := apmtest.NewRecordingTracer()
+	defer tracer.Close()
+	tx := tracer.StartTransaction("name", "type")
+	ctx := apm.ContextWithTransaction(context.Background(), tx)
+	// The caller's attributes must not be modified, as
+	// they may be shared by other messages.
+	attrs := map[string]string{"key": "value", "tracestate": "stale=1"}
+	msg := &pubsub.Message{Data: []byte("hello"), Attributes: attrs}
+	_, err = apmgcp.WrapTopic(topic).Publish(ctx, msg).Get(ctx)
+	require.NoError(t, err)
+	tx.End()
+	assert.Equal(t, map[string]string{"key": "value", "tracestate": "stale=1"}, attrs)
+
+	// The span is ended asynchronously, once the publish result is ready.
+	var spans []model.Span
+	require.Eventually(t, func() bool {
+		tracer.Flush(nil)
+		spans = tracer.Payloads().Spans
+		return len(spans) == 1
+	}, 10*time.Second, 10*time.Millisecond)
+	span := spans[0]
+
+	assert.Equal(t, "PubSub SEND to mytopic", span.Name)
+	assert.Equal(t, "messaging", span.Type)
+	assert.Equal(t, "pubsub", span.Subtype)
+	assert.Equal(t, "send", span.Action)
+	assert.Equal(t, "success", span.Outcome)
+	assert.Equal(t, "mytopic", span.Context.Message.Queue.Name)
+	assert.Equal(t, "pubsub/mytopic", span.Context.Destination.Service.Resource)
+	assert.Equal(t, &model.ServiceTargetSpanContext{Type: "pubsub", Name: "mytopic"}, span.Context.Service.Target)
+
+	msgs := srv.Messages()
+	require.Len(t, msgs, 1)
+	traceparent := apmhttp.FormatTraceparentHeader(apm.TraceContext{
+		Trace:   apm.TraceID(span.TraceID),
+		Span:    apm.SpanID(span.ID),
+		Options: apm.TraceOptions(0).WithRecorded(true),
+	})
+	assert.Equal(t, "value", msgs[0].Attributes["key"])
+	assert.Equal(t, traceparent, msgs[0].Attributes["traceparent"])
+	assert.Equal(t, traceparent, msgs[0].Attributes["elastic-apm-traceparent"])
+	assert.Equal(t, "es=s:1", msgs[0].Attributes["tracestate"])
+}
+
+func TestTopicPublishNoTransaction(t *testing.T) {
+	srv, conn := newPubSubServer(t)
+	client, err := pubsub.NewClient(context.Background(), "project", option.WithGRPCConn(conn))
+	require.NoError(t, err)
+	defer client.Close()
+	topic, err := client.CreateTopic(context.Background(), "mytopic")
+	require.NoError(t, err)
+	defer topic.Stop()
+
+	ctx := context.Background()
+	_, err = apmgcp.WrapTopic(topic).Publish(ctx, &pubsub.Message{Data: []byte("hello")}).Get(ctx)
+	require.NoError(t, err)
+
+	msgs := srv.Messages()
+	require.Len(t, msgs, 1)
+	assert.NotContains(t, msgs[0].Attributes, "traceparent")
+}
+
+func TestPublishPullInterceptor(t *testing.T) {
+	srv, conn := newPubSubServer(t)
+	ctx := context.Background()
+	publisher, err := pubsubapi.NewPublisherClient(ctx, option.WithGRPCConn(conn))
+	require.NoError(t, err)
+	subscriber, err := pubsubapi.NewSubscriberClient(ctx, option.WithGRPCConn(conn))
+	require.NoError(t, err)
+
+	_, err = publisher.CreateTopic(ctx, &pubsubpb.Topic{Name: "projects/project/topics/mytopic"})
+	require.NoError(t, err)
+	_, err = subscriber.CreateSubscription(ctx, &pubsubpb.Subscription{
+		Name:  "projects/project/subscriptions/mysub",
+		Topic: "projects/project/topics/mytopic",
+	})
+	require.NoError(t, err)
+
+	tx, spans, errs := apmtest.WithTransaction(func(ctx context.Context) {
+		_, err := publisher.Publish(ctx, &pubsubpb.PublishRequest{
+			Topic:    "projects/project/topics/mytopic",
+			Messages: []*pubsubpb.PubsubMessage{{Data: []byte("hello")}},
+		})
+		require.NoError(t, err)
+		resp, err := subscriber.Pull(ctx, &pubsubpb.PullRequest{
+			Subscription: "projects/project/subscriptions/mysub",
+			MaxMessages:  1,
+		})
+		require.NoError(t, err)
+		assert.Len(t, resp.ReceivedMessages, 1)
+	})
+	require.Empty(t, errs)
+	require.Len(t, spans, 2)
+	assert.Equal(t, "PubSub SEND to mytopic", spans[0].Name)
+	assert.Equal(t, "send", spans[0].Action)
+	assert.Equal(t, "PubSub RECEIVE from mysub", spans[1].Name)
+	assert.Equal(t, "receive", spans[1].Action)
+	assert.Equal(t, "pubsub/mysub", spans[1].Context.Destination.Service.Resource)
+
+	msgs := srv.Messages()
+	require.Len(t, msgs, 1)
+	traceContext, err := apmhttp.ParseTraceparentHeader(msgs[0].Attributes["traceparent"])
+	require.NoError(t, err)
+	assert.Equal(t, tx.TraceID, model.TraceID(traceContext.Trace))
+	assert.Equal(t, spans[0].ID, model.SpanID(traceContext.Span))
+}
+
+func TestWrapReceiveFunc(t *testing.T) {
+	srv, conn := newPubSubServer(t)
+	client, err := pubsub.NewClient(context.Background(), "project", option.WithGRPCConn(conn))
+	require.NoError(t, err)
+	defer client.Close()
+	topic, err := client.CreateTopic(context.Background(), "mytopic")
+	require.NoError(t, err)
+	sub, err := client.CreateSubscription(context.Background(), "mysub", pubsub.SubscriptionConfig{Topic: topic})
+	require.NoError(t, err)
+
+	traceContext := apm.TraceContext{
+		Trace:   apm.TraceID{1, 2, 3},
+		Span:    apm.SpanID{4, 5, 6},
+		Options: apm.TraceOptions(0).WithRecorded(true),
+	}
+	srv.Publish("projects/project/topics/mytopic", []byte("hello"), map[string]string{
+		"traceparent": apmhttp.FormatTraceparentHeader(traceContext),
+	})
+
+	tracer := apmtest.NewRecordingTracer()
+	defer tracer.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err = sub.Receive(ctx, apmgcp.WrapReceiveFunc(tracer.Tracer, sub.ID(), func(ctx context.Context, msg *pubsub.Message) {
+		assert.NotNil(t, apm.TransactionFromContext(ctx))
+		msg.Ack()
+		cancel()
+	}))
+	require.NoError(t, err)
+	tracer.Flush(nil)
+
+	payloads := tracer.Payloads()
+	require.Len(t, payloads.Transactions, 1)
+	transaction := payloads.Transactions[0]
+	assert.Equal(t, "PubSub RECEIVE from mysub", transaction.Name)
+	assert.Equal(t, "messaging", transaction.Type)
+	assert.Equal(t, model.TraceID(traceContext.Trace), transaction.TraceID)
+	assert.Equal(t, model.SpanID(traceContext.Span), transaction.ParentID)
+	assert.Equal(t, "mysub", transaction.Context.Message.Queue.Name)
+	assert.NotNil(t, transaction.Context.Message.Age)
+}
+
+func newPubSubServer(t testing.TB) (*pstest.Server, *grpc.ClientConn) {
+	srv := pstest.NewServer()
+	t.Cleanup(func() { srv.Close() })
+	conn, err := grpc.Dial(srv.Addr,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithUnaryInterceptor(apmgcp.NewUnaryClientInterceptor()),
+		grpc.WithStreamInterceptor(apmgcp.NewStreamClientInterceptor()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return srv, conn
+}
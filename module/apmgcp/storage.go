@@ -0,0 +1,286 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apmgcp // import "go.elastic.co/apm/module/apmgcp/v2"
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/option"
+	htransport "google.golang.org/api/transport/http"
+
+	"go.elastic.co/apm/v2"
+)
+
+const gcsSubtype = "gcs"
+
+// NewStorageHTTPClient returns an authenticated *http.Client for use
+// with storage.NewClient, which reports Cloud Storage requests as spans
+// within the transaction contained in the request context. The options
+// are used for creating the authenticated transport, as for
+// storage.NewClient:
+//
+//	hc, err := apmgcp.NewStorageHTTPClient(ctx)
+//	...
+//	client, err := storage.NewClient(ctx, option.WithHTTPClient(hc))
+func NewStorageHTTPClient(ctx context.Context, opts ...option.ClientOption) (*http.Client, error) {
+	opts = append([]option.ClientOption{option.WithScopes(storage.ScopeFullControl)}, opts...)
+	transport, err := htransport.NewTransport(ctx, http.DefaultTransport, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{Transport: WrapRoundTripper(transport)}, nil
+}
+
+// WrapRoundTripper returns an http.RoundTripper wrapping r, reporting
+// Cloud Storage JSON and XML API requests as spans within the transaction
+// contained in the request context. If r is nil, http.DefaultTransport
+// is wrapped.
+//
+// Spans for requests with a response are ended when the response body
+// is closed or fully read.
+func WrapRoundTripper(r http.RoundTripper) http.RoundTripper {
+	if r == nil {
+		r = http.DefaultTransport
+	}
+	return &roundTripper{r: r}
+}
+
+type roundTripper struct {
+	r http.RoundTripper
+}
+
+// RoundTrip delegates to r.r, emitting a span if req's context
+// contains a transaction.
+func (r *roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	tx := apm.TransactionFromContext(ctx)
+	if tx == nil {
+		return r.r.RoundTrip(req)
+	}
+	op := parseGCSRequest(req)
+	span, ctx := startOperationSpan(ctx, op)
+	if span == nil {
+		return r.r.RoundTrip(req)
+	}
+	req = req.WithContext(ctx)
+	span.Context.SetHTTPRequest(req)
+	// SetHTTPRequest sets the destination service,
+	// so the operation's resource is set again.
+	span.Context.SetDestinationService(apm.DestinationServiceSpanContext{
+		Name:     gcsSubtype,
+		Resource: op.resource(),
+	})
+
+	resp, err := r.r.RoundTrip(req)
+	if err != nil {
+		endOperationSpan(ctx, span, err)
+		return nil, err
+	}
+	span.Context.SetHTTPStatusCode(resp.StatusCode)
+	resp.Body = &responseBody{span: span, body: resp.Body}
+	return resp, nil
+}
+
+// CloseIdleConnections calls r.r.CloseIdleConnections if the method exists.
+func (r *roundTripper) CloseIdleConnections() {
+	type closeIdler interface {
+		CloseIdleConnections()
+	}
+	if r, ok := r.r.(closeIdler); ok {
+		r.CloseIdleConnections()
+	}
+}
+
+type responseBody struct {
+	span *apm.Span
+	body io.ReadCloser
+	once sync.Once
+}
+
+// Close closes the response body, and ends the span if it hasn't already been ended.
+func (b *responseBody) Close() error {
+	b.endSpan()
+	return b.body.Close()
+}
+
+// Read reads from the response body, and ends the span when io.EOF is
+// returned if the span hasn't already been ended.
+func (b *responseBody) Read(p []byte) (n int, err error) {
+	n, err = b.body.Read(p)
+	if err == io.EOF {
+		b.endSpan()
+	}
+	return n, err
+}
+
+func (b *responseBody) endSpan() {
+	b.once.Do(b.span.End)
+}
+
+// gcsOperation is an operation for a Cloud Storage request.
+type gcsOperation struct {
+	name   string
+	bucket string
+}
+
+func (op *gcsOperation) spanName() string {
+	name := "GCS " + op.name
+	if op.bucket != "" {
+		name += " " + op.bucket
+	}
+	return name
+}
+
+func (op *gcsOperation) spanType() string {
+	return "storage"
+}
+
+func (op *gcsOperation) spanSubtype() string {
+	return gcsSubtype
+}
+
+func (op *gcsOperation) action() string {
+	return op.name
+}
+
+func (op *gcsOperation) resource() string {
+	if op.bucket == "" {
+		return gcsSubtype
+	}
+	return gcsSubtype + "/" + op.bucket
+}
+
+func (op *gcsOperation) targetName() string {
+	return op.bucket
+}
+
+func (op *gcsOperation) setAdditional(span *apm.Span) {}
+
+// parseGCSRequest returns the operation for a Cloud Storage request.
+//
+// JSON API requests have paths of the form /storage/v1/b/{bucket}/o/{object},
+// optionally prefixed with /upload or /download; XML API requests, which are
+// used by the Go client for reading objects, have paths of the form
+// /{bucket}/{object}.
+func parseGCSRequest(req *http.Request) *gcsOperation {
+	const jsonPrefix = "storage/v1/"
+	path := req.URL.EscapedPath()
+	i := strings.Index(path, jsonPrefix)
+	if i < 0 {
+		return parseGCSXMLRequest(req.Method, path)
+	}
+	upload := strings.HasSuffix(path[:i], "/upload/")
+	download := strings.HasSuffix(path[:i], "/download/")
+	segments := strings.SplitN(path[i+len(jsonPrefix):], "/", 4)
+
+	op := &gcsOperation{}
+	if len(segments) < 2 || segments[0] != "b" {
+		// Buckets are listed and created with the /b path.
+		op.name = methodOperation(req.Method, "ListBuckets", "CreateBucket", "", "")
+		return op
+	}
+	op.bucket = unescapePathSegment(segments[1])
+	if len(segments) == 2 {
+		op.name = methodOperation(req.Method, "GetBucket", "", "UpdateBucket", "DeleteBucket")
+		return op
+	}
+	if segments[2] != "o" {
+		// Bucket sub-resources, such as /iam and /acl, are
+		// reported with the request method as the operation.
+		op.name = methodOperation(req.Method, "", "", "", "")
+		return op
+	}
+	if len(segments) == 3 {
+		if upload {
+			op.name = "PutObject"
+		} else {
+			op.name = methodOperation(req.Method, "ListObjects", "", "", "")
+		}
+		return op
+	}
+
+	object := segments[3]
+	switch {
+	case strings.Contains(object, "/rewriteTo/"), strings.Contains(object, "/copyTo/"):
+		op.name = "CopyObject"
+	case strings.HasSuffix(object, "/compose"):
+		op.name = "ComposeObject"
+	default:
+		if upload {
+			op.name = "PutObject"
+		} else if req.Method == http.MethodGet && (download || req.URL.Query().Get("alt") == "media") {
+			op.name = "GetObject"
+		} else {
+			op.name = methodOperation(req.Method, "GetObjectMetadata", "", "UpdateObject", "DeleteObject")
+		}
+	}
+	return op
+}
+
+func parseGCSXMLRequest(method, path string) *gcsOperation {
+	segments := strings.SplitN(strings.TrimPrefix(path, "/"), "/", 2)
+	op := &gcsOperation{bucket: unescapePathSegment(segments[0])}
+	if len(segments) == 2 && segments[1] != "" {
+		switch method {
+		case http.MethodHead:
+			op.name = "GetObjectMetadata"
+		case http.MethodPut, http.MethodPost:
+			op.name = "PutObject"
+		default:
+			op.name = methodOperation(method, "GetObject", "", "", "DeleteObject")
+		}
+		return op
+	}
+	op.name = methodOperation(method, "ListObjects", "", "", "DeleteBucket")
+	return op
+}
+
+// methodOperation returns the operation name for the request method,
+// falling back to the method itself for unknown or unsupported methods.
+func methodOperation(method, get, post, patch, del string) string {
+	var name string
+	switch method {
+	case http.MethodGet, "":
+		name = get
+	case http.MethodPost:
+		name = post
+	case http.MethodPatch, http.MethodPut:
+		name = patch
+	case http.MethodDelete:
+		name = del
+	}
+	if name == "" {
+		if name = method; name == "" {
+			name = http.MethodGet
+		}
+	}
+	return name
+}
+
+func unescapePathSegment(s string) string {
+	if unescaped, err := url.PathUnescape(s); err == nil {
+		return unescaped
+	}
+	return s
+}
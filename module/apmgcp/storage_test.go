@@ -0,0 +1,116 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apmgcp_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.elastic.co/apm/module/apmgcp/v2"
+	"go.elastic.co/apm/v2/apmtest"
+	"go.elastic.co/apm/v2/model"
+)
+
+func TestStorageOperations(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "{}")
+	}))
+	defer srv.Close()
+	client := &http.Client{Transport: apmgcp.WrapRoundTripper(nil)}
+
+	for _, test := range []struct {
+		method string
+		path   string
+		action string
+		bucket string
+	}{
+		{"GET", "/storage/v1/b?project=p", "ListBuckets", ""},
+		{"POST", "/storage/v1/b?project=p", "CreateBucket", ""},
+		{"GET", "/storage/v1/b/bkt", "GetBucket", "bkt"},
+		{"PATCH", "/storage/v1/b/bkt", "UpdateBucket", "bkt"},
+		{"DELETE", "/storage/v1/b/bkt", "DeleteBucket", "bkt"},
+		{"GET", "/storage/v1/b/bkt/iam", "GET", "bkt"},
+		{"GET", "/storage/v1/b/bkt/o?prefix=a", "ListObjects", "bkt"},
+		{"POST", "/upload/storage/v1/b/bkt/o?uploadType=multipart&name=a", "PutObject", "bkt"},
+		{"PUT", "/upload/storage/v1/b/bkt/o?uploadType=resumable&upload_id=x", "PutObject", "bkt"},
+		{"GET", "/storage/v1/b/bkt/o/dir%2Fobj", "GetObjectMetadata", "bkt"},
+		{"GET", "/storage/v1/b/bkt/o/obj?alt=media", "GetObject", "bkt"},
+		{"GET", "/download/storage/v1/b/bkt/o/obj", "GetObject", "bkt"},
+		{"PATCH", "/storage/v1/b/bkt/o/obj", "UpdateObject", "bkt"},
+		{"DELETE", "/storage/v1/b/bkt/o/obj", "DeleteObject", "bkt"},
+		{"POST", "/storage/v1/b/bkt/o/obj/rewriteTo/b/bkt2/o/obj2", "CopyObject", "bkt"},
+		{"POST", "/storage/v1/b/bkt/o/obj/compose", "ComposeObject", "bkt"},
+		{"GET", "/bkt/dir/obj", "GetObject", "bkt"},
+		{"HEAD", "/bkt/obj", "GetObjectMetadata", "bkt"},
+		{"PUT", "/bkt/obj", "PutObject", "bkt"},
+		{"DELETE", "/bkt/obj", "DeleteObject", "bkt"},
+		{"GET", "/bkt", "ListObjects", "bkt"},
+	} {
+		t.Run(test.method+" "+test.path, func(t *testing.T) {
+			_, spans, _ := apmtest.WithTransaction(func(ctx context.Context) {
+				req, err := http.NewRequestWithContext(ctx, test.method, srv.URL+test.path, nil)
+				require.NoError(t, err)
+				resp, err := client.Do(req)
+				require.NoError(t, err)
+				io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+			})
+			require.Len(t, spans, 1)
+			span := spans[0]
+
+			expectedName := "GCS " + test.action
+			expectedResource := "gcs"
+			if test.bucket != "" {
+				expectedName += " " + test.bucket
+				expectedResource += "/" + test.bucket
+			}
+			assert.Equal(t, expectedName, span.Name)
+			assert.Equal(t, "storage", span.Type)
+			assert.Equal(t, "gcs", span.Subtype)
+			assert.Equal(t, test.action, span.Action)
+			assert.Equal(t, "success", span.Outcome)
+			assert.Equal(t, expectedResource, span.Context.Destination.Service.Resource)
+			assert.Equal(t, &model.ServiceTargetSpanContext{Type: "gcs", Name: test.bucket}, span.Context.Service.Target)
+			assert.Equal(t, 200, span.Context.HTTP.StatusCode)
+		})
+	}
+}
+
+func TestStorageNotFound(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+	client := &http.Client{Transport: apmgcp.WrapRoundTripper(nil)}
+
+	_, spans, _ := apmtest.WithTransaction(func(ctx context.Context) {
+		req, err := http.NewRequestWithContext(ctx, "GET", srv.URL+"/bkt/missing", nil)
+		require.NoError(t, err)
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+	})
+	require.Len(t, spans, 1)
+	assert.Equal(t, "GCS GetObject bkt", spans[0].Name)
+	assert.Equal(t, 404, spans[0].Context.HTTP.StatusCode)
+	assert.Equal(t, "failure", spans[0].Outcome)
+}
@@ -23,6 +23,7 @@ import (
 	"github.com/gocql/gocql"
 
 	"go.elastic.co/apm/v2"
+	"go.elastic.co/apm/v2/sqlutil"
 	"go.elastic.co/apm/v2/stacktrace"
 )
 
@@ -64,11 +65,16 @@ func (o *Observer) ObserveBatch(ctx context.Context, batch gocql.ObservedBatch)
 	})
 	defer batchSpan.End()
 
+	tx := apm.TransactionFromContext(ctx)
+	obfuscate := tx != nil && tx.ShouldObfuscateSQL()
 	for _, statement := range batch.Statements {
 		span, _ := apm.StartSpanOptions(ctx, querySignature(statement), "db.cassandra.query", apm.SpanOptions{
 			Start: batch.Start,
 		})
 		span.Duration = batchSpan.Duration
+		if obfuscate {
+			statement = sqlutil.Obfuscate(statement)
+		}
 		span.Context.SetDatabase(apm.DatabaseSpanContext{
 			Type:      "cassandra",
 			Instance:  batch.Keyspace,
@@ -89,10 +95,14 @@ func (o *Observer) ObserveQuery(ctx context.Context, query gocql.ObservedQuery)
 		Start: query.Start,
 	})
 	span.Duration = query.End.Sub(query.Start)
+	statement := query.Statement
+	if tx := apm.TransactionFromContext(ctx); tx != nil && tx.ShouldObfuscateSQL() {
+		statement = sqlutil.Obfuscate(statement)
+	}
 	span.Context.SetDatabase(apm.DatabaseSpanContext{
 		Type:      "cassandra",
 		Instance:  query.Keyspace,
-		Statement: query.Statement,
+		Statement: statement,
 	})
 	if e := apm.CaptureError(ctx, query.Err); e != nil && e.ErrorData != nil {
 		e.Timestamp = query.End
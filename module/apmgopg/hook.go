@@ -25,6 +25,7 @@ import (
 
 	"go.elastic.co/apm/module/apmsql/v2"
 	"go.elastic.co/apm/v2"
+	"go.elastic.co/apm/v2/sqlutil"
 	"go.elastic.co/apm/v2/stacktrace"
 )
 
@@ -70,6 +71,9 @@ func (qh *queryHook) BeforeQuery(evt *pg.QueryEvent) {
 	}
 
 	span, _ := apm.StartSpan(evt.DB.Context(), apmsql.QuerySignature(sql), "db.postgresql.query")
+	if tx := apm.TransactionFromContext(evt.DB.Context()); tx != nil && tx.ShouldObfuscateSQL() {
+		sql = sqlutil.Obfuscate(sql)
+	}
 	span.Context.SetDatabase(apm.DatabaseSpanContext{
 		Statement: sql,
 
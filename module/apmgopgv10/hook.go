@@ -25,6 +25,7 @@ import (
 
 	"go.elastic.co/apm/module/apmsql/v2"
 	"go.elastic.co/apm/v2"
+	"go.elastic.co/apm/v2/sqlutil"
 	"go.elastic.co/apm/v2/stacktrace"
 )
 
@@ -65,8 +66,12 @@ func (qh *queryHook) BeforeQuery(ctx context.Context, evt *pg.QueryEvent) (conte
 	}
 
 	span, ctx := apm.StartSpan(ctx, apmsql.QuerySignature(string(sql)), "db.postgresql.query")
+	statement := string(sql)
+	if tx := apm.TransactionFromContext(ctx); tx != nil && tx.ShouldObfuscateSQL() {
+		statement = sqlutil.Obfuscate(statement)
+	}
 	span.Context.SetDatabase(apm.DatabaseSpanContext{
-		Statement: string(sql),
+		Statement: statement,
 
 		// Static
 		Type:     "sql",
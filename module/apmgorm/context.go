@@ -26,6 +26,7 @@ import (
 
 	"go.elastic.co/apm/module/apmsql/v2"
 	"go.elastic.co/apm/v2"
+	"go.elastic.co/apm/v2/sqlutil"
 )
 
 const (
@@ -129,11 +130,15 @@ func newAfterCallback(dsnInfo apmsql.DSNInfo) func(*gorm.Scope) {
 		if span == nil {
 			return
 		}
+		statement := scope.SQL
+		if tx := apm.TransactionFromContext(ctx); tx != nil && tx.ShouldObfuscateSQL() {
+			statement = sqlutil.Obfuscate(statement)
+		}
 		span.Name = apmsql.QuerySignature(scope.SQL)
 		span.Context.SetDestinationAddress(dsnInfo.Address, dsnInfo.Port)
 		span.Context.SetDatabase(apm.DatabaseSpanContext{
 			Instance:  dsnInfo.Database,
-			Statement: scope.SQL,
+			Statement: statement,
 			Type:      "sql",
 			User:      dsnInfo.User,
 		})
@@ -41,10 +41,13 @@ import (
 // request made, for any client method presented with a context containing
 // a sampled apm.Transaction.
 func NewUnaryClientInterceptor(o ...ClientOption) grpc.UnaryClientInterceptor {
-	opts := clientOptions{}
+	opts := clientOptions{
+		propagator: apmhttp.DefaultPropagator(),
+	}
 	for _, o := range o {
 		o(&opts)
 	}
+	propagator := opts.propagator
 	return func(
 		ctx context.Context,
 		method string,
@@ -55,7 +58,7 @@ func NewUnaryClientInterceptor(o ...ClientOption) grpc.UnaryClientInterceptor {
 	) error {
 		var peer peer.Peer     // maybe set after call if span != nil
 		var header metadata.MD // maybe set after call if span != nil
-		span, ctx := startSpan(ctx, method)
+		span, ctx := startSpan(ctx, method, propagator)
 		if span != nil {
 			defer span.End()
 			opts = append(opts, grpc.Peer(&peer), grpc.Header(&header))
@@ -99,10 +102,13 @@ func NewUnaryClientInterceptor(o ...ClientOption) grpc.UnaryClientInterceptor {
 // ways: the initial stream setup request fails, Header, SendMsg or RecvMsg
 // return with an error, or RecvMsg returns for a non-streaming server.
 func NewStreamClientInterceptor(o ...ClientOption) grpc.StreamClientInterceptor {
-	opts := clientOptions{}
+	opts := clientOptions{
+		propagator: apmhttp.DefaultPropagator(),
+	}
 	for _, o := range o {
 		o(&opts)
 	}
+	propagator := opts.propagator
 	return func(
 		ctx context.Context,
 		desc *grpc.StreamDesc,
@@ -112,7 +118,7 @@ func NewStreamClientInterceptor(o ...ClientOption) grpc.StreamClientInterceptor
 		opts ...grpc.CallOption,
 	) (grpc.ClientStream, error) {
 		var peer peer.Peer
-		span, ctx := startSpan(ctx, method)
+		span, ctx := startSpan(ctx, method, propagator)
 		if span != nil {
 			opts = append(opts, grpc.Peer(&peer))
 		}
@@ -187,7 +193,7 @@ func (s *clientStream) setError(err error) {
 	}
 }
 
-func startSpan(ctx context.Context, name string) (*apm.Span, context.Context) {
+func startSpan(ctx context.Context, name string, propagator apmhttp.Propagator) (*apm.Span, context.Context) {
 	tx := apm.TransactionFromContext(ctx)
 	if tx == nil {
 		return nil, ctx
@@ -195,14 +201,14 @@ func startSpan(ctx context.Context, name string) (*apm.Span, context.Context) {
 	traceContext := tx.TraceContext()
 	propagateLegacyHeader := tx.ShouldPropagateLegacyHeader()
 	if !traceContext.Options.Recorded() {
-		return nil, outgoingContextWithTraceContext(ctx, traceContext, propagateLegacyHeader)
+		return nil, outgoingContextWithTraceContext(ctx, traceContext, propagateLegacyHeader, propagator)
 	}
 	span := tx.StartSpan(name, "external.grpc", apm.SpanFromContext(ctx))
 	if !span.Dropped() {
 		traceContext = span.TraceContext()
 		ctx = apm.ContextWithSpan(ctx, span)
 	}
-	return span, outgoingContextWithTraceContext(ctx, traceContext, propagateLegacyHeader)
+	return span, outgoingContextWithTraceContext(ctx, traceContext, propagateLegacyHeader, propagator)
 }
 
 func setSpanContext(span *apm.Span, peer peer.Peer) {
@@ -222,21 +228,18 @@ func outgoingContextWithTraceContext(
 	ctx context.Context,
 	traceContext apm.TraceContext,
 	propagateLegacyHeader bool,
+	propagator apmhttp.Propagator,
 ) context.Context {
-	traceparentValue := apmhttp.FormatTraceparentHeader(traceContext)
 	md, ok := metadata.FromOutgoingContext(ctx)
 	if !ok {
-		md = metadata.Pairs(w3cTraceparentHeader, traceparentValue)
+		md = metadata.MD{}
 	} else {
 		md = md.Copy()
-		md.Set(w3cTraceparentHeader, traceparentValue)
 	}
 	if propagateLegacyHeader {
-		md.Set(elasticTraceparentHeader, traceparentValue)
-	}
-	if tracestate := traceContext.State.String(); tracestate != "" {
-		md.Set(tracestateHeader, tracestate)
+		md.Set(elasticTraceparentHeader, apmhttp.FormatTraceparentHeader(traceContext))
 	}
+	propagator.Inject(metadataCarrier(md), traceContext)
 	return metadata.NewOutgoingContext(ctx, md)
 }
 
@@ -256,8 +259,24 @@ func setSpanOutcome(span *apm.Span, err error) {
 }
 
 type clientOptions struct {
-	tracer *apm.Tracer
+	tracer     *apm.Tracer
+	propagator apmhttp.Propagator
 }
 
 // ClientOption sets options for client-side tracing.
 type ClientOption func(*clientOptions)
+
+// WithClientPropagator returns a ClientOption which sets p as the
+// propagator to use for injecting trace context into outgoing
+// request metadata.
+//
+// By default, the propagator returned by apmhttp.DefaultPropagator
+// is used.
+func WithClientPropagator(p apmhttp.Propagator) ClientOption {
+	if p == nil {
+		panic("p == nil")
+	}
+	return func(o *clientOptions) {
+		o.propagator = p
+	}
+}
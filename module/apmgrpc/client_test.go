@@ -28,10 +28,12 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"golang.org/x/net/context"
+	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	pb "google.golang.org/grpc/examples/helloworld/helloworld"
 	"google.golang.org/grpc/status"
 
+	"go.elastic.co/apm/module/apmgrpc/v2"
 	"go.elastic.co/apm/module/apmgrpc/v2/internal/testservice"
 	"go.elastic.co/apm/module/apmhttp/v2"
 	"go.elastic.co/apm/v2"
@@ -77,6 +79,7 @@ func testClientSpan(t *testing.T, traceparentHeaders ...string) {
 			Span:    apm.SpanID{1},
 			Options: apm.TraceOptions(0).WithRecorded(true),
 			State:   apm.NewTraceState(apm.TraceStateEntry{Key: "vendor", Value: "tracestate"}),
+			Baggage: apm.NewBaggage(apm.BaggageMember{Key: "tenant", Value: "acme corp"}),
 		},
 	}, func(ctx context.Context) {
 		resp, err = client.SayHello(ctx, &pb.HelloRequest{Name: "birita"})
@@ -125,7 +128,10 @@ func testClientSpan(t *testing.T, traceparentHeaders ...string) {
 		Span:    apm.SpanID(clientSpans[0].ID),
 		Options: apm.TraceOptions(0).WithRecorded(true),
 	})
-	expectedCustom := model.IfaceMap{}
+	expectedCustom := model.IfaceMap{{
+		Key:   "baggage",
+		Value: "tenant=acme%20corp",
+	}}
 	for _, header := range traceparentHeaders {
 		expectedCustom = append(expectedCustom, model.IfaceMapItem{
 			Key:   header,
@@ -139,6 +145,46 @@ func testClientSpan(t *testing.T, traceparentHeaders ...string) {
 	assert.Equal(t, expectedCustom, serverTransactions[1].Context.Custom)
 }
 
+func TestClientServerPropagator(t *testing.T) {
+	serverTracer, serverTransport := transporttest.NewRecorderTracer()
+	defer serverTracer.Close()
+	s, _, addr := newGreeterServer(t, serverTracer, apmgrpc.WithServerPropagator(apmhttp.B3Propagator()))
+	defer s.GracefulStop()
+
+	conn, err := grpc.Dial(
+		addr.String(), grpc.WithInsecure(),
+		grpc.WithUnaryInterceptor(apmgrpc.NewUnaryClientInterceptor(
+			apmgrpc.WithClientPropagator(apmhttp.B3Propagator()),
+		)),
+	)
+	require.NoError(t, err)
+	defer conn.Close()
+	client := pb.NewGreeterClient(conn)
+
+	_, clientSpans, _ := apmtest.WithTransaction(func(ctx context.Context) {
+		_, err := client.SayHello(ctx, &pb.HelloRequest{Name: "birita"})
+		require.NoError(t, err)
+	})
+	require.Len(t, clientSpans, 1)
+
+	serverTracer.Flush(nil)
+	serverTransactions := serverTransport.Payloads().Transactions
+	require.Len(t, serverTransactions, 1)
+	assert.Equal(t, clientSpans[0].TraceID, serverTransactions[0].TraceID)
+	assert.Equal(t, clientSpans[0].ID, serverTransactions[0].ParentID)
+
+	// Only the legacy traceparent header is sent
+	// in addition to the b3 header.
+	assert.Equal(t, model.IfaceMap{{
+		Key: "elastic-apm-traceparent",
+		Value: apmhttp.FormatTraceparentHeader(apm.TraceContext{
+			Trace:   apm.TraceID(clientSpans[0].TraceID),
+			Span:    apm.SpanID(clientSpans[0].ID),
+			Options: apm.TraceOptions(0).WithRecorded(true),
+		}),
+	}}, serverTransactions[0].Context.Custom)
+}
+
 func TestClientSpanDropped(t *testing.T) {
 	serverTracer := apmtest.NewRecordingTracer()
 	defer serverTracer.Close()
@@ -37,8 +37,6 @@ import (
 
 var (
 	elasticTraceparentHeader = strings.ToLower(apmhttp.ElasticTraceparentHeader)
-	w3cTraceparentHeader     = strings.ToLower(apmhttp.W3CTraceparentHeader)
-	tracestateHeader         = strings.ToLower(apmhttp.TracestateHeader)
 )
 
 // NewUnaryServerInterceptor returns a grpc.UnaryServerInterceptor that
@@ -57,6 +55,7 @@ func NewUnaryServerInterceptor(o ...ServerOption) grpc.UnaryServerInterceptor {
 		recover:        false,
 		requestIgnorer: DefaultServerRequestIgnorer(),
 		streamIgnorer:  DefaultServerStreamIgnorer(),
+		propagator:     apmhttp.DefaultPropagator(),
 	}
 	for _, o := range o {
 		o(&opts)
@@ -70,7 +69,7 @@ func NewUnaryServerInterceptor(o ...ServerOption) grpc.UnaryServerInterceptor {
 		if !opts.tracer.Recording() || opts.requestIgnorer(info) {
 			return handler(ctx, req)
 		}
-		tx, ctx := startTransaction(ctx, opts.tracer, info.FullMethod)
+		tx, ctx := startTransaction(ctx, opts.tracer, info.FullMethod, opts.propagator)
 		defer tx.End()
 
 		// TODO(axw) define span context schema for RPC,
@@ -113,6 +112,7 @@ func NewStreamServerInterceptor(o ...ServerOption) grpc.StreamServerInterceptor
 		tracer:        apm.DefaultTracer(),
 		recover:       false,
 		streamIgnorer: DefaultServerStreamIgnorer(),
+		propagator:    apmhttp.DefaultPropagator(),
 	}
 	for _, o := range o {
 		o(&opts)
@@ -128,7 +128,7 @@ func NewStreamServerInterceptor(o ...ServerOption) grpc.StreamServerInterceptor
 			return handler(srv, stream)
 		}
 		ctx := stream.Context()
-		tx, ctx := startTransaction(ctx, opts.tracer, info.FullMethod)
+		tx, ctx := startTransaction(ctx, opts.tracer, info.FullMethod, opts.propagator)
 		defer tx.End()
 
 		wrapped := wrapServerStream(stream)
@@ -157,15 +157,11 @@ func NewStreamServerInterceptor(o ...ServerOption) grpc.StreamServerInterceptor
 	}
 }
 
-func startTransaction(ctx context.Context, tracer *apm.Tracer, name string) (*apm.Transaction, context.Context) {
+func startTransaction(ctx context.Context, tracer *apm.Tracer, name string, propagator apmhttp.Propagator) (*apm.Transaction, context.Context) {
 	var opts apm.TransactionOptions
 	md, ok := metadata.FromIncomingContext(ctx)
 	if ok {
-		traceContext, ok := getIncomingMetadataTraceContext(md, w3cTraceparentHeader)
-		if !ok {
-			traceContext, _ = getIncomingMetadataTraceContext(md, elasticTraceparentHeader)
-		}
-		opts.TraceContext = traceContext
+		opts.TraceContext, _ = propagator.Extract(metadataCarrier(md))
 	}
 	tx := tracer.StartTransactionOptions(name, "request", opts)
 	tx.Context.SetFramework("grpc", grpc.Version)
@@ -201,15 +197,17 @@ func startTransaction(ctx context.Context, tracer *apm.Tracer, name string) (*ap
 	return tx, apm.ContextWithTransaction(ctx, tx)
 }
 
-func getIncomingMetadataTraceContext(md metadata.MD, header string) (apm.TraceContext, bool) {
-	if values := md.Get(header); len(values) == 1 {
-		traceContext, err := apmhttp.ParseTraceparentHeader(values[0])
-		if err == nil {
-			traceContext.State, _ = apmhttp.ParseTracestateHeader(md.Get(tracestateHeader)...)
-			return traceContext, true
-		}
-	}
-	return apm.TraceContext{}, false
+// metadataCarrier is an apmhttp.Carrier for gRPC metadata.
+type metadataCarrier metadata.MD
+
+// Get returns the values for the given key.
+func (md metadataCarrier) Get(key string) []string {
+	return metadata.MD(md).Get(key)
+}
+
+// Set sets the value of the given key.
+func (md metadataCarrier) Set(key, value string) {
+	metadata.MD(md).Set(key, value)
 }
 
 func setTransactionResult(tx *apm.Transaction, err error) {
@@ -251,6 +249,7 @@ type serverOptions struct {
 	recover        bool
 	requestIgnorer RequestIgnorerFunc
 	streamIgnorer  StreamIgnorerFunc
+	propagator     apmhttp.Propagator
 }
 
 // ServerOption sets options for server-side tracing.
@@ -312,6 +311,21 @@ func WithServerStreamIgnorer(s StreamIgnorerFunc) ServerOption {
 	}
 }
 
+// WithServerPropagator returns a ServerOption which sets p as the
+// propagator to use for extracting trace context from incoming
+// request metadata.
+//
+// By default, the propagator returned by apmhttp.DefaultPropagator
+// is used.
+func WithServerPropagator(p apmhttp.Propagator) ServerOption {
+	if p == nil {
+		panic("p == nil")
+	}
+	return func(o *serverOptions) {
+		o.propagator = p
+	}
+}
+
 // wrappedServerStream is a thin wrapper around grpc.ServerStream that allows modifying context.
 type wrappedServerStream struct {
 	grpc.ServerStream
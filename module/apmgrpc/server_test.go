@@ -438,7 +438,7 @@ func (s *helloworldServer) SayHello(ctx context.Context, req *pb.HelloRequest) (
 	// The context passed to the server should contain a Transaction for the gRPC request.
 	if md, ok := metadata.FromIncomingContext(ctx); ok {
 		tx := apm.TransactionFromContext(ctx)
-		for _, header := range []string{"elastic-apm-traceparent", "traceparent", "tracestate"} {
+		for _, header := range []string{"elastic-apm-traceparent", "traceparent", "tracestate", "baggage"} {
 			if values := md.Get(header); len(values) > 0 {
 				tx.Context.SetCustom(header, strings.Join(values, " "))
 			}
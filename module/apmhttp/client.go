@@ -60,6 +60,7 @@ func WrapRoundTripper(r http.RoundTripper, o ...ClientOption) http.RoundTripper
 		requestName:    ClientRequestName,
 		requestIgnorer: IgnoreNone,
 		spanType:       "external.http",
+		propagator:     DefaultPropagator(),
 	}
 	for _, o := range o {
 		o(rt)
@@ -73,6 +74,7 @@ type roundTripper struct {
 	requestIgnorer RequestIgnorerFunc
 	traceRequests  bool
 	spanType       string
+	propagator     Propagator
 }
 
 // RoundTrip delegates to r.r, emitting a span if req's context
@@ -99,7 +101,7 @@ func (r *roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
 	propagateLegacyHeader := tx.ShouldPropagateLegacyHeader()
 	traceContext := tx.TraceContext()
 	if !traceContext.Options.Recorded() {
-		SetHeaders(req, traceContext, propagateLegacyHeader)
+		setHeaders(req, traceContext, propagateLegacyHeader, r.propagator)
 		return r.r.RoundTrip(req)
 	}
 
@@ -119,7 +121,7 @@ func (r *roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
 		span = nil
 	}
 
-	SetHeaders(req, traceContext, propagateLegacyHeader)
+	setHeaders(req, traceContext, propagateLegacyHeader, r.propagator)
 	resp, err := r.r.RoundTrip(req)
 	if span != nil {
 		if err != nil {
@@ -135,16 +137,18 @@ func (r *roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
 	return resp, err
 }
 
-// SetHeaders sets traceparent and tracestate headers on an http request.
+// SetHeaders sets trace context headers on an http request, using the
+// propagator returned by DefaultPropagator. If propagateLegacyHeader is
+// true, the legacy Elastic-Apm-Traceparent header will also be set.
 func SetHeaders(req *http.Request, traceContext apm.TraceContext, propagateLegacyHeader bool) {
-	headerValue := FormatTraceparentHeader(traceContext)
+	setHeaders(req, traceContext, propagateLegacyHeader, DefaultPropagator())
+}
+
+func setHeaders(req *http.Request, traceContext apm.TraceContext, propagateLegacyHeader bool, propagator Propagator) {
 	if propagateLegacyHeader {
-		req.Header.Set(ElasticTraceparentHeader, headerValue)
-	}
-	req.Header.Set(W3CTraceparentHeader, headerValue)
-	if tracestate := traceContext.State.String(); tracestate != "" {
-		req.Header.Set(TracestateHeader, tracestate)
+		req.Header.Set(ElasticTraceparentHeader, FormatTraceparentHeader(traceContext))
 	}
+	propagator.Inject(HeaderCarrier(req.Header), traceContext)
 }
 
 // CloseIdleConnections calls r.r.CloseIdleConnections if the method exists.
@@ -222,3 +226,16 @@ func WithClientSpanType(spanType string) ClientOption {
 		rt.spanType = spanType
 	})
 }
+
+// WithClientPropagator returns a ClientOption which sets p as the
+// Propagator to use for injecting trace context into requests.
+//
+// By default, the propagator returned by DefaultPropagator is used.
+func WithClientPropagator(p Propagator) ClientOption {
+	if p == nil {
+		panic("p == nil")
+	}
+	return ClientOption(func(rt *roundTripper) {
+		rt.propagator = p
+	})
+}
@@ -129,6 +129,7 @@ func testClientTraceContextHeaders(t *testing.T, traceparentHeaders ...string) {
 			Span:    apm.SpanID{1},
 			Options: apm.TraceOptions(0).WithRecorded(true),
 			State:   apm.NewTraceState(apm.TraceStateEntry{Key: "vendor", Value: "tracestate"}),
+			Baggage: apm.NewBaggage(apm.BaggageMember{Key: "tenant", Value: "acme corp"}),
 		},
 	})
 	ctx := apm.ContextWithTransaction(context.Background(), tx)
@@ -157,6 +158,9 @@ func testClientTraceContextHeaders(t *testing.T, traceparentHeaders ...string) {
 
 	require.Contains(t, headers, "Tracestate")
 	assert.Equal(t, "vendor=tracestate", headers["Tracestate"])
+
+	require.Contains(t, headers, "Baggage")
+	assert.Equal(t, "tenant=acme%20corp", headers["Baggage"])
 }
 
 func TestClientSpanDropped(t *testing.T) {
@@ -312,6 +316,23 @@ func TestWithClientRequestName(t *testing.T) {
 	assert.Equal(t, "http://test", span.Name)
 }
 
+func TestWithClientPropagator(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		assert.Empty(t, req.Header.Get("Traceparent"))
+		w.Write([]byte(req.Header.Get("B3")))
+	}))
+	defer server.Close()
+
+	var responseBody string
+	_, spans, _ := apmtest.WithTransaction(func(ctx context.Context) {
+		_, responseBody = mustGET(ctx, server.URL, apmhttp.WithClientPropagator(apmhttp.B3Propagator()))
+	})
+
+	require.Len(t, spans, 1)
+	span := spans[0]
+	assert.Equal(t, fmt.Sprintf("%s-%s-1", apm.TraceID(span.TraceID), apm.SpanID(span.ID)), responseBody)
+}
+
 func TestWithClientTrace(t *testing.T) {
 	server := httptest.NewServer(http.NotFoundHandler())
 	defer server.Close()
@@ -42,6 +42,7 @@ func Wrap(h http.Handler, o ...ServerOption) http.Handler {
 		handler:     h,
 		tracer:      apm.DefaultTracer(),
 		requestName: ServerRequestName,
+		propagator:  DefaultPropagator(),
 	}
 	for _, o := range o {
 		o(handler)
@@ -65,6 +66,7 @@ type handler struct {
 	panicPropagation bool
 	requestName      RequestNameFunc
 	requestIgnorer   RequestIgnorerFunc
+	propagator       Propagator
 }
 
 // ServeHTTP delegates to h.Handler, tracing the transaction with
@@ -74,7 +76,7 @@ func (h *handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		h.handler.ServeHTTP(w, req)
 		return
 	}
-	tx, body, req := StartTransactionWithBody(h.tracer, h.requestName(req), req)
+	tx, body, req := startTransactionWithBody(h.tracer, h.requestName(req), req, h.propagator)
 	defer tx.End()
 
 	w, resp := WrapResponseWriter(w)
@@ -110,13 +112,11 @@ func (h *handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 //
 // DEPRECATED. Use StartTransactionWithBody instead.
 func StartTransaction(tracer *apm.Tracer, name string, req *http.Request) (*apm.Transaction, *http.Request) {
-	traceContext, ok := getRequestTraceparent(req, W3CTraceparentHeader)
-	if !ok {
-		traceContext, ok = getRequestTraceparent(req, ElasticTraceparentHeader)
-	}
-	if ok {
-		traceContext.State, _ = ParseTracestateHeader(req.Header[TracestateHeader]...)
-	}
+	return startTransaction(tracer, name, req, DefaultPropagator())
+}
+
+func startTransaction(tracer *apm.Tracer, name string, req *http.Request, propagator Propagator) (*apm.Transaction, *http.Request) {
+	traceContext, _ := propagator.Extract(HeaderCarrier(req.Header))
 	tx := tracer.StartTransactionOptions(name, "request", apm.TransactionOptions{TraceContext: traceContext})
 	ctx := apm.ContextWithTransaction(req.Context(), tx)
 	req = RequestWithContext(ctx, req)
@@ -129,7 +129,11 @@ func StartTransaction(tracer *apm.Tracer, name string, req *http.Request) (*apm.
 // If the transaction is not ignored, the request and the request body
 // capturer will be returned with the transaction added to its context.
 func StartTransactionWithBody(tracer *apm.Tracer, name string, req *http.Request) (*apm.Transaction, *apm.BodyCapturer, *http.Request) {
-	tx, req := StartTransaction(tracer, name, req)
+	return startTransactionWithBody(tracer, name, req, DefaultPropagator())
+}
+
+func startTransactionWithBody(tracer *apm.Tracer, name string, req *http.Request, propagator Propagator) (*apm.Transaction, *apm.BodyCapturer, *http.Request) {
+	tx, req := startTransaction(tracer, name, req, propagator)
 	bc := tracer.CaptureHTTPRequestBody(req)
 	if bc != nil {
 		req = RequestWithContext(apm.ContextWithBodyCapturer(req.Context(), bc), req)
@@ -137,15 +141,6 @@ func StartTransactionWithBody(tracer *apm.Tracer, name string, req *http.Request
 	return tx, bc, req
 }
 
-func getRequestTraceparent(req *http.Request, header string) (apm.TraceContext, bool) {
-	if values := req.Header[header]; len(values) == 1 && values[0] != "" {
-		if c, err := ParseTraceparentHeader(values[0]); err == nil {
-			return c, true
-		}
-	}
-	return apm.TraceContext{}, false
-}
-
 // SetTransactionContext sets tx.Result and, if the transaction is being
 // sampled, sets tx.Context with information from req, resp, and body.
 func SetTransactionContext(tx *apm.Transaction, req *http.Request, resp *Response, body *apm.BodyCapturer) {
@@ -387,3 +382,16 @@ func RequestWithContext(ctx context.Context, req *http.Request) *http.Request {
 	req.URL = url
 	return reqCopy
 }
+
+// WithServerPropagator returns a ServerOption which sets p as the
+// Propagator to use for extracting trace context from requests.
+//
+// By default, the propagator returned by DefaultPropagator is used.
+func WithServerPropagator(p Propagator) ServerOption {
+	if p == nil {
+		panic("p == nil")
+	}
+	return func(h *handler) {
+		h.propagator = p
+	}
+}
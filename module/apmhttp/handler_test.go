@@ -534,6 +534,77 @@ func TestHandlerTracestateHeader(t *testing.T) {
 	assert.Equal(t, "", w.Body.String())
 }
 
+func TestHandlerBaggageHeader(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("/foo", http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		tx := apm.TransactionFromContext(req.Context())
+		w.Write([]byte(tx.TraceContext().Baggage.String()))
+	}))
+
+	makeReq := func(traceparent bool, baggage ...string) *http.Request {
+		req, _ := http.NewRequest("GET", "http://server.testing/foo", nil)
+		if traceparent {
+			req.Header.Set("Traceparent", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+		}
+		req.Header["Baggage"] = baggage
+		return req
+	}
+
+	h := apmhttp.Wrap(mux, apmhttp.WithTracer(apmtest.DiscardTracer))
+	w := httptest.NewRecorder()
+
+	w.Body = new(bytes.Buffer)
+	h.ServeHTTP(w, makeReq(true, "a=b, c=d"))
+	assert.Equal(t, "a=b,c=d", w.Body.String())
+
+	// Baggage is propagated in the absence of traceparent.
+	w.Body = new(bytes.Buffer)
+	h.ServeHTTP(w, makeReq(false, "a=b", "c=d"))
+	assert.Equal(t, "a=b,c=d", w.Body.String())
+
+	w.Body = new(bytes.Buffer)
+	h.ServeHTTP(w, makeReq(true, "a")) // invalid baggage
+	assert.Equal(t, "", w.Body.String())
+}
+
+func TestHandlerPropagator(t *testing.T) {
+	tracer, transport := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+
+	mux := http.NewServeMux()
+	mux.Handle("/foo", http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+
+	makeReq := func(header, value string) *http.Request {
+		req, _ := http.NewRequest("GET", "http://server.testing/foo", nil)
+		req.Header.Set(header, value)
+		return req
+	}
+
+	h := apmhttp.Wrap(mux,
+		apmhttp.WithTracer(tracer),
+		apmhttp.WithServerPropagator(apmhttp.CompositePropagator(
+			apmhttp.B3MultiPropagator(),
+			apmhttp.JaegerPropagator(),
+		)),
+	)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, makeReq("Uber-Trace-Id", "0af7651916cd43dd8448eb211c80319c:b7ad6b7169203331:0:1"))
+	h.ServeHTTP(w, makeReq("Traceparent", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"))
+	tracer.Flush(nil)
+
+	payloads := transport.Payloads()
+	require.Len(t, payloads.Transactions, 2)
+	assert.Equal(t, "0af7651916cd43dd8448eb211c80319c", apm.TraceID(payloads.Transactions[0].TraceID).String())
+	assert.Equal(t, "b7ad6b7169203331", apm.SpanID(payloads.Transactions[0].ParentID).String())
+
+	// The traceparent header is ignored, as the W3C
+	// propagator was not included in the composite.
+	assert.NotEqual(t, "0af7651916cd43dd8448eb211c80319c", apm.TraceID(payloads.Transactions[1].TraceID).String())
+	assert.Zero(t, payloads.Transactions[1].ParentID)
+}
+
 func TestHandlerReaderFrom(t *testing.T) {
 	recorder := apmtest.NewRecordingTracer()
 	defer recorder.Close()
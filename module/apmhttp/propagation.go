@@ -0,0 +1,447 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apmhttp // import "go.elastic.co/apm/module/apmhttp/v2"
+
+import (
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"go.elastic.co/apm/v2"
+)
+
+const (
+	// B3Header is the Zipkin B3 single header for trace propagation.
+	B3Header = "B3"
+
+	// B3TraceIDHeader is the Zipkin B3 multi-header format's
+	// trace ID header.
+	B3TraceIDHeader = "X-B3-Traceid"
+
+	// B3SpanIDHeader is the Zipkin B3 multi-header format's
+	// span ID header.
+	B3SpanIDHeader = "X-B3-Spanid"
+
+	// B3SampledHeader is the Zipkin B3 multi-header format's
+	// sampling decision header.
+	B3SampledHeader = "X-B3-Sampled"
+
+	// B3FlagsHeader is the Zipkin B3 multi-header format's
+	// debug flag header.
+	B3FlagsHeader = "X-B3-Flags"
+
+	// JaegerTraceHeader is the Jaeger header for trace propagation.
+	JaegerTraceHeader = "Uber-Trace-Id"
+
+	// envPropagators holds the name of the environment variable used
+	// for configuring the propagation formats used by DefaultPropagator.
+	envPropagators = "ELASTIC_APM_PROPAGATORS"
+)
+
+var (
+	defaultPropagatorOnce sync.Once
+	defaultPropagator     Propagator
+)
+
+// Carrier is an interface for getting and setting trace context
+// headers, e.g. in HTTP request headers or gRPC metadata.
+type Carrier interface {
+	// Get returns the values for the given header. Header
+	// names are case-insensitive.
+	Get(key string) []string
+
+	// Set sets the value of the given header, replacing
+	// any existing values.
+	Set(key, value string)
+}
+
+// HeaderCarrier is a Carrier for http.Header.
+type HeaderCarrier http.Header
+
+// Get returns the values for the given header.
+func (h HeaderCarrier) Get(key string) []string {
+	return http.Header(h).Values(key)
+}
+
+// Set sets the value of the given header.
+func (h HeaderCarrier) Set(key, value string) {
+	http.Header(h).Set(key, value)
+}
+
+// Propagator is an interface for injecting trace context into,
+// and extracting trace context from, a Carrier.
+type Propagator interface {
+	// Inject injects traceContext into carrier.
+	Inject(carrier Carrier, traceContext apm.TraceContext)
+
+	// Extract extracts trace context from carrier, returning the
+	// trace context and a boolean indicating whether trace context
+	// was found. The returned trace context may hold baggage even
+	// if no trace context was found.
+	Extract(carrier Carrier) (apm.TraceContext, bool)
+}
+
+// DefaultPropagator returns the Propagator configured with the
+// ELASTIC_APM_PROPAGATORS environment variable, which holds a
+// comma-separated list of propagator names as accepted by
+// ParsePropagators. If the environment variable is unset, the
+// W3C Trace Context propagator is used. Unknown propagator names
+// are logged and ignored.
+func DefaultPropagator() Propagator {
+	defaultPropagatorOnce.Do(func() {
+		p, err := ParsePropagators(os.Getenv(envPropagators))
+		if err != nil {
+			log.Printf("[apm]: invalid %s: %s", envPropagators, err)
+		}
+		defaultPropagator = p
+	})
+	return defaultPropagator
+}
+
+// ParsePropagators parses a comma-separated list of propagator names,
+// returning a Propagator which injects trace context using each of the
+// named propagators, and extracts trace context using the first of the
+// named propagators to find it.
+//
+// The supported propagator names are:
+//
+//   - "tracecontext": W3C Trace Context and Baggage (W3CPropagator)
+//   - "b3": Zipkin B3 single header (B3Propagator)
+//   - "b3multi": Zipkin B3 multiple headers (B3MultiPropagator)
+//   - "jaeger": Jaeger uber-trace-id header (JaegerPropagator)
+//
+// If names is empty, the W3C Trace Context propagator is returned.
+// If names contains unknown propagator names, ParsePropagators returns
+// an error naming them, along with a Propagator for the known names.
+func ParsePropagators(names string) (Propagator, error) {
+	var propagators []Propagator
+	var unknown []string
+	for _, name := range strings.Split(names, ",") {
+		switch name = strings.TrimSpace(name); strings.ToLower(name) {
+		case "":
+			continue
+		case "tracecontext":
+			propagators = append(propagators, W3CPropagator())
+		case "b3":
+			propagators = append(propagators, B3Propagator())
+		case "b3multi":
+			propagators = append(propagators, B3MultiPropagator())
+		case "jaeger":
+			propagators = append(propagators, JaegerPropagator())
+		default:
+			unknown = append(unknown, strconv.Quote(name))
+		}
+	}
+	var err error
+	switch len(unknown) {
+	case 0:
+	case 1:
+		err = fmt.Errorf("unknown propagator %s", unknown[0])
+	default:
+		err = fmt.Errorf("unknown propagators %s", strings.Join(unknown, ", "))
+	}
+	switch len(propagators) {
+	case 0:
+		return W3CPropagator(), err
+	case 1:
+		return propagators[0], err
+	}
+	return CompositePropagator(propagators...), err
+}
+
+// CompositePropagator returns a Propagator which injects trace context
+// using each of the given propagators, and extracts trace context using
+// the first of the given propagators to find it. Baggage is extracted
+// from the first propagator to find any.
+func CompositePropagator(propagators ...Propagator) Propagator {
+	return compositePropagator(propagators)
+}
+
+type compositePropagator []Propagator
+
+func (c compositePropagator) Inject(carrier Carrier, traceContext apm.TraceContext) {
+	for _, p := range c {
+		p.Inject(carrier, traceContext)
+	}
+}
+
+func (c compositePropagator) Extract(carrier Carrier) (apm.TraceContext, bool) {
+	var out apm.TraceContext
+	var baggage apm.Baggage
+	var found bool
+	for _, p := range c {
+		traceContext, ok := p.Extract(carrier)
+		if baggage.Len() == 0 {
+			baggage = traceContext.Baggage
+		}
+		if ok && !found {
+			out, found = traceContext, true
+		}
+	}
+	out.Baggage = baggage
+	return out, found
+}
+
+// W3CPropagator returns a Propagator for the W3C Trace Context and
+// Baggage formats: the traceparent, tracestate, and baggage headers.
+//
+// When extracting trace context, the legacy Elastic-Apm-Traceparent
+// header will be used if there is no valid traceparent header. The
+// legacy header is not injected by the propagator; see SetHeaders.
+func W3CPropagator() Propagator {
+	return w3cPropagator{}
+}
+
+type w3cPropagator struct{}
+
+func (w3cPropagator) Inject(carrier Carrier, traceContext apm.TraceContext) {
+	carrier.Set(W3CTraceparentHeader, FormatTraceparentHeader(traceContext))
+	if tracestate := traceContext.State.String(); tracestate != "" {
+		carrier.Set(TracestateHeader, tracestate)
+	}
+	if baggage := traceContext.Baggage.String(); baggage != "" {
+		carrier.Set(BaggageHeader, baggage)
+	}
+}
+
+func (w3cPropagator) Extract(carrier Carrier) (apm.TraceContext, bool) {
+	traceContext, ok := getTraceparent(carrier, W3CTraceparentHeader)
+	if !ok {
+		traceContext, ok = getTraceparent(carrier, ElasticTraceparentHeader)
+	}
+	if ok {
+		traceContext.State, _ = ParseTracestateHeader(carrier.Get(TracestateHeader)...)
+	}
+	// Baggage is independent of the trace context, and
+	// is propagated even in the absence of traceparent.
+	traceContext.Baggage, _ = ParseBaggageHeader(carrier.Get(BaggageHeader)...)
+	return traceContext, ok
+}
+
+func getTraceparent(carrier Carrier, header string) (apm.TraceContext, bool) {
+	if values := carrier.Get(header); len(values) == 1 && values[0] != "" {
+		if c, err := ParseTraceparentHeader(values[0]); err == nil {
+			return c, true
+		}
+	}
+	return apm.TraceContext{}, false
+}
+
+// B3Propagator returns a Propagator for the Zipkin B3 single
+// header format:
+//
+//	b3: {TraceId}-{SpanId}-{SamplingState}-{ParentSpanId}
+//
+// When extracting trace context without a sampling decision,
+// the trace context will be considered recorded.
+func B3Propagator() Propagator {
+	return b3Propagator{}
+}
+
+type b3Propagator struct{}
+
+func (b3Propagator) Inject(carrier Carrier, traceContext apm.TraceContext) {
+	sampled := "0"
+	if traceContext.Options.Recorded() {
+		sampled = "1"
+	}
+	carrier.Set(B3Header, fmt.Sprintf("%s-%s-%s", traceContext.Trace, traceContext.Span, sampled))
+}
+
+func (b3Propagator) Extract(carrier Carrier) (apm.TraceContext, bool) {
+	values := carrier.Get(B3Header)
+	if len(values) != 1 {
+		return apm.TraceContext{}, false
+	}
+	traceContext, err := ParseB3Header(values[0])
+	if err != nil {
+		return apm.TraceContext{}, false
+	}
+	return traceContext, true
+}
+
+// ParseB3Header parses the given header, which is expected to be in the
+// Zipkin B3 single header format. A header holding only a sampling
+// decision, with no trace or span ID, is considered invalid.
+func ParseB3Header(h string) (apm.TraceContext, error) {
+	var out apm.TraceContext
+	parts := strings.Split(h, "-")
+	if len(parts) < 2 || len(parts) > 4 {
+		return out, errors.Errorf("invalid b3 header %q", h)
+	}
+	if err := decodeTraceID(&out.Trace, parts[0]); err != nil {
+		return out, err
+	}
+	if err := decodeSpanID(&out.Span, parts[1]); err != nil {
+		return out, err
+	}
+	sampled := true
+	if len(parts) > 2 {
+		switch parts[2] {
+		case "1", "d":
+		case "0":
+			sampled = false
+		default:
+			return out, errors.Errorf("invalid b3 sampling state %q", parts[2])
+		}
+	}
+	out.Options = out.Options.WithRecorded(sampled)
+	return out, nil
+}
+
+// B3MultiPropagator returns a Propagator for the Zipkin B3 multiple
+// header format: X-B3-TraceId, X-B3-SpanId, X-B3-Sampled, and
+// X-B3-Flags. The X-B3-ParentSpanId header is not propagated.
+//
+// When extracting trace context without a sampling decision,
+// the trace context will be considered recorded.
+func B3MultiPropagator() Propagator {
+	return b3MultiPropagator{}
+}
+
+type b3MultiPropagator struct{}
+
+func (b3MultiPropagator) Inject(carrier Carrier, traceContext apm.TraceContext) {
+	sampled := "0"
+	if traceContext.Options.Recorded() {
+		sampled = "1"
+	}
+	carrier.Set(B3TraceIDHeader, traceContext.Trace.String())
+	carrier.Set(B3SpanIDHeader, traceContext.Span.String())
+	carrier.Set(B3SampledHeader, sampled)
+}
+
+func (b3MultiPropagator) Extract(carrier Carrier) (apm.TraceContext, bool) {
+	var out apm.TraceContext
+	traceID, spanID := carrier.Get(B3TraceIDHeader), carrier.Get(B3SpanIDHeader)
+	if len(traceID) != 1 || len(spanID) != 1 {
+		return out, false
+	}
+	if decodeTraceID(&out.Trace, traceID[0]) != nil || decodeSpanID(&out.Span, spanID[0]) != nil {
+		return apm.TraceContext{}, false
+	}
+	sampled := true
+	if values := carrier.Get(B3SampledHeader); len(values) == 1 {
+		switch strings.ToLower(values[0]) {
+		case "1", "true":
+		case "0", "false":
+			sampled = false
+		default:
+			return apm.TraceContext{}, false
+		}
+	}
+	if values := carrier.Get(B3FlagsHeader); len(values) == 1 && values[0] == "1" {
+		// Debug implies sampled.
+		sampled = true
+	}
+	out.Options = out.Options.WithRecorded(sampled)
+	return out, true
+}
+
+// JaegerPropagator returns a Propagator for the Jaeger
+// uber-trace-id header format:
+//
+//	uber-trace-id: {trace-id}:{span-id}:{parent-span-id}:{flags}
+//
+// Jaeger baggage headers are not propagated.
+func JaegerPropagator() Propagator {
+	return jaegerPropagator{}
+}
+
+type jaegerPropagator struct{}
+
+func (jaegerPropagator) Inject(carrier Carrier, traceContext apm.TraceContext) {
+	var flags int
+	if traceContext.Options.Recorded() {
+		flags = 1
+	}
+	carrier.Set(JaegerTraceHeader, fmt.Sprintf("%s:%s:0:%d", traceContext.Trace, traceContext.Span, flags))
+}
+
+func (jaegerPropagator) Extract(carrier Carrier) (apm.TraceContext, bool) {
+	values := carrier.Get(JaegerTraceHeader)
+	if len(values) != 1 {
+		return apm.TraceContext{}, false
+	}
+	traceContext, err := ParseJaegerHeader(values[0])
+	if err != nil {
+		return apm.TraceContext{}, false
+	}
+	return traceContext, true
+}
+
+// ParseJaegerHeader parses the given header, which is expected to be in
+// the Jaeger uber-trace-id format. The header value may be URL-encoded.
+func ParseJaegerHeader(h string) (apm.TraceContext, error) {
+	var out apm.TraceContext
+	if strings.ContainsRune(h, '%') {
+		unescaped, err := url.QueryUnescape(h)
+		if err != nil {
+			return out, errors.Wrap(err, "error decoding uber-trace-id header")
+		}
+		h = unescaped
+	}
+	parts := strings.Split(h, ":")
+	if len(parts) != 4 {
+		return out, errors.Errorf("invalid uber-trace-id header %q", h)
+	}
+	if err := decodeTraceID(&out.Trace, parts[0]); err != nil {
+		return out, err
+	}
+	if err := decodeSpanID(&out.Span, parts[1]); err != nil {
+		return out, err
+	}
+	flags, err := strconv.ParseUint(parts[3], 16, 8)
+	if err != nil {
+		return out, errors.Wrap(err, "error decoding uber-trace-id flags")
+	}
+	out.Options = out.Options.WithRecorded(flags&1 == 1)
+	return out, nil
+}
+
+// decodeTraceID decodes a hex-encoded 64-bit or 128-bit trace ID,
+// left-padding shorter IDs with zeroes.
+func decodeTraceID(out *apm.TraceID, s string) error {
+	if len(s) == 0 || len(s) > 32 {
+		return errors.Errorf("invalid trace-id %q", s)
+	}
+	if _, err := hex.Decode(out[:], []byte(strings.Repeat("0", 32-len(s))+s)); err != nil {
+		return errors.Wrap(err, "error decoding trace-id")
+	}
+	return errors.Wrap(out.Validate(), "invalid trace-id")
+}
+
+// decodeSpanID decodes a hex-encoded 64-bit span ID, left-padding
+// shorter IDs with zeroes.
+func decodeSpanID(out *apm.SpanID, s string) error {
+	if len(s) == 0 || len(s) > 16 {
+		return errors.Errorf("invalid span-id %q", s)
+	}
+	if _, err := hex.Decode(out[:], []byte(strings.Repeat("0", 16-len(s))+s)); err != nil {
+		return errors.Wrap(err, "error decoding span-id")
+	}
+	return errors.Wrap(out.Validate(), "invalid span-id")
+}
@@ -0,0 +1,251 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apmhttp_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.elastic.co/apm/module/apmhttp/v2"
+	"go.elastic.co/apm/v2"
+)
+
+var testTraceContext = apm.TraceContext{
+	Trace:   apm.TraceID{0x0a, 0xf7, 0x65, 0x19, 0x16, 0xcd, 0x43, 0xdd, 0x84, 0x48, 0xeb, 0x21, 0x1c, 0x80, 0x31, 0x9c},
+	Span:    apm.SpanID{0xb7, 0xad, 0x6b, 0x71, 0x69, 0x20, 0x33, 0x31},
+	Options: apm.TraceOptions(0).WithRecorded(true),
+}
+
+func TestParsePropagators(t *testing.T) {
+	_, err := apmhttp.ParsePropagators("tracecontext,zipkin")
+	assert.EqualError(t, err, `unknown propagator "zipkin"`)
+
+	// Unknown names are reported, but the known names are
+	// still used, so a typo does not disable propagation.
+	p, err := apmhttp.ParsePropagators("b3multi,jagger,zipkin")
+	assert.EqualError(t, err, `unknown propagators "jagger", "zipkin"`)
+	require.NotNil(t, p)
+	header := make(http.Header)
+	p.Inject(apmhttp.HeaderCarrier(header), testTraceContext)
+	assert.Equal(t, http.Header{
+		"X-B3-Traceid": {"0af7651916cd43dd8448eb211c80319c"},
+		"X-B3-Spanid":  {"b7ad6b7169203331"},
+		"X-B3-Sampled": {"1"},
+	}, header)
+
+	for _, names := range []string{"", "tracecontext", " tracecontext, ", "b3", "b3multi", "jaeger", "tracecontext,b3,jaeger"} {
+		p, err := apmhttp.ParsePropagators(names)
+		require.NoError(t, err, names)
+		assert.NotNil(t, p, names)
+	}
+
+	header = make(http.Header)
+	p, err = apmhttp.ParsePropagators("B3, tracecontext")
+	require.NoError(t, err)
+	p.Inject(apmhttp.HeaderCarrier(header), testTraceContext)
+	assert.Equal(t, http.Header{
+		"B3":          {"0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-1"},
+		"Traceparent": {"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"},
+	}, header)
+}
+
+func TestW3CPropagator(t *testing.T) {
+	p := apmhttp.W3CPropagator()
+	traceContext := testTraceContext
+	traceContext.State = apm.NewTraceState(apm.TraceStateEntry{Key: "vendor", Value: "value"})
+	traceContext.Baggage = apm.NewBaggage(apm.BaggageMember{Key: "user.id", Value: "123"})
+
+	header := make(http.Header)
+	p.Inject(apmhttp.HeaderCarrier(header), traceContext)
+	assert.Equal(t, http.Header{
+		"Traceparent": {"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"},
+		"Tracestate":  {"vendor=value"},
+		"Baggage":     {"user.id=123"},
+	}, header)
+
+	out, ok := p.Extract(apmhttp.HeaderCarrier(header))
+	assert.True(t, ok)
+	assert.Equal(t, traceContext.Trace, out.Trace)
+	assert.Equal(t, traceContext.Span, out.Span)
+	assert.Equal(t, traceContext.Options, out.Options)
+	assert.Equal(t, "vendor=value", out.State.String())
+	assert.Equal(t, "user.id=123", out.Baggage.String())
+
+	// The legacy header is used in the absence of traceparent.
+	header = http.Header{apmhttp.ElasticTraceparentHeader: header[apmhttp.W3CTraceparentHeader]}
+	out, ok = p.Extract(apmhttp.HeaderCarrier(header))
+	assert.True(t, ok)
+	assert.Equal(t, traceContext.Trace, out.Trace)
+
+	// Baggage is extracted even without trace context.
+	header = http.Header{apmhttp.BaggageHeader: {"k=v"}}
+	out, ok = p.Extract(apmhttp.HeaderCarrier(header))
+	assert.False(t, ok)
+	assert.Equal(t, "k=v", out.Baggage.String())
+}
+
+func TestB3Propagator(t *testing.T) {
+	p := apmhttp.B3Propagator()
+	header := make(http.Header)
+	p.Inject(apmhttp.HeaderCarrier(header), testTraceContext)
+	assert.Equal(t, http.Header{
+		"B3": {"0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-1"},
+	}, header)
+
+	out, ok := p.Extract(apmhttp.HeaderCarrier(header))
+	assert.True(t, ok)
+	assert.Equal(t, testTraceContext, out)
+
+	out, ok = p.Extract(apmhttp.HeaderCarrier(http.Header{}))
+	assert.False(t, ok)
+	assert.Zero(t, out)
+}
+
+func TestParseB3Header(t *testing.T) {
+	assertParseError := func(h, expect string) {
+		_, err := apmhttp.ParseB3Header(h)
+		if assert.Error(t, err) {
+			assert.Regexp(t, expect, err.Error())
+		}
+	}
+	assertParseError("", `invalid b3 header ""`)
+	assertParseError("1", `invalid b3 header "1"`)
+	assertParseError("a-b-c-d-e", `invalid b3 header "a-b-c-d-e"`)
+	assertParseError("zz-b7ad6b7169203331", `error decoding trace-id: encoding/hex: invalid byte: .*`)
+	assertParseError("0af7651916cd43dd8448eb211c80319c-", `invalid span-id ""`)
+	assertParseError("00000000000000000000000000000000-b7ad6b7169203331", `invalid trace-id: zero trace-id is invalid`)
+	assertParseError("0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-x", `invalid b3 sampling state "x"`)
+
+	assertParse := func(h string, expectTrace, expectSpan string, expectRecorded bool) {
+		out, err := apmhttp.ParseB3Header(h)
+		if assert.NoError(t, err, h) {
+			assert.Equal(t, expectTrace, out.Trace.String(), h)
+			assert.Equal(t, expectSpan, out.Span.String(), h)
+			assert.Equal(t, expectRecorded, out.Options.Recorded(), h)
+		}
+	}
+	assertParse("0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331", "0af7651916cd43dd8448eb211c80319c", "b7ad6b7169203331", true)
+	assertParse("0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-0", "0af7651916cd43dd8448eb211c80319c", "b7ad6b7169203331", false)
+	assertParse("0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-d", "0af7651916cd43dd8448eb211c80319c", "b7ad6b7169203331", true)
+	assertParse("0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-1-05e3ac9a4f6e3b90", "0af7651916cd43dd8448eb211c80319c", "b7ad6b7169203331", true)
+
+	// 64-bit trace IDs are left-padded with zeroes.
+	assertParse("8448eb211c80319c-b7ad6b7169203331-1", "00000000000000008448eb211c80319c", "b7ad6b7169203331", true)
+}
+
+func TestB3MultiPropagator(t *testing.T) {
+	p := apmhttp.B3MultiPropagator()
+	header := make(http.Header)
+	p.Inject(apmhttp.HeaderCarrier(header), testTraceContext)
+	assert.Equal(t, http.Header{
+		"X-B3-Traceid": {"0af7651916cd43dd8448eb211c80319c"},
+		"X-B3-Spanid":  {"b7ad6b7169203331"},
+		"X-B3-Sampled": {"1"},
+	}, header)
+
+	out, ok := p.Extract(apmhttp.HeaderCarrier(header))
+	assert.True(t, ok)
+	assert.Equal(t, testTraceContext, out)
+
+	header.Set(apmhttp.B3SampledHeader, "false")
+	out, ok = p.Extract(apmhttp.HeaderCarrier(header))
+	assert.True(t, ok)
+	assert.False(t, out.Options.Recorded())
+
+	// The debug flag implies sampled.
+	header.Set(apmhttp.B3FlagsHeader, "1")
+	out, ok = p.Extract(apmhttp.HeaderCarrier(header))
+	assert.True(t, ok)
+	assert.True(t, out.Options.Recorded())
+
+	header.Set(apmhttp.B3SampledHeader, "maybe")
+	_, ok = p.Extract(apmhttp.HeaderCarrier(header))
+	assert.False(t, ok)
+
+	header.Del(apmhttp.B3SpanIDHeader)
+	_, ok = p.Extract(apmhttp.HeaderCarrier(header))
+	assert.False(t, ok)
+}
+
+func TestJaegerPropagator(t *testing.T) {
+	p := apmhttp.JaegerPropagator()
+	header := make(http.Header)
+	p.Inject(apmhttp.HeaderCarrier(header), testTraceContext)
+	assert.Equal(t, http.Header{
+		"Uber-Trace-Id": {"0af7651916cd43dd8448eb211c80319c:b7ad6b7169203331:0:1"},
+	}, header)
+
+	out, ok := p.Extract(apmhttp.HeaderCarrier(header))
+	assert.True(t, ok)
+	assert.Equal(t, testTraceContext, out)
+}
+
+func TestParseJaegerHeader(t *testing.T) {
+	assertParseError := func(h, expect string) {
+		_, err := apmhttp.ParseJaegerHeader(h)
+		if assert.Error(t, err) {
+			assert.Regexp(t, expect, err.Error())
+		}
+	}
+	assertParseError("", `invalid uber-trace-id header ""`)
+	assertParseError("a:b:c", `invalid uber-trace-id header "a:b:c"`)
+	assertParseError("%zz", `error decoding uber-trace-id header: .*`)
+	assertParseError("8448eb211c80319c:0:0:1", `invalid span-id: zero span-id is invalid`)
+	assertParseError("8448eb211c80319c:b7ad6b7169203331:0:x", `error decoding uber-trace-id flags: .*`)
+
+	out, err := apmhttp.ParseJaegerHeader("8448eb211c80319c%3Ab7ad6b7169203331%3A0%3A3")
+	require.NoError(t, err)
+	assert.Equal(t, "00000000000000008448eb211c80319c", out.Trace.String())
+	assert.Equal(t, "b7ad6b7169203331", out.Span.String())
+	assert.True(t, out.Options.Recorded())
+
+	out, err = apmhttp.ParseJaegerHeader("8448eb211c80319c:b7ad6b7169203331:0:2")
+	require.NoError(t, err)
+	assert.False(t, out.Options.Recorded())
+}
+
+func TestCompositePropagator(t *testing.T) {
+	p := apmhttp.CompositePropagator(
+		apmhttp.B3Propagator(),
+		apmhttp.JaegerPropagator(),
+		apmhttp.W3CPropagator(),
+	)
+
+	// The first propagator to find trace context wins, while
+	// baggage is taken from the first propagator to find any.
+	header := http.Header{
+		"Uber-Trace-Id": {"8448eb211c80319c:b7ad6b7169203331:0:0"},
+		"Traceparent":   {"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"},
+		"Baggage":       {"k=v"},
+	}
+	out, ok := p.Extract(apmhttp.HeaderCarrier(header))
+	assert.True(t, ok)
+	assert.Equal(t, "00000000000000008448eb211c80319c", out.Trace.String())
+	assert.False(t, out.Options.Recorded())
+	assert.Equal(t, "k=v", out.Baggage.String())
+
+	header = make(http.Header)
+	p.Inject(apmhttp.HeaderCarrier(header), testTraceContext)
+	assert.Len(t, header, 3)
+	assert.Contains(t, header, "B3")
+	assert.Contains(t, header, "Uber-Trace-Id")
+	assert.Contains(t, header, "Traceparent")
+}
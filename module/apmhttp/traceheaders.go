@@ -20,6 +20,7 @@ package apmhttp // import "go.elastic.co/apm/module/apmhttp/v2"
 import (
 	"encoding/hex"
 	"fmt"
+	"net/url"
 	"strings"
 
 	"github.com/pkg/errors"
@@ -47,6 +48,10 @@ const (
 	// TracestateHeader is the standard W3C Trace-Context HTTP header
 	// for vendor-specific trace propagation.
 	TracestateHeader = "Tracestate"
+
+	// BaggageHeader is the standard W3C Baggage HTTP header for
+	// propagating user-defined key/value pairs.
+	BaggageHeader = "Baggage"
 )
 
 // FormatTraceparentHeader formats the given trace context as a
@@ -168,3 +173,45 @@ func ParseTracestateHeader(h ...string) (apm.TraceState, error) {
 	}
 	return apm.NewTraceState(entries...), nil
 }
+
+// ParseBaggageHeader parses the given header, which is expected to be in the
+// W3C Baggage format according to W3C Working Draft 12 September 2023:
+//
+//	https://www.w3.org/TR/baggage/#baggage-http-header-format
+//
+// Member values are percent-decoded; member properties are retained in
+// their encoded form. Note that the returned Baggage is not necessarily
+// valid. The caller must decide whether or not it wishes to disregard
+// invalid baggage, and validate it as required using its Validate method.
+//
+// Multiple header values may be presented, in which case they will be treated as
+// if they are concatenated together with commas.
+func ParseBaggageHeader(h ...string) (apm.Baggage, error) {
+	var members []apm.BaggageMember
+	for _, h := range h {
+		for _, member := range strings.Split(h, ",") {
+			member = strings.TrimSpace(member)
+			if member == "" {
+				continue
+			}
+			var properties string
+			if semi := strings.IndexRune(member, ';'); semi != -1 {
+				member, properties = member[:semi], strings.TrimSpace(member[semi+1:])
+			}
+			equal := strings.IndexRune(member, '=')
+			if equal == -1 {
+				return apm.Baggage{}, errors.New("missing '=' in baggage member")
+			}
+			value, err := url.PathUnescape(strings.TrimSpace(member[equal+1:]))
+			if err != nil {
+				return apm.Baggage{}, errors.Wrap(err, "error decoding baggage value")
+			}
+			members = append(members, apm.BaggageMember{
+				Key:        strings.TrimSpace(member[:equal]),
+				Value:      value,
+				Properties: properties,
+			})
+		}
+	}
+	return apm.NewBaggage(members...), nil
+}
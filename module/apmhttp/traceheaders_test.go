@@ -89,3 +89,36 @@ func TestParseTracestateHeader(t *testing.T) {
 	tracestate, _ = assertParse("vendorname1=opaqueValue1", "vendorname2=opaqueValue2")
 	assert.Equal(t, "vendorname1=opaqueValue1,vendorname2=opaqueValue2", tracestate.String())
 }
+
+func TestParseBaggageHeader(t *testing.T) {
+	assertParseError := func(h, expect string) {
+		_, err := apmhttp.ParseBaggageHeader(h)
+		if assert.Error(t, err) {
+			assert.Regexp(t, expect, err.Error())
+		}
+	}
+
+	assertParseError("a", `missing '=' in baggage member`)
+	assertParseError("a=b, c;p=q", `missing '=' in baggage member`)
+	assertParseError("a=%zz", `error decoding baggage value`)
+
+	assertParse := func(h ...string) (apm.Baggage, bool) {
+		out, err := apmhttp.ParseBaggageHeader(h...)
+		return out, assert.NoError(t, err)
+	}
+
+	baggage, _ := assertParse("userId=alice, serverNode = DF%2028 ,isProduction=false")
+	assert.Equal(t, []apm.BaggageMember{
+		{Key: "userId", Value: "alice"},
+		{Key: "serverNode", Value: "DF 28"},
+		{Key: "isProduction", Value: "false"},
+	}, baggage.Members())
+	assert.Equal(t, "userId=alice,serverNode=DF%2028,isProduction=false", baggage.String())
+
+	baggage, _ = assertParse("key1=value1;property1;property2", "key2=value2;p=q")
+	assert.Equal(t, []apm.BaggageMember{
+		{Key: "key1", Value: "value1", Properties: "property1;property2"},
+		{Key: "key2", Value: "value2", Properties: "p=q"},
+	}, baggage.Members())
+	assert.Equal(t, "key1=value1;property1;property2,key2=value2;p=q", baggage.String())
+}
@@ -0,0 +1,243 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apmkafkago_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.elastic.co/apm/module/apmhttp/v2"
+	"go.elastic.co/apm/module/apmkafkago/v2"
+	"go.elastic.co/apm/v2"
+	"go.elastic.co/apm/v2/apmtest"
+	"go.elastic.co/apm/v2/model"
+)
+
+func TestWriteMessages(t *testing.T) {
+	var recorder recordingWriter
+	w := apmkafkago.WrapWriter(&recorder)
+
+	tracer := apmtest.NewRecordingTracer()
+	defer tracer.Close()
+	tx, spans, errs := tracer.WithTransaction(func(ctx context.Context) {
+		err := w.WriteMessages(ctx, kafka.Message{Topic: "topic", Value: []byte("value")})
+		require.NoError(t, err)
+	})
+	require.Len(t, spans, 1)
+	assert.Empty(t, errs)
+
+	span := spans[0]
+	assert.Equal(t, tx.ID, span.ParentID)
+	assert.Equal(t, "Kafka SEND to topic", span.Name)
+	assert.Equal(t, "messaging", span.Type)
+	assert.Equal(t, "kafka", span.Subtype)
+	assert.Equal(t, "send", span.Action)
+	assert.Equal(t, "success", span.Outcome)
+	assert.Equal(t, &model.SpanContext{
+		Message: &model.MessageSpanContext{
+			Queue: &model.MessageQueueSpanContext{Name: "topic"},
+		},
+		Destination: &model.DestinationSpanContext{
+			Service: &model.DestinationServiceSpanContext{
+				Type:     "messaging",
+				Name:     "kafka",
+				Resource: "kafka/topic",
+			},
+		},
+		Service: &model.ServiceSpanContext{
+			Target: &model.ServiceTargetSpanContext{
+				Type: "kafka",
+				Name: "topic",
+			},
+		},
+	}, span.Context)
+
+	require.Len(t, recorder.msgs, 1)
+	headers := make(map[string]string)
+	for _, h := range recorder.msgs[0].Headers {
+		headers[h.Key] = string(h.Value)
+	}
+	assert.Equal(t, map[string]string{
+		"traceparent":           formatTraceparent(tx.TraceID, span.ID),
+		"elasticapmtraceparent": formatTraceparent(tx.TraceID, span.ID),
+		"tracestate":            "es=s:1",
+	}, headers)
+}
+
+func TestWriteMessagesWriterTopic(t *testing.T) {
+	w := apmkafkago.WrapWriter(&kafka.Writer{
+		Addr:        kafka.TCP("localhost:9092"),
+		Topic:       "topic",
+		MaxAttempts: 1,
+		Transport:   failingRoundTripper{},
+	})
+
+	tracer := apmtest.NewRecordingTracer()
+	defer tracer.Close()
+	_, spans, errs := tracer.WithTransaction(func(ctx context.Context) {
+		err := w.WriteMessages(ctx, kafka.Message{Value: []byte("value")})
+		assert.Error(t, err)
+	})
+	require.Len(t, spans, 1)
+	require.Len(t, errs, 1)
+	assert.Equal(t, "Kafka SEND to topic", spans[0].Name)
+	assert.Equal(t, "failure", spans[0].Outcome)
+	assert.Equal(t, spans[0].ID, errs[0].ParentID)
+}
+
+func TestWriteMessagesError(t *testing.T) {
+	w := apmkafkago.WrapWriter(&recordingWriter{err: errors.New("boom")})
+
+	tracer := apmtest.NewRecordingTracer()
+	defer tracer.Close()
+	_, spans, errs := tracer.WithTransaction(func(ctx context.Context) {
+		err := w.WriteMessages(ctx, kafka.Message{Topic: "topic"})
+		assert.EqualError(t, err, "boom")
+	})
+	require.Len(t, spans, 1)
+	require.Len(t, errs, 1)
+	assert.Equal(t, "failure", spans[0].Outcome)
+	assert.Equal(t, spans[0].ID, errs[0].ParentID)
+	assert.Equal(t, "boom", errs[0].Exception.Message)
+}
+
+func TestWriteMessagesNoTransaction(t *testing.T) {
+	var recorder recordingWriter
+	w := apmkafkago.WrapWriter(&recorder)
+	err := w.WriteMessages(context.Background(), kafka.Message{Topic: "topic"})
+	require.NoError(t, err)
+	require.Len(t, recorder.msgs, 1)
+	assert.Empty(t, recorder.msgs[0].Headers)
+}
+
+func TestWriteMessagesMixedTopics(t *testing.T) {
+	var recorder recordingWriter
+	w := apmkafkago.WrapWriter(&recorder)
+
+	tracer := apmtest.NewRecordingTracer()
+	defer tracer.Close()
+	tx, spans, _ := tracer.WithTransaction(func(ctx context.Context) {
+		err := w.WriteMessages(ctx, kafka.Message{Topic: "topic1"}, kafka.Message{Topic: "topic2"})
+		require.NoError(t, err)
+	})
+	require.Len(t, spans, 1)
+	assert.Equal(t, "Kafka SEND", spans[0].Name)
+	assert.Nil(t, spans[0].Context.Message)
+	assert.Equal(t, "kafka", spans[0].Context.Destination.Service.Resource)
+
+	// Each message is given the trace context of the batch span.
+	require.Len(t, recorder.msgs, 2)
+	for _, msg := range recorder.msgs {
+		require.Len(t, msg.Headers, 3)
+		assert.Equal(t, "traceparent", msg.Headers[0].Key)
+		assert.Equal(t, formatTraceparent(tx.TraceID, spans[0].ID), string(msg.Headers[0].Value))
+	}
+}
+
+func TestStartTransaction(t *testing.T) {
+	tracer := apmtest.NewRecordingTracer()
+	defer tracer.Close()
+
+	traceID := model.TraceID{0: 1, 15: 1}
+	spanID := model.SpanID{0: 2, 7: 2}
+	msg := kafka.Message{
+		Topic: "topic",
+		Time:  time.Now().Add(-time.Second),
+		Headers: []kafka.Header{{
+			Key:   "traceparent",
+			Value: []byte(formatTraceparent(traceID, spanID)),
+		}},
+	}
+	tx, ctx := apmkafkago.StartTransaction(context.Background(), tracer.Tracer, msg)
+	assert.Equal(t, tx, apm.TransactionFromContext(ctx))
+	tx.End()
+	tracer.Flush(nil)
+
+	payloads := tracer.Payloads()
+	require.Len(t, payloads.Transactions, 1)
+	transaction := payloads.Transactions[0]
+	assert.Equal(t, "Kafka RECEIVE from topic", transaction.Name)
+	assert.Equal(t, "messaging", transaction.Type)
+	assert.Equal(t, traceID, transaction.TraceID)
+	assert.Equal(t, spanID, transaction.ParentID)
+	require.NotNil(t, transaction.Context)
+	require.NotNil(t, transaction.Context.Message)
+	assert.Equal(t, "topic", transaction.Context.Message.Queue.Name)
+	require.NotNil(t, transaction.Context.Message.Age)
+	assert.InDelta(t, 1000, transaction.Context.Message.Age.Millis, 500)
+}
+
+func TestStartBatchTransaction(t *testing.T) {
+	tracer := apmtest.NewRecordingTracer()
+	defer tracer.Close()
+
+	// Write messages, and then read them in a batch.
+	var recorder recordingWriter
+	w := apmkafkago.WrapWriter(&recorder)
+	for i := 0; i < 2; i++ {
+		tracer.WithTransaction(func(ctx context.Context) {
+			require.NoError(t, w.WriteMessages(ctx, kafka.Message{Topic: "topic"}))
+		})
+		tracer.ResetPayloads()
+	}
+	msgs := append(recorder.msgs, kafka.Message{Topic: "topic"})
+
+	tx, _ := apmkafkago.StartBatchTransaction(context.Background(), tracer.Tracer, msgs)
+	tx.End()
+	tracer.Flush(nil)
+
+	payloads := tracer.Payloads()
+	require.Len(t, payloads.Transactions, 1)
+	transaction := payloads.Transactions[0]
+	assert.Equal(t, "Kafka RECEIVE from topic", transaction.Name)
+	assert.Equal(t, "messaging", transaction.Type)
+	assert.Zero(t, transaction.ParentID)
+	assert.Len(t, transaction.Links, 2)
+	assert.Equal(t, "topic", transaction.Context.Message.Queue.Name)
+}
+
+type recordingWriter struct {
+	msgs []kafka.Message
+	err  error
+}
+
+func (w *recordingWriter) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	w.msgs = append(w.msgs, msgs...)
+	return w.err
+}
+
+type failingRoundTripper struct{}
+
+func (failingRoundTripper) RoundTrip(ctx context.Context, addr net.Addr, req kafka.Request) (kafka.Response, error) {
+	return nil, errors.New("boom")
+}
+
+func formatTraceparent(traceID model.TraceID, spanID model.SpanID) string {
+	return apmhttp.FormatTraceparentHeader(apm.TraceContext{
+		Trace:   apm.TraceID(traceID),
+		Span:    apm.SpanID(spanID),
+		Options: apm.TraceOptions(0).WithRecorded(true),
+	})
+}
@@ -0,0 +1,25 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// Package apmkafkago provides helpers for tracing Kafka writers and
+// readers using github.com/segmentio/kafka-go.
+//
+// Messages written with a Writer returned by WrapWriter are reported as
+// exit spans, and the trace context is propagated in the message headers.
+// Readers may use StartTransaction or StartBatchTransaction to report
+// the processing of received messages as messaging transactions.
+package apmkafkago // import "go.elastic.co/apm/module/apmkafkago/v2"
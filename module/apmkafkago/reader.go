@@ -0,0 +1,90 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apmkafkago // import "go.elastic.co/apm/module/apmkafkago/v2"
+
+import (
+	"context"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+
+	"go.elastic.co/apm/v2"
+)
+
+// maxBatchLinks is the maximum number of span links added to a
+// transaction started by StartBatchTransaction.
+const maxBatchLinks = 1000
+
+// StartTransaction starts a messaging transaction for processing msg,
+// continuing the trace propagated in the message headers, if any, and
+// returns the transaction and a context containing it.
+//
+// The caller is responsible for ending the transaction once the message
+// has been processed.
+func StartTransaction(ctx context.Context, tracer *apm.Tracer, msg kafka.Message) (*apm.Transaction, context.Context) {
+	var opts apm.TransactionOptions
+	if traceContext, ok := traceContextFromHeaders(msg.Headers); ok {
+		opts.TraceContext = traceContext
+	}
+	tx := tracer.StartTransactionOptions("Kafka RECEIVE from "+msg.Topic, "messaging", opts)
+	var age time.Duration
+	if !msg.Time.IsZero() {
+		age = time.Since(msg.Time)
+	}
+	tx.Context.SetMessage(apm.MessageContext{QueueName: msg.Topic, Age: age})
+	return tx, apm.ContextWithTransaction(ctx, tx)
+}
+
+// StartBatchTransaction starts a messaging transaction for processing
+// a batch of messages, and returns the transaction and a context
+// containing it.
+//
+// The transaction starts a new trace, with links to the traces
+// propagated in the message headers.
+//
+// The caller is responsible for ending the transaction once the
+// messages have been processed.
+func StartBatchTransaction(ctx context.Context, tracer *apm.Tracer, msgs []kafka.Message) (*apm.Transaction, context.Context) {
+	var topic string
+	var links []apm.SpanLink
+	for i, msg := range msgs {
+		if i == 0 {
+			topic = msg.Topic
+		} else if msg.Topic != topic {
+			topic = ""
+		}
+		if len(links) == maxBatchLinks {
+			continue
+		}
+		if traceContext, ok := traceContextFromHeaders(msg.Headers); ok {
+			links = append(links, apm.SpanLink{
+				Trace: traceContext.Trace,
+				Span:  traceContext.Span,
+			})
+		}
+	}
+	name := "Kafka RECEIVE"
+	if topic != "" {
+		name += " from " + topic
+	}
+	tx := tracer.StartTransactionOptions(name, "messaging", apm.TransactionOptions{Links: links})
+	if topic != "" {
+		tx.Context.SetMessage(apm.MessageContext{QueueName: topic})
+	}
+	return tx, apm.ContextWithTransaction(ctx, tx)
+}
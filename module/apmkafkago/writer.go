@@ -0,0 +1,134 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apmkafkago // import "go.elastic.co/apm/module/apmkafkago/v2"
+
+import (
+	"context"
+
+	"github.com/segmentio/kafka-go"
+
+	"go.elastic.co/apm/v2"
+)
+
+// MessageWriter is the interface for writing messages implemented
+// by *kafka.Writer.
+type MessageWriter interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+}
+
+// WrapWriter returns a Writer wrapping w, reporting spans for
+// messages written within the transaction or span contained
+// in the context passed to WriteMessages.
+func WrapWriter(w MessageWriter) *Writer {
+	return &Writer{w: w}
+}
+
+// Writer wraps a MessageWriter, such as *kafka.Writer, to report
+// spans for written messages and propagate the trace context in
+// the message headers.
+type Writer struct {
+	w MessageWriter
+}
+
+// WriteMessages writes msgs using the wrapped writer, reporting a single
+// span for the batch if ctx contains a transaction, and adding trace context
+// headers to each message.
+//
+// If the wrapped writer is an asynchronous *kafka.Writer, the span measures
+// only the time taken to enqueue the messages.
+func (w *Writer) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	span := w.startSpan(ctx, msgs)
+	err := w.w.WriteMessages(ctx, msgs...)
+	if span != nil {
+		if err != nil {
+			span.Outcome = "failure"
+			apm.CaptureError(apm.ContextWithSpan(ctx, span), err).Send()
+		} else {
+			span.Outcome = "success"
+		}
+		span.End()
+	}
+	return err
+}
+
+// startSpan starts a span for writing msgs, if ctx contains a transaction,
+// and sets trace context headers in each message. If the transaction is not
+// sampled or the span is dropped, the transaction's trace context is
+// propagated and startSpan returns nil.
+func (w *Writer) startSpan(ctx context.Context, msgs []kafka.Message) *apm.Span {
+	tx := apm.TransactionFromContext(ctx)
+	if tx == nil || len(msgs) == 0 {
+		return nil
+	}
+
+	var span *apm.Span
+	traceContext := tx.TraceContext()
+	if traceContext.Options.Recorded() {
+		topic := w.topic(msgs[0])
+		for _, msg := range msgs[1:] {
+			if w.topic(msg) != topic {
+				topic = ""
+				break
+			}
+		}
+		name := "Kafka SEND"
+		if topic != "" {
+			name += " to " + topic
+		}
+		span, _ = apm.StartSpanOptions(ctx, name, "messaging", apm.SpanOptions{ExitSpan: true})
+		if !span.Dropped() {
+			traceContext = span.TraceContext()
+			span.Subtype = "kafka"
+			span.Action = "send"
+			resource := "kafka"
+			if topic != "" {
+				resource += "/" + topic
+				span.Context.SetMessage(apm.MessageSpanContext{QueueName: topic})
+			}
+			span.Context.SetDestinationService(apm.DestinationServiceSpanContext{
+				Name:     "kafka",
+				Resource: resource,
+			})
+			span.Context.SetServiceTarget(apm.ServiceTargetSpanContext{
+				Type: "kafka",
+				Name: topic,
+			})
+		} else {
+			span.End()
+			span = nil
+		}
+	}
+
+	propagateLegacyHeader := tx.ShouldPropagateLegacyHeader()
+	for i := range msgs {
+		msgs[i].Headers = setHeaders(msgs[i].Headers, traceContext, propagateLegacyHeader)
+	}
+	return span
+}
+
+// topic returns the topic to which msg will be written: either the
+// message's topic, or that of the wrapped *kafka.Writer.
+func (w *Writer) topic(msg kafka.Message) string {
+	if msg.Topic != "" {
+		return msg.Topic
+	}
+	if kw, ok := w.w.(*kafka.Writer); ok {
+		return kw.Topic
+	}
+	return ""
+}
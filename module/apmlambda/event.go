@@ -0,0 +1,327 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apmlambda // import "go.elastic.co/apm/module/apmlambda/v2"
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.elastic.co/apm/module/apmhttp/v2"
+	"go.elastic.co/apm/v2"
+)
+
+// Trigger types, as defined by the faas.trigger.type field.
+const (
+	triggerHTTP       = "http"
+	triggerPubSub     = "pubsub"
+	triggerDatasource = "datasource"
+	triggerOther      = "other"
+)
+
+// maxLinks is the maximum number of span links added to a transaction
+// for an invocation with multiple SQS or SNS records.
+const maxLinks = 1000
+
+// event holds the fields of the Lambda event payloads understood by
+// the instrumentation. A payload is decoded into a single event value,
+// and the trigger is determined by the fields which are present.
+type event struct {
+	// API Gateway and ALB events.
+	Version               string              `json:"version"`
+	HTTPMethod            string              `json:"httpMethod"`
+	Path                  string              `json:"path"`
+	RawPath               string              `json:"rawPath"`
+	RawQueryString        string              `json:"rawQueryString"`
+	RouteKey              string              `json:"routeKey"`
+	Headers               map[string]string   `json:"headers"`
+	MultiValueHeaders     map[string][]string `json:"multiValueHeaders"`
+	QueryStringParameters map[string]string   `json:"queryStringParameters"`
+	RequestContext        *struct {
+		RequestID    string `json:"requestId"`
+		ResourcePath string `json:"resourcePath"`
+		HTTP         *struct {
+			Method string `json:"method"`
+		} `json:"http"`
+		ELB *struct {
+			TargetGroupARN string `json:"targetGroupArn"`
+		} `json:"elb"`
+	} `json:"requestContext"`
+
+	// SQS, SNS, and S3 events.
+	Records []eventRecord `json:"Records"`
+}
+
+type eventRecord struct {
+	// EventSource is "aws:sqs", "aws:sns", or "aws:s3". SNS records
+	// use "EventSource" rather than "eventSource"; both are matched,
+	// as JSON field names are matched case-insensitively.
+	EventSource string `json:"eventSource"`
+
+	// SQS records.
+	MessageID         string            `json:"messageId"`
+	EventSourceARN    string            `json:"eventSourceARN"`
+	Attributes        map[string]string `json:"attributes"`
+	MessageAttributes map[string]struct {
+		StringValue *string `json:"stringValue"`
+	} `json:"messageAttributes"`
+
+	// SNS records.
+	SNS *struct {
+		MessageID         string `json:"MessageId"`
+		TopicARN          string `json:"TopicArn"`
+		Timestamp         string `json:"Timestamp"`
+		MessageAttributes map[string]struct {
+			Type  string `json:"Type"`
+			Value string `json:"Value"`
+		} `json:"MessageAttributes"`
+	} `json:"Sns"`
+
+	// S3 records.
+	EventName        string            `json:"eventName"`
+	ResponseElements map[string]string `json:"responseElements"`
+	S3               *struct {
+		Bucket struct {
+			Name string `json:"name"`
+		} `json:"bucket"`
+	} `json:"s3"`
+}
+
+// trigger describes the trigger of an invocation, derived from the
+// event payload.
+type trigger struct {
+	name      string
+	txType    string
+	faasType  string
+	requestID string
+
+	// traceContext holds the trace context propagated by the
+	// trigger, if haveTraceContext is true.
+	traceContext     apm.TraceContext
+	haveTraceContext bool
+
+	// links holds links to the traces propagated by each record
+	// of an invocation with multiple SQS or SNS records.
+	links []apm.SpanLink
+
+	// request holds the HTTP request for API Gateway and ALB
+	// events, and is nil otherwise.
+	request *http.Request
+
+	// message holds the message context for SQS and SNS events.
+	message *apm.MessageContext
+}
+
+// parseTrigger returns the trigger of an invocation with the given
+// payload. Payloads which are not recognized are given the trigger
+// type "other", and the transaction is named after the function.
+func parseTrigger(payload []byte, functionName string) trigger {
+	var e event
+	if err := json.Unmarshal(payload, &e); err == nil {
+		switch {
+		case e.RequestContext != nil && e.RequestContext.ELB != nil:
+			return httpTrigger(&e, apmhttp.UnknownRouteRequestName)
+		case e.RequestContext != nil && e.RequestContext.HTTP != nil:
+			return httpTrigger(&e, apiGatewayV2RequestName(&e))
+		case e.RequestContext != nil && e.HTTPMethod != "":
+			return httpTrigger(&e, func(req *http.Request) string {
+				return req.Method + " " + e.RequestContext.ResourcePath
+			})
+		case len(e.Records) > 0:
+			switch strings.ToLower(e.Records[0].EventSource) {
+			case "aws:sqs":
+				return sqsTrigger(e.Records)
+			case "aws:sns":
+				return snsTrigger(e.Records)
+			case "aws:s3":
+				return s3Trigger(e.Records)
+			}
+		}
+	}
+	return trigger{name: functionName, txType: "request", faasType: triggerOther}
+}
+
+func apiGatewayV2RequestName(e *event) func(*http.Request) string {
+	// The route key is "$default", or an HTTP method followed by
+	// a path template, e.g. "GET /items/{id}"; the method may be
+	// "ANY", so we always take the method from the request.
+	if i := strings.IndexByte(e.RouteKey, ' '); i != -1 {
+		path := e.RouteKey[i+1:]
+		return func(req *http.Request) string {
+			return req.Method + " " + path
+		}
+	}
+	return apmhttp.UnknownRouteRequestName
+}
+
+func httpTrigger(e *event, requestName func(*http.Request) string) trigger {
+	req := &http.Request{
+		Method: e.HTTPMethod,
+		URL:    &url.URL{Path: e.Path, RawQuery: e.RawQueryString},
+		Header: make(http.Header),
+	}
+	if e.RequestContext.HTTP != nil {
+		req.Method = e.RequestContext.HTTP.Method
+		req.URL.Path = e.RawPath
+	}
+	if req.URL.RawQuery == "" && len(e.QueryStringParameters) > 0 {
+		query := make(url.Values, len(e.QueryStringParameters))
+		for k, v := range e.QueryStringParameters {
+			query.Set(k, v)
+		}
+		req.URL.RawQuery = query.Encode()
+	}
+	for k, v := range e.Headers {
+		req.Header.Set(k, v)
+	}
+	for k, values := range e.MultiValueHeaders {
+		req.Header[http.CanonicalHeaderKey(k)] = values
+	}
+	req.Host = req.Header.Get("Host")
+	req.URL.Host = req.Host
+	req.URL.Scheme = "https"
+	if proto := req.Header.Get("X-Forwarded-Proto"); proto != "" {
+		req.URL.Scheme = proto
+	}
+
+	t := trigger{
+		name:      requestName(req),
+		txType:    "request",
+		faasType:  triggerHTTP,
+		requestID: e.RequestContext.RequestID,
+		request:   req,
+	}
+	t.traceContext, t.haveTraceContext = traceContextFromCarrier(func(key string) string {
+		return req.Header.Get(key)
+	})
+	return t
+}
+
+func sqsTrigger(records []eventRecord) trigger {
+	queueName := records[0].EventSourceARN[strings.LastIndexByte(records[0].EventSourceARN, ':')+1:]
+	t := messagingTrigger(len(records), "SQS", queueName, func(i int) (apm.TraceContext, bool) {
+		return traceContextFromCarrier(func(key string) string {
+			for k, v := range records[i].MessageAttributes {
+				if strings.EqualFold(k, key) && v.StringValue != nil {
+					return *v.StringValue
+				}
+			}
+			return ""
+		})
+	})
+	if len(records) == 1 {
+		t.requestID = records[0].MessageID
+		if sent, err := strconv.ParseInt(records[0].Attributes["SentTimestamp"], 10, 64); err == nil {
+			t.message.Age = time.Since(time.Unix(0, sent*int64(time.Millisecond)))
+		}
+	}
+	return t
+}
+
+func snsTrigger(records []eventRecord) trigger {
+	var topicName string
+	if records[0].SNS != nil {
+		topicARN := records[0].SNS.TopicARN
+		topicName = topicARN[strings.LastIndexByte(topicARN, ':')+1:]
+	}
+	t := messagingTrigger(len(records), "SNS", topicName, func(i int) (apm.TraceContext, bool) {
+		if records[i].SNS == nil {
+			return apm.TraceContext{}, false
+		}
+		return traceContextFromCarrier(func(key string) string {
+			for k, v := range records[i].SNS.MessageAttributes {
+				if strings.EqualFold(k, key) {
+					return v.Value
+				}
+			}
+			return ""
+		})
+	})
+	if len(records) == 1 && records[0].SNS != nil {
+		t.requestID = records[0].SNS.MessageID
+		if ts, err := time.Parse(time.RFC3339, records[0].SNS.Timestamp); err == nil {
+			t.message.Age = time.Since(ts)
+		}
+	}
+	return t
+}
+
+// messagingTrigger returns a trigger for n SQS or SNS records. If there
+// is a single record, its trace context is continued; otherwise the
+// transaction is linked to the traces of each record.
+func messagingTrigger(n int, service, queueName string, recordTraceContext func(i int) (apm.TraceContext, bool)) trigger {
+	t := trigger{
+		name:     service + " RECEIVE",
+		txType:   "messaging",
+		faasType: triggerPubSub,
+		message:  &apm.MessageContext{QueueName: queueName},
+	}
+	if queueName != "" {
+		t.name += " from " + queueName
+	}
+	if n == 1 {
+		t.traceContext, t.haveTraceContext = recordTraceContext(0)
+		return t
+	}
+	for i := 0; i < n && len(t.links) < maxLinks; i++ {
+		if traceContext, ok := recordTraceContext(i); ok {
+			t.links = append(t.links, apm.SpanLink{
+				Trace: traceContext.Trace,
+				Span:  traceContext.Span,
+			})
+		}
+	}
+	return t
+}
+
+func s3Trigger(records []eventRecord) trigger {
+	record := records[0]
+	t := trigger{
+		name:     "S3 " + record.EventName,
+		txType:   "request",
+		faasType: triggerDatasource,
+	}
+	if record.S3 != nil && record.S3.Bucket.Name != "" {
+		t.name += " " + record.S3.Bucket.Name
+	}
+	if len(records) == 1 {
+		t.requestID = record.ResponseElements["x-amz-request-id"]
+	}
+	return t
+}
+
+// traceContextFromCarrier returns the trace context propagated in the
+// carrier, given a function which returns the value for a key, and a
+// boolean indicating whether it was found.
+func traceContextFromCarrier(get func(key string) string) (apm.TraceContext, bool) {
+	traceparent := get(apmhttp.W3CTraceparentHeader)
+	if traceparent == "" {
+		traceparent = get(apmhttp.ElasticTraceparentHeader)
+	}
+	traceContext, err := apmhttp.ParseTraceparentHeader(traceparent)
+	if err != nil {
+		return apm.TraceContext{}, false
+	}
+	if tracestate := get(apmhttp.TracestateHeader); tracestate != "" {
+		traceContext.State, _ = apmhttp.ParseTracestateHeader(tracestate)
+	}
+	return traceContext, true
+}
@@ -0,0 +1,194 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apmlambda // import "go.elastic.co/apm/module/apmlambda/v2"
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testTraceparent1 = "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"
+	testTraceparent2 = "00-1af7651916cd43dd8448eb211c80319c-c7ad6b7169203331-01"
+)
+
+func TestParseTrigger(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		payload   string
+		expected  trigger
+		traceID   string
+		queueName string
+		links     int
+	}{{
+		name: "api_gateway_v1",
+		payload: `{
+			"httpMethod": "POST",
+			"path": "/items/123",
+			"headers": {"Host": "abc.execute-api.us-east-1.amazonaws.com", "traceparent": "` + testTraceparent1 + `"},
+			"requestContext": {"requestId": "req", "resourcePath": "/items/{id}", "stage": "prod"}
+		}`,
+		expected: trigger{name: "POST /items/{id}", txType: "request", faasType: "http", requestID: "req"},
+		traceID:  "0af7651916cd43dd8448eb211c80319c",
+	}, {
+		name: "api_gateway_v2",
+		payload: `{
+			"version": "2.0",
+			"routeKey": "ANY /items/{id}",
+			"rawPath": "/items/123",
+			"rawQueryString": "a=b",
+			"headers": {"host": "abc.execute-api.us-east-1.amazonaws.com"},
+			"requestContext": {"requestId": "req", "http": {"method": "GET", "path": "/items/123"}}
+		}`,
+		expected: trigger{name: "GET /items/{id}", txType: "request", faasType: "http", requestID: "req"},
+	}, {
+		name: "api_gateway_v2_default_route",
+		payload: `{
+			"version": "2.0",
+			"routeKey": "$default",
+			"rawPath": "/items/123",
+			"requestContext": {"requestId": "req", "http": {"method": "GET", "path": "/items/123"}}
+		}`,
+		expected: trigger{name: "GET unknown route", txType: "request", faasType: "http", requestID: "req"},
+	}, {
+		name: "alb",
+		payload: `{
+			"httpMethod": "GET",
+			"path": "/items/123",
+			"headers": {"elastic-apm-traceparent": "` + testTraceparent1 + `"},
+			"requestContext": {"elb": {"targetGroupArn": "arn:aws:elasticloadbalancing:us-east-1:123456789012:targetgroup/tg/abc"}}
+		}`,
+		expected: trigger{name: "GET unknown route", txType: "request", faasType: "http"},
+		traceID:  "0af7651916cd43dd8448eb211c80319c",
+	}, {
+		name: "sqs",
+		payload: `{"Records": [{
+			"messageId": "msg",
+			"eventSource": "aws:sqs",
+			"eventSourceARN": "arn:aws:sqs:us-east-1:123456789012:my-queue",
+			"attributes": {"SentTimestamp": "1545082649183"},
+			"messageAttributes": {"traceparent": {"stringValue": "` + testTraceparent1 + `", "dataType": "String"}}
+		}]}`,
+		expected:  trigger{name: "SQS RECEIVE from my-queue", txType: "messaging", faasType: "pubsub", requestID: "msg"},
+		traceID:   "0af7651916cd43dd8448eb211c80319c",
+		queueName: "my-queue",
+	}, {
+		name: "sqs_batch",
+		payload: `{"Records": [{
+			"messageId": "msg1",
+			"eventSource": "aws:sqs",
+			"eventSourceARN": "arn:aws:sqs:us-east-1:123456789012:my-queue",
+			"messageAttributes": {"Traceparent": {"stringValue": "` + testTraceparent1 + `", "dataType": "String"}}
+		}, {
+			"messageId": "msg2",
+			"eventSource": "aws:sqs",
+			"eventSourceARN": "arn:aws:sqs:us-east-1:123456789012:my-queue",
+			"messageAttributes": {"traceparent": {"stringValue": "` + testTraceparent2 + `", "dataType": "String"}}
+		}, {
+			"messageId": "msg3",
+			"eventSource": "aws:sqs",
+			"eventSourceARN": "arn:aws:sqs:us-east-1:123456789012:my-queue"
+		}]}`,
+		expected:  trigger{name: "SQS RECEIVE from my-queue", txType: "messaging", faasType: "pubsub"},
+		queueName: "my-queue",
+		links:     2,
+	}, {
+		name: "sns",
+		payload: `{"Records": [{
+			"EventSource": "aws:sns",
+			"Sns": {
+				"MessageId": "msg",
+				"TopicArn": "arn:aws:sns:us-east-1:123456789012:my-topic",
+				"Timestamp": "2019-01-02T12:45:07.000Z",
+				"MessageAttributes": {"traceparent": {"Type": "String", "Value": "` + testTraceparent1 + `"}}
+			}
+		}]}`,
+		expected:  trigger{name: "SNS RECEIVE from my-topic", txType: "messaging", faasType: "pubsub", requestID: "msg"},
+		traceID:   "0af7651916cd43dd8448eb211c80319c",
+		queueName: "my-topic",
+	}, {
+		name: "s3",
+		payload: `{"Records": [{
+			"eventSource": "aws:s3",
+			"eventName": "ObjectCreated:Put",
+			"responseElements": {"x-amz-request-id": "req"},
+			"s3": {"bucket": {"name": "my-bucket"}, "object": {"key": "key"}}
+		}]}`,
+		expected: trigger{name: "S3 ObjectCreated:Put my-bucket", txType: "request", faasType: "datasource", requestID: "req"},
+	}, {
+		name:     "other",
+		payload:  `{"name": "value"}`,
+		expected: trigger{name: "my-function", txType: "request", faasType: "other"},
+	}, {
+		name:     "invalid",
+		payload:  `"string"`,
+		expected: trigger{name: "my-function", txType: "request", faasType: "other"},
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			trigger := parseTrigger([]byte(tc.payload), "my-function")
+			assert.Equal(t, tc.expected.name, trigger.name)
+			assert.Equal(t, tc.expected.txType, trigger.txType)
+			assert.Equal(t, tc.expected.faasType, trigger.faasType)
+			assert.Equal(t, tc.expected.requestID, trigger.requestID)
+			assert.Len(t, trigger.links, tc.links)
+			if tc.traceID != "" {
+				require.True(t, trigger.haveTraceContext)
+				assert.Equal(t, tc.traceID, trigger.traceContext.Trace.String())
+			} else {
+				assert.False(t, trigger.haveTraceContext)
+			}
+			if tc.queueName != "" {
+				require.NotNil(t, trigger.message)
+				assert.Equal(t, tc.queueName, trigger.message.QueueName)
+			}
+			if tc.expected.faasType == "http" {
+				assert.NotNil(t, trigger.request)
+			} else {
+				assert.Nil(t, trigger.request)
+			}
+		})
+	}
+}
+
+func TestParseTriggerHTTPRequest(t *testing.T) {
+	trigger := parseTrigger([]byte(`{
+		"version": "2.0",
+		"routeKey": "GET /items/{id}",
+		"rawPath": "/items/123",
+		"rawQueryString": "a=b",
+		"headers": {"host": "example.com", "x-forwarded-proto": "http", "user-agent": "test"},
+		"requestContext": {"requestId": "req", "http": {"method": "GET", "path": "/items/123"}}
+	}`), "my-function")
+	require.NotNil(t, trigger.request)
+	assert.Equal(t, "GET", trigger.request.Method)
+	assert.Equal(t, "http://example.com/items/123?a=b", trigger.request.URL.String())
+	assert.Equal(t, "test", trigger.request.Header.Get("User-Agent"))
+}
+
+func TestParseTriggerSQSMessageAge(t *testing.T) {
+	trigger := parseTrigger([]byte(`{"Records": [{
+		"eventSource": "aws:sqs",
+		"eventSourceARN": "arn:aws:sqs:us-east-1:123456789012:my-queue",
+		"attributes": {"SentTimestamp": "1545082649183"}
+	}]}`), "my-function")
+	require.NotNil(t, trigger.message)
+	assert.Equal(t, "my-queue", trigger.message.QueueName)
+	assert.True(t, trigger.message.Age > 0)
+}
@@ -23,9 +23,7 @@ package main
 import (
 	"context"
 
-	"github.com/aws/aws-lambda-go/lambda"
-
-	_ "go.elastic.co/apm/module/apmlambda/v2"
+	"go.elastic.co/apm/module/apmlambda/v2"
 )
 
 type Request struct {
@@ -37,5 +35,5 @@ func Handler(ctx context.Context, req Request) (string, error) {
 }
 
 func main() {
-	lambda.Start(Handler)
+	apmlambda.Start(Handler)
 }
@@ -0,0 +1,79 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apmlambda // import "go.elastic.co/apm/module/apmlambda/v2"
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+const (
+	// envExtensionAPMServer is the environment variable which configures
+	// the APM Server URL for the Elastic APM AWS Lambda extension. If it
+	// is set, we assume the extension is in use.
+	envExtensionAPMServer = "ELASTIC_APM_LAMBDA_APM_SERVER"
+
+	envServerURL     = "ELASTIC_APM_SERVER_URL"
+	defaultServerURL = "http://localhost:8200"
+)
+
+// extensionClient signals the Elastic APM AWS Lambda extension at the
+// end of each invocation, once all of the invocation's events have been
+// flushed, so the extension can forward the events to APM Server without
+// waiting for the next invocation.
+type extensionClient struct {
+	flushURL string
+	client   *http.Client
+}
+
+// newExtensionClient returns a new extensionClient if the extension is
+// in use, and nil otherwise. The extension listens on the URL which the
+// agent is configured to send events to.
+func newExtensionClient() *extensionClient {
+	if os.Getenv(envExtensionAPMServer) == "" {
+		return nil
+	}
+	serverURL := os.Getenv(envServerURL)
+	if serverURL == "" {
+		serverURL = defaultServerURL
+	}
+	return &extensionClient{
+		flushURL: strings.TrimSuffix(serverURL, "/") + "/intake/v2/events?flushed=true",
+		client:   &http.Client{},
+	}
+}
+
+// flushed signals the extension that all events for the current
+// invocation have been flushed. Errors are ignored, as they must
+// not affect the function.
+func (c *extensionClient) flushed(ctx context.Context) {
+	req, err := http.NewRequestWithContext(ctx, "POST", c.flushURL, nil)
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+}
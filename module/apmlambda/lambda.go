@@ -18,155 +18,251 @@
 package apmlambda // import "go.elastic.co/apm/module/apmlambda/v2"
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
 	"log"
-	"net"
-	"net/rpc"
 	"os"
-	"unicode/utf8"
+	"reflect"
+	"strings"
 
-	"github.com/aws/aws-lambda-go/lambda/messages"
+	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-lambda-go/lambdacontext"
 
+	"go.elastic.co/apm/module/apmhttp/v2"
 	"go.elastic.co/apm/v2"
 	"go.elastic.co/apm/v2/stacktrace"
 )
 
-const (
-	// TODO(axw) make this configurable via environment
-	payloadLimit = 1024
-)
+func init() {
+	stacktrace.RegisterLibraryPackage(
+		"github.com/aws/aws-lambda-go",
+	)
+}
 
-var (
-	// nonBlocking is passed to Tracer.Flush so it does not block functions.
-	nonBlocking = make(chan struct{})
-
-	// Globals below used during tracing, to avoid reallocating for each
-	// invocation. Only one invocation will happen at a time.
-	lambdaContext struct {
-		RequestID       string `json:"request_id,omitempty"`
-		Region          string `json:"region,omitempty"`
-		XAmznTraceID    string `json:"x_amzn_trace_id,omitempty"`
-		FunctionVersion string `json:"function_version,omitempty"`
-		MemoryLimit     int    `json:"memory_limit,omitempty"`
-		Request         string `json:"request,omitempty"`
-		Response        string `json:"response,omitempty"`
-	}
-)
+// Start starts the Lambda runtime, calling handler for each invocation
+// received from the Lambda Runtime API, and reporting each invocation
+// as a transaction.
+//
+// handler must be a function accepted by lambda.Start, in the package
+// github.com/aws/aws-lambda-go/lambda. Start never returns; if the
+// Runtime API returns an error, Start logs the error and exits.
+func Start(handler interface{}, o ...Option) {
+	StartHandler(lambda.NewHandler(handler), o...)
+}
 
-func init() {
-	close(nonBlocking)
-	lambdaContext.FunctionVersion = lambdacontext.FunctionVersion
-	lambdaContext.MemoryLimit = lambdacontext.MemoryLimitInMB
-	lambdaContext.Region = os.Getenv("AWS_REGION")
+// StartHandler is like Start, but accepts a lambda.Handler.
+func StartHandler(handler lambda.Handler, o ...Option) {
+	runtime := newRuntimeClient(os.Getenv("AWS_LAMBDA_RUNTIME_API"))
+	log.Fatal(newInvoker(handler, runtime, o...).run())
+}
+
+type invoker struct {
+	handler   lambda.Handler
+	runtime   *runtimeClient
+	extension *extensionClient
+	tracer    *apm.Tracer
+
+	// coldstart is true until the first invocation has started.
+	// Only one invocation will happen at a time.
+	coldstart bool
 }
 
-// Function is type exposed via net/rpc, to match the signature implemented
-// by the aws-lambda-go package.
-type Function struct {
-	client *rpc.Client
-	tracer *apm.Tracer
+func newInvoker(handler lambda.Handler, runtime *runtimeClient, o ...Option) *invoker {
+	i := &invoker{
+		handler:   handler,
+		runtime:   runtime,
+		extension: newExtensionClient(),
+		coldstart: true,
+	}
+	for _, o := range o {
+		o(i)
+	}
+	if i.tracer == nil {
+		i.tracer = apm.DefaultTracer()
+	}
+	return i
 }
 
-// Ping pings the function implementation.
-func (f *Function) Ping(req *messages.PingRequest, response *messages.PingResponse) error {
-	return f.client.Call("Function.Ping", req, response)
+// run handles invocations until the Runtime API returns an error.
+func (i *invoker) run() error {
+	for {
+		inv, err := i.runtime.next()
+		if err != nil {
+			return err
+		}
+		if err := i.invoke(inv); err != nil {
+			return err
+		}
+	}
 }
 
-// Invoke invokes the Lambda function. This is our main trace point.
-func (f *Function) Invoke(req *messages.InvokeRequest, response *messages.InvokeResponse) error {
-	tx := f.tracer.StartTransaction(lambdacontext.FunctionName, "function")
-	defer f.tracer.Flush(nonBlocking)
-	defer tx.End()
-	defer func() {
-		if v := recover(); v != nil {
-			e := f.tracer.Recovered(v)
-			e.SetTransaction(tx)
-			e.Send()
+// invoke calls the handler for inv, reporting the invocation as a
+// transaction, and reports the result of the invocation to the
+// Runtime API.
+//
+// If the handler panics, the panic is reported as an error to both
+// Elastic APM and the Runtime API, and then propagated.
+func (i *invoker) invoke(inv *invocation) error {
+	lc := &lambdacontext.LambdaContext{
+		AwsRequestID:       inv.requestID,
+		InvokedFunctionArn: inv.invokedFunctionARN,
+	}
+	if inv.clientContext != "" {
+		if err := json.Unmarshal([]byte(inv.clientContext), &lc.ClientContext); err != nil {
+			return fmt.Errorf("failed to decode client context: %w", err)
 		}
-	}()
+	}
+	if inv.cognitoIdentity != "" {
+		if err := json.Unmarshal([]byte(inv.cognitoIdentity), &lc.Identity); err != nil {
+			return fmt.Errorf("failed to decode cognito identity: %w", err)
+		}
+	}
+	os.Setenv("_X_AMZN_TRACE_ID", inv.traceID)
 
-	// TODO(axw) define a schema for lambda/serverless/FaaS.
+	ctx, cancel := context.WithDeadline(context.Background(), inv.deadline)
+	defer cancel()
+	ctx = lambdacontext.NewContext(ctx, lc)
+	tx, t, ctx := i.startTransaction(ctx, inv, lc)
 
-	lambdaContext.RequestID = req.RequestId
-	lambdaContext.XAmznTraceID = req.XAmznTraceId
-	lambdaContext.Request = formatPayload(req.Payload)
-	lambdaContext.Response = ""
+	var (
+		response  []byte
+		err       error
+		recovered interface{}
+		panicked  = true
+	)
+	func() {
+		defer func() {
+			if panicked {
+				recovered = recover()
+			}
+		}()
+		response, err = i.handler.Invoke(ctx, inv.payload)
+		panicked = false
+	}()
 
-	err := f.client.Call("Function.Invoke", req, response)
-	if err != nil {
-		e := f.tracer.NewError(err)
+	switch {
+	case panicked:
+		e := i.tracer.Recovered(recovered)
 		e.SetTransaction(tx)
 		e.Send()
-		return err
-	}
-
-	if response.Payload != nil {
-		lambdaContext.Response = formatPayload(response.Payload)
-	}
-	if response.Error != nil {
-		e := f.tracer.NewError(invokeResponseError{response.Error})
+	case err != nil:
+		e := i.tracer.NewError(err)
 		e.SetTransaction(tx)
 		e.Send()
 	}
-	return nil
-}
+	endTransaction(tx, t, response, panicked || err != nil)
 
-type invokeResponseError struct {
-	err *messages.InvokeResponse_Error
-}
+	// The function instance may be frozen as soon as the response
+	// has been sent, so we must flush before sending the response.
+	i.tracer.Flush(ctx.Done())
+	if i.extension != nil {
+		i.extension.flushed(ctx)
+	}
 
-func (e invokeResponseError) Error() string {
-	return e.err.Message
+	switch {
+	case panicked:
+		i.runtime.fail(inv.requestID, invocationError{
+			Message: fmt.Sprint(recovered),
+			Type:    "Runtime.Panic",
+		})
+		panic(recovered)
+	case err != nil:
+		return i.runtime.fail(inv.requestID, invocationError{
+			Message: err.Error(),
+			Type:    errorType(err),
+		})
+	}
+	return i.runtime.respond(inv.requestID, response)
 }
 
-func (e invokeResponseError) Type() string {
-	return e.err.Type
-}
+// startTransaction starts a transaction for the invocation, and returns
+// the transaction, the trigger of the invocation, and a context
+// containing the transaction.
+func (i *invoker) startTransaction(
+	ctx context.Context, inv *invocation, lc *lambdacontext.LambdaContext,
+) (*apm.Transaction, trigger, context.Context) {
+	t := parseTrigger(inv.payload, lambdacontext.FunctionName)
+	if !t.haveTraceContext && len(t.links) == 0 {
+		// Continue traces propagated in the client context,
+		// for functions invoked directly by instrumented clients.
+		t.traceContext, t.haveTraceContext = traceContextFromCarrier(func(key string) string {
+			for k, v := range lc.ClientContext.Custom {
+				if strings.EqualFold(k, key) {
+					return v
+				}
+			}
+			return ""
+		})
+	}
 
-func (e invokeResponseError) StackTrace() []stacktrace.Frame {
-	frames := make([]stacktrace.Frame, len(e.err.StackTrace))
-	for i, f := range e.err.StackTrace {
-		frames[i] = stacktrace.Frame{
-			Function: f.Label,
-			File:     f.Path,
-			Line:     int(f.Line),
-		}
+	opts := apm.TransactionOptions{Links: t.links}
+	if t.haveTraceContext {
+		opts.TraceContext = t.traceContext
+	}
+	tx := i.tracer.StartTransactionOptions(t.name, t.txType, opts)
+	tx.Context.SetFAAS(apm.FAASContext{
+		ID:               functionID(inv.invokedFunctionARN),
+		Name:             lambdacontext.FunctionName,
+		Version:          lambdacontext.FunctionVersion,
+		Execution:        inv.requestID,
+		Coldstart:        i.coldstart,
+		TriggerType:      t.faasType,
+		TriggerRequestID: t.requestID,
+	})
+	i.coldstart = false
+	if t.request != nil {
+		tx.Context.SetHTTPRequest(t.request)
+	}
+	if t.message != nil {
+		tx.Context.SetMessage(*t.message)
 	}
-	return frames
+	return tx, t, apm.ContextWithTransaction(ctx, tx)
 }
 
-func formatPayload(payload []byte) string {
-	if len(payload) > payloadLimit {
-		payload = payload[:payloadLimit]
+// endTransaction sets the result and outcome of tx, and ends it.
+//
+// For API Gateway and ALB events, the result is taken from the status
+// code of the response, if the handler returns an API Gateway or ALB
+// response.
+func endTransaction(tx *apm.Transaction, t trigger, response []byte, failed bool) {
+	defer tx.End()
+	if failed {
+		tx.Result = "failure"
+		tx.Outcome = "failure"
+		return
 	}
-	if !utf8.Valid(payload) {
-		return ""
+	if t.request != nil {
+		var httpResponse struct {
+			StatusCode int `json:"statusCode"`
+		}
+		if json.Unmarshal(response, &httpResponse) == nil && httpResponse.StatusCode != 0 {
+			tx.Result = apmhttp.StatusCodeResult(httpResponse.StatusCode)
+			tx.Context.SetHTTPStatusCode(httpResponse.StatusCode)
+			return
+		}
 	}
-	return string(payload)
+	tx.Result = "success"
+	tx.Outcome = "success"
 }
 
-func init() {
-	pipeClient, pipeServer := net.Pipe()
-	rpcClient := rpc.NewClient(pipeClient)
-	go rpc.DefaultServer.ServeConn(pipeServer)
-
-	origPort := os.Getenv("_LAMBDA_SERVER_PORT")
-	lis, err := net.Listen("tcp", "localhost:"+origPort)
-	if err != nil {
-		log.Fatal(err)
-	}
-	srv := rpc.NewServer()
-	srv.Register(&Function{
-		client: rpcClient,
-		tracer: apm.DefaultTracer(),
-	})
-	go srv.Accept(lis)
-
-	// Setting _LAMBDA_SERVER_PORT causes lambda.Start
-	// to listen on any free port. We don't care which;
-	// we don't use it.
-	os.Setenv("_LAMBDA_SERVER_PORT", "0")
+// functionID returns the ARN of the invoked function, excluding any
+// version or alias qualifier.
+func functionID(invokedFunctionARN string) string {
+	// format: arn:aws:lambda:us-east-1:123456789012:function:my-function:alias
+	// should return arn:aws:lambda:us-east-1:123456789012:function:my-function
+	if parts := strings.SplitN(invokedFunctionARN, ":", 8); len(parts) == 8 {
+		return strings.Join(parts[:7], ":")
+	}
+	return invokedFunctionARN
 }
 
-// TODO(axw) Start() function, which wraps a given function
-// such that its context is updated with the transaction.
+// errorType returns the name of the type of err,
+// as reported to the Runtime API.
+func errorType(err error) string {
+	t := reflect.TypeOf(err)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Name()
+}
@@ -0,0 +1,324 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apmlambda // import "go.elastic.co/apm/module/apmlambda/v2"
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.elastic.co/apm/module/apmhttp/v2"
+	"go.elastic.co/apm/v2"
+	"go.elastic.co/apm/v2/apmtest"
+	"go.elastic.co/apm/v2/model"
+)
+
+const testFunctionARN = "arn:aws:lambda:us-east-1:123456789012:function:my-function"
+
+func init() {
+	lambdacontext.FunctionName = "my-function"
+	lambdacontext.FunctionVersion = "$LATEST"
+}
+
+// fakeRuntime is a fake Lambda Runtime API server, which serves the
+// given invocations, and records the responses and errors.
+type fakeRuntime struct {
+	*httptest.Server
+
+	mu          sync.Mutex
+	invocations []fakeInvocation
+	responses   map[string]string
+	errors      map[string]invocationError
+}
+
+type fakeInvocation struct {
+	requestID     string
+	clientContext string
+	payload       string
+}
+
+func newFakeRuntime(invocations ...fakeInvocation) *fakeRuntime {
+	rt := &fakeRuntime{
+		invocations: invocations,
+		responses:   make(map[string]string),
+		errors:      make(map[string]invocationError),
+	}
+	rt.Server = httptest.NewServer(http.HandlerFunc(rt.serveHTTP))
+	return rt
+}
+
+func (rt *fakeRuntime) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	path := strings.TrimPrefix(r.URL.Path, "/2018-06-01/runtime/invocation/")
+	if path == "next" {
+		if len(rt.invocations) == 0 {
+			http.Error(w, "no more invocations", http.StatusGone)
+			return
+		}
+		inv := rt.invocations[0]
+		rt.invocations = rt.invocations[1:]
+		deadline := time.Now().Add(time.Minute).UnixNano() / int64(time.Millisecond)
+		w.Header().Set(headerRequestID, inv.requestID)
+		w.Header().Set(headerDeadlineMillis, strconv.FormatInt(deadline, 10))
+		w.Header().Set(headerInvokedFunctionARN, testFunctionARN+":alias")
+		w.Header().Set(headerTraceID, "Root=1-5bef4de7-ad49b0e87f6ef6c87fc2e700")
+		if inv.clientContext != "" {
+			w.Header().Set(headerClientContext, inv.clientContext)
+		}
+		io.WriteString(w, inv.payload)
+		return
+	}
+
+	body, _ := io.ReadAll(r.Body)
+	switch {
+	case strings.HasSuffix(path, "/response"):
+		rt.responses[strings.TrimSuffix(path, "/response")] = string(body)
+	case strings.HasSuffix(path, "/error"):
+		var invErr invocationError
+		json.Unmarshal(body, &invErr)
+		rt.errors[strings.TrimSuffix(path, "/error")] = invErr
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// run runs an invoker with the given handler against rt,
+// until all of the invocations have been handled.
+func (rt *fakeRuntime) run(t *testing.T, tracer *apm.Tracer, handler interface{}) {
+	runtime := newRuntimeClient(rt.Listener.Addr().String())
+	err := newInvoker(lambda.NewHandler(handler), runtime, WithTracer(tracer)).run()
+	require.EqualError(t, err, "failed to get next invocation: 410 Gone")
+}
+
+func TestStartHTTP(t *testing.T) {
+	tracer := apmtest.NewRecordingTracer()
+	defer tracer.Close()
+
+	rt := newFakeRuntime(fakeInvocation{
+		requestID: "req1",
+		payload: `{
+			"httpMethod": "POST",
+			"path": "/items/123",
+			"headers": {"Host": "example.com", "traceparent": "` + testTraceparent1 + `"},
+			"requestContext": {"requestId": "apigw", "resourcePath": "/items/{id}"}
+		}`,
+	})
+	defer rt.Close()
+
+	type response struct {
+		StatusCode int `json:"statusCode"`
+	}
+	rt.run(t, tracer.Tracer, func(ctx context.Context) (response, error) {
+		assert.NotNil(t, apm.TransactionFromContext(ctx))
+		lc, ok := lambdacontext.FromContext(ctx)
+		require.True(t, ok)
+		assert.Equal(t, "req1", lc.AwsRequestID)
+		return response{StatusCode: 201}, nil
+	})
+	assert.Equal(t, map[string]string{"req1": `{"statusCode":201}`}, rt.responses)
+
+	tracer.Flush(nil)
+	payloads := tracer.Payloads()
+	require.Len(t, payloads.Transactions, 1)
+	tx := payloads.Transactions[0]
+	assert.Equal(t, "POST /items/{id}", tx.Name)
+	assert.Equal(t, "request", tx.Type)
+	assert.Equal(t, "HTTP 2xx", tx.Result)
+	assert.Equal(t, "success", tx.Outcome)
+	assertContinuesTrace(t, tx)
+	assert.Equal(t, &model.FAAS{
+		ID:        testFunctionARN,
+		Name:      "my-function",
+		Version:   "$LATEST",
+		Execution: "req1",
+		Coldstart: true,
+		Trigger: &model.FAASTrigger{
+			Type:      "http",
+			RequestID: "apigw",
+		},
+	}, tx.FAAS)
+	require.NotNil(t, tx.Context)
+	require.NotNil(t, tx.Context.Request)
+	assert.Equal(t, "POST", tx.Context.Request.Method)
+	assert.Equal(t, "example.com", tx.Context.Request.URL.Hostname)
+	assert.Equal(t, "/items/123", tx.Context.Request.URL.Path)
+	require.NotNil(t, tx.Context.Response)
+	assert.Equal(t, 201, tx.Context.Response.StatusCode)
+}
+
+func TestStartColdstart(t *testing.T) {
+	tracer := apmtest.NewRecordingTracer()
+	defer tracer.Close()
+
+	rt := newFakeRuntime(
+		fakeInvocation{requestID: "req1", payload: `{}`},
+		fakeInvocation{requestID: "req2", payload: `{}`},
+	)
+	defer rt.Close()
+	rt.run(t, tracer.Tracer, func() (string, error) { return "ok", nil })
+	assert.Equal(t, map[string]string{"req1": `"ok"`, "req2": `"ok"`}, rt.responses)
+
+	tracer.Flush(nil)
+	payloads := tracer.Payloads()
+	require.Len(t, payloads.Transactions, 2)
+	for i, tx := range payloads.Transactions {
+		assert.Equal(t, "my-function", tx.Name)
+		assert.Equal(t, "request", tx.Type)
+		assert.Equal(t, "success", tx.Result)
+		require.NotNil(t, tx.FAAS)
+		assert.Equal(t, i == 0, tx.FAAS.Coldstart)
+		assert.Equal(t, &model.FAASTrigger{Type: "other"}, tx.FAAS.Trigger)
+	}
+}
+
+func TestStartClientContext(t *testing.T) {
+	tracer := apmtest.NewRecordingTracer()
+	defer tracer.Close()
+
+	rt := newFakeRuntime(fakeInvocation{
+		requestID:     "req1",
+		clientContext: `{"custom": {"Traceparent": "` + testTraceparent1 + `"}}`,
+		payload:       `{}`,
+	})
+	defer rt.Close()
+	rt.run(t, tracer.Tracer, func() error { return nil })
+
+	tracer.Flush(nil)
+	payloads := tracer.Payloads()
+	require.Len(t, payloads.Transactions, 1)
+	assertContinuesTrace(t, payloads.Transactions[0])
+}
+
+type testError struct{}
+
+func (testError) Error() string { return "boom" }
+
+func TestStartError(t *testing.T) {
+	tracer := apmtest.NewRecordingTracer()
+	defer tracer.Close()
+
+	rt := newFakeRuntime(fakeInvocation{requestID: "req1", payload: `{}`})
+	defer rt.Close()
+	rt.run(t, tracer.Tracer, func() error { return &testError{} })
+	assert.Empty(t, rt.responses)
+	assert.Equal(t, map[string]invocationError{
+		"req1": {Message: "boom", Type: "testError"},
+	}, rt.errors)
+
+	tracer.Flush(nil)
+	payloads := tracer.Payloads()
+	require.Len(t, payloads.Transactions, 1)
+	require.Len(t, payloads.Errors, 1)
+	tx := payloads.Transactions[0]
+	assert.Equal(t, "failure", tx.Result)
+	assert.Equal(t, "failure", tx.Outcome)
+	assert.Equal(t, tx.ID, payloads.Errors[0].TransactionID)
+	assert.Equal(t, "boom", payloads.Errors[0].Exception.Message)
+}
+
+func TestStartPanic(t *testing.T) {
+	tracer := apmtest.NewRecordingTracer()
+	defer tracer.Close()
+
+	rt := newFakeRuntime(fakeInvocation{requestID: "req1", payload: `{}`})
+	defer rt.Close()
+	assert.PanicsWithValue(t, "boom", func() {
+		rt.run(t, tracer.Tracer, func() error { panic("boom") })
+	})
+	assert.Equal(t, map[string]invocationError{
+		"req1": {Message: "boom", Type: "Runtime.Panic"},
+	}, rt.errors)
+
+	tracer.Flush(nil)
+	payloads := tracer.Payloads()
+	require.Len(t, payloads.Transactions, 1)
+	require.Len(t, payloads.Errors, 1)
+	assert.Equal(t, "failure", payloads.Transactions[0].Outcome)
+	assert.Equal(t, payloads.Transactions[0].ID, payloads.Errors[0].TransactionID)
+}
+
+func TestStartExtensionFlushed(t *testing.T) {
+	var flushed int
+	extension := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/intake/v2/events" && r.URL.Query().Get("flushed") == "true" {
+			flushed++
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer extension.Close()
+	t.Setenv(envExtensionAPMServer, "https://apm.example.com")
+	t.Setenv(envServerURL, extension.URL)
+
+	tracer := apmtest.NewRecordingTracer()
+	defer tracer.Close()
+
+	rt := newFakeRuntime(
+		fakeInvocation{requestID: "req1", payload: `{}`},
+		fakeInvocation{requestID: "req2", payload: `{}`},
+	)
+	defer rt.Close()
+	rt.run(t, tracer.Tracer, func() error { return nil })
+	assert.Equal(t, 2, flushed)
+}
+
+func TestStartExtensionNotConfigured(t *testing.T) {
+	extension := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected request to %s", r.URL)
+	}))
+	defer extension.Close()
+	t.Setenv(envExtensionAPMServer, "")
+	t.Setenv(envServerURL, extension.URL)
+
+	tracer := apmtest.NewRecordingTracer()
+	defer tracer.Close()
+
+	rt := newFakeRuntime(fakeInvocation{requestID: "req1", payload: `{}`})
+	defer rt.Close()
+	rt.run(t, tracer.Tracer, func() error { return nil })
+}
+
+// assertContinuesTrace asserts that tx continues the trace
+// propagated with testTraceparent1.
+func assertContinuesTrace(t *testing.T, tx model.Transaction) {
+	traceContext, err := apmhttp.ParseTraceparentHeader(testTraceparent1)
+	require.NoError(t, err)
+	assert.Equal(t, model.TraceID(traceContext.Trace), tx.TraceID)
+	assert.Equal(t, model.SpanID(traceContext.Span), tx.ParentID)
+}
+
+func TestFunctionID(t *testing.T) {
+	assert.Equal(t, testFunctionARN, functionID(testFunctionARN))
+	assert.Equal(t, testFunctionARN, functionID(testFunctionARN+":alias"))
+	assert.Equal(t, "", functionID(""))
+}
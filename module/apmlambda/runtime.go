@@ -0,0 +1,140 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apmlambda // import "go.elastic.co/apm/module/apmlambda/v2"
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	runtimeAPIVersion = "2018-06-01"
+
+	headerRequestID          = "Lambda-Runtime-Aws-Request-Id"
+	headerDeadlineMillis     = "Lambda-Runtime-Deadline-Ms"
+	headerInvokedFunctionARN = "Lambda-Runtime-Invoked-Function-Arn"
+	headerTraceID            = "Lambda-Runtime-Trace-Id"
+	headerClientContext      = "Lambda-Runtime-Client-Context"
+	headerCognitoIdentity    = "Lambda-Runtime-Cognito-Identity"
+	headerFunctionErrorType  = "Lambda-Runtime-Function-Error-Type"
+)
+
+// runtimeClient is a client for the Lambda Runtime API.
+//
+// See https://docs.aws.amazon.com/lambda/latest/dg/runtimes-api.html
+type runtimeClient struct {
+	baseURL string
+	client  *http.Client
+}
+
+func newRuntimeClient(address string) *runtimeClient {
+	return &runtimeClient{
+		baseURL: "http://" + address + "/" + runtimeAPIVersion + "/runtime/invocation/",
+		// The next invocation request blocks until there
+		// is an invocation, so we must not set a timeout.
+		client: &http.Client{},
+	}
+}
+
+// invocation holds the details of a function invocation.
+type invocation struct {
+	requestID          string
+	deadline           time.Time
+	invokedFunctionARN string
+	traceID            string
+	clientContext      string
+	cognitoIdentity    string
+	payload            []byte
+}
+
+// invocationError is the error reported to the Runtime API
+// when a function invocation fails.
+type invocationError struct {
+	Message string `json:"errorMessage"`
+	Type    string `json:"errorType"`
+}
+
+// next waits for, and returns, the next invocation.
+func (c *runtimeClient) next() (*invocation, error) {
+	resp, err := c.client.Get(c.baseURL + "next")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get next invocation: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to get next invocation: %s", resp.Status)
+	}
+	payload, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read next invocation: %w", err)
+	}
+	inv := &invocation{
+		requestID:          resp.Header.Get(headerRequestID),
+		invokedFunctionARN: resp.Header.Get(headerInvokedFunctionARN),
+		traceID:            resp.Header.Get(headerTraceID),
+		clientContext:      resp.Header.Get(headerClientContext),
+		cognitoIdentity:    resp.Header.Get(headerCognitoIdentity),
+		payload:            payload,
+	}
+	deadlineMillis, err := strconv.ParseInt(resp.Header.Get(headerDeadlineMillis), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse invocation deadline: %w", err)
+	}
+	inv.deadline = time.Unix(0, deadlineMillis*int64(time.Millisecond))
+	return inv, nil
+}
+
+// respond reports the successful response to the invocation
+// with the given request ID.
+func (c *runtimeClient) respond(requestID string, payload []byte) error {
+	return c.post(c.baseURL+requestID+"/response", bytes.NewReader(payload), "")
+}
+
+// fail reports the failure of the invocation with the given request ID.
+func (c *runtimeClient) fail(requestID string, invErr invocationError) error {
+	body, err := json.Marshal(invErr)
+	if err != nil {
+		return err
+	}
+	return c.post(c.baseURL+requestID+"/error", bytes.NewReader(body), invErr.Type)
+}
+
+func (c *runtimeClient) post(url string, body io.Reader, errorType string) error {
+	req, err := http.NewRequest("POST", url, body)
+	if err != nil {
+		return err
+	}
+	if errorType != "" {
+		req.Header.Set(headerFunctionErrorType, errorType)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to post to %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("failed to post to %s: %s", url, resp.Status)
+	}
+	return nil
+}
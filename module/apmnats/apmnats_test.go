@@ -0,0 +1,251 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apmnats_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.elastic.co/apm/module/apmhttp/v2"
+	"go.elastic.co/apm/module/apmnats/v2"
+	"go.elastic.co/apm/v2"
+	"go.elastic.co/apm/v2/apmtest"
+	"go.elastic.co/apm/v2/model"
+)
+
+func TestPublishMsgContext(t *testing.T) {
+	nc := connect(t)
+	sub, err := nc.SubscribeSync("subject")
+	require.NoError(t, err)
+
+	tracer := apmtest.NewRecordingTracer()
+	defer tracer.Close()
+	tx, spans, errs := tracer.WithTransaction(func(ctx context.Context) {
+		err := nc.PublishContext(ctx, "subject", []byte("data"))
+		require.NoError(t, err)
+	})
+	require.Len(t, spans, 1)
+	assert.Empty(t, errs)
+
+	span := spans[0]
+	assert.Equal(t, tx.ID, span.ParentID)
+	assert.Equal(t, "NATS SEND to subject", span.Name)
+	assert.Equal(t, "messaging", span.Type)
+	assert.Equal(t, "nats", span.Subtype)
+	assert.Equal(t, "send", span.Action)
+	assert.Equal(t, "success", span.Outcome)
+	assert.Equal(t, &model.SpanContext{
+		Message: &model.MessageSpanContext{
+			Queue: &model.MessageQueueSpanContext{Name: "subject"},
+		},
+		Destination: &model.DestinationSpanContext{
+			Service: &model.DestinationServiceSpanContext{
+				Type:     "messaging",
+				Name:     "nats",
+				Resource: "nats/subject",
+			},
+		},
+		Service: &model.ServiceSpanContext{
+			Target: &model.ServiceTargetSpanContext{
+				Type: "nats",
+				Name: "subject",
+			},
+		},
+	}, span.Context)
+
+	msg, err := sub.NextMsg(10 * time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, "data", string(msg.Data))
+	assert.Equal(t, formatTraceparent(tx.TraceID, span.ID), msg.Header.Get("traceparent"))
+	assert.Equal(t, formatTraceparent(tx.TraceID, span.ID), msg.Header.Get("elastic-apm-traceparent"))
+	assert.Equal(t, "es=s:1", msg.Header.Get("tracestate"))
+}
+
+func TestPublishMsgContextNoTransaction(t *testing.T) {
+	nc := connect(t)
+	sub, err := nc.SubscribeSync("subject")
+	require.NoError(t, err)
+
+	err = nc.PublishContext(context.Background(), "subject", []byte("data"))
+	require.NoError(t, err)
+	msg, err := sub.NextMsg(10 * time.Second)
+	require.NoError(t, err)
+	assert.Empty(t, msg.Header)
+}
+
+func TestRequestWithContext(t *testing.T) {
+	nc := connect(t)
+	tracer := apmtest.NewRecordingTracer()
+	defer tracer.Close()
+
+	// Reply from a handler traced with a separate tracer, so we can
+	// check that the handler's transaction continues the requester's
+	// trace.
+	handlerTracer := apmtest.NewRecordingTracer()
+	defer handlerTracer.Close()
+	_, err := nc.Subscribe("subject", apmnats.WrapHandler(func(ctx context.Context, msg *nats.Msg) {
+		assert.NotNil(t, apm.TransactionFromContext(ctx))
+		assert.NoError(t, msg.Respond([]byte("pong")))
+	}, apmnats.WithTracer(handlerTracer.Tracer)))
+	require.NoError(t, err)
+
+	tx, spans, errs := tracer.WithTransaction(func(ctx context.Context) {
+		reply, err := nc.RequestWithContext(ctx, "subject", []byte("ping"))
+		require.NoError(t, err)
+		assert.Equal(t, "pong", string(reply.Data))
+	})
+	require.Len(t, spans, 1)
+	assert.Empty(t, errs)
+
+	span := spans[0]
+	assert.Equal(t, "NATS REQUEST to subject", span.Name)
+	assert.Equal(t, "request", span.Action)
+	assert.Equal(t, "success", span.Outcome)
+
+	handlerTx := waitTransaction(t, handlerTracer)
+	assert.Equal(t, "NATS RECEIVE from subject", handlerTx.Name)
+	assert.Equal(t, "messaging", handlerTx.Type)
+	assert.Equal(t, tx.TraceID, handlerTx.TraceID)
+	assert.Equal(t, span.ID, handlerTx.ParentID)
+	require.NotNil(t, handlerTx.Context)
+	require.NotNil(t, handlerTx.Context.Message)
+	assert.Equal(t, "subject", handlerTx.Context.Message.Queue.Name)
+}
+
+func TestRequestWithContextNoResponders(t *testing.T) {
+	nc := connect(t)
+	tracer := apmtest.NewRecordingTracer()
+	defer tracer.Close()
+
+	_, spans, errs := tracer.WithTransaction(func(ctx context.Context) {
+		_, err := nc.RequestWithContext(ctx, "subject", []byte("ping"))
+		assert.True(t, errors.Is(err, nats.ErrNoResponders))
+	})
+	require.Len(t, spans, 1)
+	require.Len(t, errs, 1)
+	assert.Equal(t, "failure", spans[0].Outcome)
+	assert.Equal(t, spans[0].ID, errs[0].ParentID)
+}
+
+func TestWrapHandlerPanic(t *testing.T) {
+	tracer := apmtest.NewRecordingTracer()
+	defer tracer.Close()
+
+	handler := apmnats.WrapHandler(func(ctx context.Context, msg *nats.Msg) {
+		panic("boom")
+	}, apmnats.WithTracer(tracer.Tracer))
+	assert.PanicsWithValue(t, "boom", func() {
+		handler(&nats.Msg{Subject: "subject"})
+	})
+	tracer.Flush(nil)
+
+	payloads := tracer.Payloads()
+	require.Len(t, payloads.Transactions, 1)
+	require.Len(t, payloads.Errors, 1)
+	assert.Equal(t, "failure", payloads.Transactions[0].Outcome)
+	assert.Zero(t, payloads.Transactions[0].ParentID)
+	assert.Equal(t, payloads.Transactions[0].ID, payloads.Errors[0].ParentID)
+	assert.Equal(t, "boom", payloads.Errors[0].Exception.Message)
+}
+
+func TestJetStream(t *testing.T) {
+	nc := connect(t)
+	js, err := nc.JetStream()
+	require.NoError(t, err)
+	_, err = js.AddStream(&nats.StreamConfig{Name: "stream", Subjects: []string{"subject"}})
+	require.NoError(t, err)
+
+	tracer := apmtest.NewRecordingTracer()
+	defer tracer.Close()
+	tx, spans, _ := tracer.WithTransaction(func(ctx context.Context) {
+		_, err := apmnats.WrapJetStream(js).PublishContext(ctx, "subject", []byte("data"))
+		require.NoError(t, err)
+	})
+	require.Len(t, spans, 1)
+	assert.Equal(t, "NATS SEND to subject", spans[0].Name)
+	tracer.ResetPayloads()
+
+	sub, err := js.PullSubscribe("subject", "consumer")
+	require.NoError(t, err)
+	msgs, err := sub.Fetch(1)
+	require.NoError(t, err)
+	require.Len(t, msgs, 1)
+
+	consumerTx, _ := apmnats.StartTransaction(context.Background(), tracer.Tracer, msgs[0])
+	consumerTx.End()
+	tracer.Flush(nil)
+
+	payloads := tracer.Payloads()
+	require.Len(t, payloads.Transactions, 1)
+	transaction := payloads.Transactions[0]
+	assert.Equal(t, tx.TraceID, transaction.TraceID)
+	assert.Equal(t, spans[0].ID, transaction.ParentID)
+	require.NotNil(t, transaction.Context.Message.Age)
+	assert.True(t, transaction.Context.Message.Age.Millis >= 0)
+}
+
+func connect(t testing.TB) *apmnats.Conn {
+	s, err := server.NewServer(&server.Options{
+		Host:      "127.0.0.1",
+		Port:      server.RANDOM_PORT,
+		JetStream: true,
+		StoreDir:  t.TempDir(),
+		NoLog:     true,
+		NoSigs:    true,
+	})
+	require.NoError(t, err)
+	go s.Start()
+	t.Cleanup(s.Shutdown)
+	require.True(t, s.ReadyForConnections(10*time.Second))
+
+	nc, err := nats.Connect(s.ClientURL())
+	require.NoError(t, err)
+	t.Cleanup(nc.Close)
+	return apmnats.WrapConn(nc)
+}
+
+// waitTransaction waits for a transaction to be reported
+// to tracer, and returns it.
+func waitTransaction(t testing.TB, tracer *apmtest.RecordingTracer) model.Transaction {
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		tracer.Flush(nil)
+		payloads := tracer.Payloads()
+		if len(payloads.Transactions) != 0 {
+			return payloads.Transactions[0]
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("timed out waiting for transaction")
+	panic("unreachable")
+}
+
+func formatTraceparent(traceID model.TraceID, spanID model.SpanID) string {
+	return apmhttp.FormatTraceparentHeader(apm.TraceContext{
+		Trace:   apm.TraceID(traceID),
+		Span:    apm.SpanID(spanID),
+		Options: apm.TraceOptions(0).WithRecorded(true),
+	})
+}
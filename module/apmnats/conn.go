@@ -0,0 +1,130 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apmnats // import "go.elastic.co/apm/module/apmnats/v2"
+
+import (
+	"context"
+
+	"github.com/nats-io/nats.go"
+
+	"go.elastic.co/apm/v2"
+)
+
+// WrapConn returns a Conn wrapping nc, providing context-aware
+// methods for publishing messages and making requests.
+func WrapConn(nc *nats.Conn) *Conn {
+	return &Conn{Conn: nc}
+}
+
+// Conn wraps a *nats.Conn, adding methods for publishing messages and
+// making requests within the transaction or span contained in a context.
+//
+// Messages published with the *nats.Conn methods, such as Publish and
+// Request, are sent without tracing.
+type Conn struct {
+	*nats.Conn
+}
+
+// PublishContext publishes data to subj, as with PublishMsgContext.
+func (c *Conn) PublishContext(ctx context.Context, subj string, data []byte) error {
+	return c.PublishMsgContext(ctx, &nats.Msg{Subject: subj, Data: data})
+}
+
+// PublishMsgContext publishes msg using c.PublishMsg, reporting a span if
+// ctx contains a transaction, and adding trace context headers to msg if
+// the server supports headers.
+func (c *Conn) PublishMsgContext(ctx context.Context, msg *nats.Msg) error {
+	span := startSpan(ctx, "send", msg, c.HeadersSupported())
+	err := c.PublishMsg(msg)
+	endSpan(ctx, span, err)
+	return err
+}
+
+// RequestWithContext sends a request with data to subj, as with
+// RequestMsgWithContext.
+func (c *Conn) RequestWithContext(ctx context.Context, subj string, data []byte) (*nats.Msg, error) {
+	return c.RequestMsgWithContext(ctx, &nats.Msg{Subject: subj, Data: data})
+}
+
+// RequestMsgWithContext sends msg as a request using c.Conn.RequestMsgWithContext,
+// and waits for a reply. If ctx contains a transaction, a span is reported
+// measuring the time until the reply is received, and trace context headers
+// are added to msg if the server supports headers.
+func (c *Conn) RequestMsgWithContext(ctx context.Context, msg *nats.Msg) (*nats.Msg, error) {
+	span := startSpan(ctx, "request", msg, c.HeadersSupported())
+	reply, err := c.Conn.RequestMsgWithContext(ctx, msg)
+	endSpan(ctx, span, err)
+	return reply, err
+}
+
+// startSpan starts a span for publishing msg with the given action, if ctx
+// contains a transaction, and sets trace context headers in msg if propagate
+// is true. If the transaction is not sampled or the span is dropped, the
+// transaction's trace context is propagated and startSpan returns nil.
+func startSpan(ctx context.Context, action string, msg *nats.Msg, propagate bool) *apm.Span {
+	tx := apm.TransactionFromContext(ctx)
+	if tx == nil || msg == nil {
+		return nil
+	}
+
+	var span *apm.Span
+	traceContext := tx.TraceContext()
+	if traceContext.Options.Recorded() {
+		name := "NATS SEND to "
+		if action == "request" {
+			name = "NATS REQUEST to "
+		}
+		span, _ = apm.StartSpanOptions(ctx, name+msg.Subject, "messaging", apm.SpanOptions{ExitSpan: true})
+		if !span.Dropped() {
+			traceContext = span.TraceContext()
+			span.Subtype = "nats"
+			span.Action = action
+			span.Context.SetMessage(apm.MessageSpanContext{QueueName: msg.Subject})
+			span.Context.SetDestinationService(apm.DestinationServiceSpanContext{
+				Name:     "nats",
+				Resource: "nats/" + msg.Subject,
+			})
+			span.Context.SetServiceTarget(apm.ServiceTargetSpanContext{
+				Type: "nats",
+				Name: msg.Subject,
+			})
+		} else {
+			span.End()
+			span = nil
+		}
+	}
+	if propagate {
+		setHeaders(msg, traceContext, tx.ShouldPropagateLegacyHeader())
+	}
+	return span
+}
+
+// endSpan ends span, if non-nil, setting its outcome and reporting
+// err if it is non-nil.
+func endSpan(ctx context.Context, span *apm.Span, err error) {
+	if span == nil {
+		return
+	}
+	if err != nil {
+		span.Outcome = "failure"
+		apm.CaptureError(apm.ContextWithSpan(ctx, span), err).Send()
+	} else {
+		span.Outcome = "success"
+	}
+	span.End()
+}
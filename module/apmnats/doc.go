@@ -0,0 +1,26 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// Package apmnats provides helpers for tracing NATS publishers and
+// subscribers using github.com/nats-io/nats.go, including JetStream.
+//
+// Messages published with Conn.PublishMsgContext, and requests made with
+// Conn.RequestMsgWithContext, are reported as exit spans, and the trace
+// context is propagated in the message headers. Message handlers wrapped
+// with WrapHandler are reported as messaging transactions, continuing the
+// trace propagated by the publisher.
+package apmnats // import "go.elastic.co/apm/module/apmnats/v2"
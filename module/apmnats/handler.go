@@ -0,0 +1,101 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apmnats // import "go.elastic.co/apm/module/apmnats/v2"
+
+import (
+	"context"
+	"time"
+
+	"github.com/nats-io/nats.go"
+
+	"go.elastic.co/apm/v2"
+)
+
+// MsgHandler is a message handler which accepts a context, which will
+// contain the transaction created for processing the message.
+type MsgHandler func(ctx context.Context, msg *nats.Msg)
+
+// WrapHandler returns a nats.MsgHandler, for use with Subscribe and
+// QueueSubscribe on a *nats.Conn or nats.JetStreamContext, which reports
+// a messaging transaction for each message handled by h.
+//
+// The transaction continues the trace propagated in the message headers,
+// if any. If h panics, the panic is reported as an error and then
+// propagated.
+func WrapHandler(h MsgHandler, o ...Option) nats.MsgHandler {
+	opts := options{tracer: apm.DefaultTracer()}
+	for _, o := range o {
+		o(&opts)
+	}
+	return func(msg *nats.Msg) {
+		tx, ctx := StartTransaction(context.Background(), opts.tracer, msg)
+		defer tx.End()
+		defer func() {
+			if v := recover(); v != nil {
+				e := opts.tracer.Recovered(v)
+				e.SetTransaction(tx)
+				e.Send()
+				tx.Outcome = "failure"
+				panic(v)
+			}
+		}()
+		h(ctx, msg)
+	}
+}
+
+// StartTransaction starts a messaging transaction for processing msg,
+// continuing the trace propagated in the message headers, if any, and
+// returns the transaction and a context containing it.
+//
+// StartTransaction may be used for messages received synchronously,
+// such as with Subscription.NextMsg or Subscription.Fetch. The caller
+// is responsible for ending the transaction once the message has been
+// processed.
+func StartTransaction(ctx context.Context, tracer *apm.Tracer, msg *nats.Msg) (*apm.Transaction, context.Context) {
+	var opts apm.TransactionOptions
+	if traceContext, ok := traceContextFromHeaders(msg.Header); ok {
+		opts.TraceContext = traceContext
+	}
+	tx := tracer.StartTransactionOptions("NATS RECEIVE from "+msg.Subject, "messaging", opts)
+	var age time.Duration
+	if meta, err := msg.Metadata(); err == nil && !meta.Timestamp.IsZero() {
+		// JetStream messages record the time at which
+		// they were stored in the stream.
+		age = time.Since(meta.Timestamp)
+	}
+	tx.Context.SetMessage(apm.MessageContext{QueueName: msg.Subject, Age: age})
+	return tx, apm.ContextWithTransaction(ctx, tx)
+}
+
+type options struct {
+	tracer *apm.Tracer
+}
+
+// Option sets options for tracing message handlers.
+type Option func(*options)
+
+// WithTracer returns an Option which sets t as the tracer
+// to use for tracing message handlers.
+func WithTracer(t *apm.Tracer) Option {
+	if t == nil {
+		panic("t == nil")
+	}
+	return func(o *options) {
+		o.tracer = t
+	}
+}
@@ -0,0 +1,71 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apmnats // import "go.elastic.co/apm/module/apmnats/v2"
+
+import (
+	"github.com/nats-io/nats.go"
+
+	"go.elastic.co/apm/module/apmhttp/v2"
+	"go.elastic.co/apm/v2"
+)
+
+const (
+	// traceparentHeader is the message header for W3C trace propagation.
+	traceparentHeader = "traceparent"
+
+	// elasticTraceparentHeader is the legacy message header for trace
+	// propagation. NATS headers are case-sensitive, so this is the
+	// lower-cased form of the legacy HTTP header name.
+	elasticTraceparentHeader = "elastic-apm-traceparent"
+
+	// tracestateHeader is the message header for W3C tracestate.
+	tracestateHeader = "tracestate"
+)
+
+// setHeaders sets the trace context headers in msg,
+// replacing any existing values.
+func setHeaders(msg *nats.Msg, traceContext apm.TraceContext, propagateLegacyHeader bool) {
+	if msg.Header == nil {
+		msg.Header = make(nats.Header)
+	}
+	traceparent := apmhttp.FormatTraceparentHeader(traceContext)
+	msg.Header.Set(traceparentHeader, traceparent)
+	if propagateLegacyHeader {
+		msg.Header.Set(elasticTraceparentHeader, traceparent)
+	}
+	if tracestate := traceContext.State.String(); tracestate != "" {
+		msg.Header.Set(tracestateHeader, tracestate)
+	}
+}
+
+// traceContextFromHeaders returns the trace context propagated in the
+// given message headers, and a boolean indicating whether it was found.
+func traceContextFromHeaders(header nats.Header) (apm.TraceContext, bool) {
+	traceparent := header.Get(traceparentHeader)
+	if traceparent == "" {
+		traceparent = header.Get(elasticTraceparentHeader)
+	}
+	traceContext, err := apmhttp.ParseTraceparentHeader(traceparent)
+	if err != nil {
+		return apm.TraceContext{}, false
+	}
+	if tracestate := header.Values(tracestateHeader); len(tracestate) != 0 {
+		traceContext.State, _ = apmhttp.ParseTracestateHeader(tracestate...)
+	}
+	return traceContext, true
+}
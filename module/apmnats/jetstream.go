@@ -0,0 +1,58 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apmnats // import "go.elastic.co/apm/module/apmnats/v2"
+
+import (
+	"context"
+
+	"github.com/nats-io/nats.go"
+)
+
+// WrapJetStream returns a JetStream wrapping js, providing
+// context-aware methods for publishing messages.
+func WrapJetStream(js nats.JetStreamContext) *JetStream {
+	return &JetStream{JetStreamContext: js}
+}
+
+// JetStream wraps a nats.JetStreamContext, adding methods for publishing
+// messages within the transaction or span contained in a context.
+//
+// Messages published with the nats.JetStreamContext methods, such as
+// Publish and PublishMsg, are sent without tracing. Subscription handlers
+// may be traced by wrapping them with WrapHandler.
+type JetStream struct {
+	nats.JetStreamContext
+}
+
+// PublishContext publishes data to subj, as with PublishMsgContext.
+func (js *JetStream) PublishContext(ctx context.Context, subj string, data []byte, opts ...nats.PubOpt) (*nats.PubAck, error) {
+	return js.PublishMsgContext(ctx, &nats.Msg{Subject: subj, Data: data}, opts...)
+}
+
+// PublishMsgContext publishes msg to a stream using js.PublishMsg and waits
+// for the acknowledgement, reporting a span if ctx contains a transaction,
+// and adding trace context headers to msg.
+//
+// ctx is used only for tracing; to bound the time spent waiting for the
+// acknowledgement with ctx, pass nats.Context(ctx) in opts.
+func (js *JetStream) PublishMsgContext(ctx context.Context, msg *nats.Msg, opts ...nats.PubOpt) (*nats.PubAck, error) {
+	span := startSpan(ctx, "send", msg, true)
+	ack, err := js.PublishMsg(msg, opts...)
+	endSpan(ctx, span, err)
+	return ack, err
+}
@@ -44,8 +44,19 @@ func (s *spanContext) Transaction() *apm.Transaction {
 	return s.tx
 }
 
-// ForeachBaggageItem is a no-op; we do not support baggage propagation.
-func (*spanContext) ForeachBaggageItem(handler func(k, v string) bool) {}
+// ForeachBaggageItem calls handler for each baggage item, stopping
+// if handler returns false.
+func (s *spanContext) ForeachBaggageItem(handler func(k, v string) bool) {
+	foreachBaggageItem(s.traceContext.Baggage, handler)
+}
+
+func foreachBaggageItem(baggage apm.Baggage, handler func(k, v string) bool) {
+	for _, m := range baggage.Members() {
+		if !handler(m.Key, m.Value) {
+			return
+		}
+	}
+}
 
 func (t *otTracer) parentSpanContext(refs []opentracing.SpanReference) (*spanContext, bool) {
 	for _, ref := range refs {
@@ -124,14 +124,24 @@ func (s *otSpan) Context() opentracing.SpanContext {
 	return &s.ctx
 }
 
-// BaggageItem returns the empty string; we do not support baggage.
-func (*otSpan) BaggageItem(key string) string {
-	return ""
+// BaggageItem returns the value of the baggage item with the given key,
+// or the empty string if there is no such item.
+func (s *otSpan) BaggageItem(key string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, _ := s.ctx.traceContext.Baggage.Member(key)
+	return m.Value
 }
 
-// SetBaggageItem is a no-op; we do not support baggage.
+// SetBaggageItem sets a baggage item, which will be propagated to
+// child spans and injected into carriers as W3C baggage.
 func (s *otSpan) SetBaggageItem(key, val string) opentracing.Span {
-	// We do not support baggage.
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ctx.traceContext.Baggage = s.ctx.traceContext.Baggage.WithMember(apm.BaggageMember{
+		Key:   key,
+		Value: val,
+	})
 	return s
 }
 
@@ -20,7 +20,6 @@ package apmot // import "go.elastic.co/apm/module/apmot/v2"
 import (
 	"io"
 	"net/http"
-	"net/textproto"
 	"time"
 
 	opentracing "github.com/opentracing/opentracing-go"
@@ -34,6 +33,8 @@ import (
 //
 // By default, the returned tracer will use apm.DefaultTracer().
 // This can be overridden by using a WithTracer option.
+// The option WithPropagator allows one to override the formats
+// used for injecting and extracting trace context.
 // The option WithSpanRefValidator allows one to override the
 // set of spans that are recorded. By default only child-of
 // spans are recorded.
@@ -41,6 +42,7 @@ func New(opts ...Option) opentracing.Tracer {
 	t := &otTracer{
 		tracer:         apm.DefaultTracer(),
 		isValidSpanRef: isChildOfSpanRef,
+		propagator:     apmhttp.DefaultPropagator(),
 	}
 	for _, opt := range opts {
 		opt(t)
@@ -52,6 +54,7 @@ func New(opts ...Option) opentracing.Tracer {
 type otTracer struct {
 	tracer         *apm.Tracer
 	isValidSpanRef SpanRefValidator
+	propagator     apmhttp.Propagator
 }
 
 // StartSpan starts a new OpenTracing span with the given name and zero or more options.
@@ -114,14 +117,11 @@ func (t *otTracer) Inject(sc opentracing.SpanContext, format interface{}, carrie
 		if !ok {
 			return opentracing.ErrInvalidCarrier
 		}
-		headerValue := apmhttp.FormatTraceparentHeader(spanContext.traceContext)
-		writer.Set(apmhttp.W3CTraceparentHeader, headerValue)
 		if t.tracer.ShouldPropagateLegacyHeader() {
+			headerValue := apmhttp.FormatTraceparentHeader(spanContext.traceContext)
 			writer.Set(apmhttp.ElasticTraceparentHeader, headerValue)
 		}
-		if tracestate := spanContext.traceContext.State.String(); tracestate != "" {
-			writer.Set(apmhttp.TracestateHeader, tracestate)
-		}
+		t.propagator.Inject(textMapWriterCarrier{writer}, spanContext.traceContext)
 		return nil
 	case opentracing.Binary:
 		writer, ok := carrier.(io.Writer)
@@ -137,42 +137,23 @@ func (t *otTracer) Inject(sc opentracing.SpanContext, format interface{}, carrie
 func (t *otTracer) Extract(format interface{}, carrier interface{}) (opentracing.SpanContext, error) {
 	switch format {
 	case opentracing.TextMap, opentracing.HTTPHeaders:
-		var traceparentHeaderValue string
-		var tracestateHeaderValues []string
+		var header http.Header
 		switch carrier := carrier.(type) {
 		case opentracing.HTTPHeadersCarrier:
-			traceparentHeaderValue = http.Header(carrier).Get(apmhttp.W3CTraceparentHeader)
-			if traceparentHeaderValue == "" {
-				traceparentHeaderValue = http.Header(carrier).Get(apmhttp.ElasticTraceparentHeader)
-			}
-			tracestateHeaderValues = http.Header(carrier)[apmhttp.TracestateHeader]
+			header = http.Header(carrier)
 		case opentracing.TextMapReader:
+			header = make(http.Header)
 			carrier.ForeachKey(func(key, val string) error {
-				switch textproto.CanonicalMIMEHeaderKey(key) {
-				case apmhttp.W3CTraceparentHeader:
-					traceparentHeaderValue = val
-				case apmhttp.ElasticTraceparentHeader:
-					// The W3C header value always trumps the Elastic one,
-					// hence we only set the value if not set already.
-					if traceparentHeaderValue == "" {
-						traceparentHeaderValue = val
-					}
-				case apmhttp.TracestateHeader:
-					tracestateHeaderValues = append(tracestateHeaderValues, val)
-				}
+				header.Add(key, val)
 				return nil
 			})
 		default:
 			return nil, opentracing.ErrInvalidCarrier
 		}
-		if traceparentHeaderValue == "" {
+		traceContext, ok := t.propagator.Extract(apmhttp.HeaderCarrier(header))
+		if !ok {
 			return nil, opentracing.ErrSpanContextNotFound
 		}
-		traceContext, err := apmhttp.ParseTraceparentHeader(traceparentHeaderValue)
-		if err != nil {
-			return nil, err
-		}
-		traceContext.State, _ = apmhttp.ParseTracestateHeader(tracestateHeaderValues...)
 		return &spanContext{tracer: t, traceContext: traceContext}, nil
 	case opentracing.Binary:
 		reader, ok := carrier.(io.Reader)
@@ -189,6 +170,17 @@ func (t *otTracer) Extract(format interface{}, carrier interface{}) (opentracing
 	}
 }
 
+// textMapWriterCarrier is an apmhttp.Carrier which
+// sets values in an opentracing.TextMapWriter.
+type textMapWriterCarrier struct {
+	opentracing.TextMapWriter
+}
+
+// Get returns nil; textMapWriterCarrier is used only for injection.
+func (textMapWriterCarrier) Get(key string) []string {
+	return nil
+}
+
 // Option sets options for the OpenTracing Tracer implementation.
 type Option func(*otTracer)
 
@@ -217,6 +209,21 @@ func WithSpanRefValidator(validator SpanRefValidator) Option {
 	}
 }
 
+// WithPropagator returns an Option which sets p as the propagator
+// to use for injecting and extracting trace context with the
+// TextMap and HTTPHeaders formats.
+//
+// By default, the propagator returned by apmhttp.DefaultPropagator
+// is used.
+func WithPropagator(p apmhttp.Propagator) Option {
+	if p == nil {
+		panic("p == nil")
+	}
+	return func(o *otTracer) {
+		o.propagator = p
+	}
+}
+
 // TODO(axw) handle binary format once Trace-Context defines one.
 // OpenTracing mandates that all implementations "MUST" support all
 // of the builtin formats.
@@ -20,6 +20,7 @@ package apmot_test
 import (
 	"context"
 	"errors"
+	"fmt"
 	"net/http"
 	"net/url"
 	"os"
@@ -33,6 +34,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"go.elastic.co/apm/module/apmhttp/v2"
 	"go.elastic.co/apm/module/apmot/v2"
 	"go.elastic.co/apm/v2"
 	"go.elastic.co/apm/v2/apmtest"
@@ -450,6 +452,64 @@ func testTraceInjectExtract(t *testing.T, shouldPropagateLegacyHeader bool) {
 	}
 }
 
+func TestBaggageInjectExtract(t *testing.T) {
+	tracer, apmtracer, _ := newTestTracer()
+	defer apmtracer.Close()
+
+	span := tracer.StartSpan("span")
+	span.SetBaggageItem("tenant", "acme corp")
+	child := tracer.StartSpan("child", opentracing.ChildOf(span.Context()))
+	assert.Equal(t, "acme corp", child.BaggageItem("tenant"))
+
+	headers := make(http.Header)
+	err := tracer.Inject(child.Context(), opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(headers))
+	require.NoError(t, err)
+	assert.Equal(t, "tenant=acme%20corp", headers.Get("Baggage"))
+
+	spanContext, err := tracer.Extract(opentracing.TextMap, opentracing.TextMapCarrier{
+		"traceparent": headers.Get("Traceparent"),
+		"baggage":     "tenant=acme%20corp,region=eu",
+	})
+	require.NoError(t, err)
+	items := make(map[string]string)
+	spanContext.ForeachBaggageItem(func(k, v string) bool {
+		items[k] = v
+		return true
+	})
+	assert.Equal(t, map[string]string{"tenant": "acme corp", "region": "eu"}, items)
+}
+
+func TestWithPropagator(t *testing.T) {
+	apmtracer := apmtest.NewRecordingTracer()
+	defer apmtracer.Close()
+	tracer := apmot.New(apmot.WithTracer(apmtracer.Tracer), apmot.WithPropagator(apmhttp.JaegerPropagator()))
+
+	span := tracer.StartSpan("span")
+	defer span.Finish()
+	traceContext := span.Context().(interface {
+		TraceContext() apm.TraceContext
+	}).TraceContext()
+
+	carrier := opentracing.TextMapCarrier{}
+	err := tracer.Inject(span.Context(), opentracing.TextMap, carrier)
+	require.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("%s:%s:0:1", traceContext.Trace, traceContext.Span), carrier["Uber-Trace-Id"])
+	assert.NotContains(t, carrier, "Traceparent")
+
+	spanContext, err := tracer.Extract(opentracing.TextMap, opentracing.TextMapCarrier{
+		"uber-trace-id": "8448eb211c80319c:b7ad6b7169203331:0:1",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "00000000000000008448eb211c80319c", spanContext.(interface {
+		TraceContext() apm.TraceContext
+	}).TraceContext().Trace.String())
+
+	_, err = tracer.Extract(opentracing.TextMap, opentracing.TextMapCarrier{
+		"traceparent": "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01",
+	})
+	assert.Equal(t, opentracing.ErrSpanContextNotFound, err)
+}
+
 func BenchmarkSpanSetSpanContext(b *testing.B) {
 	tags := opentracing.Tags{
 		"component":    "myComponent",
@@ -95,8 +95,11 @@ func (ctx apmSpanWrapperContext) Transaction() *apm.Transaction {
 	return ctx.transaction
 }
 
-// ForeachBaggageItem is a no-op; we do not support baggage propagation.
-func (apmSpanWrapperContext) ForeachBaggageItem(handler func(k, v string) bool) {}
+// ForeachBaggageItem calls handler for each of the span's baggage items,
+// stopping if handler returns false.
+func (ctx apmSpanWrapperContext) ForeachBaggageItem(handler func(k, v string) bool) {
+	foreachBaggageItem(ctx.span.TraceContext().Baggage, handler)
+}
 
 // apmSpanWrapper is an opentracing.Span that wraps an apmSpanWrapperContext.
 type apmSpanWrapper struct {
@@ -127,14 +130,16 @@ func (s apmSpanWrapper) Context() opentracing.SpanContext {
 	return s.spanContext
 }
 
-// BaggageItem returns the empty string; we do not support baggage.
-func (apmSpanWrapper) BaggageItem(key string) string {
-	return ""
+// BaggageItem returns the value of the span's baggage item with the
+// given key, or the empty string if there is no such item.
+func (s apmSpanWrapper) BaggageItem(key string) string {
+	m, _ := s.spanContext.span.TraceContext().Baggage.Member(key)
+	return m.Value
 }
 
-// SetBaggageItem is a no-op; we do not support baggage.
+// SetBaggageItem is a no-op; baggage cannot be modified for spans
+// created through the native API.
 func (s apmSpanWrapper) SetBaggageItem(key, val string) opentracing.Span {
-	// We do not support baggage.
 	return s
 }
 
@@ -156,8 +161,11 @@ func (ctx apmTransactionWrapperContext) Transaction() *apm.Transaction {
 	return ctx.transaction
 }
 
-// ForeachBaggageItem is a no-op; we do not support baggage propagation.
-func (apmTransactionWrapperContext) ForeachBaggageItem(handler func(k, v string) bool) {}
+// ForeachBaggageItem calls handler for each of the transaction's baggage
+// items, stopping if handler returns false.
+func (ctx apmTransactionWrapperContext) ForeachBaggageItem(handler func(k, v string) bool) {
+	foreachBaggageItem(ctx.transaction.TraceContext().Baggage, handler)
+}
 
 // apmTransactionWrapper is an opentracing.Span that wraps an apmTransactionWrapperContext.
 type apmTransactionWrapper struct {
@@ -183,14 +191,16 @@ func (s apmTransactionWrapper) Context() opentracing.SpanContext {
 	return s.spanContext
 }
 
-// BaggageItem returns the empty string; we do not support baggage.
-func (apmTransactionWrapper) BaggageItem(key string) string {
-	return ""
+// BaggageItem returns the value of the transaction's baggage item with
+// the given key, or the empty string if there is no such item.
+func (s apmTransactionWrapper) BaggageItem(key string) string {
+	m, _ := s.spanContext.transaction.TraceContext().Baggage.Member(key)
+	return m.Value
 }
 
-// SetBaggageItem is a no-op; we do not support baggage.
+// SetBaggageItem is a no-op; baggage cannot be modified for transactions
+// created through the native API.
 func (s apmTransactionWrapper) SetBaggageItem(key, val string) opentracing.Span {
-	// We do not support baggage.
 	return s
 }
 
@@ -0,0 +1,394 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apmotel // import "go.elastic.co/apm/module/apmotel/v2"
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/embedded"
+
+	"go.elastic.co/apm/v2"
+)
+
+// span wraps apm objects to implement the trace.Span interface.
+//
+// If span is nil, then the OpenTelemetry span is represented by the
+// transaction tx; otherwise tx is the transaction containing span.
+type span struct {
+	embedded.Span
+	tracer *tracer
+
+	traceContext apm.TraceContext
+	spanContext  trace.SpanContext
+	startTime    time.Time
+
+	mu         sync.Mutex
+	tx         *apm.Transaction
+	span       *apm.Span
+	name       string
+	kind       trace.SpanKind
+	attributes []attribute.KeyValue
+	status     codes.Code
+	ended      bool
+}
+
+// End completes the span, ending the underlying Elastic APM transaction
+// or span. Any calls to End after the first have no effect.
+func (s *span) End(options ...trace.SpanEndOption) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ended {
+		return
+	}
+	s.ended = true
+
+	duration := time.Duration(-1)
+	cfg := trace.NewSpanEndConfig(options...)
+	if timestamp := cfg.Timestamp(); !timestamp.IsZero() {
+		duration = timestamp.Sub(s.startTime)
+	}
+	attrs := makeAttributes(s.attributes)
+	if s.span != nil {
+		s.span.Name = s.name
+		if duration >= 0 {
+			s.span.Duration = duration
+		}
+		s.span.Context.SetOTelSpanKind(formatSpanKind(s.kind))
+		if len(attrs.all) != 0 {
+			s.span.Context.SetOTelAttributes(attrs.all)
+		}
+		s.setSpanContext(attrs)
+		s.span.Outcome = statusOutcome(s.status)
+		s.span.End()
+		return
+	}
+	s.tx.Name = s.name
+	if duration >= 0 {
+		s.tx.Duration = duration
+	}
+	s.tx.Context.SetOTelSpanKind(formatSpanKind(s.kind))
+	if len(attrs.all) != 0 {
+		s.tx.Context.SetOTelAttributes(attrs.all)
+	}
+	s.setTransactionContext(attrs)
+	s.tx.Outcome = statusOutcome(s.status)
+	s.tx.End()
+}
+
+// AddEvent is a no-op; span events are not supported, apart from
+// exceptions recorded with RecordError.
+func (s *span) AddEvent(name string, options ...trace.EventOption) {}
+
+// AddLink is a no-op; links may only be added when starting a span.
+func (s *span) AddLink(link trace.Link) {}
+
+// IsRecording reports whether the span is still recording; that is,
+// whether End has not yet been called.
+func (s *span) IsRecording() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return !s.ended
+}
+
+// RecordError reports err to Elastic APM as an error, associated
+// with the span. RecordError does not change the span status.
+func (s *span) RecordError(err error, options ...trace.EventOption) {
+	if err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ended {
+		return
+	}
+	e := s.tracer.provider.tracer.NewError(err)
+	e.Handled = true
+	cfg := trace.NewEventConfig(options...)
+	if timestamp := cfg.Timestamp(); !timestamp.IsZero() {
+		e.Timestamp = timestamp
+	}
+	if s.span != nil {
+		e.SetSpan(s.span)
+	} else {
+		e.SetTransaction(s.tx)
+	}
+	e.Send()
+}
+
+// SpanContext returns the span's OpenTelemetry span context.
+//
+// It is valid to call SpanContext after calling End.
+func (s *span) SpanContext() trace.SpanContext {
+	return s.spanContext
+}
+
+// SetStatus sets the span's status, which is translated to an outcome
+// when the span ends: codes.Ok to "success", and codes.Error to "failure".
+//
+// As defined by the OpenTelemetry specification, the codes.Ok status is
+// final, and setting the status to codes.Unset has no effect.
+func (s *span) SetStatus(code codes.Code, description string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if code == codes.Unset || s.status == codes.Ok {
+		return
+	}
+	s.status = code
+}
+
+// SetName sets or changes the span name.
+func (s *span) SetName(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.name = name
+}
+
+// SetAttributes adds or changes span attributes.
+func (s *span) SetAttributes(kv ...attribute.KeyValue) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attributes = append(s.attributes, kv...)
+}
+
+// TracerProvider returns the provider of the tracer that created this span.
+func (s *span) TracerProvider() trace.TracerProvider {
+	return s.tracer.provider
+}
+
+func (s *span) setSpanContext(attrs attributes) {
+	switch {
+	case attrs.dbSystem != "":
+		s.span.Type = "db"
+		s.span.Subtype = attrs.dbSystem
+		s.span.Context.SetDatabase(apm.DatabaseSpanContext{
+			Instance:  attrs.dbName,
+			Statement: attrs.dbStatement,
+			Type:      attrs.dbSystem,
+			User:      attrs.dbUser,
+		})
+		s.span.Context.SetServiceTarget(apm.ServiceTargetSpanContext{
+			Type: attrs.dbSystem,
+			Name: attrs.dbName,
+		})
+		s.setDestinationService(attrs.dbSystem)
+	case attrs.messagingSystem != "":
+		s.span.Type = "messaging"
+		s.span.Subtype = attrs.messagingSystem
+		s.span.Context.SetMessage(apm.MessageSpanContext{
+			QueueName: attrs.messagingDestination,
+		})
+		s.span.Context.SetServiceTarget(apm.ServiceTargetSpanContext{
+			Type: attrs.messagingSystem,
+			Name: attrs.messagingDestination,
+		})
+		resource := attrs.messagingSystem
+		if attrs.messagingDestination != "" {
+			resource += "/" + attrs.messagingDestination
+		}
+		s.setDestinationService(resource)
+	case attrs.rpcSystem != "":
+		s.span.Type = "external"
+		s.span.Subtype = attrs.rpcSystem
+		name := attrs.rpcService
+		if attrs.peerHost != "" {
+			name = attrs.peerAddr()
+		}
+		s.span.Context.SetServiceTarget(apm.ServiceTargetSpanContext{
+			Type: attrs.rpcSystem,
+			Name: name,
+		})
+		s.setDestinationService(name)
+	case attrs.httpURL != "" || attrs.httpScheme != "":
+		s.span.Type = "external"
+		s.span.Subtype = "http"
+		if u := attrs.url(); u != nil {
+			s.span.Context.SetHTTPRequest(&http.Request{
+				ProtoMajor: 1,
+				ProtoMinor: 1,
+				Method:     attrs.httpMethod,
+				URL:        u,
+			})
+			s.span.Context.SetServiceTarget(apm.ServiceTargetSpanContext{
+				Type: "http",
+				Name: u.Host,
+			})
+		}
+		if attrs.httpStatusCode > 0 {
+			s.span.Context.SetHTTPStatusCode(attrs.httpStatusCode)
+		}
+	case s.kind == trace.SpanKindInternal:
+		s.span.Type = "app"
+		s.span.Subtype = "internal"
+	default:
+		s.span.Type = "unknown"
+	}
+}
+
+// setDestinationService sets the span's destination service resource
+// for client and producer spans, which represent outgoing requests.
+func (s *span) setDestinationService(resource string) {
+	switch s.kind {
+	case trace.SpanKindClient, trace.SpanKindProducer:
+		s.span.Context.SetDestinationService(apm.DestinationServiceSpanContext{
+			Resource: resource,
+		})
+	}
+}
+
+func (s *span) setTransactionContext(attrs attributes) {
+	switch {
+	case attrs.messagingSystem != "" && s.kind == trace.SpanKindConsumer:
+		s.tx.Type = "messaging"
+	case attrs.rpcSystem != "" || attrs.httpURL != "" || attrs.httpScheme != "" || attrs.httpTarget != "":
+		s.tx.Type = "request"
+		if attrs.httpStatusCode > 0 {
+			s.tx.Result = fmt.Sprintf("HTTP %dxx", attrs.httpStatusCode/100)
+			s.tx.Context.SetHTTPStatusCode(attrs.httpStatusCode)
+		}
+	default:
+		s.tx.Type = "unknown"
+	}
+}
+
+// attributes holds span attributes, and the values of those
+// attributes that are used for inferring Elastic APM fields.
+type attributes struct {
+	all map[string]interface{}
+
+	dbSystem             string
+	dbName               string
+	dbStatement          string
+	dbUser               string
+	messagingSystem      string
+	messagingDestination string
+	rpcSystem            string
+	rpcService           string
+	httpURL              string
+	httpScheme           string
+	httpHost             string
+	httpTarget           string
+	httpMethod           string
+	httpStatusCode       int
+	peerHost             string
+	peerPort             int
+}
+
+func makeAttributes(kvs []attribute.KeyValue) attributes {
+	var out attributes
+	if len(kvs) == 0 {
+		return out
+	}
+	out.all = make(map[string]interface{}, len(kvs))
+	for _, kv := range kvs {
+		out.all[string(kv.Key)] = kv.Value.AsInterface()
+		switch kv.Key {
+		case "db.system":
+			out.dbSystem = kv.Value.Emit()
+		case "db.name":
+			out.dbName = kv.Value.Emit()
+		case "db.statement":
+			out.dbStatement = kv.Value.Emit()
+		case "db.user":
+			out.dbUser = kv.Value.Emit()
+		case "messaging.system":
+			out.messagingSystem = kv.Value.Emit()
+		case "messaging.destination", "messaging.destination.name":
+			out.messagingDestination = kv.Value.Emit()
+		case "rpc.system":
+			out.rpcSystem = kv.Value.Emit()
+		case "rpc.service":
+			out.rpcService = kv.Value.Emit()
+		case "http.url", "url.full":
+			out.httpURL = kv.Value.Emit()
+		case "http.scheme", "url.scheme":
+			out.httpScheme = kv.Value.Emit()
+		case "http.host":
+			out.httpHost = kv.Value.Emit()
+		case "http.target", "url.path":
+			out.httpTarget = kv.Value.Emit()
+		case "http.method", "http.request.method":
+			out.httpMethod = kv.Value.Emit()
+		case "http.status_code", "http.response.status_code":
+			out.httpStatusCode, _ = strconv.Atoi(kv.Value.Emit())
+		case "net.peer.name", "server.address":
+			out.peerHost = kv.Value.Emit()
+		case "net.peer.port", "server.port":
+			out.peerPort, _ = strconv.Atoi(kv.Value.Emit())
+		}
+	}
+	return out
+}
+
+// peerAddr returns the "host:port" peer address, or just the
+// host if the port is unknown.
+func (a attributes) peerAddr() string {
+	if a.peerPort > 0 {
+		return net.JoinHostPort(a.peerHost, strconv.Itoa(a.peerPort))
+	}
+	return a.peerHost
+}
+
+// url returns the URL described by the HTTP attributes, or nil
+// if there is insufficient information to construct a URL.
+func (a attributes) url() *url.URL {
+	if a.httpURL != "" {
+		u, err := url.Parse(a.httpURL)
+		if err != nil {
+			return nil
+		}
+		return u
+	}
+	host := a.httpHost
+	if host == "" && a.peerHost != "" {
+		host = a.peerAddr()
+	}
+	if host == "" {
+		return nil
+	}
+	u, err := url.Parse(a.httpTarget)
+	if err != nil {
+		return nil
+	}
+	u.Scheme = a.httpScheme
+	u.Host = host
+	return u
+}
+
+func formatSpanKind(kind trace.SpanKind) string {
+	return strings.ToUpper(kind.String())
+}
+
+func statusOutcome(code codes.Code) string {
+	switch code {
+	case codes.Ok:
+		return "success"
+	case codes.Error:
+		return "failure"
+	}
+	return ""
+}
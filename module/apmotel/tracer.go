@@ -0,0 +1,152 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apmotel // import "go.elastic.co/apm/module/apmotel/v2"
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/embedded"
+
+	"go.elastic.co/apm/v2"
+)
+
+// tracer is a trace.Tracer backed by an apm.Tracer.
+type tracer struct {
+	embedded.Tracer
+
+	provider *tracerProvider
+	name     string
+	version  string
+}
+
+// Start starts a new OpenTelemetry span with the given name and options.
+//
+// If ctx holds an Elastic APM transaction, either created by this bridge
+// or by other Elastic APM instrumentation, the new span will be created
+// as an Elastic APM span within that transaction. Otherwise, a new
+// transaction is started, continuing the trace of any remote span
+// context held in ctx.
+func (t *tracer) Start(ctx context.Context, spanName string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	cfg := trace.NewSpanStartConfig(opts...)
+	s := &span{
+		tracer:     t,
+		name:       spanName,
+		kind:       trace.ValidateSpanKind(cfg.SpanKind()),
+		attributes: cfg.Attributes(),
+		startTime:  cfg.Timestamp(),
+	}
+	if s.startTime.IsZero() {
+		s.startTime = time.Now()
+	}
+
+	var links []apm.SpanLink
+	for _, link := range cfg.Links() {
+		if !link.SpanContext.IsValid() {
+			continue
+		}
+		links = append(links, apm.SpanLink{
+			Trace: apm.TraceID(link.SpanContext.TraceID()),
+			Span:  apm.SpanID(link.SpanContext.SpanID()),
+		})
+	}
+
+	var tx *apm.Transaction
+	var remoteTraceContext apm.TraceContext
+	if cfg.NewRoot() {
+		// Clear any Elastic APM span in the context, so it
+		// does not become the parent of subsequent spans.
+		ctx = apm.ContextWithSpan(ctx, nil)
+	} else if tx = apm.TransactionFromContext(ctx); tx == nil {
+		if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+			remoteTraceContext = traceContextFromSpanContext(sc)
+		}
+	}
+
+	if tx != nil {
+		s.tx = tx
+		s.span, ctx = apm.StartSpanOptions(ctx, spanName, "", apm.SpanOptions{
+			Start: s.startTime,
+			Links: links,
+		})
+		s.traceContext = s.span.TraceContext()
+		if s.traceContext.Span.Validate() != nil {
+			// The span was dropped without being assigned a
+			// trace context; propagate the transaction's.
+			s.traceContext = tx.TraceContext()
+		}
+	} else {
+		s.tx = t.provider.tracer.StartTransactionOptions(spanName, "", apm.TransactionOptions{
+			TraceContext: remoteTraceContext,
+			Start:        s.startTime,
+			Links:        links,
+		})
+		ctx = apm.ContextWithTransaction(ctx, s.tx)
+		s.traceContext = s.tx.TraceContext()
+	}
+	s.spanContext = spanContextFromTraceContext(s.traceContext)
+	return trace.ContextWithSpan(ctx, s), s
+}
+
+// traceContextFromSpanContext converts an OpenTelemetry span context
+// to an Elastic APM trace context.
+func traceContextFromSpanContext(sc trace.SpanContext) apm.TraceContext {
+	var entries []apm.TraceStateEntry
+	if state := sc.TraceState().String(); state != "" {
+		// trace.TraceState has already validated the list members,
+		// so we can split the list without further checks.
+		for _, member := range strings.Split(state, ",") {
+			if i := strings.IndexByte(member, '='); i > 0 {
+				entries = append(entries, apm.TraceStateEntry{
+					Key:   member[:i],
+					Value: member[i+1:],
+				})
+			}
+		}
+	}
+	return apm.TraceContext{
+		Trace:   apm.TraceID(sc.TraceID()),
+		Span:    apm.SpanID(sc.SpanID()),
+		Options: apm.TraceOptions(0).WithRecorded(sc.IsSampled()),
+		State:   apm.NewTraceState(entries...),
+	}
+}
+
+// spanContextFromTraceContext converts an Elastic APM trace context
+// to an OpenTelemetry span context.
+func spanContextFromTraceContext(tc apm.TraceContext) trace.SpanContext {
+	var flags trace.TraceFlags
+	if tc.Options.Recorded() {
+		flags = trace.FlagsSampled
+	}
+	// The tracestate is validated by apm.TraceState, so we
+	// can ignore the error returned by ParseTraceState; in
+	// the worst case we propagate an empty tracestate.
+	state, _ := trace.ParseTraceState(tc.State.String())
+	return trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID(tc.Trace),
+		SpanID:     trace.SpanID(tc.Span),
+		TraceFlags: flags,
+		TraceState: state,
+	})
+}
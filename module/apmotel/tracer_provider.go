@@ -0,0 +1,70 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apmotel // import "go.elastic.co/apm/module/apmotel/v2"
+
+import (
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/embedded"
+
+	"go.elastic.co/apm/v2"
+)
+
+// NewTracerProvider returns a new trace.TracerProvider backed by the
+// supplied Elastic APM tracer.
+//
+// By default, the returned provider will use apm.DefaultTracer().
+// This can be overridden by using a WithTracer option.
+func NewTracerProvider(opts ...Option) trace.TracerProvider {
+	tp := &tracerProvider{tracer: apm.DefaultTracer()}
+	for _, opt := range opts {
+		opt(tp)
+	}
+	return tp
+}
+
+// tracerProvider is a trace.TracerProvider backed by an apm.Tracer.
+type tracerProvider struct {
+	embedded.TracerProvider
+	tracer *apm.Tracer
+}
+
+// Tracer returns a new trace.Tracer with the given instrumentation name.
+//
+// All tracers returned by the provider report to the same apm.Tracer.
+func (tp *tracerProvider) Tracer(name string, opts ...trace.TracerOption) trace.Tracer {
+	cfg := trace.NewTracerConfig(opts...)
+	return &tracer{
+		provider: tp,
+		name:     name,
+		version:  cfg.InstrumentationVersion(),
+	}
+}
+
+// Option sets options for the OpenTelemetry TracerProvider implementation.
+type Option func(*tracerProvider)
+
+// WithTracer returns an Option which sets t as the underlying
+// apm.Tracer for constructing an OpenTelemetry TracerProvider.
+func WithTracer(t *apm.Tracer) Option {
+	if t == nil {
+		panic("t == nil")
+	}
+	return func(tp *tracerProvider) {
+		tp.tracer = t
+	}
+}
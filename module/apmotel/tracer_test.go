@@ -0,0 +1,306 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apmotel_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"go.elastic.co/apm/module/apmotel/v2"
+	"go.elastic.co/apm/v2"
+	"go.elastic.co/apm/v2/model"
+	"go.elastic.co/apm/v2/transport/transporttest"
+)
+
+func TestTransactionAndSpan(t *testing.T) {
+	tracer, apmtracer, recorder := newTestTracer()
+	defer apmtracer.Close()
+
+	ctx, txSpan := tracer.Start(context.Background(), "tx",
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("http.scheme", "http"),
+			attribute.Int("http.status_code", 503),
+		),
+	)
+	_, childSpan := tracer.Start(ctx, "child", trace.WithAttributes(
+		attribute.String("db.system", "mysql"),
+		attribute.String("db.name", "testdb"),
+		attribute.String("db.statement", "SELECT 1"),
+	))
+	childSpan.SetStatus(codes.Error, "boom")
+	childSpan.End()
+	txSpan.End()
+
+	apmtracer.Flush(nil)
+	payloads := recorder.Payloads()
+	require.Len(t, payloads.Transactions, 1)
+	require.Len(t, payloads.Spans, 1)
+
+	tx := payloads.Transactions[0]
+	assert.Equal(t, "tx", tx.Name)
+	assert.Equal(t, "request", tx.Type)
+	assert.Equal(t, "HTTP 5xx", tx.Result)
+	assert.Equal(t, "failure", tx.Outcome)
+	assert.Equal(t, &model.OTel{
+		SpanKind: "SERVER",
+		Attributes: map[string]interface{}{
+			"http.scheme":      "http",
+			"http.status_code": float64(503),
+		},
+	}, tx.OTel)
+
+	span := payloads.Spans[0]
+	assert.Equal(t, "child", span.Name)
+	assert.Equal(t, "db", span.Type)
+	assert.Equal(t, "mysql", span.Subtype)
+	assert.Equal(t, "failure", span.Outcome)
+	assert.Equal(t, tx.ID, span.ParentID)
+	assert.Equal(t, tx.TraceID, span.TraceID)
+	assert.Equal(t, "INTERNAL", span.OTel.SpanKind)
+	require.NotNil(t, span.Context)
+	assert.Equal(t, &model.DatabaseSpanContext{
+		Instance:  "testdb",
+		Statement: "SELECT 1",
+		Type:      "mysql",
+	}, span.Context.Database)
+	assert.Equal(t, &model.ServiceTargetSpanContext{
+		Type: "mysql",
+		Name: "testdb",
+	}, span.Context.Service.Target)
+}
+
+func TestSpanType(t *testing.T) {
+	tracer, apmtracer, recorder := newTestTracer()
+	defer apmtracer.Close()
+
+	type test struct {
+		Kind       trace.SpanKind
+		Attributes []attribute.KeyValue
+		Type       string
+		Subtype    string
+	}
+	tests := []test{
+		{Kind: trace.SpanKindInternal, Type: "app", Subtype: "internal"},
+		{Kind: trace.SpanKindClient, Type: "unknown"},
+		{
+			Kind:       trace.SpanKindClient,
+			Attributes: []attribute.KeyValue{attribute.String("http.url", "http://testing.invalid:8000/foo")},
+			Type:       "external",
+			Subtype:    "http",
+		},
+		{
+			Kind:       trace.SpanKindClient,
+			Attributes: []attribute.KeyValue{attribute.String("rpc.system", "grpc")},
+			Type:       "external",
+			Subtype:    "grpc",
+		},
+		{
+			Kind: trace.SpanKindProducer,
+			Attributes: []attribute.KeyValue{
+				attribute.String("messaging.system", "kafka"),
+				attribute.String("messaging.destination", "topic"),
+			},
+			Type:    "messaging",
+			Subtype: "kafka",
+		},
+	}
+
+	ctx, txSpan := tracer.Start(context.Background(), "tx")
+	for _, test := range tests {
+		_, span := tracer.Start(ctx, "child", trace.WithSpanKind(test.Kind), trace.WithAttributes(test.Attributes...))
+		span.End()
+	}
+	txSpan.End()
+
+	apmtracer.Flush(nil)
+	payloads := recorder.Payloads()
+	require.Len(t, payloads.Spans, len(tests))
+	for i, test := range tests {
+		assert.Equal(t, test.Type, payloads.Spans[i].Type)
+		assert.Equal(t, test.Subtype, payloads.Spans[i].Subtype)
+	}
+	assert.Equal(t, "unknown", payloads.Transactions[0].Type)
+
+	producer := payloads.Spans[4]
+	assert.Equal(t, &model.MessageSpanContext{
+		Queue: &model.MessageQueueSpanContext{Name: "topic"},
+	}, producer.Context.Message)
+	assert.Equal(t, "kafka/topic", producer.Context.Destination.Service.Resource)
+}
+
+func TestRemoteParent(t *testing.T) {
+	tracer, apmtracer, recorder := newTestTracer()
+	defer apmtracer.Close()
+
+	traceID := trace.TraceID{0x0a, 0xf7, 0x65, 0x19, 0x16, 0xcd, 0x43, 0xdd, 0x84, 0x48, 0xeb, 0x21, 0x1c, 0x80, 0x31, 0x9c}
+	spanID := trace.SpanID{0xb7, 0xad, 0x6b, 0x71, 0x69, 0x20, 0x33, 0x31}
+	state, err := trace.ParseTraceState("es=s:0.5,vendor=value")
+	require.NoError(t, err)
+	remote := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     spanID,
+		TraceFlags: trace.FlagsSampled,
+		TraceState: state,
+		Remote:     true,
+	})
+	ctx := trace.ContextWithRemoteSpanContext(context.Background(), remote)
+
+	_, span := tracer.Start(ctx, "tx")
+	sc := span.SpanContext()
+	assert.Equal(t, traceID, sc.TraceID())
+	assert.NotEqual(t, spanID, sc.SpanID())
+	assert.True(t, sc.IsSampled())
+	assert.Equal(t, "es=s:0.5,vendor=value", sc.TraceState().String())
+	span.End()
+
+	apmtracer.Flush(nil)
+	payloads := recorder.Payloads()
+	require.Len(t, payloads.Transactions, 1)
+	assert.Equal(t, model.TraceID(traceID), payloads.Transactions[0].TraceID)
+	assert.Equal(t, model.SpanID(spanID), payloads.Transactions[0].ParentID)
+	assert.Equal(t, model.SpanID(sc.SpanID()), payloads.Transactions[0].ID)
+}
+
+func TestNewRoot(t *testing.T) {
+	tracer, apmtracer, recorder := newTestTracer()
+	defer apmtracer.Close()
+
+	ctx, span1 := tracer.Start(context.Background(), "tx1")
+	_, span2 := tracer.Start(ctx, "tx2", trace.WithNewRoot())
+	assert.NotEqual(t, span1.SpanContext().TraceID(), span2.SpanContext().TraceID())
+	span2.End()
+	span1.End()
+
+	apmtracer.Flush(nil)
+	payloads := recorder.Payloads()
+	require.Len(t, payloads.Transactions, 2)
+	assert.Empty(t, payloads.Spans)
+}
+
+func TestLinks(t *testing.T) {
+	tracer, apmtracer, recorder := newTestTracer()
+	defer apmtracer.Close()
+
+	link := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID: trace.TraceID{1},
+		SpanID:  trace.SpanID{2},
+	})
+	ctx, txSpan := tracer.Start(context.Background(), "tx", trace.WithLinks(trace.Link{SpanContext: link}))
+	_, span := tracer.Start(ctx, "span", trace.WithLinks(trace.Link{SpanContext: link}))
+	span.End()
+	txSpan.End()
+
+	apmtracer.Flush(nil)
+	payloads := recorder.Payloads()
+	expected := []model.SpanLink{{TraceID: model.TraceID{1}, SpanID: model.SpanID{2}}}
+	require.Len(t, payloads.Transactions, 1)
+	require.Len(t, payloads.Spans, 1)
+	assert.Equal(t, expected, payloads.Transactions[0].Links)
+	assert.Equal(t, expected, payloads.Spans[0].Links)
+}
+
+func TestExplicitTimestamps(t *testing.T) {
+	tracer, apmtracer, recorder := newTestTracer()
+	defer apmtracer.Close()
+
+	start := time.Unix(123, 0)
+	_, span := tracer.Start(context.Background(), "tx", trace.WithTimestamp(start))
+	span.End(trace.WithTimestamp(start.Add(3 * time.Second)))
+
+	apmtracer.Flush(nil)
+	payloads := recorder.Payloads()
+	require.Len(t, payloads.Transactions, 1)
+	assert.Equal(t, model.Time(start.UTC()), payloads.Transactions[0].Timestamp)
+	assert.Equal(t, float64(3000), payloads.Transactions[0].Duration)
+}
+
+func TestRecordError(t *testing.T) {
+	tracer, apmtracer, recorder := newTestTracer()
+	defer apmtracer.Close()
+
+	ctx, txSpan := tracer.Start(context.Background(), "tx")
+	_, span := tracer.Start(ctx, "span")
+	span.RecordError(errors.New("boom"))
+	span.End()
+	span.RecordError(errors.New("ignored after end"))
+	txSpan.End()
+
+	apmtracer.Flush(nil)
+	payloads := recorder.Payloads()
+	require.Len(t, payloads.Spans, 1)
+	require.Len(t, payloads.Errors, 1)
+	assert.Equal(t, "boom", payloads.Errors[0].Exception.Message)
+	assert.Equal(t, payloads.Spans[0].ID, payloads.Errors[0].ParentID)
+	assert.Equal(t, payloads.Transactions[0].ID, payloads.Errors[0].TransactionID)
+}
+
+func TestInteropNativeAPI(t *testing.T) {
+	tracer, apmtracer, recorder := newTestTracer()
+	defer apmtracer.Close()
+
+	tx := apmtracer.StartTransaction("native", "request")
+	ctx := apm.ContextWithTransaction(context.Background(), tx)
+	ctx, otelSpan := tracer.Start(ctx, "otel")
+	nativeSpan, _ := apm.StartSpan(ctx, "native-child", "custom")
+	nativeSpan.End()
+	otelSpan.End()
+	tx.End()
+
+	apmtracer.Flush(nil)
+	payloads := recorder.Payloads()
+	require.Len(t, payloads.Transactions, 1)
+	require.Len(t, payloads.Spans, 2)
+	assert.Equal(t, "native-child", payloads.Spans[0].Name)
+	assert.Equal(t, "otel", payloads.Spans[1].Name)
+	assert.Equal(t, payloads.Spans[1].ID, payloads.Spans[0].ParentID)
+	assert.Equal(t, payloads.Transactions[0].ID, payloads.Spans[1].ParentID)
+}
+
+func TestSetStatus(t *testing.T) {
+	tracer, apmtracer, recorder := newTestTracer()
+	defer apmtracer.Close()
+
+	_, span := tracer.Start(context.Background(), "tx")
+	span.SetStatus(codes.Ok, "")
+	span.SetStatus(codes.Error, "ignored, Ok is final")
+	span.SetName("renamed")
+	assert.True(t, span.IsRecording())
+	span.End()
+	assert.False(t, span.IsRecording())
+
+	apmtracer.Flush(nil)
+	payloads := recorder.Payloads()
+	require.Len(t, payloads.Transactions, 1)
+	assert.Equal(t, "renamed", payloads.Transactions[0].Name)
+	assert.Equal(t, "success", payloads.Transactions[0].Outcome)
+}
+
+func newTestTracer() (trace.Tracer, *apm.Tracer, *transporttest.RecorderTransport) {
+	apmtracer, recorder := transporttest.NewRecorderTracer()
+	tracer := apmotel.NewTracerProvider(apmotel.WithTracer(apmtracer)).Tracer("apmotel_test")
+	return tracer, apmtracer, recorder
+}
@@ -0,0 +1,290 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apmpgxv5 // import "go.elastic.co/apm/module/apmpgxv5/v2"
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+
+	"go.elastic.co/apm/module/apmsql/v2"
+	"go.elastic.co/apm/v2"
+	"go.elastic.co/apm/v2/sqlutil"
+	"go.elastic.co/apm/v2/stacktrace"
+)
+
+func init() {
+	stacktrace.RegisterLibraryPackage("github.com/jackc/pgx/v5")
+}
+
+const (
+	driverName = "postgresql"
+
+	querySpanType   = "db." + driverName + ".query"
+	prepareSpanType = "db." + driverName + ".prepare"
+	connectSpanType = "db." + driverName + ".connect"
+)
+
+var (
+	_ pgx.QueryTracer    = (*Tracer)(nil)
+	_ pgx.BatchTracer    = (*Tracer)(nil)
+	_ pgx.CopyFromTracer = (*Tracer)(nil)
+	_ pgx.PrepareTracer  = (*Tracer)(nil)
+	_ pgx.ConnectTracer  = (*Tracer)(nil)
+)
+
+// Tracer is a pgx tracer which reports queries, batches, COPY FROM
+// operations, statement preparation and connections as spans, if they
+// occur within the context of a transaction.
+//
+// Tracer is installed by setting the Tracer field of pgx.ConnConfig,
+// for example when using pgxpool:
+//
+//	cfg, err := pgxpool.ParseConfig(dsn)
+//	...
+//	cfg.ConnConfig.Tracer = apmpgxv5.NewTracer()
+//	pool, err := pgxpool.NewWithConfig(ctx, cfg)
+type Tracer struct{}
+
+// NewTracer returns a new Tracer.
+func NewTracer() *Tracer {
+	return &Tracer{}
+}
+
+// We store spans in context using a separate context key, to avoid
+// ending spans not created by the tracer.
+type spanKey struct{}
+
+// batchKey is the context key for a *batchSpan.
+type batchKey struct{}
+
+// batchSpan holds the state of a batch span between
+// calls to TraceBatchStart, TraceBatchQuery, and TraceBatchEnd.
+type batchSpan struct {
+	span         *apm.Span
+	rowsAffected int64
+	err          error
+}
+
+// TraceQueryStart starts a span for the query.
+func (t *Tracer) TraceQueryStart(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	span, ctx := startSpan(ctx, connConfig(conn), apmsql.QuerySignature(data.SQL), querySpanType, data.SQL)
+	if span == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+// TraceQueryEnd ends the span started by TraceQueryStart.
+func (t *Tracer) TraceQueryEnd(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryEndData) {
+	if span, ok := ctx.Value(spanKey{}).(*apm.Span); ok {
+		setRowsAffected(span, data.CommandTag)
+		endSpan(ctx, span, data.Err)
+	}
+}
+
+// TraceBatchStart starts a span for the batch. The span's statement
+// holds the batch's queries, separated by semicolons.
+func (t *Tracer) TraceBatchStart(ctx context.Context, conn *pgx.Conn, data pgx.TraceBatchStartData) context.Context {
+	var stmt string
+	if data.Batch != nil {
+		queries := make([]string, len(data.Batch.QueuedQueries))
+		for i, q := range data.Batch.QueuedQueries {
+			queries[i] = q.SQL
+		}
+		stmt = strings.Join(queries, ";\n")
+	}
+	span, ctx := startSpan(ctx, connConfig(conn), "BATCH", querySpanType, stmt)
+	if span == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, batchKey{}, &batchSpan{span: span})
+}
+
+// TraceBatchQuery records the result of a query in the batch,
+// reporting its error, if any.
+func (t *Tracer) TraceBatchQuery(ctx context.Context, conn *pgx.Conn, data pgx.TraceBatchQueryData) {
+	b, ok := ctx.Value(batchKey{}).(*batchSpan)
+	if !ok {
+		return
+	}
+	if data.Err != nil {
+		if b.err == nil {
+			b.err = data.Err
+		}
+		captureError(ctx, data.Err)
+		return
+	}
+	if !data.CommandTag.Select() {
+		b.rowsAffected += data.CommandTag.RowsAffected()
+	}
+}
+
+// TraceBatchEnd ends the span started by TraceBatchStart.
+func (t *Tracer) TraceBatchEnd(ctx context.Context, conn *pgx.Conn, data pgx.TraceBatchEndData) {
+	b, ok := ctx.Value(batchKey{}).(*batchSpan)
+	if !ok {
+		return
+	}
+	if b.err == nil {
+		b.span.Context.SetDatabaseRowsAffected(b.rowsAffected)
+	}
+	err := data.Err
+	if b.err != nil && errors.Is(err, b.err) {
+		// Already reported by TraceBatchQuery.
+		err = nil
+	}
+	endSpan(ctx, b.span, err)
+}
+
+// TraceCopyFromStart starts a span for the COPY FROM operation.
+func (t *Tracer) TraceCopyFromStart(ctx context.Context, conn *pgx.Conn, data pgx.TraceCopyFromStartData) context.Context {
+	table := strings.Join(data.TableName, ".")
+	stmt := "COPY " + data.TableName.Sanitize()
+	if len(data.ColumnNames) > 0 {
+		columns := make([]string, len(data.ColumnNames))
+		for i, name := range data.ColumnNames {
+			columns[i] = pgx.Identifier{name}.Sanitize()
+		}
+		stmt += " (" + strings.Join(columns, ", ") + ")"
+	}
+	stmt += " FROM STDIN"
+	span, ctx := startSpan(ctx, connConfig(conn), "COPY "+table, querySpanType, stmt)
+	if span == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+// TraceCopyFromEnd ends the span started by TraceCopyFromStart.
+func (t *Tracer) TraceCopyFromEnd(ctx context.Context, conn *pgx.Conn, data pgx.TraceCopyFromEndData) {
+	if span, ok := ctx.Value(spanKey{}).(*apm.Span); ok {
+		setRowsAffected(span, data.CommandTag)
+		endSpan(ctx, span, data.Err)
+	}
+}
+
+// TracePrepareStart starts a span for preparing the statement.
+func (t *Tracer) TracePrepareStart(ctx context.Context, conn *pgx.Conn, data pgx.TracePrepareStartData) context.Context {
+	span, ctx := startSpan(ctx, connConfig(conn), apmsql.QuerySignature(data.SQL), prepareSpanType, data.SQL)
+	if span == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+// TracePrepareEnd ends the span started by TracePrepareStart.
+func (t *Tracer) TracePrepareEnd(ctx context.Context, conn *pgx.Conn, data pgx.TracePrepareEndData) {
+	if span, ok := ctx.Value(spanKey{}).(*apm.Span); ok {
+		endSpan(ctx, span, data.Err)
+	}
+}
+
+// TraceConnectStart starts a span for establishing a connection.
+func (t *Tracer) TraceConnectStart(ctx context.Context, data pgx.TraceConnectStartData) context.Context {
+	span, ctx := startSpan(ctx, data.ConnConfig, "connect", connectSpanType, "")
+	if span == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+// TraceConnectEnd ends the span started by TraceConnectStart.
+func (t *Tracer) TraceConnectEnd(ctx context.Context, data pgx.TraceConnectEndData) {
+	if span, ok := ctx.Value(spanKey{}).(*apm.Span); ok {
+		endSpan(ctx, span, data.Err)
+	}
+}
+
+// startSpan starts a span if ctx contains a transaction, returning the
+// span and a context containing it. If ctx does not contain a transaction,
+// or the span is dropped, startSpan returns a nil span.
+func startSpan(ctx context.Context, cfg *pgx.ConnConfig, name, spanType, stmt string) (*apm.Span, context.Context) {
+	if apm.TransactionFromContext(ctx) == nil {
+		return nil, ctx
+	}
+	span, spanCtx := apm.StartSpan(ctx, name, spanType)
+	if span.Dropped() {
+		span.End()
+		return nil, ctx
+	}
+	var database, user string
+	if cfg != nil {
+		database, user = cfg.Database, cfg.User
+		if cfg.Host != "" {
+			span.Context.SetDestinationAddress(cfg.Host, int(cfg.Port))
+			span.Context.SetDestinationService(apm.DestinationServiceSpanContext{
+				Name:     driverName,
+				Resource: driverName,
+			})
+			span.Context.SetServiceTarget(apm.ServiceTargetSpanContext{
+				Type: driverName,
+				Name: database,
+			})
+		}
+	}
+	if tx := apm.TransactionFromContext(ctx); tx != nil && tx.ShouldObfuscateSQL() {
+		stmt = sqlutil.Obfuscate(stmt)
+	}
+	span.Context.SetDatabase(apm.DatabaseSpanContext{
+		Instance:  database,
+		Statement: stmt,
+		Type:      "sql",
+		User:      user,
+	})
+	return span, spanCtx
+}
+
+// endSpan reports err, if it is non-nil and not a context cancellation,
+// and ends span.
+func endSpan(ctx context.Context, span *apm.Span, err error) {
+	if err != nil {
+		captureError(ctx, err)
+	}
+	span.End()
+}
+
+func captureError(ctx context.Context, err error) {
+	if errors.Is(err, context.Canceled) {
+		// context.Canceled means the callers canceled
+		// the operation, so this is expected.
+		return
+	}
+	if e := apm.CaptureError(ctx, err); e != nil {
+		e.Send()
+	}
+}
+
+// setRowsAffected records the number of rows affected by a statement,
+// as reported in its command tag. Rows returned by SELECT statements
+// are not recorded.
+func setRowsAffected(span *apm.Span, tag pgconn.CommandTag) {
+	if tag.String() != "" && !tag.Select() {
+		span.Context.SetDatabaseRowsAffected(tag.RowsAffected())
+	}
+}
+
+func connConfig(conn *pgx.Conn) *pgx.ConnConfig {
+	if conn == nil {
+		return nil
+	}
+	return conn.Config()
+}
@@ -0,0 +1,254 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apmpgxv5_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.elastic.co/apm/module/apmpgxv5/v2"
+	"go.elastic.co/apm/v2/apmtest"
+	"go.elastic.co/apm/v2/model"
+)
+
+func TestQuery(t *testing.T) {
+	tracer := apmpgxv5.NewTracer()
+	_, spans, errs := apmtest.WithTransaction(func(ctx context.Context) {
+		ctx = tracer.TraceQueryStart(ctx, nil, pgx.TraceQueryStartData{SQL: "UPDATE foo SET bar = $1"})
+		tracer.TraceQueryEnd(ctx, nil, pgx.TraceQueryEndData{CommandTag: pgconn.NewCommandTag("UPDATE 3")})
+
+		ctx = tracer.TraceQueryStart(ctx, nil, pgx.TraceQueryStartData{SQL: "SELECT * FROM foo"})
+		tracer.TraceQueryEnd(ctx, nil, pgx.TraceQueryEndData{CommandTag: pgconn.NewCommandTag("SELECT 10")})
+	})
+	require.Len(t, spans, 2)
+	assert.Empty(t, errs)
+
+	assert.Equal(t, "UPDATE foo", spans[0].Name)
+	assert.Equal(t, "db", spans[0].Type)
+	assert.Equal(t, "postgresql", spans[0].Subtype)
+	assert.Equal(t, "query", spans[0].Action)
+	rowsAffected := int64(3)
+	assert.Equal(t, &model.DatabaseSpanContext{
+		Statement:    "UPDATE foo SET bar = $1",
+		Type:         "sql",
+		RowsAffected: &rowsAffected,
+	}, spans[0].Context.Database)
+
+	assert.Equal(t, "SELECT FROM foo", spans[1].Name)
+	assert.Nil(t, spans[1].Context.Database.RowsAffected)
+}
+
+func TestQueryError(t *testing.T) {
+	tracer := apmpgxv5.NewTracer()
+	_, spans, errs := apmtest.WithTransaction(func(ctx context.Context) {
+		ctx = tracer.TraceQueryStart(ctx, nil, pgx.TraceQueryStartData{SQL: "SELECT * FROM missing"})
+		tracer.TraceQueryEnd(ctx, nil, pgx.TraceQueryEndData{Err: errors.New("relation does not exist")})
+
+		ctx = tracer.TraceQueryStart(ctx, nil, pgx.TraceQueryStartData{SQL: "SELECT * FROM foo"})
+		tracer.TraceQueryEnd(ctx, nil, pgx.TraceQueryEndData{Err: context.Canceled})
+	})
+	require.Len(t, spans, 2)
+	require.Len(t, errs, 1)
+	assert.Equal(t, "relation does not exist", errs[0].Exception.Message)
+	assert.Equal(t, spans[0].ID, errs[0].ParentID)
+	assert.Equal(t, "failure", spans[0].Outcome)
+	assert.Equal(t, "success", spans[1].Outcome)
+}
+
+func TestNoTransaction(t *testing.T) {
+	tracer := apmpgxv5.NewTracer()
+	ctx := context.Background()
+	assert.Equal(t, ctx, tracer.TraceQueryStart(ctx, nil, pgx.TraceQueryStartData{SQL: "SELECT 1"}))
+	tracer.TraceQueryEnd(ctx, nil, pgx.TraceQueryEndData{})
+}
+
+func TestBatch(t *testing.T) {
+	tracer := apmpgxv5.NewTracer()
+	batch := &pgx.Batch{}
+	batch.Queue("INSERT INTO foo VALUES (1)")
+	batch.Queue("DELETE FROM foo WHERE bar = 2")
+	batch.Queue("SELECT * FROM foo")
+
+	_, spans, errs := apmtest.WithTransaction(func(ctx context.Context) {
+		ctx = tracer.TraceBatchStart(ctx, nil, pgx.TraceBatchStartData{Batch: batch})
+		tracer.TraceBatchQuery(ctx, nil, pgx.TraceBatchQueryData{CommandTag: pgconn.NewCommandTag("INSERT 0 1")})
+		tracer.TraceBatchQuery(ctx, nil, pgx.TraceBatchQueryData{CommandTag: pgconn.NewCommandTag("DELETE 2")})
+		tracer.TraceBatchQuery(ctx, nil, pgx.TraceBatchQueryData{CommandTag: pgconn.NewCommandTag("SELECT 5")})
+		tracer.TraceBatchEnd(ctx, nil, pgx.TraceBatchEndData{})
+	})
+	require.Len(t, spans, 1)
+	assert.Empty(t, errs)
+
+	assert.Equal(t, "BATCH", spans[0].Name)
+	assert.Equal(t, "query", spans[0].Action)
+	rowsAffected := int64(3)
+	assert.Equal(t, &model.DatabaseSpanContext{
+		Statement:    "INSERT INTO foo VALUES (1);\nDELETE FROM foo WHERE bar = 2;\nSELECT * FROM foo",
+		Type:         "sql",
+		RowsAffected: &rowsAffected,
+	}, spans[0].Context.Database)
+}
+
+func TestBatchError(t *testing.T) {
+	tracer := apmpgxv5.NewTracer()
+	batch := &pgx.Batch{}
+	batch.Queue("INSERT INTO foo VALUES (1)")
+	batch.Queue("INSERT INTO missing VALUES (1)")
+
+	queryErr := errors.New("relation does not exist")
+	_, spans, errs := apmtest.WithTransaction(func(ctx context.Context) {
+		ctx = tracer.TraceBatchStart(ctx, nil, pgx.TraceBatchStartData{Batch: batch})
+		tracer.TraceBatchQuery(ctx, nil, pgx.TraceBatchQueryData{CommandTag: pgconn.NewCommandTag("INSERT 0 1")})
+		tracer.TraceBatchQuery(ctx, nil, pgx.TraceBatchQueryData{Err: queryErr})
+		tracer.TraceBatchEnd(ctx, nil, pgx.TraceBatchEndData{Err: queryErr})
+	})
+	require.Len(t, spans, 1)
+	require.Len(t, errs, 1)
+	assert.Equal(t, spans[0].ID, errs[0].ParentID)
+	assert.Equal(t, "failure", spans[0].Outcome)
+	assert.Nil(t, spans[0].Context.Database.RowsAffected)
+}
+
+func TestCopyFrom(t *testing.T) {
+	tracer := apmpgxv5.NewTracer()
+	_, spans, _ := apmtest.WithTransaction(func(ctx context.Context) {
+		ctx = tracer.TraceCopyFromStart(ctx, nil, pgx.TraceCopyFromStartData{
+			TableName:   pgx.Identifier{"public", "foo"},
+			ColumnNames: []string{"bar", "baz"},
+		})
+		tracer.TraceCopyFromEnd(ctx, nil, pgx.TraceCopyFromEndData{CommandTag: pgconn.NewCommandTag("COPY 42")})
+	})
+	require.Len(t, spans, 1)
+
+	assert.Equal(t, "COPY public.foo", spans[0].Name)
+	assert.Equal(t, "query", spans[0].Action)
+	rowsAffected := int64(42)
+	assert.Equal(t, &model.DatabaseSpanContext{
+		Statement:    `COPY "public"."foo" ("bar", "baz") FROM STDIN`,
+		Type:         "sql",
+		RowsAffected: &rowsAffected,
+	}, spans[0].Context.Database)
+}
+
+func TestPrepare(t *testing.T) {
+	tracer := apmpgxv5.NewTracer()
+	_, spans, _ := apmtest.WithTransaction(func(ctx context.Context) {
+		ctx = tracer.TracePrepareStart(ctx, nil, pgx.TracePrepareStartData{Name: "stmt", SQL: "SELECT * FROM foo WHERE bar = $1"})
+		tracer.TracePrepareEnd(ctx, nil, pgx.TracePrepareEndData{})
+	})
+	require.Len(t, spans, 1)
+	assert.Equal(t, "SELECT FROM foo", spans[0].Name)
+	assert.Equal(t, "prepare", spans[0].Action)
+	assert.Equal(t, "SELECT * FROM foo WHERE bar = $1", spans[0].Context.Database.Statement)
+}
+
+func TestConnect(t *testing.T) {
+	cfg, err := pgx.ParseConfig("postgres://postgres@db.example:5433/test_db")
+	require.NoError(t, err)
+
+	tracer := apmpgxv5.NewTracer()
+	_, spans, _ := apmtest.WithTransaction(func(ctx context.Context) {
+		ctx = tracer.TraceConnectStart(ctx, pgx.TraceConnectStartData{ConnConfig: cfg})
+		tracer.TraceConnectEnd(ctx, pgx.TraceConnectEndData{})
+	})
+	require.Len(t, spans, 1)
+
+	assert.Equal(t, "connect", spans[0].Name)
+	assert.Equal(t, "db", spans[0].Type)
+	assert.Equal(t, "postgresql", spans[0].Subtype)
+	assert.Equal(t, "connect", spans[0].Action)
+	assert.Equal(t, &model.SpanContext{
+		Destination: &model.DestinationSpanContext{
+			Address: "db.example",
+			Port:    5433,
+			Service: &model.DestinationServiceSpanContext{
+				Type:     "db",
+				Name:     "postgresql",
+				Resource: "postgresql",
+			},
+		},
+		Service: &model.ServiceSpanContext{
+			Target: &model.ServiceTargetSpanContext{
+				Type: "postgresql",
+				Name: "test_db",
+			},
+		},
+		Database: &model.DatabaseSpanContext{
+			Instance: "test_db",
+			Type:     "sql",
+			User:     "postgres",
+		},
+	}, spans[0].Context)
+}
+
+func TestPool(t *testing.T) {
+	if os.Getenv("PGHOST") == "" {
+		t.Skipf("PGHOST not specified")
+	}
+
+	cfg, err := pgxpool.ParseConfig("user=postgres password=hunter2 dbname=test_db sslmode=disable")
+	require.NoError(t, err)
+	cfg.ConnConfig.Tracer = apmpgxv5.NewTracer()
+
+	pool, err := pgxpool.NewWithConfig(context.Background(), cfg)
+	require.NoError(t, err)
+	defer pool.Close()
+
+	_, err = pool.Exec(context.Background(), "CREATE TABLE IF NOT EXISTS foo (bar INT)")
+	require.NoError(t, err)
+
+	_, spans, _ := apmtest.WithTransaction(func(ctx context.Context) {
+		_, err := pool.Exec(ctx, "INSERT INTO foo VALUES (1)")
+		require.NoError(t, err)
+
+		rows, err := pool.Query(ctx, "SELECT * FROM foo")
+		require.NoError(t, err)
+		rows.Close()
+
+		batch := &pgx.Batch{}
+		batch.Queue("INSERT INTO foo VALUES (2)")
+		batch.Queue("INSERT INTO foo VALUES (3)")
+		require.NoError(t, pool.SendBatch(ctx, batch).Close())
+
+		n, err := pool.CopyFrom(ctx, pgx.Identifier{"foo"}, []string{"bar"}, pgx.CopyFromRows([][]any{{4}, {5}}))
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), n)
+	})
+	require.Len(t, spans, 4)
+
+	assert.Equal(t, "INSERT INTO foo", spans[0].Name)
+	assert.Equal(t, "SELECT FROM foo", spans[1].Name)
+	assert.Equal(t, "BATCH", spans[2].Name)
+	assert.Equal(t, "COPY foo", spans[3].Name)
+	for _, span := range spans {
+		assert.Equal(t, "postgresql", span.Subtype)
+		assert.Equal(t, "test_db", span.Context.Database.Instance)
+		assert.Equal(t, os.Getenv("PGHOST"), span.Context.Destination.Address)
+	}
+	assert.Equal(t, int64(1), *spans[0].Context.Database.RowsAffected)
+	assert.Equal(t, int64(2), *spans[2].Context.Database.RowsAffected)
+	assert.Equal(t, int64(2), *spans[3].Context.Database.RowsAffected)
+}
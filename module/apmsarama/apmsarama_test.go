@@ -0,0 +1,256 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apmsarama_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.elastic.co/apm/module/apmhttp/v2"
+	"go.elastic.co/apm/module/apmsarama/v2"
+	"go.elastic.co/apm/v2"
+	"go.elastic.co/apm/v2/apmtest"
+	"go.elastic.co/apm/v2/model"
+)
+
+func TestSendMessageContext(t *testing.T) {
+	var sent *sarama.ProducerMessage
+	mockProducer := mocks.NewSyncProducer(t, nil)
+	mockProducer.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(func(msg *sarama.ProducerMessage) error {
+		sent = msg
+		return nil
+	})
+	producer := apmsarama.WrapSyncProducer(mockProducer)
+	defer producer.Close()
+
+	tracer := apmtest.NewRecordingTracer()
+	defer tracer.Close()
+	tx, spans, errs := tracer.WithTransaction(func(ctx context.Context) {
+		_, _, err := producer.SendMessageContext(ctx, &sarama.ProducerMessage{
+			Topic: "topic",
+			Value: sarama.StringEncoder("value"),
+		})
+		require.NoError(t, err)
+	})
+	require.Len(t, spans, 1)
+	assert.Empty(t, errs)
+
+	span := spans[0]
+	assert.Equal(t, tx.ID, span.ParentID)
+	assert.Equal(t, "Kafka SEND to topic", span.Name)
+	assert.Equal(t, "messaging", span.Type)
+	assert.Equal(t, "kafka", span.Subtype)
+	assert.Equal(t, "send", span.Action)
+	assert.Equal(t, "success", span.Outcome)
+	assert.Equal(t, &model.SpanContext{
+		Message: &model.MessageSpanContext{
+			Queue: &model.MessageQueueSpanContext{Name: "topic"},
+		},
+		Destination: &model.DestinationSpanContext{
+			Service: &model.DestinationServiceSpanContext{
+				Type:     "messaging",
+				Name:     "kafka",
+				Resource: "kafka/topic",
+			},
+		},
+		Service: &model.ServiceSpanContext{
+			Target: &model.ServiceTargetSpanContext{
+				Type: "kafka",
+				Name: "topic",
+			},
+		},
+	}, span.Context)
+
+	require.NotNil(t, sent)
+	headers := make(map[string]string)
+	for _, h := range sent.Headers {
+		headers[string(h.Key)] = string(h.Value)
+	}
+	assert.Equal(t, map[string]string{
+		"traceparent":           formatTraceparent(tx.TraceID, span.ID),
+		"elasticapmtraceparent": formatTraceparent(tx.TraceID, span.ID),
+		"tracestate":            "es=s:1",
+	}, headers)
+}
+
+func TestSendMessageContextError(t *testing.T) {
+	mockProducer := mocks.NewSyncProducer(t, nil)
+	mockProducer.ExpectSendMessageAndFail(errors.New("boom"))
+	producer := apmsarama.WrapSyncProducer(mockProducer)
+	defer producer.Close()
+
+	tracer := apmtest.NewRecordingTracer()
+	defer tracer.Close()
+	_, spans, errs := tracer.WithTransaction(func(ctx context.Context) {
+		_, _, err := producer.SendMessageContext(ctx, &sarama.ProducerMessage{Topic: "topic"})
+		assert.EqualError(t, err, "boom")
+	})
+	require.Len(t, spans, 1)
+	require.Len(t, errs, 1)
+	assert.Equal(t, "failure", spans[0].Outcome)
+	assert.Equal(t, spans[0].ID, errs[0].ParentID)
+	assert.Equal(t, "boom", errs[0].Exception.Message)
+}
+
+func TestSendMessageContextNoTransaction(t *testing.T) {
+	var sent *sarama.ProducerMessage
+	mockProducer := mocks.NewSyncProducer(t, nil)
+	mockProducer.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(func(msg *sarama.ProducerMessage) error {
+		sent = msg
+		return nil
+	})
+	producer := apmsarama.WrapSyncProducer(mockProducer)
+	defer producer.Close()
+
+	_, _, err := producer.SendMessageContext(context.Background(), &sarama.ProducerMessage{Topic: "topic"})
+	require.NoError(t, err)
+	require.NotNil(t, sent)
+	assert.Empty(t, sent.Headers)
+}
+
+func TestSendMessagesContext(t *testing.T) {
+	mockProducer := mocks.NewSyncProducer(t, nil)
+	mockProducer.ExpectSendMessageAndSucceed()
+	mockProducer.ExpectSendMessageAndSucceed()
+	producer := apmsarama.WrapSyncProducer(mockProducer)
+	defer producer.Close()
+
+	msgs := []*sarama.ProducerMessage{{Topic: "topic1"}, {Topic: "topic2"}}
+	tracer := apmtest.NewRecordingTracer()
+	defer tracer.Close()
+	tx, spans, _ := tracer.WithTransaction(func(ctx context.Context) {
+		require.NoError(t, producer.SendMessagesContext(ctx, msgs))
+	})
+	require.Len(t, spans, 1)
+	assert.Equal(t, "Kafka SEND", spans[0].Name)
+	assert.Nil(t, spans[0].Context.Message)
+	assert.Equal(t, "kafka", spans[0].Context.Destination.Service.Resource)
+
+	// Each message is given the trace context of the batch span.
+	for _, msg := range msgs {
+		require.Len(t, msg.Headers, 3)
+		assert.Equal(t, "traceparent", string(msg.Headers[0].Key))
+		assert.Equal(t, formatTraceparent(tx.TraceID, spans[0].ID), string(msg.Headers[0].Value))
+	}
+}
+
+func TestStartTransaction(t *testing.T) {
+	tracer := apmtest.NewRecordingTracer()
+	defer tracer.Close()
+
+	traceContext := apm.TraceContext{
+		Trace:   apm.TraceID{0: 1, 15: 1},
+		Span:    apm.SpanID{0: 2, 7: 2},
+		Options: apm.TraceOptions(0).WithRecorded(true),
+	}
+	msg := &sarama.ConsumerMessage{
+		Topic:     "topic",
+		Timestamp: time.Now().Add(-time.Second),
+		Headers: []*sarama.RecordHeader{{
+			Key:   []byte("traceparent"),
+			Value: []byte(formatTraceparent(model.TraceID(traceContext.Trace), model.SpanID(traceContext.Span))),
+		}},
+	}
+	tx, ctx := apmsarama.StartTransaction(context.Background(), tracer.Tracer, msg)
+	assert.Equal(t, tx, apm.TransactionFromContext(ctx))
+	tx.End()
+	tracer.Flush(nil)
+
+	payloads := tracer.Payloads()
+	require.Len(t, payloads.Transactions, 1)
+	transaction := payloads.Transactions[0]
+	assert.Equal(t, "Kafka RECEIVE from topic", transaction.Name)
+	assert.Equal(t, "messaging", transaction.Type)
+	assert.Equal(t, model.TraceID(traceContext.Trace), transaction.TraceID)
+	assert.Equal(t, model.SpanID(traceContext.Span), transaction.ParentID)
+	require.NotNil(t, transaction.Context)
+	require.NotNil(t, transaction.Context.Message)
+	assert.Equal(t, "topic", transaction.Context.Message.Queue.Name)
+	require.NotNil(t, transaction.Context.Message.Age)
+	assert.InDelta(t, 1000, transaction.Context.Message.Age.Millis, 500)
+}
+
+func TestStartTransactionNoTraceContext(t *testing.T) {
+	tracer := apmtest.NewRecordingTracer()
+	defer tracer.Close()
+
+	tx, _ := apmsarama.StartTransaction(context.Background(), tracer.Tracer, &sarama.ConsumerMessage{Topic: "topic"})
+	tx.End()
+	tracer.Flush(nil)
+
+	payloads := tracer.Payloads()
+	require.Len(t, payloads.Transactions, 1)
+	assert.Zero(t, payloads.Transactions[0].ParentID)
+	assert.Nil(t, payloads.Transactions[0].Context.Message.Age)
+}
+
+func TestStartBatchTransaction(t *testing.T) {
+	tracer := apmtest.NewRecordingTracer()
+	defer tracer.Close()
+
+	// Produce messages, and then consume them in a batch.
+	mockProducer := mocks.NewSyncProducer(t, nil)
+	mockProducer.ExpectSendMessageAndSucceed()
+	mockProducer.ExpectSendMessageAndSucceed()
+	producer := apmsarama.WrapSyncProducer(mockProducer)
+	defer producer.Close()
+
+	var consumed []*sarama.ConsumerMessage
+	for i := 0; i < 2; i++ {
+		msg := &sarama.ProducerMessage{Topic: "topic"}
+		tracer.WithTransaction(func(ctx context.Context) {
+			_, _, err := producer.SendMessageContext(ctx, msg)
+			require.NoError(t, err)
+		})
+		tracer.ResetPayloads()
+		consumerMessage := &sarama.ConsumerMessage{Topic: msg.Topic}
+		for i := range msg.Headers {
+			consumerMessage.Headers = append(consumerMessage.Headers, &msg.Headers[i])
+		}
+		consumed = append(consumed, consumerMessage)
+	}
+	consumed = append(consumed, &sarama.ConsumerMessage{Topic: "topic"})
+
+	tx, _ := apmsarama.StartBatchTransaction(context.Background(), tracer.Tracer, consumed)
+	tx.End()
+	tracer.Flush(nil)
+
+	payloads := tracer.Payloads()
+	require.Len(t, payloads.Transactions, 1)
+	transaction := payloads.Transactions[0]
+	assert.Equal(t, "Kafka RECEIVE from topic", transaction.Name)
+	assert.Equal(t, "messaging", transaction.Type)
+	assert.Zero(t, transaction.ParentID)
+	assert.Len(t, transaction.Links, 2)
+	assert.Equal(t, "topic", transaction.Context.Message.Queue.Name)
+}
+
+func formatTraceparent(traceID model.TraceID, spanID model.SpanID) string {
+	return apmhttp.FormatTraceparentHeader(apm.TraceContext{
+		Trace:   apm.TraceID(traceID),
+		Span:    apm.SpanID(spanID),
+		Options: apm.TraceOptions(0).WithRecorded(true),
+	})
+}
@@ -0,0 +1,92 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apmsarama // import "go.elastic.co/apm/module/apmsarama/v2"
+
+import (
+	"github.com/IBM/sarama"
+
+	"go.elastic.co/apm/module/apmhttp/v2"
+	"go.elastic.co/apm/v2"
+)
+
+const (
+	// traceparentHeader is the record header for W3C trace propagation.
+	traceparentHeader = "traceparent"
+
+	// elasticTraceparentHeader is the legacy record header for trace
+	// propagation. Kafka header names should not contain hyphens, so
+	// this differs from the legacy HTTP header name.
+	elasticTraceparentHeader = "elasticapmtraceparent"
+
+	// tracestateHeader is the record header for W3C tracestate.
+	tracestateHeader = "tracestate"
+)
+
+// setHeaders sets the trace context headers in headers,
+// replacing any existing values, and returns the result.
+func setHeaders(headers []sarama.RecordHeader, traceContext apm.TraceContext, propagateLegacyHeader bool) []sarama.RecordHeader {
+	traceparent := []byte(apmhttp.FormatTraceparentHeader(traceContext))
+	headers = setHeader(headers, traceparentHeader, traceparent)
+	if propagateLegacyHeader {
+		headers = setHeader(headers, elasticTraceparentHeader, traceparent)
+	}
+	if tracestate := traceContext.State.String(); tracestate != "" {
+		headers = setHeader(headers, tracestateHeader, []byte(tracestate))
+	}
+	return headers
+}
+
+func setHeader(headers []sarama.RecordHeader, key string, value []byte) []sarama.RecordHeader {
+	for i := range headers {
+		if string(headers[i].Key) == key {
+			headers[i].Value = value
+			return headers
+		}
+	}
+	return append(headers, sarama.RecordHeader{Key: []byte(key), Value: value})
+}
+
+// traceContextFromHeaders returns the trace context propagated in the
+// given message headers, and a boolean indicating whether it was found.
+func traceContextFromHeaders(headers []*sarama.RecordHeader) (apm.TraceContext, bool) {
+	var traceparent, elasticTraceparent, tracestate []byte
+	for _, h := range headers {
+		if h == nil {
+			continue
+		}
+		switch string(h.Key) {
+		case traceparentHeader:
+			traceparent = h.Value
+		case elasticTraceparentHeader:
+			elasticTraceparent = h.Value
+		case tracestateHeader:
+			tracestate = h.Value
+		}
+	}
+	if traceparent == nil {
+		traceparent = elasticTraceparent
+	}
+	traceContext, err := apmhttp.ParseTraceparentHeader(string(traceparent))
+	if err != nil {
+		return apm.TraceContext{}, false
+	}
+	if tracestate != nil {
+		traceContext.State, _ = apmhttp.ParseTracestateHeader(string(tracestate))
+	}
+	return traceContext, true
+}
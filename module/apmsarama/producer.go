@@ -0,0 +1,128 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apmsarama // import "go.elastic.co/apm/module/apmsarama/v2"
+
+import (
+	"context"
+
+	"github.com/IBM/sarama"
+
+	"go.elastic.co/apm/v2"
+)
+
+// WrapSyncProducer returns a SyncProducer wrapping p, providing
+// context-aware methods for sending messages.
+func WrapSyncProducer(p sarama.SyncProducer) *SyncProducer {
+	return &SyncProducer{SyncProducer: p}
+}
+
+// SyncProducer wraps a sarama.SyncProducer, adding methods for sending
+// messages within the transaction or span contained in a context.
+//
+// Messages sent with the sarama.SyncProducer methods, such as SendMessage,
+// are sent without tracing.
+type SyncProducer struct {
+	sarama.SyncProducer
+}
+
+// SendMessageContext sends msg using p.SendMessage, reporting a span if ctx
+// contains a transaction, and adding trace context headers to msg.
+func (p *SyncProducer) SendMessageContext(ctx context.Context, msg *sarama.ProducerMessage) (partition int32, offset int64, err error) {
+	span := startProducerSpan(ctx, []*sarama.ProducerMessage{msg})
+	partition, offset, err = p.SendMessage(msg)
+	endProducerSpan(ctx, span, err)
+	return partition, offset, err
+}
+
+// SendMessagesContext sends msgs using p.SendMessages, reporting a single
+// span for the batch if ctx contains a transaction, and adding trace context
+// headers to each message.
+func (p *SyncProducer) SendMessagesContext(ctx context.Context, msgs []*sarama.ProducerMessage) error {
+	span := startProducerSpan(ctx, msgs)
+	err := p.SendMessages(msgs)
+	endProducerSpan(ctx, span, err)
+	return err
+}
+
+// startProducerSpan starts a span for sending msgs, if ctx contains a
+// transaction, and sets trace context headers in each message. If the
+// transaction is not sampled or the span is dropped, the transaction's
+// trace context is propagated and startProducerSpan returns nil.
+func startProducerSpan(ctx context.Context, msgs []*sarama.ProducerMessage) *apm.Span {
+	tx := apm.TransactionFromContext(ctx)
+	if tx == nil || len(msgs) == 0 {
+		return nil
+	}
+
+	var span *apm.Span
+	traceContext := tx.TraceContext()
+	if traceContext.Options.Recorded() {
+		topic := msgs[0].Topic
+		for _, msg := range msgs[1:] {
+			if msg.Topic != topic {
+				topic = ""
+				break
+			}
+		}
+		name := "Kafka SEND"
+		if topic != "" {
+			name += " to " + topic
+		}
+		span, _ = apm.StartSpanOptions(ctx, name, "messaging", apm.SpanOptions{ExitSpan: true})
+		if !span.Dropped() {
+			traceContext = span.TraceContext()
+			span.Subtype = "kafka"
+			span.Action = "send"
+			resource := "kafka"
+			if topic != "" {
+				resource += "/" + topic
+				span.Context.SetMessage(apm.MessageSpanContext{QueueName: topic})
+			}
+			span.Context.SetDestinationService(apm.DestinationServiceSpanContext{
+				Name:     "kafka",
+				Resource: resource,
+			})
+			span.Context.SetServiceTarget(apm.ServiceTargetSpanContext{
+				Type: "kafka",
+				Name: topic,
+			})
+		} else {
+			span.End()
+			span = nil
+		}
+	}
+
+	propagateLegacyHeader := tx.ShouldPropagateLegacyHeader()
+	for _, msg := range msgs {
+		msg.Headers = setHeaders(msg.Headers, traceContext, propagateLegacyHeader)
+	}
+	return span
+}
+
+func endProducerSpan(ctx context.Context, span *apm.Span, err error) {
+	if span == nil {
+		return
+	}
+	defer span.End()
+	if err != nil {
+		span.Outcome = "failure"
+		apm.CaptureError(apm.ContextWithSpan(ctx, span), err).Send()
+		return
+	}
+	span.Outcome = "success"
+}
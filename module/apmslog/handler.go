@@ -0,0 +1,220 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apmslog // import "go.elastic.co/apm/module/apmslog/v2"
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+
+	"go.elastic.co/apm/v2"
+	"go.elastic.co/apm/v2/stacktrace"
+)
+
+const (
+	// FieldKeyTraceID is the attribute key for the trace ID.
+	FieldKeyTraceID = "trace.id"
+
+	// FieldKeyTransactionID is the attribute key for the transaction ID.
+	FieldKeyTransactionID = "transaction.id"
+
+	// FieldKeySpanID is the attribute key for the span ID.
+	FieldKeySpanID = "span.id"
+)
+
+func init() {
+	stacktrace.RegisterLibraryPackage("log/slog")
+}
+
+// Handler is an implementation of slog.Handler, adding the trace context
+// of the transaction and span in the context passed to the slog.Logger
+// methods (e.g. InfoContext) to log records, and optionally reporting
+// log records as errors to the APM Server.
+//
+// Trace context attributes are added to the log record at the top level,
+// unless WithGroup has been called on the wrapped handler, in which case
+// they will be added to the innermost group.
+type Handler struct {
+	// Handler is the slog.Handler to which log records are passed,
+	// with trace context attributes added. If Handler is nil, then
+	// log records are only reported as errors.
+	Handler slog.Handler
+
+	// Tracer is the apm.Tracer to use for reporting errors.
+	// If Tracer is nil, then apm.DefaultTracer() will be used.
+	Tracer *apm.Tracer
+
+	// ReportErrors controls whether log records with a level of
+	// at least MinLevel are reported as errors to the APM Server.
+	//
+	// If the log record or handler has an "error" attribute whose
+	// value is an error, it will be reported as the error's exception.
+	ReportErrors bool
+
+	// MinLevel holds the minimum level of log records to report
+	// as errors.
+	//
+	// MinLevel must be greater than or equal to slog.LevelError.
+	// If it is less than this, slog.LevelError will be used as the
+	// minimum instead.
+	MinLevel slog.Level
+
+	// attrs holds the top-level attributes added with WithAttrs,
+	// for extracting the error to report.
+	attrs []slog.Attr
+
+	// grouped records whether WithGroup has been called, in which
+	// case subsequent attributes are not at the top level.
+	grouped bool
+}
+
+func (h *Handler) tracer() *apm.Tracer {
+	tracer := h.Tracer
+	if tracer == nil {
+		tracer = apm.DefaultTracer()
+	}
+	return tracer
+}
+
+func (h *Handler) minLevel() slog.Level {
+	minLevel := h.MinLevel
+	if minLevel < slog.LevelError {
+		minLevel = slog.LevelError
+	}
+	return minLevel
+}
+
+// reportError reports whether a log record with the given level
+// should be reported as an error.
+func (h *Handler) reportError(level slog.Level) bool {
+	return h.ReportErrors && level >= h.minLevel() && h.tracer().Recording()
+}
+
+// Enabled reports whether either the wrapped handler is enabled for
+// level, or log records with the given level are reported as errors.
+func (h *Handler) Enabled(ctx context.Context, level slog.Level) bool {
+	if h.Handler != nil && h.Handler.Enabled(ctx, level) {
+		return true
+	}
+	return h.reportError(level)
+}
+
+// Handle adds trace context attributes to r and passes it to the
+// wrapped handler, and reports r as an error if it has a level of
+// at least MinLevel and ReportErrors is true.
+func (h *Handler) Handle(ctx context.Context, r slog.Record) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if h.reportError(r.Level) {
+		h.sendError(ctx, r)
+	}
+	if h.Handler == nil || !h.Handler.Enabled(ctx, r.Level) {
+		return nil
+	}
+	if attrs := TraceContext(ctx); len(attrs) != 0 {
+		r = r.Clone()
+		r.AddAttrs(attrs...)
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *Handler) sendError(ctx context.Context, r slog.Record) {
+	var err error
+	if !h.grouped {
+		r.Attrs(func(attr slog.Attr) bool {
+			err = attrError(attr)
+			return err == nil
+		})
+	}
+	for i := len(h.attrs) - 1; err == nil && i >= 0; i-- {
+		err = attrError(h.attrs[i])
+	}
+
+	errlog := h.tracer().NewErrorLog(apm.ErrorLogRecord{
+		Message: r.Message,
+		Level:   strings.ToLower(r.Level.String()),
+		Error:   err,
+	})
+	errlog.Handled = true
+	if !r.Time.IsZero() {
+		errlog.Timestamp = r.Time
+	}
+	errlog.SetStacktrace(2)
+	if span := apm.SpanFromContext(ctx); span != nil {
+		errlog.SetSpan(span)
+	} else if tx := apm.TransactionFromContext(ctx); tx != nil {
+		errlog.SetTransaction(tx)
+	}
+	errlog.Send()
+}
+
+// WithAttrs returns a new Handler whose wrapped handler has the
+// given attributes added.
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	out := *h
+	if h.Handler != nil {
+		out.Handler = h.Handler.WithAttrs(attrs)
+	}
+	if !h.grouped {
+		out.attrs = append(h.attrs[:len(h.attrs):len(h.attrs)], attrs...)
+	}
+	return &out
+}
+
+// WithGroup returns a new Handler whose wrapped handler has the
+// given group added.
+func (h *Handler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	out := *h
+	if h.Handler != nil {
+		out.Handler = h.Handler.WithGroup(name)
+	}
+	out.grouped = true
+	return &out
+}
+
+// TraceContext returns slog.Attrs containing the trace context
+// of the transaction and span contained in ctx, if any.
+func TraceContext(ctx context.Context) []slog.Attr {
+	tx := apm.TransactionFromContext(ctx)
+	if tx == nil {
+		return nil
+	}
+	traceContext := tx.TraceContext()
+	attrs := []slog.Attr{
+		slog.String(FieldKeyTraceID, traceContext.Trace.String()),
+		slog.String(FieldKeyTransactionID, traceContext.Span.String()),
+	}
+	if span := apm.SpanFromContext(ctx); span != nil {
+		attrs = append(attrs, slog.String(FieldKeySpanID, span.TraceContext().Span.String()))
+	}
+	return attrs
+}
+
+// attrError returns the error value of attr if its key is "error",
+// and nil otherwise.
+func attrError(attr slog.Attr) error {
+	if attr.Key != "error" {
+		return nil
+	}
+	err, _ := attr.Value.Any().(error)
+	return err
+}
@@ -0,0 +1,198 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apmslog_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.elastic.co/apm/module/apmslog/v2"
+	"go.elastic.co/apm/v2"
+	"go.elastic.co/apm/v2/transport/transporttest"
+)
+
+func TestHandlerTraceContext(t *testing.T) {
+	tracer, transport := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+
+	var buf bytes.Buffer
+	logger := slog.New(&apmslog.Handler{
+		Handler: slog.NewJSONHandler(&buf, nil),
+		Tracer:  tracer,
+	})
+
+	tx := tracer.StartTransaction("name", "type")
+	ctx := apm.ContextWithTransaction(context.Background(), tx)
+	span, ctx := apm.StartSpan(ctx, "name", "type")
+	logger.InfoContext(ctx, "¡hola, mundo!")
+	span.End()
+	tx.End()
+
+	tracer.Flush(nil)
+	payloads := transport.Payloads()
+	require.Len(t, payloads.Transactions, 1)
+	require.Len(t, payloads.Spans, 1)
+	assert.Empty(t, payloads.Errors)
+
+	var record map[string]interface{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &record))
+	assert.Equal(t, "¡hola, mundo!", record["msg"])
+	assert.Equal(t, apm.TraceID(payloads.Transactions[0].TraceID).String(), record["trace.id"])
+	assert.Equal(t, apm.SpanID(payloads.Transactions[0].ID).String(), record["transaction.id"])
+	assert.Equal(t, apm.SpanID(payloads.Spans[0].ID).String(), record["span.id"])
+}
+
+func TestHandlerNoTraceContext(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(&apmslog.Handler{Handler: slog.NewJSONHandler(&buf, nil)})
+	logger.Info("¡hola, mundo!")
+
+	var record map[string]interface{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &record))
+	assert.NotContains(t, record, "trace.id")
+	assert.NotContains(t, record, "transaction.id")
+	assert.NotContains(t, record, "span.id")
+}
+
+func TestHandlerReportErrors(t *testing.T) {
+	tracer, transport := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+
+	logger := slog.New(&apmslog.Handler{Tracer: tracer, ReportErrors: true})
+	tx := tracer.StartTransaction("name", "type")
+	ctx := apm.ContextWithTransaction(context.Background(), tx)
+	logger.InfoContext(ctx, "not reported")
+	logger.ErrorContext(ctx, "¡hola, mundo!")
+	tx.End()
+
+	tracer.Flush(nil)
+	payloads := transport.Payloads()
+	require.Len(t, payloads.Transactions, 1)
+	require.Len(t, payloads.Errors, 1)
+
+	err0 := payloads.Errors[0]
+	assert.Equal(t, "¡hola, mundo!", err0.Log.Message)
+	assert.Equal(t, "error", err0.Log.Level)
+	assert.Equal(t, "TestHandlerReportErrors", err0.Culprit)
+	require.NotEmpty(t, err0.Log.Stacktrace)
+	assert.Equal(t, "(*Handler).Handle", err0.Log.Stacktrace[0].Function)
+	assert.Zero(t, err0.Exception)
+	assert.Equal(t, payloads.Transactions[0].TraceID, err0.TraceID)
+	assert.Equal(t, payloads.Transactions[0].ID, err0.TransactionID)
+	assert.Equal(t, payloads.Transactions[0].ID, err0.ParentID)
+}
+
+func TestHandlerReportErrorsWithError(t *testing.T) {
+	tracer, transport := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+
+	var buf bytes.Buffer
+	logger := slog.New(&apmslog.Handler{
+		Handler:      slog.NewJSONHandler(&buf, nil),
+		Tracer:       tracer,
+		ReportErrors: true,
+	})
+	logger.Error("nope nope nope", "error", errors.New("kablamo"))
+	logger.With("error", errors.New("boom")).Error("with attrs")
+
+	tracer.Flush(nil)
+	payloads := transport.Payloads()
+	require.Len(t, payloads.Errors, 2)
+	assert.Equal(t, "nope nope nope", payloads.Errors[0].Log.Message)
+	assert.Equal(t, "kablamo", payloads.Errors[0].Exception.Message)
+	assert.Equal(t, "with attrs", payloads.Errors[1].Log.Message)
+	assert.Equal(t, "boom", payloads.Errors[1].Exception.Message)
+
+	// Log records are still passed to the wrapped handler.
+	assert.Equal(t, 2, bytes.Count(buf.Bytes(), []byte("\n")))
+}
+
+func TestHandlerMinLevel(t *testing.T) {
+	tracer, transport := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+
+	logger := slog.New(&apmslog.Handler{
+		Tracer:       tracer,
+		ReportErrors: true,
+		MinLevel:     slog.LevelError + 4,
+	})
+	logger.Error("not reported")
+	logger.Log(context.Background(), slog.LevelError+4, "reported")
+
+	tracer.Flush(nil)
+	payloads := transport.Payloads()
+	require.Len(t, payloads.Errors, 1)
+	assert.Equal(t, "reported", payloads.Errors[0].Log.Message)
+	assert.Equal(t, "error+4", payloads.Errors[0].Log.Level)
+}
+
+func TestHandlerEnabled(t *testing.T) {
+	tracer, _ := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+
+	warn := slog.NewTextHandler(&bytes.Buffer{}, &slog.HandlerOptions{Level: slog.LevelWarn})
+	h := &apmslog.Handler{Handler: warn, Tracer: tracer}
+	ctx := context.Background()
+	assert.False(t, h.Enabled(ctx, slog.LevelInfo))
+	assert.True(t, h.Enabled(ctx, slog.LevelWarn))
+
+	h = &apmslog.Handler{Tracer: tracer, ReportErrors: true}
+	assert.False(t, h.Enabled(ctx, slog.LevelWarn))
+	assert.True(t, h.Enabled(ctx, slog.LevelError))
+
+	tracer.SetRecording(false)
+	assert.False(t, h.Enabled(ctx, slog.LevelError))
+}
+
+func TestHandlerWithGroup(t *testing.T) {
+	tracer, transport := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+
+	var buf bytes.Buffer
+	logger := slog.New(&apmslog.Handler{
+		Handler:      slog.NewJSONHandler(&buf, nil),
+		Tracer:       tracer,
+		ReportErrors: true,
+	})
+	tx := tracer.StartTransaction("name", "type")
+	ctx := apm.ContextWithTransaction(context.Background(), tx)
+	logger.WithGroup("request").ErrorContext(ctx, "grouped", "error", errors.New("kablamo"))
+	tx.End()
+
+	tracer.Flush(nil)
+	payloads := transport.Payloads()
+	require.Len(t, payloads.Errors, 1)
+	// Attributes within groups are not considered for the
+	// reported error's exception.
+	assert.Zero(t, payloads.Errors[0].Exception)
+
+	var record map[string]interface{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &record))
+	assert.Equal(t, map[string]interface{}{
+		"error":          "kablamo",
+		"trace.id":       apm.TraceID(payloads.Transactions[0].TraceID).String(),
+		"transaction.id": apm.SpanID(payloads.Transactions[0].ID).String(),
+	}, record["request"])
+}
@@ -29,6 +29,7 @@ import (
 
 	"go.elastic.co/apm/module/apmsql/v2"
 	_ "go.elastic.co/apm/module/apmsql/v2/sqlite3"
+	"go.elastic.co/apm/v2"
 	"go.elastic.co/apm/v2/apmtest"
 	"go.elastic.co/apm/v2/model"
 )
@@ -165,6 +166,55 @@ func TestQueryContext(t *testing.T) {
 	}, spans[0].Context)
 }
 
+func TestQueryContextObfuscateSQL(t *testing.T) {
+	db, err := apmsql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, err = db.Exec("CREATE TABLE foo (bar INT, baz TEXT)")
+	require.NoError(t, err)
+
+	tracer := apmtest.NewRecordingTracer()
+	defer tracer.Close()
+	tracer.SetObfuscateSQL(true)
+
+	_, spans, _ := tracer.WithTransaction(func(ctx context.Context) {
+		rows, err := db.QueryContext(ctx, "SELECT * FROM foo WHERE baz = 'secret' AND bar IN (1, 2, 3)")
+		require.NoError(t, err)
+		rows.Close()
+	})
+	require.Len(t, spans, 1)
+	assert.Equal(t, "SELECT FROM foo", spans[0].Name)
+	assert.Equal(t, "SELECT * FROM foo WHERE baz = ? AND bar IN (?)", spans[0].Context.Database.Statement)
+}
+
+func TestQueryContextObfuscateSQLEndedTransaction(t *testing.T) {
+	db, err := apmsql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, err = db.Exec("CREATE TABLE foo (bar INT, baz TEXT)")
+	require.NoError(t, err)
+
+	tracer := apmtest.NewRecordingTracer()
+	defer tracer.Close()
+	tracer.SetObfuscateSQL(true)
+
+	// Spans started after the transaction has ended are still
+	// recorded, and their statements must still be obfuscated.
+	tx := tracer.StartTransaction("name", "type")
+	tx.End()
+	ctx := apm.ContextWithTransaction(context.Background(), tx)
+	rows, err := db.QueryContext(ctx, "SELECT * FROM foo WHERE baz = 'secret'")
+	require.NoError(t, err)
+	rows.Close()
+	tracer.Flush(nil)
+
+	spans := tracer.Payloads().Spans
+	require.Len(t, spans, 1)
+	assert.Equal(t, "SELECT * FROM foo WHERE baz = ?", spans[0].Context.Database.Statement)
+}
+
 func TestPrepareContext(t *testing.T) {
 	db, err := apmsql.Open("sqlite3", ":memory:")
 	require.NoError(t, err)
@@ -81,6 +81,9 @@ func (c *conn) startSpan(ctx context.Context, name, spanType, stmt string) (*apm
 				Name: c.dsnInfo.Database,
 			})
 		}
+		if stmt != "" {
+			stmt = c.driver.obfuscateStatement(ctx, stmt)
+		}
 		span.Context.SetDatabase(apm.DatabaseSpanContext{
 			Instance:  c.dsnInfo.Database,
 			Statement: stmt,
@@ -125,10 +125,23 @@ func WithDSNParser(f DSNParserFunc) WrapOption {
 	}
 }
 
+// WithStatementObfuscation returns a WrapOption which sets whether
+// or not SQL statements recorded for spans are obfuscated with
+// sqlutil.Obfuscate, replacing literal values with "?". If
+// WithStatementObfuscation is not supplied to Wrap, statements
+// will be obfuscated according to the tracer's configuration;
+// see ELASTIC_APM_OBFUSCATE_SQL.
+func WithStatementObfuscation(obfuscate bool) WrapOption {
+	return func(d *tracingDriver) {
+		d.obfuscate = &obfuscate
+	}
+}
+
 type tracingDriver struct {
 	driver.Driver
 	driverName string
 	dsnParser  DSNParserFunc
+	obfuscate  *bool
 
 	connectSpanType string
 	execSpanType    string
@@ -137,6 +150,21 @@ type tracingDriver struct {
 	querySpanType   string
 }
 
+// obfuscateStatement returns stmt, obfuscated if statement obfuscation
+// is enabled for the driver, or otherwise for the transaction in ctx.
+func (d *tracingDriver) obfuscateStatement(ctx context.Context, stmt string) string {
+	obfuscate := d.obfuscate != nil && *d.obfuscate
+	if d.obfuscate == nil {
+		if tx := apm.TransactionFromContext(ctx); tx != nil {
+			obfuscate = tx.ShouldObfuscateSQL()
+		}
+	}
+	if obfuscate {
+		return sqlutil.Obfuscate(stmt)
+	}
+	return stmt
+}
+
 func (d *tracingDriver) formatSpanType(suffix string) string {
 	return fmt.Sprintf("db.%s.%s", d.driverName, suffix)
 }
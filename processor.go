@@ -0,0 +1,76 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apm // import "go.elastic.co/apm/v2"
+
+import (
+	"go.elastic.co/apm/v2/model"
+)
+
+// Processor provides a means of filtering, enriching, or otherwise
+// modifying events after they have ended, and before they are encoded
+// and sent to the APM Server.
+//
+// Processors are configured with Tracer.SetProcessors, and are invoked
+// in order for each transaction, span, and error event. Events are
+// processed after HTTP request and response data has been sanitized
+// according to ELASTIC_APM_SANITIZE_FIELD_NAMES, and after fields have
+// been redacted according to ELASTIC_APM_REDACT_FIELDS. Metrics are not
+// processed.
+type Processor interface {
+	// ProcessEvent processes an event, returning false if the event
+	// should be dropped. If an event is dropped, subsequent processors
+	// will not be invoked for it.
+	//
+	// This method will be invoked by the tracer's background goroutine,
+	// so it must not block. The event and its contents must not be
+	// retained after the method returns; processors which send events
+	// elsewhere must copy or encode them before returning.
+	ProcessEvent(*Event) bool
+}
+
+// ProcessorFunc is a function type implementing Processor.
+type ProcessorFunc func(*Event) bool
+
+// ProcessEvent returns f(e).
+func (f ProcessorFunc) ProcessEvent(e *Event) bool {
+	return f(e)
+}
+
+// Event holds a transaction, span, or error event to be processed by
+// a Processor. Exactly one of the fields will be non-nil.
+type Event struct {
+	// Transaction holds a transaction event.
+	Transaction *model.Transaction
+
+	// Span holds a span event.
+	Span *model.Span
+
+	// Error holds an error event.
+	Error *model.Error
+}
+
+// processEvent invokes each of the processors in order for e,
+// returning false as soon as any of them drops the event.
+func processEvent(processors []Processor, e *Event) bool {
+	for _, p := range processors {
+		if !p.ProcessEvent(e) {
+			return false
+		}
+	}
+	return true
+}
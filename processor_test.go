@@ -0,0 +1,119 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apm_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.elastic.co/apm/v2"
+	"go.elastic.co/apm/v2/model"
+	"go.elastic.co/apm/v2/transport/transporttest"
+)
+
+func TestTracerProcessors(t *testing.T) {
+	tracer, recorder := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+
+	var calls []string
+	tracer.SetProcessors(
+		apm.ProcessorFunc(func(e *apm.Event) bool {
+			switch {
+			case e.Transaction != nil:
+				calls = append(calls, "transaction:"+e.Transaction.Name)
+				if e.Transaction.Context == nil {
+					e.Transaction.Context = &model.Context{}
+				}
+				e.Transaction.Context.Tags = append(e.Transaction.Context.Tags, model.IfaceMapItem{
+					Key: "region", Value: "eu",
+				})
+			case e.Span != nil:
+				calls = append(calls, "span:"+e.Span.Name)
+				return e.Span.Type != "cache"
+			case e.Error != nil:
+				calls = append(calls, "error")
+				return false
+			}
+			return true
+		}),
+		apm.ProcessorFunc(func(e *apm.Event) bool {
+			if e.Span != nil {
+				e.Span.Name += "!"
+			}
+			return true
+		}),
+	)
+
+	tx := tracer.StartTransaction("name", "type")
+	tx.StartSpan("db", "db", nil).End()
+	tx.StartSpan("cache", "cache", nil).End()
+	tracer.NewError(errors.New("boom")).Send()
+	tx.End()
+	tracer.Flush(nil)
+
+	payloads := recorder.Payloads()
+	require.Len(t, payloads.Transactions, 1)
+	require.Len(t, payloads.Spans, 1)
+	assert.Empty(t, payloads.Errors)
+	assert.Equal(t, model.IfaceMap{{Key: "region", Value: "eu"}}, payloads.Transactions[0].Context.Tags)
+	assert.Equal(t, "db!", payloads.Spans[0].Name)
+	assert.Equal(t, []string{"span:db", "span:cache", "error", "transaction:name"}, calls)
+
+	stats := tracer.Stats()
+	assert.Equal(t, apm.TracerStatsProcessors{
+		SpansDropped:  1,
+		ErrorsDropped: 1,
+	}, stats.Processors)
+
+	// Calling SetProcessors with no arguments removes all processors.
+	tracer.SetProcessors()
+	tracer.StartTransaction("name", "type").End()
+	tracer.Flush(nil)
+	assert.Len(t, recorder.Payloads().Transactions, 2)
+	assert.Len(t, calls, 4)
+}
+
+func TestTracerProcessorsTailSampling(t *testing.T) {
+	tracer, recorder := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+
+	var sampled int
+	tracer.SetTailSampler(apm.TailSamplerFunc(func(apm.TailSampleParams) bool {
+		sampled++
+		return false
+	}))
+	tracer.SetProcessors(apm.ProcessorFunc(func(e *apm.Event) bool {
+		return e.Transaction == nil
+	}))
+
+	tx := tracer.StartTransaction("name", "type")
+	tx.StartSpan("span", "type", nil).End()
+	tx.End()
+	tracer.Flush(nil)
+
+	// The transaction was dropped by the processor, so its
+	// spans are sent without consulting the tail sampler.
+	payloads := recorder.Payloads()
+	assert.Empty(t, payloads.Transactions)
+	require.Len(t, payloads.Spans, 1)
+	assert.Zero(t, sampled)
+	assert.Equal(t, uint64(1), tracer.Stats().Processors.TransactionsDropped)
+}
@@ -0,0 +1,319 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apm // import "go.elastic.co/apm/v2"
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"go.elastic.co/apm/v2/internal/configutil"
+	"go.elastic.co/apm/v2/internal/wildcard"
+	"go.elastic.co/apm/v2/model"
+	"go.elastic.co/apm/v2/sqlutil"
+)
+
+// redactFields is a bitmask of event fields to redact.
+type redactFields uint8
+
+const (
+	redactDBStatement redactFields = 1 << iota
+	redactURLQuery
+	redactLabels
+)
+
+var redactFieldNames = map[string]redactFields{
+	"db.statement": redactDBStatement,
+	"url.query":    redactURLQuery,
+	"labels":       redactLabels,
+}
+
+// builtinRedactPatterns holds named regular expressions which may be
+// specified in ELASTIC_APM_REDACT_PATTERNS in place of an expression.
+var builtinRedactPatterns = map[string]*regexp.Regexp{
+	"credit_card":  regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`),
+	"bearer_token": regexp.MustCompile(`(?i)\bbearer\s+[A-Za-z0-9\-._~+/]+=*`),
+	"jwt":          regexp.MustCompile(`\beyJ[A-Za-z0-9_-]*\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`),
+}
+
+// redaction holds the configuration for redacting
+// event fields before they are encoded.
+type redaction struct {
+	fields   redactFields
+	patterns []*regexp.Regexp
+}
+
+// defaultRedaction redacts no fields.
+var defaultRedaction = redaction{}
+
+func parseRedactFields(names []string) (redactFields, error) {
+	var fields redactFields
+	for _, name := range names {
+		field, ok := redactFieldNames[strings.ToLower(name)]
+		if !ok {
+			return 0, errors.Errorf("unknown redact field %q", name)
+		}
+		fields |= field
+	}
+	return fields, nil
+}
+
+func parseRedactPatterns(patterns []string) ([]*regexp.Regexp, error) {
+	var out []*regexp.Regexp
+	for _, pattern := range patterns {
+		if re, ok := builtinRedactPatterns[pattern]; ok {
+			out = append(out, re)
+			continue
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid redact pattern %q", pattern)
+		}
+		out = append(out, re)
+	}
+	return out, nil
+}
+
+func initialRedaction() (redaction, error) {
+	fields, err := parseRedactFields(configutil.ParseListEnv(envRedactFields, ",", nil))
+	if err != nil {
+		return redaction{}, errors.Wrapf(err, "failed to parse %s", envRedactFields)
+	}
+	patterns, err := parseRedactPatterns(configutil.ParseListEnv(envRedactPatterns, ",", nil))
+	if err != nil {
+		return redaction{}, errors.Wrapf(err, "failed to parse %s", envRedactPatterns)
+	}
+	return redaction{fields: fields, patterns: patterns}, nil
+}
+
+// redactTransaction redacts the configured fields of tx. Keys of query
+// parameters and labels are matched against matchers, which should
+// hold the sanitized field names.
+func (r *redaction) redactTransaction(tx *model.Transaction, matchers wildcard.Matchers) {
+	r.redactContext(tx.Context, matchers)
+}
+
+// redactSpan redacts the configured fields of s.
+func (r *redaction) redactSpan(s *model.Span, matchers wildcard.Matchers) {
+	if s.Context == nil {
+		return
+	}
+	if r.fields&redactDBStatement != 0 && s.Context.Database != nil && s.Context.Database.Statement != "" {
+		s.Context.Database.Statement = r.redactStatement(s.Context.Database.Statement, matchers)
+	}
+	if r.fields&redactURLQuery != 0 && s.Context.HTTP != nil && s.Context.HTTP.URL != nil && s.Context.HTTP.URL.RawQuery != "" {
+		// The URL may be shared with the instrumented request,
+		// so it must be copied before modification.
+		u := *s.Context.HTTP.URL
+		u.RawQuery = r.redactQuery(u.RawQuery, matchers)
+		s.Context.HTTP.URL = &u
+	}
+	if r.fields&redactLabels != 0 {
+		r.redactLabels(s.Context.Tags, matchers)
+	}
+}
+
+// redactError redacts the configured fields of e.
+func (r *redaction) redactError(e *model.Error, matchers wildcard.Matchers) {
+	r.redactContext(e.Context, matchers)
+}
+
+func (r *redaction) redactContext(c *model.Context, matchers wildcard.Matchers) {
+	if c == nil {
+		return
+	}
+	if r.fields&redactURLQuery != 0 && c.Request != nil && c.Request.URL.Search != "" {
+		u := &c.Request.URL
+		search := r.redactQuery(u.Search, matchers)
+		u.Full = strings.Replace(u.Full, "?"+u.Search, "?"+search, 1)
+		u.Search = search
+	}
+	if r.fields&redactLabels != 0 {
+		r.redactLabels(c.Tags, matchers)
+	}
+}
+
+// redactLabels redacts the values of labels whose keys match
+// any of matchers, and masks string label values matching any
+// of the configured patterns.
+func (r *redaction) redactLabels(labels model.IfaceMap, matchers wildcard.Matchers) {
+	for i := range labels {
+		label := &labels[i]
+		if matchers.MatchAny(label.Key) {
+			label.Value = redacted
+		} else if s, ok := label.Value.(string); ok {
+			label.Value = r.mask(s)
+		}
+	}
+}
+
+// redactQuery redacts the values of query parameters whose keys match
+// any of matchers, preserving the order and encoding of the query, and
+// then masks any remaining values matching the configured patterns.
+func (r *redaction) redactQuery(query string, matchers wildcard.Matchers) string {
+	params := strings.Split(query, "&")
+	for i, param := range params {
+		rawKey := param
+		if j := strings.IndexByte(param, '='); j >= 0 {
+			rawKey = param[:j]
+		}
+		key, err := url.QueryUnescape(rawKey)
+		if err != nil {
+			key = rawKey
+		}
+		if matchers.MatchAny(key) {
+			params[i] = rawKey + "=" + redacted
+		}
+	}
+	return r.mask(strings.Join(params, "&"))
+}
+
+// redactStatement redacts a database statement. JSON statements, such as
+// MongoDB commands and Elasticsearch request bodies, have the values of
+// fields matching any of matchers redacted; other statements are assumed
+// to be SQL, and have their string and numeric literals replaced with "?".
+// Any remaining text matching the configured patterns is then masked.
+func (r *redaction) redactStatement(stmt string, matchers wildcard.Matchers) string {
+	if trimmed := strings.TrimSpace(stmt); strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[") {
+		if redactedJSON, err := redactJSON(trimmed, matchers); err == nil {
+			stmt = redactedJSON
+		}
+	} else {
+		stmt = sqlutil.ReplaceLiterals(stmt)
+	}
+	return r.mask(stmt)
+}
+
+// mask replaces all text in s which matches any of the
+// configured patterns with "[REDACTED]".
+func (r *redaction) mask(s string) string {
+	for _, re := range r.patterns {
+		s = re.ReplaceAllLiteralString(s, redacted)
+	}
+	return s
+}
+
+// redactJSON re-encodes the JSON value in s, redacting the values of
+// object fields whose names match any of matchers. Object field order
+// is preserved. An error is returned if s is not valid JSON, but may
+// be truncated.
+func redactJSON(s string, matchers wildcard.Matchers) (string, error) {
+	type container struct {
+		object bool
+		n      int // number of tokens written
+	}
+	var buf bytes.Buffer
+	var stack []container
+	var skip int // nesting depth of a redacted value being skipped
+	dec := json.NewDecoder(strings.NewReader(s))
+	dec.UseNumber()
+loop:
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			// The statement may have been truncated, in which case
+			// we return the redacted content up to the truncation.
+			break
+		} else if err != nil {
+			return "", err
+		}
+		if skip > 0 {
+			// Skipping the contents of a redacted object or array.
+			if delim, ok := tok.(json.Delim); ok {
+				switch delim {
+				case '{', '[':
+					skip++
+				default:
+					skip--
+				}
+			}
+			continue
+		}
+
+		var top *container
+		if len(stack) > 0 {
+			top = &stack[len(stack)-1]
+		}
+		if delim, ok := tok.(json.Delim); ok && (delim == '}' || delim == ']') {
+			buf.WriteRune(rune(delim))
+			stack = stack[:len(stack)-1]
+			continue
+		}
+		isKey := top != nil && top.object && top.n%2 == 0
+		if top == nil && buf.Len() > 0 {
+			// Newline-delimited JSON, e.g. an Elasticsearch
+			// multi-search request body.
+			buf.WriteByte('\n')
+		}
+		if top != nil {
+			switch {
+			case isKey && top.n > 0:
+				buf.WriteByte(',')
+			case top.object && !isKey:
+				buf.WriteByte(':')
+			case !top.object && top.n > 0:
+				buf.WriteByte(',')
+			}
+			top.n++
+		}
+		if isKey {
+			key := tok.(string)
+			writeJSONValue(&buf, key)
+			if matchers.MatchAny(key) {
+				// Write the redacted value in place of the
+				// field's value, skipping the original value.
+				buf.WriteByte(':')
+				writeJSONValue(&buf, redacted)
+				top.n++
+				next, err := dec.Token()
+				if err == io.EOF || err == io.ErrUnexpectedEOF {
+					break loop
+				} else if err != nil {
+					return "", err
+				}
+				if delim, ok := next.(json.Delim); ok && (delim == '{' || delim == '[') {
+					skip = 1
+				}
+			}
+			continue
+		}
+		if delim, ok := tok.(json.Delim); ok {
+			buf.WriteRune(rune(delim))
+			stack = append(stack, container{object: delim == '{'})
+			continue
+		}
+		writeJSONValue(&buf, tok)
+	}
+	return buf.String(), nil
+}
+
+func writeJSONValue(buf *bytes.Buffer, v interface{}) {
+	if n, ok := v.(json.Number); ok {
+		buf.WriteString(string(n))
+		return
+	}
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	enc.Encode(v)
+	buf.Truncate(buf.Len() - 1) // remove trailing newline
+}
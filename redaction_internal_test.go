@@ -0,0 +1,52 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apm // import "go.elastic.co/apm/v2"
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.elastic.co/apm/v2/internal/configutil"
+)
+
+func TestRedactJSON(t *testing.T) {
+	matchers := configutil.ParseWildcardPatterns("password,*token*")
+	for _, test := range []struct {
+		input, expect string
+	}{
+		{`{}`, `{}`},
+		{`[1, "two", null, true]`, `[1,"two",null,true]`},
+		{`{"a": {"b": [{"password": "x"}, {"c": 1.50}]}}`, `{"a":{"b":[{"password":"[REDACTED]"},{"c":1.50}]}}`},
+		{`{"token": [{"a": [1, 2]}, 3], "after": "<&>"}`, `{"token":"[REDACTED]","after":"<&>"}`},
+		{"{\"index\":\"foo\"}\n{\"query\":{\"term\":{\"access_token\":\"abc\"}}}\n", "{\"index\":\"foo\"}\n{\"query\":{\"term\":{\"access_token\":\"[REDACTED]\"}}}"},
+	} {
+		out, err := redactJSON(test.input, matchers)
+		require.NoError(t, err, test.input)
+		assert.Equal(t, test.expect, out, test.input)
+	}
+
+	// Truncated JSON is redacted up to the truncation.
+	out, err := redactJSON(`{"a":1,"password":"hunt`, matchers)
+	require.NoError(t, err)
+	assert.Equal(t, `{"a":1,"password":"[REDACTED]"`, out)
+
+	_, err = redactJSON(`{"a": b}`, matchers)
+	assert.Error(t, err)
+}
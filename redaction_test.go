@@ -0,0 +1,146 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apm_test
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.elastic.co/apm/v2"
+	"go.elastic.co/apm/v2/apmtest"
+	"go.elastic.co/apm/v2/model"
+	"go.elastic.co/apm/v2/transport/transporttest"
+)
+
+func TestTracerRedaction(t *testing.T) {
+	tracer, recorder := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+	require.NoError(t, tracer.SetRedactFields("db.statement", "url.query", "labels"))
+	require.NoError(t, tracer.SetRedactPatterns("credit_card", `internal-\w+`))
+
+	req, _ := http.NewRequest("GET", "http://server.testing/foo?access_token=abc&q=internal-name", nil)
+	tx := tracer.StartTransaction("name", "type")
+	tx.Context.SetHTTPRequest(req)
+	tx.Context.SetLabel("api_key", "xyz")
+	tx.Context.SetLabel("note", "card 4111 1111 1111 1111")
+	tx.Context.SetLabel("count", 123)
+
+	span := tx.StartSpan("sql", "db", nil)
+	span.Context.SetDatabase(apm.DatabaseSpanContext{
+		Type:      "sql",
+		Statement: "SELECT * FROM users WHERE name = 'bob' AND pin = 1234",
+	})
+	span.End()
+
+	span = tx.StartSpan("mongo", "db", nil)
+	span.Context.SetDatabase(apm.DatabaseSpanContext{
+		Type:      "mongodb",
+		Statement: `{"find":"users","filter":{"password":"hunter2","age":30},"auth":{"user":"bob"},"q":"<internal-x>"}`,
+	})
+	span.End()
+
+	clientReq, _ := http.NewRequest("GET", "http://client.testing/bar?password=x&page=2", nil)
+	span = tx.StartSpan("http", "external", nil)
+	span.Context.SetHTTPRequest(clientReq)
+	span.End()
+
+	e := tracer.NewError(errors.New("boom"))
+	e.SetTransaction(tx)
+	e.Context.SetLabel("secret", "s3cr3t")
+	e.Send()
+	tx.End()
+	tracer.Flush(nil)
+
+	payloads := recorder.Payloads()
+	require.Len(t, payloads.Transactions, 1)
+	require.Len(t, payloads.Spans, 3)
+	require.Len(t, payloads.Errors, 1)
+
+	txContext := payloads.Transactions[0].Context
+	assert.Equal(t, "access_token=[REDACTED]&q=[REDACTED]", txContext.Request.URL.Search)
+	assert.Equal(t, "http://server.testing/foo?access_token=[REDACTED]&q=[REDACTED]", txContext.Request.URL.Full)
+	assert.Equal(t, model.IfaceMap{
+		{Key: "api_key", Value: "[REDACTED]"},
+		{Key: "count", Value: float64(123)},
+		{Key: "note", Value: "card [REDACTED]"},
+	}, txContext.Tags)
+
+	assert.Equal(t,
+		"SELECT * FROM users WHERE name = ? AND pin = ?",
+		payloads.Spans[0].Context.Database.Statement,
+	)
+	assert.Equal(t,
+		`{"find":"users","filter":{"password":"[REDACTED]","age":30},"auth":"[REDACTED]","q":"<[REDACTED]>"}`,
+		payloads.Spans[1].Context.Database.Statement,
+	)
+	assert.Equal(t, "password=[REDACTED]&page=2", payloads.Spans[2].Context.HTTP.URL.RawQuery)
+	assert.Equal(t, "password=x&page=2", clientReq.URL.RawQuery) // request is unmodified
+
+	assert.Equal(t, model.IfaceMap{{Key: "secret", Value: "[REDACTED]"}}, payloads.Errors[0].Context.Tags)
+}
+
+func TestTracerRedactionDisabled(t *testing.T) {
+	tracer, recorder := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+
+	tx := tracer.StartTransaction("name", "type")
+	tx.Context.SetLabel("api_key", "xyz")
+	span := tx.StartSpan("sql", "db", nil)
+	span.Context.SetDatabase(apm.DatabaseSpanContext{Statement: "SELECT 'a'"})
+	span.End()
+	tx.End()
+	tracer.Flush(nil)
+
+	payloads := recorder.Payloads()
+	assert.Equal(t, model.IfaceMap{{Key: "api_key", Value: "xyz"}}, payloads.Transactions[0].Context.Tags)
+	assert.Equal(t, "SELECT 'a'", payloads.Spans[0].Context.Database.Statement)
+}
+
+func TestTracerRedactionEnv(t *testing.T) {
+	os.Setenv("ELASTIC_APM_REDACT_FIELDS", "labels")
+	defer os.Unsetenv("ELASTIC_APM_REDACT_FIELDS")
+	os.Setenv("ELASTIC_APM_REDACT_PATTERNS", "bearer_token")
+	defer os.Unsetenv("ELASTIC_APM_REDACT_PATTERNS")
+
+	tracer, recorder := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+
+	tx := tracer.StartTransaction("name", "type")
+	tx.Context.SetLabel("header", "Bearer abc.def")
+	span := tx.StartSpan("sql", "db", nil)
+	span.Context.SetDatabase(apm.DatabaseSpanContext{Statement: "SELECT 'a'"})
+	span.End()
+	tx.End()
+	tracer.Flush(nil)
+
+	payloads := recorder.Payloads()
+	assert.Equal(t, model.IfaceMap{{Key: "header", Value: "[REDACTED]"}}, payloads.Transactions[0].Context.Tags)
+	assert.Equal(t, "SELECT 'a'", payloads.Spans[0].Context.Database.Statement)
+}
+
+func TestTracerSetRedactFieldsInvalid(t *testing.T) {
+	tracer := apmtest.NewDiscardTracer()
+	defer tracer.Close()
+	assert.EqualError(t, tracer.SetRedactFields("db.statement", "body"), `unknown redact field "body"`)
+	assert.EqualError(t, tracer.SetRedactPatterns("credit_card", "("), "invalid redact pattern \"(\": error parsing regexp: missing closing ): `(`")
+}
@@ -21,6 +21,8 @@ import (
 	"encoding/binary"
 	"math"
 	"math/big"
+	"sync"
+	"time"
 
 	"github.com/pkg/errors"
 )
@@ -41,6 +43,14 @@ type SampleParams struct {
 	// TraceContext holds the newly-generated TraceContext
 	// for the root transaction which is being sampled.
 	TraceContext TraceContext
+
+	// Name holds the name of the transaction being sampled,
+	// as passed to Tracer.StartTransaction.
+	Name string
+
+	// Type holds the type of the transaction being sampled,
+	// as passed to Tracer.StartTransaction.
+	Type string
 }
 
 // SampleResult holds information about a sampling decision.
@@ -77,6 +87,10 @@ func NewRatioSampler(r float64) Sampler {
 	if r < 0 || r > 1.0 {
 		panic(errors.Errorf("ratio %v out of range [0,1.0]", r))
 	}
+	return newRatioSampler(r)
+}
+
+func newRatioSampler(r float64) ratioSampler {
 	r = roundSampleRate(r)
 	var x big.Float
 	x.SetUint64(math.MaxUint64)
@@ -109,3 +123,214 @@ func roundSampleRate(r float64) float64 {
 	}
 	return math.Round(r*10000) / 10000
 }
+
+// maxRateLimitedTransactionNames is the maximum number of transaction
+// names for which NewTransactionNameRateLimitingSampler will maintain
+// separate limits. Transactions with additional names share a limit.
+const maxRateLimitedTransactionNames = 1000
+
+// NewRateLimitingSampler returns a new Sampler which samples at most
+// tracesPerSecond root transactions per second, using a token bucket
+// which permits bursts of up to tracesPerSecond transactions (or one,
+// if tracesPerSecond is less than one). If tracesPerSecond is not
+// greater than zero, NewRateLimitingSampler will panic.
+//
+// The sample rate reported for sampled transactions is tracesPerSecond
+// divided by a moving average of the observed rate of root transactions,
+// and is never less than 0.0001. It is used by APM Server to scale the
+// sampled transactions when calculating metrics.
+func NewRateLimitingSampler(tracesPerSecond float64) Sampler {
+	if !(tracesPerSecond > 0) {
+		panic(errors.Errorf("tracesPerSecond %v must be greater than zero", tracesPerSecond))
+	}
+	return &rateLimitingSampler{
+		bucket: newTokenBucket(tracesPerSecond),
+		now:    time.Now,
+	}
+}
+
+type rateLimitingSampler struct {
+	mu     sync.Mutex
+	bucket *tokenBucket
+	now    func() time.Time
+}
+
+// Sample samples the transaction if there is a token available.
+func (s *rateLimitingSampler) Sample(args SampleParams) SampleResult {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.bucket.sample(s.now())
+}
+
+// NewTransactionNameRateLimitingSampler returns a new Sampler which
+// samples at most tracesPerSecond root transactions per second for each
+// transaction name, in the same manner as NewRateLimitingSampler. This
+// ensures that transactions with rarely occurring names are sampled,
+// even if they are outnumbered by other transactions.
+//
+// Limits are maintained separately for up to 1000 transaction names;
+// beyond that, transactions with other names share a single limit.
+// If tracesPerSecond is not greater than zero, the function will panic.
+func NewTransactionNameRateLimitingSampler(tracesPerSecond float64) Sampler {
+	if !(tracesPerSecond > 0) {
+		panic(errors.Errorf("tracesPerSecond %v must be greater than zero", tracesPerSecond))
+	}
+	return &transactionNameRateLimitingSampler{
+		tracesPerSecond: tracesPerSecond,
+		buckets:         make(map[string]*tokenBucket),
+		other:           newTokenBucket(tracesPerSecond),
+		now:             time.Now,
+	}
+}
+
+type transactionNameRateLimitingSampler struct {
+	tracesPerSecond float64
+	now             func() time.Time
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	other   *tokenBucket
+}
+
+// Sample samples the transaction if there is a token available
+// for the transaction name.
+func (s *transactionNameRateLimitingSampler) Sample(args SampleParams) SampleResult {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	bucket, ok := s.buckets[args.Name]
+	if !ok {
+		if len(s.buckets) < maxRateLimitedTransactionNames {
+			bucket = newTokenBucket(s.tracesPerSecond)
+			s.buckets[args.Name] = bucket
+		} else {
+			bucket = s.other
+		}
+	}
+	return bucket.sample(s.now())
+}
+
+// tokenBucket is a token bucket rate limiter, which additionally
+// tracks a moving average of the rate of transactions observed, in
+// the same manner as adaptiveSampler, for reporting the sample rate.
+//
+// tokenBucket is not safe for concurrent use.
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+
+	windowStart time.Time
+	seen        uint64
+	throughput  float64
+	sampleRate  float64
+}
+
+func newTokenBucket(rate float64) *tokenBucket {
+	burst := math.Max(rate, 1)
+	return &tokenBucket{rate: rate, burst: burst, tokens: burst, sampleRate: 1}
+}
+
+func (b *tokenBucket) sample(now time.Time) SampleResult {
+	if b.last.IsZero() {
+		b.last = now
+		b.windowStart = now
+	}
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = math.Min(b.burst, b.tokens+elapsed.Seconds()*b.rate)
+		b.last = now
+	}
+	if elapsed := now.Sub(b.windowStart); elapsed >= time.Second {
+		// Sampled transactions must never report a sample rate of
+		// zero, so the rate is derived from the observed throughput
+		// rather than the number of transactions sampled, which may
+		// be zero in a window when the rate is less than one.
+		observed := float64(b.seen) / elapsed.Seconds()
+		if b.throughput == 0 {
+			b.throughput = observed
+		} else {
+			weight := math.Pow(0.5, elapsed.Seconds())
+			b.throughput = weight*b.throughput + (1-weight)*observed
+		}
+		ratio := 1.0
+		if b.throughput > b.rate {
+			ratio = math.Max(b.rate/b.throughput, 0.0001)
+		}
+		b.sampleRate = roundSampleRate(ratio)
+		b.windowStart = now
+		b.seen = 0
+	}
+	b.seen++
+	if b.tokens < 1 {
+		return SampleResult{Sampled: false, SampleRate: b.sampleRate}
+	}
+	b.tokens--
+	return SampleResult{Sampled: true, SampleRate: b.sampleRate}
+}
+
+// adaptiveSamplerInterval is the interval at which the adaptive
+// sampler recalculates its sampling ratio.
+const adaptiveSamplerInterval = time.Second
+
+// NewAdaptiveSampler returns a new Sampler which adjusts its sampling
+// ratio to sample approximately targetTracesPerSecond root transactions
+// per second. If targetTracesPerSecond is not greater than zero,
+// NewAdaptiveSampler will panic.
+//
+// The ratio is recalculated every second, based on a moving average of
+// the rate of root transactions, and is initially 1.0. Between updates,
+// the returned Sampler behaves like one returned by NewRatioSampler,
+// and reports the current ratio as the sample rate.
+func NewAdaptiveSampler(targetTracesPerSecond float64) Sampler {
+	if !(targetTracesPerSecond > 0) {
+		panic(errors.Errorf("targetTracesPerSecond %v must be greater than zero", targetTracesPerSecond))
+	}
+	return &adaptiveSampler{
+		target:  targetTracesPerSecond,
+		now:     time.Now,
+		sampler: newRatioSampler(1),
+	}
+}
+
+type adaptiveSampler struct {
+	target float64
+	now    func() time.Time
+
+	mu          sync.Mutex
+	windowStart time.Time
+	seen        uint64
+	rate        float64
+	sampler     ratioSampler
+}
+
+// Sample samples the transaction according to the current ratio,
+// recalculating the ratio if the interval has elapsed.
+func (s *adaptiveSampler) Sample(args SampleParams) SampleResult {
+	s.mu.Lock()
+	now := s.now()
+	if s.windowStart.IsZero() {
+		s.windowStart = now
+	} else if elapsed := now.Sub(s.windowStart); elapsed >= adaptiveSamplerInterval {
+		// Calculate an exponentially weighted moving average of
+		// the rate, halving the weight of older observations
+		// with each interval elapsed.
+		observed := float64(s.seen) / elapsed.Seconds()
+		if s.rate == 0 {
+			s.rate = observed
+		} else {
+			weight := math.Pow(0.5, elapsed.Seconds()/adaptiveSamplerInterval.Seconds())
+			s.rate = weight*s.rate + (1-weight)*observed
+		}
+		ratio := 1.0
+		if s.rate > s.target {
+			ratio = s.target / s.rate
+		}
+		s.sampler = newRatioSampler(ratio)
+		s.windowStart = now
+		s.seen = 0
+	}
+	s.seen++
+	sampler := s.sampler
+	s.mu.Unlock()
+	return sampler.Sample(args)
+}
@@ -0,0 +1,143 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apm
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time {
+	return c.t
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.t = c.t.Add(d)
+}
+
+func TestRateLimitingSamplerRefill(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(0, 0)}
+	s := NewRateLimitingSampler(2).(*rateLimitingSampler)
+	s.now = clock.now
+
+	sample := func(n int) (sampled int, rates []float64) {
+		for i := 0; i < n; i++ {
+			result := s.Sample(SampleParams{})
+			if result.Sampled {
+				sampled++
+				rates = append(rates, result.SampleRate)
+			}
+		}
+		return sampled, rates
+	}
+
+	// The bucket starts full, and the sample rate is initially 1.
+	sampled, rates := sample(8)
+	assert.Equal(t, 2, sampled)
+	assert.Equal(t, []float64{1, 1}, rates)
+
+	// After half a second, one token has been added.
+	clock.advance(500 * time.Millisecond)
+	sampled, _ = sample(8)
+	assert.Equal(t, 1, sampled)
+
+	// After a second has elapsed since the first decision, the sample
+	// rate reflects the 16 transactions observed in that window.
+	clock.advance(500 * time.Millisecond)
+	sampled, rates = sample(8)
+	assert.Equal(t, 1, sampled)
+	assert.Equal(t, []float64{0.125}, rates)
+}
+
+func TestRateLimitingSamplerFractionalRate(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(0, 0)}
+	s := NewRateLimitingSampler(0.5).(*rateLimitingSampler)
+	s.now = clock.now
+
+	// With 2 transactions per second and a rate of 0.5, most windows
+	// contain no sampled transactions. Sampled transactions must still
+	// report a non-zero sample rate.
+	var sampled int
+	var rates []float64
+	for i := 0; i < 40; i++ {
+		result := s.Sample(SampleParams{})
+		if result.Sampled {
+			sampled++
+			rates = append(rates, result.SampleRate)
+			assert.Greater(t, result.SampleRate, 0.0)
+		}
+		clock.advance(500 * time.Millisecond)
+	}
+	assert.Equal(t, 10, sampled)
+	assert.Equal(t, 0.25, rates[len(rates)-1])
+}
+
+func TestAdaptiveSampler(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(0, 0)}
+	s := NewAdaptiveSampler(10).(*adaptiveSampler)
+	s.now = clock.now
+
+	// sample makes n sampling decisions with evenly distributed
+	// transaction IDs, returning the number sampled and the
+	// sample rate in effect.
+	sample := func(n int) (sampled int, rate float64) {
+		for i := 0; i < n; i++ {
+			var traceContext TraceContext
+			binary.BigEndian.PutUint64(traceContext.Span[:], uint64(i+1)*(math.MaxUint64/uint64(n)))
+			result := s.Sample(SampleParams{TraceContext: traceContext})
+			if result.Sampled {
+				sampled++
+			}
+			rate = result.SampleRate
+		}
+		return sampled, rate
+	}
+
+	// Initially everything is sampled.
+	sampled, rate := sample(100)
+	assert.Equal(t, 100, sampled)
+	assert.Equal(t, 1.0, rate)
+
+	// 100 transactions were seen in the first second,
+	// so the ratio is adjusted to 10/100.
+	clock.advance(time.Second)
+	sampled, rate = sample(100)
+	assert.Equal(t, 10, sampled)
+	assert.Equal(t, 0.1, rate)
+
+	// The rate is averaged over intervals: with 300 transactions
+	// in the next second, the average rate is 200/s.
+	clock.advance(time.Second)
+	sample(300)
+	clock.advance(time.Second)
+	_, rate = sample(1)
+	assert.Equal(t, 0.05, rate)
+
+	// When throughput falls below the target, everything is sampled.
+	clock.advance(10 * time.Second)
+	_, rate = sample(1)
+	assert.Equal(t, 1.0, rate)
+}
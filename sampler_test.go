@@ -24,8 +24,10 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"go.elastic.co/apm/v2"
+	"go.elastic.co/apm/v2/apmtest"
 )
 
 func TestRatioSampler(t *testing.T) {
@@ -102,3 +104,64 @@ func TestRatioSamplerPrecision(t *testing.T) {
 		assert.Equal(t, want, got)
 	}
 }
+
+func TestRateLimitingSampler(t *testing.T) {
+	s := apm.NewRateLimitingSampler(10)
+	var sampled int
+	for i := 0; i < 100; i++ {
+		result := s.Sample(apm.SampleParams{})
+		if result.Sampled {
+			sampled++
+		}
+	}
+	// The bucket is initially full, permitting a burst of 10 transactions.
+	// Allow for some tokens being added while the test runs.
+	assert.GreaterOrEqual(t, sampled, 10)
+	assert.LessOrEqual(t, sampled, 12)
+}
+
+func TestRateLimitingSamplerInvalid(t *testing.T) {
+	assert.Panics(t, func() { apm.NewRateLimitingSampler(0) })
+	assert.Panics(t, func() { apm.NewTransactionNameRateLimitingSampler(-1) })
+	assert.Panics(t, func() { apm.NewAdaptiveSampler(0) })
+}
+
+func TestTransactionNameRateLimitingSampler(t *testing.T) {
+	s := apm.NewTransactionNameRateLimitingSampler(1)
+	assert.True(t, s.Sample(apm.SampleParams{Name: "GET /"}).Sampled)
+	assert.False(t, s.Sample(apm.SampleParams{Name: "GET /"}).Sampled)
+	assert.True(t, s.Sample(apm.SampleParams{Name: "POST /checkout"}).Sampled)
+	assert.False(t, s.Sample(apm.SampleParams{Name: "POST /checkout"}).Sampled)
+}
+
+func TestSamplerParams(t *testing.T) {
+	var params []apm.SampleParams
+	tracer := apmtest.NewRecordingTracer()
+	defer tracer.Close()
+	tracer.SetSampler(samplerFunc(func(p apm.SampleParams) apm.SampleResult {
+		params = append(params, p)
+		return apm.SampleResult{Sampled: true, SampleRate: 1}
+	}))
+
+	tx := tracer.StartTransaction("name", "type")
+	tx.End()
+	require.Len(t, params, 1)
+	assert.Equal(t, "name", params[0].Name)
+	assert.Equal(t, "type", params[0].Type)
+	assert.Equal(t, tx.TraceContext().Span, params[0].TraceContext.Span)
+}
+
+func TestTracerSetTransactionSampleRules(t *testing.T) {
+	tracer := apmtest.NewDiscardTracer()
+	defer tracer.Close()
+
+	tracer.SetSampler(apm.NewRatioSampler(0))
+	require.NoError(t, tracer.SetTransactionSampleRules("type|name=1"))
+	assert.True(t, tracer.StartTransaction("name", "type").Sampled())
+	assert.False(t, tracer.StartTransaction("name", "other").Sampled())
+	assert.False(t, tracer.StartTransaction("other", "type").Sampled())
+
+	err := tracer.SetTransactionSampleRules("name")
+	assert.EqualError(t, err, `invalid rule "name" in ELASTIC_APM_TRANSACTION_SAMPLE_RULES: expected <pattern>=<rate>`)
+	assert.True(t, tracer.StartTransaction("name", "type").Sampled())
+}
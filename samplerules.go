@@ -0,0 +1,86 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apm // import "go.elastic.co/apm/v2"
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"go.elastic.co/apm/v2/internal/configutil"
+	"go.elastic.co/apm/v2/internal/wildcard"
+)
+
+// sampleRules holds an ordered list of transaction sampling rules.
+type sampleRules []sampleRule
+
+// sampleRule holds a transaction sampling rule, which applies
+// a ratio sampler to transactions matching name and type patterns.
+type sampleRule struct {
+	typ     *wildcard.Matcher // nil matches any type
+	name    *wildcard.Matcher
+	sampler Sampler
+}
+
+// match returns the sampler of the first rule matching the transaction
+// name and type, or nil if no rule matches.
+func (rules sampleRules) match(name, transactionType string) Sampler {
+	for _, rule := range rules {
+		if rule.typ != nil && !rule.typ.Match(transactionType) {
+			continue
+		}
+		if rule.name.Match(name) {
+			return rule.sampler
+		}
+	}
+	return nil
+}
+
+// parseSampleRules parses a comma-separated list of sampling rules of
+// the form "[<type>|]<name>=<rate>", where type and name are wildcard
+// patterns and rate is a sample rate in the range [0,1.0].
+func parseSampleRules(name, value string) (sampleRules, error) {
+	var rules sampleRules
+	for _, rule := range configutil.ParseList(value, ",") {
+		i := strings.LastIndexByte(rule, '=')
+		if i < 0 {
+			return nil, errors.Errorf("invalid rule %q in %s: expected <pattern>=<rate>", rule, name)
+		}
+		pattern := strings.TrimSpace(rule[:i])
+		rate, err := strconv.ParseFloat(strings.TrimSpace(rule[i+1:]), 64)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid rule %q in %s", rule, name)
+		}
+		if rate < 0.0 || rate > 1.0 {
+			return nil, errors.Errorf(
+				"invalid rule %q in %s: rate out of range [0,1.0]",
+				rule, name,
+			)
+		}
+		var r sampleRule
+		if j := strings.IndexByte(pattern, '|'); j >= 0 {
+			r.typ = configutil.ParseWildcardPattern(strings.TrimSpace(pattern[:j]))
+			pattern = strings.TrimSpace(pattern[j+1:])
+		}
+		r.name = configutil.ParseWildcardPattern(pattern)
+		r.sampler = NewRatioSampler(rate)
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
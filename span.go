@@ -127,6 +127,7 @@ func (tx *Transaction) StartSpanOptions(name, spanType string, opts SpanOptions)
 		span.stackTraceLimit = tx.stackTraceLimit
 		span.compressedSpan.options = tx.compressedSpan.options
 		span.exitSpanMinDuration = tx.exitSpanMinDuration
+		span.traceContext.Baggage.setLabels(tx.baggageToAttach, span.Context.SetLabel)
 		tx.spansCreated++
 	}
 
@@ -467,6 +468,9 @@ func (s *Span) enqueue() {
 	event := tracerEvent{eventType: spanEvent}
 	event.span.Span = s
 	event.span.SpanData = s.SpanData
+	// enqueue is called with s.tx.mu held, so the transaction
+	// cannot end concurrently.
+	event.span.transactionActive = s.tx != nil && !s.tx.ended()
 	select {
 	case s.tracer.events <- event:
 	default:
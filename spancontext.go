@@ -105,6 +105,9 @@ type DestinationCloudSpanContext struct {
 type MessageSpanContext struct {
 	// QueueName holds the message queue name.
 	QueueName string
+
+	// RoutingKey holds the routing key of the message, if any.
+	RoutingKey string
 }
 
 func (c *SpanContext) build() *model.SpanContext {
@@ -251,6 +254,7 @@ func (c *SpanContext) SetMessage(message MessageSpanContext) {
 	c.message.Queue = &model.MessageQueueSpanContext{
 		Name: truncateString(message.QueueName),
 	}
+	c.message.RoutingKey = truncateString(message.RoutingKey)
 	c.model.Message = &c.message
 }
 
@@ -51,6 +51,22 @@ func TestSpanContextSetLabel(t *testing.T) {
 	}, spans[0].Context.Tags)
 }
 
+func TestSpanContextSetMessage(t *testing.T) {
+	_, spans, _ := apmtest.WithTransaction(func(ctx context.Context) {
+		span, _ := apm.StartSpan(ctx, "name", "messaging")
+		span.Context.SetMessage(apm.MessageSpanContext{
+			QueueName:  "exchange",
+			RoutingKey: "key",
+		})
+		span.End()
+	})
+	require.Len(t, spans, 1)
+	assert.Equal(t, &model.MessageSpanContext{
+		Queue:      &model.MessageQueueSpanContext{Name: "exchange"},
+		RoutingKey: "key",
+	}, spans[0].Context.Message)
+}
+
 func TestSpanContextSetHTTPRequest(t *testing.T) {
 	type testcase struct {
 		url string
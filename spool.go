@@ -0,0 +1,356 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apm // import "go.elastic.co/apm/v2"
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"go.elastic.co/apm/v2/internal/spool"
+	"go.elastic.co/apm/v2/transport"
+)
+
+// spoolReplayInterval is the minimum interval between attempts
+// to replay the spool.
+const spoolReplayInterval = time.Second
+
+// sharedSpools holds the spools opened by tracers in this process,
+// keyed by absolute directory path. A spool directory must not be
+// shared by multiple spool.Spools, so tracers using the same
+// directory share a single spool.Spool.
+var sharedSpools = struct {
+	mu     sync.Mutex
+	spools map[string]*sharedSpool
+}{spools: make(map[string]*sharedSpool)}
+
+type sharedSpool struct {
+	dir   string
+	spool *spool.Spool
+	refs  int
+}
+
+// openSharedSpool returns the spool for dir, opening it if it is
+// not already open in this process. If the spool is already open,
+// maxSize is ignored. The returned spool must be released with
+// releaseSharedSpool.
+func openSharedSpool(dir string, maxSize int64) (*sharedSpool, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	sharedSpools.mu.Lock()
+	defer sharedSpools.mu.Unlock()
+	if s, ok := sharedSpools.spools[dir]; ok {
+		s.refs++
+		return s, nil
+	}
+	sp, err := spool.Open(dir, maxSize)
+	if err != nil {
+		return nil, err
+	}
+	s := &sharedSpool{dir: dir, spool: sp, refs: 1}
+	sharedSpools.spools[dir] = s
+	return s, nil
+}
+
+// releaseSharedSpool releases a reference to s, closing the
+// spool when there are no more references.
+func releaseSharedSpool(s *sharedSpool) {
+	sharedSpools.mu.Lock()
+	defer sharedSpools.mu.Unlock()
+	s.refs--
+	if s.refs == 0 {
+		delete(sharedSpools.spools, s.dir)
+		s.spool.Close()
+	}
+}
+
+// spoolTransport is a transport.Transport which writes streams to a
+// disk-backed spool when they fail to send, and replays the spooled
+// streams in the background once the underlying transport successfully
+// sends a stream.
+type spoolTransport struct {
+	transport transport.Transport
+	spool     *sharedSpool
+	stats     *TracerStats
+
+	replayc   chan struct{}
+	closing   chan struct{}
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newSpoolTransport(transport transport.Transport, spool *sharedSpool, stats *TracerStats) *spoolTransport {
+	t := &spoolTransport{
+		transport: transport,
+		spool:     spool,
+		stats:     stats,
+		replayc:   make(chan struct{}, 1),
+		closing:   make(chan struct{}),
+		closed:    make(chan struct{}),
+	}
+	go t.replayLoop()
+	return t
+}
+
+// close stops replaying the spool, and releases it.
+func (t *spoolTransport) close() {
+	t.closeOnce.Do(func() {
+		close(t.closing)
+		<-t.closed
+		releaseSharedSpool(t.spool)
+	})
+}
+
+func (t *spoolTransport) SendStream(ctx context.Context, r io.Reader) error {
+	var buf bytes.Buffer
+	tr := &spoolTeeReader{r: r, w: &buf}
+	err := t.transport.SendStream(ctx, tr)
+	tr.detach()
+	if err == nil {
+		select {
+		case t.replayc <- struct{}{}:
+		default:
+		}
+		return nil
+	}
+
+	// The stream could not be sent. Consume the remainder of the stream,
+	// so the events are spooled rather than dropped. If the tracer is
+	// closing, the stream may be incomplete; repairStream salvages the
+	// complete events.
+	if tr.err == nil {
+		io.Copy(&buf, r)
+	}
+	var stats TracerStatsSpool
+	data := repairStream(buf.Bytes())
+	if err, ok := err.(*transport.PartialSendError); ok && data != nil {
+		// Only spool the events that were not sent,
+		// so the others are not duplicated on replay.
+		data = filterStream(data, err.EventTypes)
+	}
+	if data != nil {
+		discarded, writeErr := t.spool.spool.Write(data)
+		if writeErr != nil {
+			discarded += int64(len(data))
+		} else {
+			stats.SpooledBytes = uint64(len(data))
+		}
+		stats.DiscardedBytes = uint64(discarded)
+	}
+	t.stats.accumulate(TracerStats{Spool: stats})
+	return err
+}
+
+// replayLoop replays the spool each time a stream is successfully sent,
+// at most once per spoolReplayInterval, until the transport is closed.
+func (t *spoolTransport) replayLoop() {
+	defer close(t.closed)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-t.closing:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	for {
+		select {
+		case <-t.closing:
+			return
+		case <-t.replayc:
+		}
+		t.replay(ctx)
+		select {
+		case <-t.closing:
+			return
+		case <-time.After(spoolReplayInterval):
+		}
+	}
+}
+
+// errReplayPartial is returned by the replay function to stop replaying
+// after a stream was only partially sent.
+var errReplayPartial = errors.New("stream partially sent")
+
+// replay sends spooled streams until the spool is empty, or a stream
+// fails to send. Streams which are rejected by the server as invalid
+// are discarded, so they do not block the remainder of the spool.
+//
+// If a stream is only partially sent, the events that were not sent
+// are spooled as a new stream, and replay stops.
+func (t *spoolTransport) replay(ctx context.Context) {
+	var stats TracerStatsSpool
+	for ctx.Err() == nil {
+		var partial bool
+		replayed, err := t.spool.spool.Replay(func(r io.Reader) error {
+			if partial {
+				return errReplayPartial
+			}
+			data, err := ioutil.ReadAll(r)
+			if err != nil {
+				return err
+			}
+			err = t.transport.SendStream(ctx, bytes.NewReader(data))
+			if err, ok := err.(*transport.PartialSendError); ok {
+				partial = true
+				if data := filterStream(data, err.EventTypes); data != nil {
+					discarded, writeErr := t.spool.spool.Write(data)
+					if writeErr != nil {
+						discarded += int64(len(data))
+					}
+					stats.DiscardedBytes += uint64(discarded)
+				}
+				return nil
+			}
+			return err
+		})
+		stats.ReplayedBytes += uint64(replayed)
+		if err == nil || !isInvalidStreamError(err) {
+			break
+		}
+		stats.DiscardedBytes += uint64(t.spool.spool.DiscardOldest())
+	}
+	t.stats.accumulate(TracerStats{Spool: stats})
+}
+
+// isInvalidStreamError reports whether err indicates that the server
+// rejected a stream as invalid, such that it will never be accepted.
+func isInvalidStreamError(err error) bool {
+	if err, ok := err.(*transport.HTTPError); ok {
+		switch err.Response.StatusCode {
+		case http.StatusBadRequest, http.StatusRequestEntityTooLarge:
+			return true
+		}
+	}
+	return false
+}
+
+// repairStream returns data if it holds a complete zlib-compressed
+// stream. If the stream is truncated, repairStream returns a new
+// stream containing the complete lines that could be decompressed.
+// If there are no complete events following the metadata, nil is
+// returned.
+func repairStream(data []byte) []byte {
+	zr, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil
+	}
+	var decompressed bytes.Buffer
+	_, err = io.Copy(&decompressed, zr)
+	lines := decompressed.Bytes()
+	if err == nil {
+		if bytes.Count(lines, []byte("\n")) < 2 {
+			return nil
+		}
+		return data
+	}
+	// Truncate to the last complete line.
+	lines = lines[:bytes.LastIndexByte(lines, '\n')+1]
+	if bytes.Count(lines, []byte("\n")) < 2 {
+		return nil
+	}
+	var repaired bytes.Buffer
+	zw, _ := zlib.NewWriterLevel(&repaired, zlib.BestSpeed)
+	zw.Write(lines)
+	zw.Close()
+	return repaired.Bytes()
+}
+
+// filterStream returns a new zlib-compressed stream containing the
+// metadata and the events in the complete stream data with the given
+// event types. If there are no such events, nil is returned.
+func filterStream(data []byte, eventTypes []string) []byte {
+	zr, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil
+	}
+	decompressed, err := ioutil.ReadAll(zr)
+	if err != nil {
+		return nil
+	}
+	var filtered bytes.Buffer
+	var events int
+	for i, line := range bytes.SplitAfter(decompressed, []byte("\n")) {
+		if i == 0 {
+			filtered.Write(line) // metadata
+			continue
+		}
+		for _, eventType := range eventTypes {
+			if bytes.HasPrefix(line, []byte(`{"`+eventType+`":`)) {
+				filtered.Write(line)
+				events++
+				break
+			}
+		}
+	}
+	if events == 0 {
+		return nil
+	}
+	var compressed bytes.Buffer
+	zw, _ := zlib.NewWriterLevel(&compressed, zlib.BestSpeed)
+	zw.Write(filtered.Bytes())
+	zw.Close()
+	return compressed.Bytes()
+}
+
+// spoolTeeReader is an io.Reader which records the data read from r
+// into w, until detached. Once detached, reads will fail; this protects
+// the recorded data from transports which read the stream in a goroutine
+// that may outlive the call to SendStream.
+//
+// err records the first error returned by r, including io.EOF, after
+// which r must not be read again.
+type spoolTeeReader struct {
+	mu       sync.Mutex
+	r        io.Reader
+	w        io.Writer
+	err      error
+	detached bool
+}
+
+func (r *spoolTeeReader) Read(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.detached {
+		return 0, io.ErrClosedPipe
+	}
+	if r.err != nil {
+		return 0, r.err
+	}
+	n, err := r.r.Read(p)
+	r.w.Write(p[:n])
+	r.err = err
+	return n, err
+}
+
+func (r *spoolTeeReader) detach() {
+	r.mu.Lock()
+	r.detached = true
+	r.mu.Unlock()
+}
@@ -0,0 +1,110 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apm
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.elastic.co/apm/v2/internal/spool"
+)
+
+func TestRepairStream(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	zw.Write([]byte("{\"metadata\":{}}\n{\"transaction\":{}}\n"))
+	zw.Flush()
+	flushed := len(buf.Bytes())
+	zw.Write([]byte("{\"span\":{}}\n"))
+	zw.Close()
+	complete := buf.Bytes()
+
+	// Complete streams are returned as-is.
+	assert.Equal(t, complete, repairStream(complete))
+
+	// Truncated streams are repaired by recompressing the
+	// complete lines that could be decompressed.
+	repaired := repairStream(complete[:flushed])
+	require.NotNil(t, repaired)
+	zr, err := zlib.NewReader(bytes.NewReader(repaired))
+	require.NoError(t, err)
+	decompressed, err := ioutil.ReadAll(zr)
+	require.NoError(t, err)
+	assert.Equal(t, "{\"metadata\":{}}\n{\"transaction\":{}}\n", string(decompressed))
+
+	// Streams with no events following the metadata are dropped.
+	buf.Reset()
+	zw.Reset(&buf)
+	zw.Write([]byte("{\"metadata\":{}}\n"))
+	zw.Close()
+	assert.Nil(t, repairStream(buf.Bytes()))
+	assert.Nil(t, repairStream(nil))
+}
+
+func TestTracerSharedSpool(t *testing.T) {
+	os.Setenv(envSpoolDir, t.TempDir())
+	defer os.Unsetenv(envSpoolDir)
+
+	tracer1, err := NewTracer("", "")
+	require.NoError(t, err)
+	tracer2, err := NewTracer("", "")
+	require.NoError(t, err)
+
+	// Tracers using the same spool directory share a spool,
+	// which is closed when the last tracer is closed.
+	require.NotNil(t, tracer1.spoolTransport)
+	require.NotNil(t, tracer2.spoolTransport)
+	assert.Same(t, tracer1.spoolTransport.spool, tracer2.spoolTransport.spool)
+	shared := tracer1.spoolTransport.spool
+
+	tracer1.Close()
+	assert.Equal(t, 1, shared.refs)
+	_, err = shared.spool.Write([]byte("abc"))
+	assert.NoError(t, err)
+
+	tracer2.Close()
+	assert.Zero(t, shared.refs)
+	_, err = shared.spool.Write([]byte("def"))
+	assert.Equal(t, spool.ErrClosed, err)
+	assert.NotContains(t, sharedSpools.spools, shared.dir)
+}
+
+func TestFilterStream(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	zw.Write([]byte("{\"metadata\":{}}\n{\"transaction\":{}}\n{\"metricset\":{}}\n{\"span\":{}}\n"))
+	zw.Close()
+
+	// Only the metadata and events of the given types are kept.
+	filtered := filterStream(buf.Bytes(), []string{"metricset", "error"})
+	require.NotNil(t, filtered)
+	zr, err := zlib.NewReader(bytes.NewReader(filtered))
+	require.NoError(t, err)
+	decompressed, err := ioutil.ReadAll(zr)
+	require.NoError(t, err)
+	assert.Equal(t, "{\"metadata\":{}}\n{\"metricset\":{}}\n", string(decompressed))
+
+	// Streams with no events of the given types are dropped.
+	assert.Nil(t, filterStream(buf.Bytes(), []string{"error"}))
+}
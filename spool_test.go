@@ -0,0 +1,164 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apm_test
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.elastic.co/apm/v2"
+	"go.elastic.co/apm/v2/transport"
+)
+
+func TestTracerSpool(t *testing.T) {
+	var mu sync.Mutex
+	var available bool
+	var transactions []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/intake/v2/events" {
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if !available {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		zr, err := zlib.NewReader(req.Body)
+		require.NoError(t, err)
+		scanner := bufio.NewScanner(zr)
+		for scanner.Scan() {
+			if bytes.HasPrefix(scanner.Bytes(), []byte(`{"transaction":`)) {
+				transactions = append(transactions, scanner.Text())
+			}
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	os.Setenv("ELASTIC_APM_SERVER_URL", server.URL)
+	defer os.Unsetenv("ELASTIC_APM_SERVER_URL")
+	os.Setenv("ELASTIC_APM_SPOOL_DIR", t.TempDir())
+	defer os.Unsetenv("ELASTIC_APM_SPOOL_DIR")
+
+	httpTransport, err := transport.NewHTTPTransport(transport.HTTPTransportOptions{})
+	require.NoError(t, err)
+	tracer, err := apm.NewTracerOptions(apm.TracerOptions{
+		ServiceName: "tracer_testing",
+		Transport:   httpTransport,
+	})
+	require.NoError(t, err)
+	defer tracer.Close()
+	tracer.SetMetricsInterval(0)
+
+	tracer.StartTransaction("spooled", "type").End()
+	tracer.Flush(nil)
+	stats := tracer.Stats()
+	assert.Equal(t, uint64(1), stats.Errors.SendStream)
+	assert.NotZero(t, stats.Spool.SpooledBytes)
+	assert.Zero(t, stats.Spool.ReplayedBytes)
+
+	mu.Lock()
+	available = true
+	mu.Unlock()
+
+	// The spool is replayed in the background after a stream is sent.
+	tracer.StartTransaction("sent", "type").End()
+	tracer.Flush(nil)
+	assert.Eventually(t, func() bool {
+		stats := tracer.Stats()
+		return stats.Spool.ReplayedBytes == stats.Spool.SpooledBytes
+	}, 10*time.Second, 10*time.Millisecond)
+	assert.Zero(t, tracer.Stats().Spool.DiscardedBytes)
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, transactions, 2)
+	assert.Contains(t, transactions[0], `"name":"sent"`)
+	assert.Contains(t, transactions[1], `"name":"spooled"`)
+}
+
+func TestTracerSpoolDiscardInvalid(t *testing.T) {
+	var mu sync.Mutex
+	var available bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/intake/v2/events" {
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if !available {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		zr, err := zlib.NewReader(req.Body)
+		require.NoError(t, err)
+		body, err := ioutil.ReadAll(zr)
+		require.NoError(t, err)
+		if bytes.Contains(body, []byte(`"name":"invalid"`)) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	os.Setenv("ELASTIC_APM_SERVER_URL", server.URL)
+	defer os.Unsetenv("ELASTIC_APM_SERVER_URL")
+	os.Setenv("ELASTIC_APM_SPOOL_DIR", t.TempDir())
+	defer os.Unsetenv("ELASTIC_APM_SPOOL_DIR")
+
+	httpTransport, err := transport.NewHTTPTransport(transport.HTTPTransportOptions{})
+	require.NoError(t, err)
+	tracer, err := apm.NewTracerOptions(apm.TracerOptions{
+		ServiceName: "tracer_testing",
+		Transport:   httpTransport,
+	})
+	require.NoError(t, err)
+	defer tracer.Close()
+	tracer.SetMetricsInterval(0)
+
+	tracer.StartTransaction("invalid", "type").End()
+	tracer.Flush(nil)
+	spooled := tracer.Stats().Spool.SpooledBytes
+	require.NotZero(t, spooled)
+
+	mu.Lock()
+	available = true
+	mu.Unlock()
+
+	// The spooled stream will be rejected by the server when
+	// replayed, and should be discarded rather than retried.
+	tracer.StartTransaction("valid", "type").End()
+	tracer.Flush(nil)
+	assert.Eventually(t, func() bool {
+		return tracer.Stats().Spool.DiscardedBytes == spooled
+	}, 10*time.Second, 10*time.Millisecond)
+	assert.Zero(t, tracer.Stats().Spool.ReplayedBytes)
+}
@@ -0,0 +1,101 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package sqlutil // import "go.elastic.co/apm/v2/sqlutil"
+
+import "strings"
+
+// ReplaceLiterals returns sql with each string and numeric literal
+// replaced by "?". Identifiers, keywords, comments, and bind
+// parameters are left unchanged.
+//
+// If sql ends with an unterminated string literal, e.g. because it
+// has been truncated, the remainder of the statement is replaced.
+func ReplaceLiterals(sql string) string {
+	return replaceLiterals(sql, false)
+}
+
+// Obfuscate returns sql with each string and numeric literal replaced
+// by "?", as with ReplaceLiterals, and with each parenthesised list of
+// values following IN collapsed to a single "?". For example:
+//
+//	SELECT * FROM foo WHERE id IN (1, 2, $1) AND name = 'bar'
+//
+// is obfuscated as:
+//
+//	SELECT * FROM foo WHERE id IN (?) AND name = ?
+//
+// Lists containing subqueries or function calls are not collapsed,
+// though any literals within them are replaced.
+func Obfuscate(sql string) string {
+	return replaceLiterals(sql, true)
+}
+
+func replaceLiterals(sql string, collapseIN bool) string {
+	var buf strings.Builder
+	var last int
+	var prevIN bool
+	s := NewScanner(sql)
+	for s.Scan() {
+		switch tok := s.Token(); tok {
+		case NUMBER, STRING:
+			buf.WriteString(sql[last:s.start])
+			buf.WriteByte('?')
+			last = s.end
+		case LPAREN:
+			if prevIN {
+				start := s.start
+				if end, ok := scanValueList(s); ok {
+					buf.WriteString(sql[last:start])
+					buf.WriteString("(?)")
+					last = end
+				}
+			}
+		}
+		prevIN = collapseIN && s.Token() == IDENT && strings.EqualFold(s.Text(), "in")
+	}
+	if s.start >= last && s.start < len(sql) && sql[s.start] == '\'' {
+		buf.WriteString(sql[last:s.start])
+		buf.WriteByte('?')
+		last = len(sql)
+	}
+	if buf.Len() == 0 {
+		return sql
+	}
+	buf.WriteString(sql[last:])
+	return buf.String()
+}
+
+// scanValueList scans a list of values following an opening
+// parenthesis. If the list is closed and consists only of literals,
+// bind parameters, and identifiers, then scanValueList advances s to
+// the closing parenthesis and returns the end offset of the list.
+// Otherwise s is left unchanged, and scanValueList returns false.
+func scanValueList(s *Scanner) (int, bool) {
+	list := *s
+	for list.Scan() {
+		switch list.Token() {
+		case NUMBER, STRING, IDENT, OTHER, COMMENT:
+		case RPAREN:
+			*s = list
+			return list.end, true
+		default:
+			return 0, false
+		}
+	}
+	return 0, false
+}
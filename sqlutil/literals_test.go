@@ -0,0 +1,59 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package sqlutil
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplaceLiterals(t *testing.T) {
+	for _, test := range []struct {
+		input, expect string
+	}{
+		{"SELECT * FROM foo", "SELECT * FROM foo"},
+		{"SELECT * FROM foo WHERE id = 123", "SELECT * FROM foo WHERE id = ?"},
+		{"SELECT * FROM foo WHERE x = 1.5e-3 AND t1.y = -2", "SELECT * FROM foo WHERE x = ? AND t1.y = -?"},
+		{"UPDATE users SET password = 'hunter2' WHERE name = 'it''s me'", "UPDATE users SET password = ? WHERE name = ?"},
+		{`SELECT "col1", [col2] FROM "table" WHERE a = $1 AND b = ?`, `SELECT "col1", [col2] FROM "table" WHERE a = $1 AND b = ?`},
+		{"SELECT $tag$secret$tag$ -- comment 'x'", "SELECT ? -- comment 'x'"},
+		{"INSERT INTO foo VALUES (1, 'a'), (2, 'b')", "INSERT INTO foo VALUES (?, ?), (?, ?)"},
+		{"SELECT * FROM foo WHERE name = 'truncat", "SELECT * FROM foo WHERE name = ?"},
+		{"'leading' literal", "? literal"},
+	} {
+		assert.Equal(t, test.expect, ReplaceLiterals(test.input), test.input)
+	}
+}
+
+func TestObfuscate(t *testing.T) {
+	for _, test := range []struct {
+		input, expect string
+	}{
+		{"SELECT * FROM foo WHERE id IN (1, 2, 3)", "SELECT * FROM foo WHERE id IN (?)"},
+		{"SELECT * FROM foo WHERE id in ($1,$2) AND name NOT IN ('a', 'b')", "SELECT * FROM foo WHERE id in (?) AND name NOT IN (?)"},
+		{"SELECT * FROM foo WHERE id IN (?, ?) OR x = 'y'", "SELECT * FROM foo WHERE id IN (?) OR x = ?"},
+		{"SELECT * FROM foo WHERE id IN (SELECT id FROM bar WHERE x = 1)", "SELECT * FROM foo WHERE id IN (SELECT id FROM bar WHERE x = ?)"},
+		{"SELECT * FROM foo WHERE id IN (lower('A'), 'b')", "SELECT * FROM foo WHERE id IN (lower(?), ?)"},
+		{"INSERT INTO foo (a, b) VALUES (1, 'x')", "INSERT INTO foo (a, b) VALUES (?, ?)"},
+		{"SELECT * FROM foo WHERE id IN (1, 2", "SELECT * FROM foo WHERE id IN (?, ?"},
+		{"SELECT * FROM login WHERE 1=1", "SELECT * FROM login WHERE ?=?"},
+	} {
+		assert.Equal(t, test.expect, Obfuscate(test.input), test.input)
+	}
+}
@@ -0,0 +1,259 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apm // import "go.elastic.co/apm/v2"
+
+import (
+	"container/list"
+	"time"
+
+	"go.elastic.co/apm/v2/model"
+)
+
+// tailSamplingEntryOverhead is the approximate memory overhead of each
+// transaction tracked by tailSamplingBuffer, in addition to its spans.
+const tailSamplingEntryOverhead = 128
+
+// TailSampler provides a means of sampling the spans of a transaction
+// after the transaction has ended.
+//
+// When a TailSampler is configured (see Tracer.SetTailSampler), the spans
+// of sampled, local transactions are buffered until the transaction ends.
+// The TailSampler then decides whether to send the transaction along with
+// all of its buffered spans, or only the transaction. Transactions are
+// always sent, so that metrics such as throughput and error rates remain
+// accurate.
+//
+// Spans which end after their transaction has ended are sent without
+// consulting the TailSampler.
+type TailSampler interface {
+	// SampleTail indicates whether or not the spans of an ended
+	// transaction should be sent. This method will be invoked by
+	// the tracer's background goroutine, so it must not block.
+	SampleTail(TailSampleParams) bool
+}
+
+// TailSamplerFunc is a function type implementing TailSampler.
+type TailSamplerFunc func(TailSampleParams) bool
+
+// SampleTail returns f(p).
+func (f TailSamplerFunc) SampleTail(p TailSampleParams) bool {
+	return f(p)
+}
+
+// TailSampleParams holds parameters for TailSampler.SampleTail,
+// describing an ended transaction.
+type TailSampleParams struct {
+	// TraceContext holds the transaction's TraceContext.
+	TraceContext TraceContext
+
+	// Name holds the transaction name.
+	Name string
+
+	// Type holds the transaction type.
+	Type string
+
+	// Result holds the transaction result.
+	Result string
+
+	// Outcome holds the transaction outcome: "success",
+	// "failure", or "unknown".
+	Outcome string
+
+	// Duration holds the transaction duration.
+	Duration time.Duration
+
+	// Labels holds the transaction labels.
+	Labels model.IfaceMap
+
+	// Spans holds the number of buffered spans which will
+	// be discarded if SampleTail returns false.
+	Spans int
+}
+
+// NewDurationTailSampler returns a TailSampler which keeps the spans
+// of transactions whose duration is at least d.
+func NewDurationTailSampler(d time.Duration) TailSampler {
+	return TailSamplerFunc(func(p TailSampleParams) bool {
+		return p.Duration >= d
+	})
+}
+
+// NewOutcomeTailSampler returns a TailSampler which keeps the spans
+// of transactions with any of the given outcomes, e.g. "failure".
+func NewOutcomeTailSampler(outcomes ...string) TailSampler {
+	return TailSamplerFunc(func(p TailSampleParams) bool {
+		return containsString(outcomes, p.Outcome)
+	})
+}
+
+// NewResultTailSampler returns a TailSampler which keeps the spans
+// of transactions with any of the given results, e.g. "HTTP 5xx".
+func NewResultTailSampler(results ...string) TailSampler {
+	return TailSamplerFunc(func(p TailSampleParams) bool {
+		return containsString(results, p.Result)
+	})
+}
+
+// NewLabelTailSampler returns a TailSampler which keeps the spans
+// of transactions with the label key. If value is non-nil, then
+// the label must also have the given value.
+func NewLabelTailSampler(key string, value interface{}) TailSampler {
+	return TailSamplerFunc(func(p TailSampleParams) bool {
+		for _, label := range p.Labels {
+			if label.Key == key {
+				return value == nil || label.Value == value
+			}
+		}
+		return false
+	})
+}
+
+// NewRatioTailSampler returns a TailSampler which keeps the spans of
+// the given ratio of transactions. The decision is based on the value
+// of the transaction ID, in the same way as NewRatioSampler.
+//
+// If the ratio provided does not lie within the range [0,1.0],
+// NewRatioTailSampler will panic.
+func NewRatioTailSampler(r float64) TailSampler {
+	sampler := NewRatioSampler(r)
+	return TailSamplerFunc(func(p TailSampleParams) bool {
+		return sampler.Sample(SampleParams{TraceContext: p.TraceContext}).Sampled
+	})
+}
+
+// AnyTailSampler returns a TailSampler which keeps the spans of a
+// transaction if any of the given samplers keep them. For example,
+// to keep all slow or failed transactions, plus 1% of the rest:
+//
+//	AnyTailSampler(
+//		NewDurationTailSampler(time.Second),
+//		NewOutcomeTailSampler("failure"),
+//		NewRatioTailSampler(0.01),
+//	)
+func AnyTailSampler(samplers ...TailSampler) TailSampler {
+	return TailSamplerFunc(func(p TailSampleParams) bool {
+		for _, s := range samplers {
+			if s.SampleTail(p) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// tailSamplingBuffer holds the encoded spans of transactions that have
+// yet to end, pending a tail sampling decision.
+//
+// The total size of buffered spans is limited. When the limit would be
+// exceeded, the oldest transactions are evicted, and their spans are
+// sent without waiting for a decision; subsequent spans of evicted
+// transactions are not buffered.
+type tailSamplingBuffer struct {
+	size    int
+	entries map[SpanID]*list.Element
+	order   list.List // of *tailSamplingEntry, oldest first
+}
+
+type tailSamplingEntry struct {
+	transactionID SpanID
+	spans         [][]byte
+	size          int
+	evicted       bool
+}
+
+func newTailSamplingBuffer() *tailSamplingBuffer {
+	return &tailSamplingBuffer{entries: make(map[SpanID]*list.Element)}
+}
+
+// add buffers the encoded span for the given transaction, calling evict
+// with the spans of any transactions evicted to remain within maxSize.
+// If the transaction has previously been evicted, or the span alone
+// exceeds maxSize, add returns false and the span is not buffered.
+func (b *tailSamplingBuffer) add(transactionID SpanID, span []byte, maxSize int, evict func(spans [][]byte)) bool {
+	var entry *tailSamplingEntry
+	if elem, ok := b.entries[transactionID]; ok {
+		entry = elem.Value.(*tailSamplingEntry)
+		if entry.evicted {
+			return false
+		}
+	} else {
+		entry = &tailSamplingEntry{transactionID: transactionID, size: tailSamplingEntryOverhead}
+		b.entries[transactionID] = b.order.PushBack(entry)
+		b.size += entry.size
+	}
+	if len(span)+tailSamplingEntryOverhead > maxSize {
+		b.evictEntry(b.entries[transactionID], evict)
+		return false
+	}
+	for b.size+len(span) > maxSize {
+		b.evictEntry(b.order.Front(), evict)
+	}
+	if entry.evicted {
+		return false
+	}
+	entry.spans = append(entry.spans, append([]byte(nil), span...))
+	entry.size += len(span)
+	b.size += len(span)
+	return true
+}
+
+// evictEntry evicts the transaction's spans, and marks it as evicted so
+// that subsequent spans are not buffered. Entries which have already been
+// evicted are removed altogether.
+func (b *tailSamplingBuffer) evictEntry(elem *list.Element, evict func(spans [][]byte)) {
+	entry := elem.Value.(*tailSamplingEntry)
+	if entry.evicted {
+		b.order.Remove(elem)
+		delete(b.entries, entry.transactionID)
+		b.size -= entry.size
+		return
+	}
+	if len(entry.spans) > 0 {
+		evict(entry.spans)
+	}
+	b.size -= entry.size - tailSamplingEntryOverhead
+	entry.spans = nil
+	entry.size = tailSamplingEntryOverhead
+	entry.evicted = true
+	// Move the entry to the back, so that evicted markers are
+	// removed only after other transactions have been evicted.
+	b.order.MoveToBack(elem)
+}
+
+// remove removes the transaction's entry from the buffer, returning its
+// buffered spans, and whether the transaction had been evicted.
+func (b *tailSamplingBuffer) remove(transactionID SpanID) (spans [][]byte, evicted bool) {
+	elem, ok := b.entries[transactionID]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*tailSamplingEntry)
+	b.order.Remove(elem)
+	delete(b.entries, transactionID)
+	b.size -= entry.size
+	return entry.spans, entry.evicted
+}
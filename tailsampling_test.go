@@ -0,0 +1,165 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package apm_test
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.elastic.co/apm/v2"
+	"go.elastic.co/apm/v2/model"
+	"go.elastic.co/apm/v2/transport/transporttest"
+)
+
+func TestTracerTailSampler(t *testing.T) {
+	tracer, recorder := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+
+	var params []apm.TailSampleParams
+	tracer.SetTailSampler(apm.TailSamplerFunc(func(p apm.TailSampleParams) bool {
+		params = append(params, p)
+		return p.Outcome == "failure"
+	}))
+
+	startTransaction := func(name, outcome string) {
+		tx := tracer.StartTransaction(name, "type")
+		tx.Context.SetLabel("foo", "bar")
+		tx.Outcome = outcome
+		tx.StartSpan("span1", "type", nil).End()
+		tx.StartSpan("span2", "type", nil).End()
+		tx.End()
+	}
+	startTransaction("kept", "failure")
+	startTransaction("discarded", "success")
+	tracer.Flush(nil)
+
+	payloads := recorder.Payloads()
+	require.Len(t, payloads.Transactions, 2)
+	require.Len(t, payloads.Spans, 2)
+	for _, span := range payloads.Spans {
+		assert.Equal(t, payloads.Transactions[0].ID, span.TransactionID)
+	}
+	assert.Equal(t, "kept", payloads.Transactions[0].Name)
+	assert.Equal(t, model.SpanCount{Started: 2}, payloads.Transactions[0].SpanCount)
+	assert.Equal(t, "discarded", payloads.Transactions[1].Name)
+	assert.Equal(t, model.SpanCount{Started: 2, Dropped: 2}, payloads.Transactions[1].SpanCount)
+
+	require.Len(t, params, 2)
+	assert.Equal(t, "kept", params[0].Name)
+	assert.Equal(t, "type", params[0].Type)
+	assert.Equal(t, "failure", params[0].Outcome)
+	assert.Equal(t, 2, params[0].Spans)
+	assert.Equal(t, model.IfaceMap{{Key: "foo", Value: "bar"}}, params[0].Labels)
+
+	stats := tracer.Stats()
+	assert.Equal(t, apm.TracerStatsTailSampling{
+		TransactionsKept:      1,
+		TransactionsDiscarded: 1,
+		SpansDiscarded:        2,
+	}, stats.TailSampling)
+}
+
+func TestTracerTailSamplerSpanAfterTransaction(t *testing.T) {
+	tracer, recorder := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+	tracer.SetTailSampler(apm.TailSamplerFunc(func(apm.TailSampleParams) bool {
+		return false
+	}))
+
+	tx := tracer.StartTransaction("name", "type")
+	span := tx.StartSpan("span", "type", nil)
+	tx.End()
+	span.End()
+	tracer.Flush(nil)
+
+	// The span ended after the transaction, so it is not
+	// subject to the tail sampling decision.
+	payloads := recorder.Payloads()
+	require.Len(t, payloads.Transactions, 1)
+	require.Len(t, payloads.Spans, 1)
+	assert.Equal(t, payloads.Transactions[0].ID, payloads.Spans[0].TransactionID)
+}
+
+func TestTracerTailSamplerBufferSize(t *testing.T) {
+	os.Setenv("ELASTIC_APM_TAIL_SAMPLING_BUFFER_SIZE", "10KB")
+	defer os.Unsetenv("ELASTIC_APM_TAIL_SAMPLING_BUFFER_SIZE")
+
+	tracer, recorder := transporttest.NewRecorderTracer()
+	defer tracer.Close()
+	tracer.SetTailSampler(apm.TailSamplerFunc(func(apm.TailSampleParams) bool {
+		return false
+	}))
+
+	tx1 := tracer.StartTransaction("tx1", "type")
+	for i := 0; i < 100; i++ {
+		tx1.StartSpan("span", "type", nil).End()
+	}
+	tx2 := tracer.StartTransaction("tx2", "type")
+	tx2.StartSpan("span", "type", nil).End()
+	tx1.End()
+	tx2.End()
+	tracer.Flush(nil)
+
+	// tx1's spans exceed the buffer size, so they are all sent
+	// before the transaction ends. tx2's span is discarded.
+	payloads := recorder.Payloads()
+	require.Len(t, payloads.Transactions, 2)
+	assert.Len(t, payloads.Spans, 100)
+	for _, span := range payloads.Spans {
+		assert.Equal(t, payloads.Transactions[0].ID, span.TransactionID)
+	}
+
+	stats := tracer.Stats()
+	assert.NotZero(t, stats.TailSampling.SpansEvicted)
+	assert.Equal(t, uint64(1), stats.TailSampling.TransactionsDiscarded)
+	assert.Equal(t, uint64(1), stats.TailSampling.SpansDiscarded)
+}
+
+func TestTailSamplers(t *testing.T) {
+	params := apm.TailSampleParams{
+		TraceContext: apm.TraceContext{
+			Span: apm.SpanID{0, 0, 0, 0, 0, 0, 0, 1},
+		},
+		Result:   "HTTP 5xx",
+		Outcome:  "failure",
+		Duration: time.Second,
+		Labels:   model.IfaceMap{{Key: "foo", Value: "bar"}},
+	}
+	assert.True(t, apm.NewDurationTailSampler(time.Second).SampleTail(params))
+	assert.False(t, apm.NewDurationTailSampler(2*time.Second).SampleTail(params))
+	assert.True(t, apm.NewOutcomeTailSampler("unknown", "failure").SampleTail(params))
+	assert.False(t, apm.NewOutcomeTailSampler("success").SampleTail(params))
+	assert.True(t, apm.NewResultTailSampler("HTTP 5xx").SampleTail(params))
+	assert.False(t, apm.NewResultTailSampler("HTTP 2xx").SampleTail(params))
+	assert.True(t, apm.NewLabelTailSampler("foo", nil).SampleTail(params))
+	assert.True(t, apm.NewLabelTailSampler("foo", "bar").SampleTail(params))
+	assert.False(t, apm.NewLabelTailSampler("foo", "baz").SampleTail(params))
+	assert.False(t, apm.NewLabelTailSampler("bar", nil).SampleTail(params))
+	assert.True(t, apm.NewRatioTailSampler(1).SampleTail(params))
+	assert.False(t, apm.NewRatioTailSampler(0).SampleTail(params))
+
+	assert.True(t, apm.AnyTailSampler(
+		apm.NewRatioTailSampler(0),
+		apm.NewOutcomeTailSampler("failure"),
+	).SampleTail(params))
+	assert.False(t, apm.AnyTailSampler().SampleTail(params))
+}
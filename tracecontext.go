@@ -78,6 +78,9 @@ type TraceContext struct {
 
 	// State holds the trace state.
 	State TraceState
+
+	// Baggage holds the W3C baggage propagated with the trace.
+	Baggage Baggage
 }
 
 // TraceID identifies a trace forest.
@@ -120,7 +120,8 @@ type TracerOptions struct {
 	// Transport holds the transport to use for sending events.
 	//
 	// If Transport is nil, a new HTTP transport will be created from environment
-	// variables.
+	// variables. If ELASTIC_APM_TRANSPORT is set to "otlp", a new OTLP transport
+	// will be created instead; see transport.NewOTLPTransport.
 	//
 	// If Transport implements apmconfig.Watcher, the tracer will begin watching
 	// for remote changes immediately. This behaviour can be disabled by setting
@@ -140,9 +141,11 @@ type TracerOptions struct {
 	bufferSize                int
 	metricsBufferSize         int
 	sampler                   Sampler
+	sampleRules               sampleRules
 	sanitizedFieldNames       wildcard.Matchers
 	disabledMetrics           wildcard.Matchers
 	ignoreTransactionURLs     wildcard.Matchers
+	baggageToAttach           wildcard.Matchers
 	continuationStrategy      string
 	captureHeaders            bool
 	captureBody               CaptureBodyMode
@@ -153,6 +156,7 @@ type TracerOptions struct {
 	configWatcher             apmconfig.Watcher
 	breakdownMetrics          bool
 	propagateLegacyHeader     bool
+	obfuscateSQL              bool
 	profileSender             profileSender
 	versionGetter             majorVersionGetter
 	cpuProfileInterval        time.Duration
@@ -161,6 +165,9 @@ type TracerOptions struct {
 	exitSpanMinDuration       time.Duration
 	compressionOptions        compressionOptions
 	globalLabels              model.StringMap
+	spool                     *sharedSpool
+	tailSamplingBufferSize    int
+	redaction                 redaction
 }
 
 // initDefaults updates opts with default values.
@@ -228,6 +235,11 @@ func (opts *TracerOptions) initDefaults(continueOnError bool) error {
 		sampler = nil
 	}
 
+	sampleRules, err := initialSampleRules()
+	if failed(err) {
+		sampleRules = nil
+	}
+
 	captureHeaders, err := initialCaptureHeaders()
 	if failed(err) {
 		captureHeaders = defaultCaptureHeaders
@@ -273,6 +285,11 @@ func (opts *TracerOptions) initDefaults(continueOnError bool) error {
 		propagateLegacyHeader = true
 	}
 
+	obfuscateSQL, err := initialObfuscateSQL()
+	if failed(err) {
+		obfuscateSQL = defaultObfuscateSQL
+	}
+
 	cpuProfileInterval, cpuProfileDuration, err := initialCPUProfileIntervalDuration()
 	if failed(err) {
 		cpuProfileInterval = 0
@@ -293,6 +310,21 @@ func (opts *TracerOptions) initDefaults(continueOnError bool) error {
 		continuationStrategy = defaultContinuationStrategy
 	}
 
+	spool, err := initialSpool()
+	if failed(err) {
+		spool = nil
+	}
+
+	tailSamplingBufferSize, err := initialTailSamplingBufferSize()
+	if failed(err) {
+		tailSamplingBufferSize = int(defaultTailSamplingBufferSize)
+	}
+
+	redaction, err := initialRedaction()
+	if failed(err) {
+		redaction = defaultRedaction
+	}
+
 	if opts.ServiceName != "" {
 		err := validateServiceName(opts.ServiceName)
 		if failed(err) {
@@ -321,13 +353,16 @@ func (opts *TracerOptions) initDefaults(continueOnError bool) error {
 	}
 
 	if len(errs) != 0 && !continueOnError {
+		if spool != nil {
+			releaseSharedSpool(spool)
+		}
 		return errs[0]
 	}
 	for _, err := range errs {
 		log.Printf("[apm]: %s", err)
 	}
 
-	opts.globalLabels = parseGlobalLabels()
+	opts.globalLabels = initialGlobalLabels()
 	opts.requestDuration = requestDuration
 	opts.metricsInterval = metricsInterval
 	opts.requestSize = requestSize
@@ -340,9 +375,11 @@ func (opts *TracerOptions) initDefaults(continueOnError bool) error {
 		sameKindMaxDuration:   spanCompressionSameKindMaxDuration,
 	}
 	opts.sampler = sampler
+	opts.sampleRules = sampleRules
 	opts.sanitizedFieldNames = initialSanitizedFieldNames()
 	opts.disabledMetrics = initialDisabledMetrics()
 	opts.ignoreTransactionURLs = initialIgnoreTransactionURLs()
+	opts.baggageToAttach = initialBaggageToAttach()
 	opts.breakdownMetrics = breakdownMetricsEnabled
 	opts.captureHeaders = captureHeaders
 	opts.captureBody = captureBody
@@ -351,8 +388,12 @@ func (opts *TracerOptions) initDefaults(continueOnError bool) error {
 	opts.active = active
 	opts.recording = recording
 	opts.propagateLegacyHeader = propagateLegacyHeader
+	opts.obfuscateSQL = obfuscateSQL
 	opts.exitSpanMinDuration = exitSpanMinDuration
 	opts.continuationStrategy = continuationStrategy
+	opts.spool = spool
+	opts.tailSamplingBufferSize = tailSamplingBufferSize
+	opts.redaction = redaction
 	if centralConfigEnabled {
 		if cw, ok := opts.Transport.(apmconfig.Watcher); ok {
 			opts.configWatcher = cw
@@ -408,9 +449,10 @@ type Tracer struct {
 	configWatcher     chan apmconfig.Watcher
 	events            chan tracerEvent
 	breakdownMetrics  *breakdownMetrics
+	agentMetrics      *agentMetrics
 	profileSender     profileSender
 	versionGetter     majorVersionGetter
-	globalLabels      model.StringMap
+	spoolTransport    *spoolTransport
 
 	// stats is heap-allocated to ensure correct alignment for atomic access.
 	stats *TracerStats
@@ -465,6 +507,7 @@ func newTracer(opts TracerOptions) *Tracer {
 		events:            make(chan tracerEvent, tracerEventChannelCap),
 		active:            1,
 		breakdownMetrics:  newBreakdownMetrics(),
+		agentMetrics:      newAgentMetrics(),
 		stats:             &TracerStats{},
 		bufferSize:        opts.bufferSize,
 		metricsBufferSize: opts.metricsBufferSize,
@@ -473,9 +516,11 @@ func newTracer(opts TracerOptions) *Tracer {
 		instrumentationConfigInternal: &instrumentationConfig{
 			local: make(map[string]func(*instrumentationConfigValues)),
 		},
-		globalLabels: opts.globalLabels,
 	}
-	t.breakdownMetrics.enabled = opts.breakdownMetrics
+	if opts.spool != nil {
+		t.spoolTransport = newSpoolTransport(opts.Transport, opts.spool, t.stats)
+		t.transport = t.spoolTransport
+	}
 	// Initialise local transaction config.
 	t.setLocalInstrumentationConfig(envRecording, func(cfg *instrumentationConfigValues) {
 		cfg.recording = opts.recording
@@ -486,6 +531,9 @@ func newTracer(opts TracerOptions) *Tracer {
 	t.setLocalInstrumentationConfig(envCaptureHeaders, func(cfg *instrumentationConfigValues) {
 		cfg.captureHeaders = opts.captureHeaders
 	})
+	t.setLocalInstrumentationConfig(envBreakdownMetrics, func(cfg *instrumentationConfigValues) {
+		cfg.breakdownMetrics = opts.breakdownMetrics
+	})
 	t.setLocalInstrumentationConfig(envMaxSpans, func(cfg *instrumentationConfigValues) {
 		cfg.maxSpans = opts.maxSpans
 	})
@@ -501,6 +549,9 @@ func newTracer(opts TracerOptions) *Tracer {
 	t.setLocalInstrumentationConfig(envTransactionSampleRate, func(cfg *instrumentationConfigValues) {
 		cfg.sampler = opts.sampler
 	})
+	t.setLocalInstrumentationConfig(envTransactionSampleRules, func(cfg *instrumentationConfigValues) {
+		cfg.sampleRules = opts.sampleRules
+	})
 	t.setLocalInstrumentationConfig(envSpanStackTraceMinDuration, func(cfg *instrumentationConfigValues) {
 		cfg.spanStackTraceMinDuration = opts.spanStackTraceMinDuration
 	})
@@ -510,12 +561,18 @@ func newTracer(opts TracerOptions) *Tracer {
 	t.setLocalInstrumentationConfig(envUseElasticTraceparentHeader, func(cfg *instrumentationConfigValues) {
 		cfg.propagateLegacyHeader = opts.propagateLegacyHeader
 	})
+	t.setLocalInstrumentationConfig(envObfuscateSQL, func(cfg *instrumentationConfigValues) {
+		cfg.obfuscateSQL = opts.obfuscateSQL
+	})
 	t.setLocalInstrumentationConfig(envSanitizeFieldNames, func(cfg *instrumentationConfigValues) {
 		cfg.sanitizedFieldNames = opts.sanitizedFieldNames
 	})
 	t.setLocalInstrumentationConfig(envIgnoreURLs, func(cfg *instrumentationConfigValues) {
 		cfg.ignoreTransactionURLs = opts.ignoreTransactionURLs
 	})
+	t.setLocalInstrumentationConfig(envBaggageToAttach, func(cfg *instrumentationConfigValues) {
+		cfg.baggageToAttach = opts.baggageToAttach
+	})
 	t.setLocalInstrumentationConfig(envExitSpanMinDuration, func(cfg *instrumentationConfigValues) {
 		cfg.exitSpanMinDuration = opts.exitSpanMinDuration
 	})
@@ -540,13 +597,32 @@ func newTracer(opts TracerOptions) *Tracer {
 	go t.loop()
 	t.configCommands <- func(cfg *tracerConfig) {
 		cfg.recording = opts.recording
-		cfg.cpuProfileInterval = opts.cpuProfileInterval
-		cfg.cpuProfileDuration = opts.cpuProfileDuration
-		cfg.heapProfileInterval = opts.heapProfileInterval
-		cfg.metricsInterval = opts.metricsInterval
-		cfg.requestDuration = opts.requestDuration
-		cfg.requestSize = opts.requestSize
-		cfg.disabledMetrics = opts.disabledMetrics
+		cfg.setLocal(envCPUProfileInterval, func(cfg *tracerConfig) {
+			cfg.cpuProfileInterval = opts.cpuProfileInterval
+		})
+		cfg.setLocal(envCPUProfileDuration, func(cfg *tracerConfig) {
+			cfg.cpuProfileDuration = opts.cpuProfileDuration
+		})
+		cfg.setLocal(envHeapProfileInterval, func(cfg *tracerConfig) {
+			cfg.heapProfileInterval = opts.heapProfileInterval
+		})
+		cfg.setLocal(envMetricsInterval, func(cfg *tracerConfig) {
+			cfg.metricsInterval = opts.metricsInterval
+		})
+		cfg.setLocal(envAPIRequestTime, func(cfg *tracerConfig) {
+			cfg.requestDuration = opts.requestDuration
+		})
+		cfg.setLocal(envAPIRequestSize, func(cfg *tracerConfig) {
+			cfg.requestSize = opts.requestSize
+		})
+		cfg.setLocal(envDisableMetrics, func(cfg *tracerConfig) {
+			cfg.disabledMetrics = opts.disabledMetrics
+		})
+		cfg.setLocal(envGlobalLabels, func(cfg *tracerConfig) {
+			cfg.globalLabels = opts.globalLabels
+		})
+		cfg.tailSamplingBufferSize = opts.tailSamplingBufferSize
+		cfg.redaction = opts.redaction
 		cfg.metricsGatherers = []MetricsGatherer{newBuiltinMetricsGatherer(t)}
 		if logger := apmlog.DefaultLogger(); logger != nil {
 			cfg.logger = logger
@@ -571,6 +647,33 @@ type tracerConfig struct {
 	cpuProfileDuration  time.Duration
 	cpuProfileInterval  time.Duration
 	heapProfileInterval time.Duration
+	globalLabels        model.StringMap
+
+	tailSampler            TailSampler
+	tailSamplingBufferSize int
+	processors             []Processor
+	redaction              redaction
+
+	// local holds functions for setting tracerConfig fields to the most
+	// recently, locally specified configuration, keyed by environment
+	// variable name. This is used to revert remote config.
+	local map[string]tracerConfigCommand
+
+	// remote holds the environment variable keys for applied remote config.
+	remote map[string]struct{}
+}
+
+// setLocal records f as the local configuration for the specified
+// environment variable key, and applies it unless overridden by
+// remote configuration.
+func (cfg *tracerConfig) setLocal(envKey string, f tracerConfigCommand) {
+	if cfg.local == nil {
+		cfg.local = make(map[string]tracerConfigCommand)
+	}
+	cfg.local[envKey] = f
+	if _, ok := cfg.remote[envKey]; !ok {
+		f(cfg)
+	}
 }
 
 type tracerConfigCommand func(*tracerConfig)
@@ -630,17 +733,29 @@ func (t *Tracer) ShouldPropagateLegacyHeader() bool {
 // SetRequestDuration sets the maximum amount of time to keep a request open
 // to the APM server for streaming data before closing the stream and starting
 // a new request.
+//
+// Configuration via Kibana takes precedence over local configuration, so
+// if the request duration has been received from Kibana, this call will
+// not have any effect until/unless that configuration has been removed.
 func (t *Tracer) SetRequestDuration(d time.Duration) {
 	t.sendConfigCommand(func(cfg *tracerConfig) {
-		cfg.requestDuration = d
+		cfg.setLocal(envAPIRequestTime, func(cfg *tracerConfig) {
+			cfg.requestDuration = d
+		})
 	})
 }
 
 // SetMetricsInterval sets the metrics interval -- the amount of time in
 // between metrics samples being gathered.
+//
+// Configuration via Kibana takes precedence over local configuration, so
+// if the metrics interval has been received from Kibana, this call will
+// not have any effect until/unless that configuration has been removed.
 func (t *Tracer) SetMetricsInterval(d time.Duration) {
 	t.sendConfigCommand(func(cfg *tracerConfig) {
-		cfg.metricsInterval = d
+		cfg.setLocal(envMetricsInterval, func(cfg *tracerConfig) {
+			cfg.metricsInterval = d
+		})
 	})
 }
 
@@ -679,6 +794,26 @@ func (t *Tracer) SetSanitizedFieldNames(patterns ...string) error {
 	return nil
 }
 
+// SetBaggageToAttach sets the wildcard patterns that will be used to
+// match the keys of W3C baggage members propagated with incoming trace
+// context. Matching baggage members will be recorded as labels on
+// transactions, spans, and errors, with the label key "baggage.<key>".
+// If SetBaggageToAttach is called with no arguments, then no baggage
+// will be recorded as labels.
+func (t *Tracer) SetBaggageToAttach(patterns ...string) error {
+	var matchers wildcard.Matchers
+	if len(patterns) != 0 {
+		matchers = make(wildcard.Matchers, len(patterns))
+		for i, p := range patterns {
+			matchers[i] = configutil.ParseWildcardPattern(p)
+		}
+	}
+	t.setLocalInstrumentationConfig(envBaggageToAttach, func(cfg *instrumentationConfigValues) {
+		cfg.baggageToAttach = matchers
+	})
+	return nil
+}
+
 // SetIgnoreTransactionURLs sets the wildcard patterns that will be used to
 // ignore transactions with matching URLs.
 func (t *Tracer) SetIgnoreTransactionURLs(pattern string) error {
@@ -770,6 +905,100 @@ func (t *Tracer) SetSampler(s Sampler) {
 	})
 }
 
+// SetTailSampler sets the tail sampler for the tracer, which decides
+// whether to send the spans of each sampled transaction after the
+// transaction has ended. If s is nil, tail-based sampling is disabled.
+//
+// While a tail sampler is set, the spans of in-flight transactions are
+// buffered in memory, up to the limit defined by the environment variable
+// ELASTIC_APM_TAIL_SAMPLING_BUFFER_SIZE. If the limit is reached, the
+// spans of the oldest in-flight transactions are sent without waiting
+// for a sampling decision.
+func (t *Tracer) SetTailSampler(s TailSampler) {
+	t.sendConfigCommand(func(cfg *tracerConfig) {
+		cfg.tailSampler = s
+	})
+}
+
+// SetProcessors sets the processors for the tracer, which are invoked
+// in order for each transaction, span, and error event before it is
+// encoded and sent to the APM Server. Processors may modify events,
+// or drop them by returning false. Calling SetProcessors with no
+// arguments removes all processors.
+func (t *Tracer) SetProcessors(p ...Processor) {
+	processors := make([]Processor, len(p))
+	copy(processors, p)
+	t.sendConfigCommand(func(cfg *tracerConfig) {
+		cfg.processors = processors
+	})
+}
+
+// SetRedactFields sets the event fields to redact before events are
+// sent to the APM Server, overriding ELASTIC_APM_REDACT_FIELDS. The
+// supported fields are "db.statement", "url.query", and "labels".
+// If SetRedactFields is called with no arguments, no fields will be
+// redacted.
+//
+// The values of query parameters and labels whose keys match the
+// sanitized field names (see SetSanitizedFieldNames) are redacted,
+// as are the values of matching fields in JSON database statements.
+// String and numeric literals are removed from other, SQL, database
+// statements. Text matching the patterns set by SetRedactPatterns is
+// masked in all of the redacted fields.
+func (t *Tracer) SetRedactFields(fields ...string) error {
+	redactFields, err := parseRedactFields(fields)
+	if err != nil {
+		return err
+	}
+	t.sendConfigCommand(func(cfg *tracerConfig) {
+		cfg.redaction.fields = redactFields
+	})
+	return nil
+}
+
+// SetRedactPatterns sets the regular expressions used for masking values
+// in the fields set with SetRedactFields, overriding the environment
+// variable ELASTIC_APM_REDACT_PATTERNS. Each pattern may be one of the
+// names "credit_card", "bearer_token", or "jwt", for a built-in pattern,
+// or otherwise a regular expression as accepted by regexp.Compile.
+//
+// Text matching any of the patterns is replaced with "[REDACTED]".
+func (t *Tracer) SetRedactPatterns(patterns ...string) error {
+	redactPatterns, err := parseRedactPatterns(patterns)
+	if err != nil {
+		return err
+	}
+	t.sendConfigCommand(func(cfg *tracerConfig) {
+		cfg.redaction.patterns = redactPatterns
+	})
+	return nil
+}
+
+// SetTransactionSampleRules sets the rules for sampling transactions by
+// name and type, overriding the sampler set with SetSampler for matching
+// transactions.
+//
+// rules is a comma-separated list of rules of the form
+// "[<type>|]<name>=<rate>", where type and name are wildcard patterns,
+// and rate is a sample rate in the range [0,1.0]. The first matching
+// rule applies. For example, "GET /health*=0, request|POST /checkout=1.0"
+// samples no health checks and all checkout requests, and samples other
+// transactions according to the sampler set with SetSampler.
+//
+// Configuration via Kibana takes precedence over local configuration, so
+// if sampling rules have been received from Kibana, this call will not
+// have any effect until/unless that configuration has been removed.
+func (t *Tracer) SetTransactionSampleRules(rules string) error {
+	parsed, err := parseSampleRules(envTransactionSampleRules, rules)
+	if err != nil {
+		return err
+	}
+	t.setLocalInstrumentationConfig(envTransactionSampleRules, func(cfg *instrumentationConfigValues) {
+		cfg.sampleRules = parsed
+	})
+	return nil
+}
+
 // SetMaxSpans sets the maximum number of spans that will be added
 // to a transaction before dropping spans.
 //
@@ -807,7 +1036,7 @@ func (t *Tracer) SetSpanCompressionSameKindMaxDuration(v time.Duration) {
 // SetSpanStackTraceMinDuration sets the minimum duration for a span after which
 // we will capture its stack frames.
 func (t *Tracer) SetSpanStackTraceMinDuration(d time.Duration) {
-	t.setLocalInstrumentationConfig(envMaxSpans, func(cfg *instrumentationConfigValues) {
+	t.setLocalInstrumentationConfig(envSpanStackTraceMinDuration, func(cfg *instrumentationConfigValues) {
 		cfg.spanStackTraceMinDuration = d
 	})
 }
@@ -815,21 +1044,35 @@ func (t *Tracer) SetSpanStackTraceMinDuration(d time.Duration) {
 // SetStackTraceLimit sets the the maximum number of stack frames to collect
 // for each stack trace. If limit is negative, then all frames will be collected.
 func (t *Tracer) SetStackTraceLimit(limit int) {
-	t.setLocalInstrumentationConfig(envMaxSpans, func(cfg *instrumentationConfigValues) {
+	t.setLocalInstrumentationConfig(envStackTraceLimit, func(cfg *instrumentationConfigValues) {
 		cfg.stackTraceLimit = limit
 	})
 }
 
 // SetCaptureHeaders enables or disables capturing of HTTP headers.
 func (t *Tracer) SetCaptureHeaders(capture bool) {
-	t.setLocalInstrumentationConfig(envMaxSpans, func(cfg *instrumentationConfigValues) {
+	t.setLocalInstrumentationConfig(envCaptureHeaders, func(cfg *instrumentationConfigValues) {
 		cfg.captureHeaders = capture
 	})
 }
 
+// SetObfuscateSQL enables or disables obfuscation of SQL statements
+// recorded by database instrumentation modules, such as apmsql. When
+// enabled, string and numeric literals in statements are replaced with
+// "?", and lists of values following IN are collapsed to a single "?".
+//
+// Configuration via Kibana takes precedence over local configuration, so
+// if obfuscate_sql has been configured via Kibana, this call will not have
+// any effect until/unless that configuration has been removed.
+func (t *Tracer) SetObfuscateSQL(obfuscate bool) {
+	t.setLocalInstrumentationConfig(envObfuscateSQL, func(cfg *instrumentationConfigValues) {
+		cfg.obfuscateSQL = obfuscate
+	})
+}
+
 // SetCaptureBody sets the HTTP request body capture mode.
 func (t *Tracer) SetCaptureBody(mode CaptureBodyMode) {
-	t.setLocalInstrumentationConfig(envMaxSpans, func(cfg *instrumentationConfigValues) {
+	t.setLocalInstrumentationConfig(envCaptureBody, func(cfg *instrumentationConfigValues) {
 		cfg.captureBody = mode
 	})
 }
@@ -875,6 +1118,9 @@ func (t *Tracer) loop() {
 	ctx, cancelContext := context.WithCancel(context.Background())
 	defer cancelContext()
 	defer close(t.closed)
+	if t.spoolTransport != nil {
+		defer t.spoolTransport.close()
+	}
 	defer atomic.StoreInt32(&t.active, 0)
 
 	var req iochan.ReadRequest
@@ -888,6 +1134,7 @@ func (t *Tracer) loop() {
 	zlibClosed := false
 	iochanReader := iochan.NewReader()
 	requestBytesRead := 0
+	var requestClosed time.Time
 	requestActive := false
 	closeRequest := false
 	flushRequest := false
@@ -932,6 +1179,7 @@ func (t *Tracer) loop() {
 
 	var breakdownMetricsLimitWarningLogged bool
 	var stats TracerStats
+	var agentStats agentStats
 	var metrics Metrics
 	var sentMetrics chan<- struct{}
 	var gatheringMetrics bool
@@ -980,6 +1228,10 @@ func (t *Tracer) loop() {
 			oldMetricsInterval = cfg.metricsInterval
 		}
 		cmd(&cfg)
+		// Global labels are encoded in the metadata, which may
+		// have changed; encode the metadata again for the next
+		// request.
+		metadata = nil
 		var metricsInterval, cpuProfileInterval, cpuProfileDuration, heapProfileInterval time.Duration
 		if cfg.recording {
 			metricsInterval = cfg.metricsInterval
@@ -1027,7 +1279,7 @@ func (t *Tracer) loop() {
 		case cw := <-t.configWatcher:
 			if configChanges != nil {
 				stopConfigWatcher()
-				t.updateRemoteConfig(cfg.logger, lastConfigChange, nil)
+				handleTracerConfigCommand(t.updateRemoteConfig(cfg.logger, lastConfigChange, nil))
 				lastConfigChange = nil
 				configChanges = nil
 			}
@@ -1057,9 +1309,11 @@ func (t *Tracer) loop() {
 					cfg.logger.Errorf("config request failed: %s", change.Err)
 				}
 			} else {
-				t.updateRemoteConfig(cfg.logger, lastConfigChange, change.Attrs)
+				updateTracerConfig := t.updateRemoteConfig(cfg.logger, lastConfigChange, change.Attrs)
 				lastConfigChange = change.Attrs
+				agentStats.configReloads++
 				handleTracerConfigCommand(func(cfg *tracerConfig) {
+					updateTracerConfig(cfg)
 					cfg.recording = t.instrumentationConfig().recording
 				})
 			}
@@ -1069,6 +1323,7 @@ func (t *Tracer) loop() {
 		case event := <-t.events:
 			switch event.eventType {
 			case transactionEvent:
+				agentStats.transactionsQueued++
 				if !t.breakdownMetrics.recordTransaction(event.tx.TransactionData) {
 					if !breakdownMetricsLimitWarningLogged && cfg.logger != nil {
 						cfg.logger.Warningf("%s", breakdownMetricsLimitWarning)
@@ -1083,8 +1338,10 @@ func (t *Tracer) loop() {
 					modelWriter.writeTransaction(event.tx.Transaction, event.tx.TransactionData)
 				}
 			case spanEvent:
-				modelWriter.writeSpan(event.span.Span, event.span.SpanData)
+				agentStats.spansQueued++
+				modelWriter.writeSpan(event.span.Span, event.span.SpanData, event.span.transactionActive)
 			case errorEvent:
+				agentStats.errorsQueued++
 				modelWriter.writeError(event.err)
 				// Flush the buffer to transmit the error immediately.
 				flushRequest = true
@@ -1114,11 +1371,11 @@ func (t *Tracer) loop() {
 				metricsTimer.Reset(cfg.metricsInterval)
 			}
 		case <-cpuProfilingState.timer.C:
-			cpuProfilingState.start(ctx, cfg.logger, t.metadataReader())
+			cpuProfilingState.start(ctx, cfg.logger, t.metadataReader(cfg.globalLabels))
 		case <-cpuProfilingState.finished:
 			cpuProfilingState.resetTimer()
 		case <-heapProfilingState.timer.C:
-			heapProfilingState.start(ctx, cfg.logger, t.metadataReader())
+			heapProfilingState.start(ctx, cfg.logger, t.metadataReader(cfg.globalLabels))
 		case <-heapProfilingState.finished:
 			heapProfilingState.resetTimer()
 		case flushed = <-t.forceFlush:
@@ -1127,6 +1384,7 @@ func (t *Tracer) loop() {
 				event := <-t.events
 				switch event.eventType {
 				case transactionEvent:
+					agentStats.transactionsQueued++
 					if !t.breakdownMetrics.recordTransaction(event.tx.TransactionData) {
 						if !breakdownMetricsLimitWarningLogged && cfg.logger != nil {
 							cfg.logger.Warningf("%s", breakdownMetricsLimitWarning)
@@ -1141,8 +1399,10 @@ func (t *Tracer) loop() {
 						modelWriter.writeTransaction(event.tx.Transaction, event.tx.TransactionData)
 					}
 				case spanEvent:
-					modelWriter.writeSpan(event.span.Span, event.span.SpanData)
+					agentStats.spansQueued++
+					modelWriter.writeSpan(event.span.Span, event.span.SpanData, event.span.transactionActive)
 				case errorEvent:
+					agentStats.errorsQueued++
 					modelWriter.writeError(event.err)
 				}
 			}
@@ -1153,8 +1413,19 @@ func (t *Tracer) loop() {
 			closeRequest = true
 		case req = <-iochanReader.C:
 		case err := <-requestResult:
+			agentStats.requests++
+			agentStats.requestBytes += uint64(requestBytesRead)
+			if !requestClosed.IsZero() {
+				agentStats.observeRequestLatency(time.Since(requestClosed))
+				requestClosed = time.Time{}
+			}
 			if err != nil {
 				stats.Errors.SendStream++
+				var statusCode int
+				if err, ok := err.(*transport.HTTPError); ok {
+					statusCode = err.Response.StatusCode
+				}
+				agentStats.observeRequestError(statusCode)
 				gracePeriod = nextGracePeriod(gracePeriod)
 				if cfg.logger != nil {
 					logf := cfg.logger.Debugf
@@ -1225,9 +1496,11 @@ func (t *Tracer) loop() {
 			t.stats.accumulate(stats)
 			stats = TracerStats{}
 		}
+		agentStats.observeBuffer(buffer)
 
 		if gatherMetrics {
 			gatheringMetrics = true
+			t.agentMetrics.update(&agentStats)
 			metrics.disabled = cfg.disabledMetrics
 			t.gatherMetrics(ctx, cfg.metricsGatherers, &metrics, cfg.logger, gatheredMetrics)
 			if cfg.logger != nil {
@@ -1241,10 +1514,11 @@ func (t *Tracer) loop() {
 			}
 			sendStreamRequest <- gracePeriod
 			if metadata == nil {
-				metadata = t.jsonRequestMetadata()
+				metadata = t.jsonRequestMetadata(cfg.globalLabels)
 			}
 			zlibWriter.Reset(&requestBuf)
 			zlibWriter.Write(metadata)
+			agentStats.requestUncompressedBytes += uint64(len(metadata))
 			zlibFlushed = false
 			zlibClosed = false
 			requestActive = true
@@ -1255,9 +1529,10 @@ func (t *Tracer) loop() {
 		if !closeRequest || !zlibClosed {
 			for requestBytesRead+requestBuf.Len() < cfg.requestSize {
 				if metricsBuffer.Len() > 0 {
-					if _, _, err := metricsBuffer.WriteBlockTo(zlibWriter); err == nil {
+					if _, n, err := metricsBuffer.WriteBlockTo(zlibWriter); err == nil {
 						requestBufMetricsets++
 						zlibWriter.Write([]byte("\n"))
+						agentStats.requestUncompressedBytes += uint64(n) + 1
 						zlibFlushed = false
 						if sentMetrics != nil {
 							// SendMetrics was called: close the request
@@ -1271,7 +1546,8 @@ func (t *Tracer) loop() {
 				if buffer.Len() == 0 {
 					break
 				}
-				if h, _, err := buffer.WriteBlockTo(zlibWriter); err == nil {
+				if h, n, err := buffer.WriteBlockTo(zlibWriter); err == nil {
+					agentStats.requestUncompressedBytes += uint64(n) + 1
 					switch h.Tag {
 					case transactionBlockTag:
 						requestBufTransactions++
@@ -1292,6 +1568,7 @@ func (t *Tracer) loop() {
 			if !zlibClosed {
 				zlibWriter.Close()
 				zlibClosed = true
+				requestClosed = time.Now()
 			}
 		} else if flushRequest && !zlibFlushed {
 			zlibWriter.Flush()
@@ -1320,23 +1597,23 @@ func (t *Tracer) loop() {
 // jsonRequestMetadata returns a JSON-encoded metadata object that features
 // at the head of every request body. This is called exactly once, when the
 // first request is made.
-func (t *Tracer) jsonRequestMetadata() []byte {
+func (t *Tracer) jsonRequestMetadata(globalLabels model.StringMap) []byte {
 	var json fastjson.Writer
 	json.RawString(`{"metadata":`)
-	t.encodeRequestMetadata(&json)
+	t.encodeRequestMetadata(&json, globalLabels)
 	json.RawString("}\n")
 	return json.Bytes()
 }
 
 // metadataReader returns an io.Reader that holds the JSON-encoded metadata,
 // suitable for including in a profile request.
-func (t *Tracer) metadataReader() io.Reader {
+func (t *Tracer) metadataReader(globalLabels model.StringMap) io.Reader {
 	var metadata fastjson.Writer
-	t.encodeRequestMetadata(&metadata)
+	t.encodeRequestMetadata(&metadata, globalLabels)
 	return bytes.NewReader(metadata.Bytes())
 }
 
-func (t *Tracer) encodeRequestMetadata(json *fastjson.Writer) {
+func (t *Tracer) encodeRequestMetadata(json *fastjson.Writer, globalLabels model.StringMap) {
 	json.RawString(`{"system":`)
 	t.system.MarshalFastJSON(json)
 	json.RawString(`,"process":`)
@@ -1347,9 +1624,9 @@ func (t *Tracer) encodeRequestMetadata(json *fastjson.Writer) {
 		json.RawString(`,"cloud":`)
 		cloud.MarshalFastJSON(json)
 	}
-	if len(t.globalLabels) > 0 {
+	if len(globalLabels) > 0 {
 		json.RawString(`,"labels":`)
-		t.globalLabels.MarshalFastJSON(json)
+		globalLabels.MarshalFastJSON(json)
 	}
 	json.RawByte('}')
 }
@@ -1448,6 +1725,12 @@ type tracerEvent struct {
 		// is created (to signify that the span is ended),
 		// so we pass it along side.
 		*SpanData
+
+		// transactionActive records whether the span's
+		// transaction had not yet ended when the span was
+		// enqueued, in which case the span may be buffered
+		// for tail-based sampling.
+		transactionActive bool
 	}
 }
 
@@ -1459,9 +1742,10 @@ type majorVersionGetter interface {
 	MajorServerVersion(ctx context.Context, refreshStale bool) uint32
 }
 
-func parseGlobalLabels() model.StringMap {
+// parseGlobalLabels parses a comma-separated list of key=value labels.
+func parseGlobalLabels(value string) model.StringMap {
 	var labels model.StringMap
-	for _, kv := range configutil.ParseListEnv(envGlobalLabels, ",", nil) {
+	for _, kv := range configutil.ParseList(value, ",") {
 		i := strings.IndexRune(kv, '=')
 		if i > 0 {
 			k, v := strings.TrimSpace(kv[:i]), strings.TrimSpace(kv[i+1:])
@@ -28,6 +28,9 @@ type TracerStats struct {
 	TransactionsDropped uint64
 	SpansSent           uint64
 	SpansDropped        uint64
+	Spool               TracerStatsSpool
+	TailSampling        TracerStatsTailSampling
+	Processors          TracerStatsProcessors
 }
 
 // TracerStatsErrors holds error statistics for a Tracer.
@@ -35,6 +38,57 @@ type TracerStatsErrors struct {
 	SendStream uint64
 }
 
+// TracerStatsSpool holds disk spool statistics for a Tracer.
+// See ELASTIC_APM_SPOOL_DIR.
+type TracerStatsSpool struct {
+	// SpooledBytes holds the number of bytes written to the spool
+	// after failing to send them.
+	SpooledBytes uint64
+
+	// ReplayedBytes holds the number of bytes read from the spool
+	// and successfully sent.
+	ReplayedBytes uint64
+
+	// DiscardedBytes holds the number of bytes discarded, either
+	// because the spool was full, or because the spooled events
+	// were rejected by the server.
+	DiscardedBytes uint64
+}
+
+// TracerStatsTailSampling holds tail-based sampling statistics for a Tracer.
+// See Tracer.SetTailSampler.
+type TracerStatsTailSampling struct {
+	// TransactionsKept holds the number of transactions whose spans
+	// were kept by the tail sampler.
+	TransactionsKept uint64
+
+	// TransactionsDiscarded holds the number of transactions whose
+	// spans were discarded by the tail sampler.
+	TransactionsDiscarded uint64
+
+	// SpansDiscarded holds the number of spans discarded by the
+	// tail sampler.
+	SpansDiscarded uint64
+
+	// SpansEvicted holds the number of spans which were sent before
+	// a sampling decision was made, due to the buffer size limit.
+	SpansEvicted uint64
+}
+
+// TracerStatsProcessors holds statistics for the processors
+// configured for a Tracer. See Tracer.SetProcessors.
+type TracerStatsProcessors struct {
+	// TransactionsDropped holds the number of transactions
+	// dropped by processors.
+	TransactionsDropped uint64
+
+	// SpansDropped holds the number of spans dropped by processors.
+	SpansDropped uint64
+
+	// ErrorsDropped holds the number of errors dropped by processors.
+	ErrorsDropped uint64
+}
+
 func (s TracerStats) isZero() bool {
 	return s == TracerStats{}
 }
@@ -49,6 +103,16 @@ func (s *TracerStats) accumulate(rhs TracerStats) {
 	atomic.AddUint64(&s.SpansDropped, rhs.SpansDropped)
 	atomic.AddUint64(&s.TransactionsSent, rhs.TransactionsSent)
 	atomic.AddUint64(&s.TransactionsDropped, rhs.TransactionsDropped)
+	atomic.AddUint64(&s.Spool.SpooledBytes, rhs.Spool.SpooledBytes)
+	atomic.AddUint64(&s.Spool.ReplayedBytes, rhs.Spool.ReplayedBytes)
+	atomic.AddUint64(&s.Spool.DiscardedBytes, rhs.Spool.DiscardedBytes)
+	atomic.AddUint64(&s.TailSampling.TransactionsKept, rhs.TailSampling.TransactionsKept)
+	atomic.AddUint64(&s.TailSampling.TransactionsDiscarded, rhs.TailSampling.TransactionsDiscarded)
+	atomic.AddUint64(&s.TailSampling.SpansDiscarded, rhs.TailSampling.SpansDiscarded)
+	atomic.AddUint64(&s.TailSampling.SpansEvicted, rhs.TailSampling.SpansEvicted)
+	atomic.AddUint64(&s.Processors.TransactionsDropped, rhs.Processors.TransactionsDropped)
+	atomic.AddUint64(&s.Processors.SpansDropped, rhs.Processors.SpansDropped)
+	atomic.AddUint64(&s.Processors.ErrorsDropped, rhs.Processors.ErrorsDropped)
 }
 
 // copy returns a copy of the most recent tracer stats.
@@ -63,5 +127,21 @@ func (s *TracerStats) copy() TracerStats {
 		TransactionsDropped: atomic.LoadUint64(&s.TransactionsDropped),
 		SpansSent:           atomic.LoadUint64(&s.SpansSent),
 		SpansDropped:        atomic.LoadUint64(&s.SpansDropped),
+		Spool: TracerStatsSpool{
+			SpooledBytes:   atomic.LoadUint64(&s.Spool.SpooledBytes),
+			ReplayedBytes:  atomic.LoadUint64(&s.Spool.ReplayedBytes),
+			DiscardedBytes: atomic.LoadUint64(&s.Spool.DiscardedBytes),
+		},
+		TailSampling: TracerStatsTailSampling{
+			TransactionsKept:      atomic.LoadUint64(&s.TailSampling.TransactionsKept),
+			TransactionsDiscarded: atomic.LoadUint64(&s.TailSampling.TransactionsDiscarded),
+			SpansDiscarded:        atomic.LoadUint64(&s.TailSampling.SpansDiscarded),
+			SpansEvicted:          atomic.LoadUint64(&s.TailSampling.SpansEvicted),
+		},
+		Processors: TracerStatsProcessors{
+			TransactionsDropped: atomic.LoadUint64(&s.Processors.TransactionsDropped),
+			SpansDropped:        atomic.LoadUint64(&s.Processors.SpansDropped),
+			ErrorsDropped:       atomic.LoadUint64(&s.Processors.ErrorsDropped),
+		},
 	}
 }
@@ -23,6 +23,8 @@ import (
 	"math/rand"
 	"sync"
 	"time"
+
+	"go.elastic.co/apm/v2/internal/wildcard"
 )
 
 const (
@@ -75,8 +77,10 @@ func (t *Tracer) StartTransactionOptions(name, transactionType string, opts Tran
 	tx.stackTraceLimit = instrumentationConfig.stackTraceLimit
 	tx.Context.captureHeaders = instrumentationConfig.captureHeaders
 	tx.propagateLegacyHeader = instrumentationConfig.propagateLegacyHeader
+	tx.obfuscateSQL = instrumentationConfig.obfuscateSQL
 	tx.Context.sanitizedFieldNames = instrumentationConfig.sanitizedFieldNames
-	tx.breakdownMetricsEnabled = t.breakdownMetrics.enabled
+	tx.breakdownMetricsEnabled = instrumentationConfig.breakdownMetrics
+	tx.baggageToAttach = instrumentationConfig.baggageToAttach
 
 	continuationStrategy := instrumentationConfig.continuationStrategy
 	shouldRestartTrace := false
@@ -129,9 +133,15 @@ func (t *Tracer) StartTransactionOptions(name, transactionType string, opts Tran
 
 	if root {
 		var result SampleResult
-		if instrumentationConfig.sampler != nil {
-			result = instrumentationConfig.sampler.Sample(SampleParams{
+		sampler := instrumentationConfig.sampler
+		if ruleSampler := instrumentationConfig.sampleRules.match(name, transactionType); ruleSampler != nil {
+			sampler = ruleSampler
+		}
+		if sampler != nil {
+			result = sampler.Sample(SampleParams{
 				TraceContext: tx.traceContext,
+				Name:         name,
+				Type:         transactionType,
 			})
 			if !result.Sampled {
 				// Special case: for unsampled transactions we
@@ -169,6 +179,13 @@ func (t *Tracer) StartTransactionOptions(name, transactionType string, opts Tran
 		tx.timestamp = time.Now()
 	}
 	tx.links = append(tx.links, opts.Links...)
+
+	// Baggage is propagated regardless of whether the trace
+	// is continued or restarted.
+	if opts.TraceContext.Baggage.Validate() == nil {
+		tx.traceContext.Baggage = opts.TraceContext.Baggage
+		tx.traceContext.Baggage.setLabels(tx.baggageToAttach, tx.Context.SetLabel)
+	}
 	return tx
 }
 
@@ -237,6 +254,21 @@ func (tx *Transaction) ShouldPropagateLegacyHeader() bool {
 	return tx.propagateLegacyHeader
 }
 
+// ShouldObfuscateSQL reports whether database instrumentation should
+// obfuscate the SQL statements recorded for spans of the transaction,
+// using sqlutil.Obfuscate. See ELASTIC_APM_OBFUSCATE_SQL.
+//
+// Spans may still be recorded after the transaction has ended, so in
+// that case the tracer's current configuration is used.
+func (tx *Transaction) ShouldObfuscateSQL() bool {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	if tx.ended() {
+		return tx.tracer.instrumentationConfig().obfuscateSQL
+	}
+	return tx.obfuscateSQL
+}
+
 // EnsureParent returns the span ID for for tx's parent, generating a
 // parent span ID if one has not already been set and tx has not been
 // ended. If tx is nil or has been ended, a zero (invalid) SpanID is
@@ -400,6 +432,8 @@ type TransactionData struct {
 	stackTraceLimit           int
 	breakdownMetricsEnabled   bool
 	propagateLegacyHeader     bool
+	obfuscateSQL              bool
+	baggageToAttach           wildcard.Matchers
 	timestamp                 time.Time
 
 	links             []SpanLink
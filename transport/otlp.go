@@ -0,0 +1,323 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package transport // import "go.elastic.co/apm/v2/transport"
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	otlpTracesPath  = "/v1/traces"
+	otlpMetricsPath = "/v1/metrics"
+	otlpLogsPath    = "/v1/logs"
+
+	envOTLPEndpoint    = "OTEL_EXPORTER_OTLP_ENDPOINT"
+	envOTLPHeaders     = "OTEL_EXPORTER_OTLP_HEADERS"
+	envOTLPTimeout     = "OTEL_EXPORTER_OTLP_TIMEOUT"
+	envOTLPCompression = "OTEL_EXPORTER_OTLP_COMPRESSION"
+)
+
+var (
+	defaultOTLPEndpoint, _ = url.Parse("http://localhost:4318")
+	defaultOTLPTimeout     = 10 * time.Second
+)
+
+// OTLPTransportOptions for the OTLPTransport.
+type OTLPTransportOptions struct {
+	// Endpoint holds the base URL of the OTLP/HTTP receiver, such as an
+	// OpenTelemetry Collector. Traces, metrics, and logs are sent to the
+	// "/v1/traces", "/v1/metrics", and "/v1/logs" paths respectively,
+	// relative to Endpoint.
+	//
+	// If Endpoint is nil, it will be initialized using the
+	// OTEL_EXPORTER_OTLP_ENDPOINT environment variable, defaulting to
+	// "http://localhost:4318" if the environment variable is not set.
+	Endpoint *url.URL
+
+	// Headers holds additional headers to send with each request,
+	// such as for authentication.
+	//
+	// If Headers is nil, it will be initialized using the
+	// OTEL_EXPORTER_OTLP_HEADERS environment variable, which holds
+	// a comma-separated list of key=value pairs.
+	Headers http.Header
+
+	// Timeout holds the timeout for requests made to the OTLP receiver.
+	//
+	// If Timeout is zero, it will be initialized using the
+	// OTEL_EXPORTER_OTLP_TIMEOUT environment variable, which holds
+	// the timeout in milliseconds, defaulting to 10 seconds if the
+	// environment variable is not set. Negative values are not allowed.
+	Timeout time.Duration
+
+	// Compression holds the compression to apply to request bodies,
+	// either "gzip" or "none".
+	//
+	// If Compression is empty, it will be initialized using the
+	// OTEL_EXPORTER_OTLP_COMPRESSION environment variable, defaulting
+	// to "none" if the environment variable is not set.
+	Compression string
+
+	// TLSClientConfig holds client TLS configuration for use in the HTTP client.
+	TLSClientConfig *tls.Config
+
+	// UserAgent holds the value to use for the User-Agent header.
+	//
+	// If unspecified, UserAgent will be set to the value returned by
+	// DefaultUserAgent().
+	UserAgent string
+}
+
+// Validate ensures the OTLPTransportOptions are valid.
+func (opts OTLPTransportOptions) Validate() error {
+	if opts.Timeout < 0 {
+		return errors.New("apm transport options: Timeout must be greater or equal to 0")
+	}
+	switch opts.Compression {
+	case "", "none", "gzip":
+	default:
+		return errors.Errorf("apm transport options: unsupported Compression %q", opts.Compression)
+	}
+	return nil
+}
+
+// OTLPTransport is an implementation of Transport which converts the
+// events in each stream to OpenTelemetry Protocol (OTLP) messages, and
+// sends them to an OTLP/HTTP receiver encoded as protobuf.
+//
+// Transactions and spans are sent as OTLP spans, errors are sent as
+// OTLP log records with exception attributes, and metrics are sent as
+// OTLP gauges, or explicit bucket histograms for histogram metrics.
+// Service, system, process, and cloud metadata are sent as OTLP resource
+// attributes.
+type OTLPTransport struct {
+	// Client exposes the http.Client used by the OTLPTransport for
+	// sending requests to the OTLP receiver.
+	Client *http.Client
+
+	headers    http.Header
+	gzip       bool
+	tracesURL  *url.URL
+	metricsURL *url.URL
+	logsURL    *url.URL
+}
+
+// NewOTLPTransport returns a new OTLPTransport, initialized with opts,
+// which can be used for sending data to an OTLP/HTTP receiver.
+func NewOTLPTransport(opts OTLPTransportOptions) (*OTLPTransport, error) {
+	if opts.Endpoint == nil {
+		endpoint := defaultOTLPEndpoint
+		if value := strings.TrimSpace(os.Getenv(envOTLPEndpoint)); value != "" {
+			u, err := url.Parse(value)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to parse %s", envOTLPEndpoint)
+			}
+			endpoint = u
+		}
+		opts.Endpoint = endpoint
+	}
+	if opts.Headers == nil {
+		headers, err := parseOTLPHeaders(os.Getenv(envOTLPHeaders))
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse %s", envOTLPHeaders)
+		}
+		opts.Headers = headers
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = defaultOTLPTimeout
+		if value := strings.TrimSpace(os.Getenv(envOTLPTimeout)); value != "" {
+			ms, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to parse %s", envOTLPTimeout)
+			}
+			opts.Timeout = time.Duration(ms) * time.Millisecond
+		}
+	}
+	if opts.Compression == "" {
+		opts.Compression = strings.ToLower(strings.TrimSpace(os.Getenv(envOTLPCompression)))
+	}
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	client := &http.Client{
+		Timeout: opts.Timeout,
+		Transport: &http.Transport{
+			Proxy:                 defaultHTTPTransport.Proxy,
+			DialContext:           defaultHTTPTransport.DialContext,
+			MaxIdleConns:          defaultHTTPTransport.MaxIdleConns,
+			IdleConnTimeout:       defaultHTTPTransport.IdleConnTimeout,
+			TLSHandshakeTimeout:   defaultHTTPTransport.TLSHandshakeTimeout,
+			ExpectContinueTimeout: defaultHTTPTransport.ExpectContinueTimeout,
+			TLSClientConfig:       opts.TLSClientConfig,
+		},
+	}
+
+	headers := copyHeaders(opts.Headers)
+	if opts.UserAgent == "" {
+		opts.UserAgent = DefaultUserAgent()
+	}
+	headers.Set("User-Agent", opts.UserAgent)
+	headers.Set("Content-Type", "application/x-protobuf")
+	gzip := opts.Compression == "gzip"
+	if gzip {
+		headers.Set("Content-Encoding", "gzip")
+	}
+
+	return &OTLPTransport{
+		Client:     client,
+		headers:    headers,
+		gzip:       gzip,
+		tracesURL:  urlWithPath(opts.Endpoint, otlpTracesPath),
+		metricsURL: urlWithPath(opts.Endpoint, otlpMetricsPath),
+		logsURL:    urlWithPath(opts.Endpoint, otlpLogsPath),
+	}, nil
+}
+
+// SendStream decodes the events in the stream, converts them to OTLP,
+// and sends them to the OTLP receiver. Up to three requests are made:
+// one each for traces, metrics, and logs, if there are any such events.
+//
+// If any request fails, SendStream continues sending the remaining
+// requests. If all requests fail, the first error is returned; if only
+// some fail, a *PartialSendError is returned, identifying the events
+// that were not sent.
+func (t *OTLPTransport) SendStream(ctx context.Context, r io.Reader) error {
+	batch, err := decodeOTLPBatch(r)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return errors.Wrap(err, "failed to decode event stream")
+	}
+
+	var firstErr error
+	var failed []string
+	var sent bool
+	var buf protoBuffer
+	send := func(u *url.URL, encode func(*protoBuffer), eventTypes ...string) {
+		buf.reset()
+		encode(&buf)
+		if err := t.send(ctx, u, buf.bytes()); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			failed = append(failed, eventTypes...)
+		} else {
+			sent = true
+		}
+	}
+	if len(batch.transactions) > 0 || len(batch.spans) > 0 {
+		send(t.tracesURL, batch.encodeTraces, "transaction", "span")
+	}
+	if len(batch.metrics) > 0 {
+		send(t.metricsURL, batch.encodeMetrics, "metricset")
+	}
+	if len(batch.errors) > 0 {
+		send(t.logsURL, batch.encodeLogs, "error")
+	}
+	if firstErr != nil && sent {
+		return &PartialSendError{EventTypes: failed, Err: firstErr}
+	}
+	return firstErr
+}
+
+// PartialSendError is returned by OTLPTransport.SendStream when some,
+// but not all, of the events in a stream could not be sent.
+type PartialSendError struct {
+	// EventTypes holds the intake event types of the events that
+	// could not be sent: "transaction", "span", "metricset", or
+	// "error". Events of other types were sent successfully.
+	EventTypes []string
+
+	// Err holds the first error that occurred.
+	Err error
+}
+
+func (e *PartialSendError) Error() string {
+	return fmt.Sprintf("failed to send %s events: %s", strings.Join(e.EventTypes, ", "), e.Err)
+}
+
+// Unwrap returns e.Err.
+func (e *PartialSendError) Unwrap() error {
+	return e.Err
+}
+
+func (t *OTLPTransport) send(ctx context.Context, u *url.URL, body []byte) error {
+	if t.gzip {
+		var compressed bytes.Buffer
+		zw := gzip.NewWriter(&compressed)
+		if _, err := zw.Write(body); err != nil {
+			return err
+		}
+		if err := zw.Close(); err != nil {
+			return err
+		}
+		body = compressed.Bytes()
+	}
+	req, err := http.NewRequest("POST", u.String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header = t.headers
+	req = requestWithContext(ctx, req)
+	resp, err := t.Client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "sending OTLP request failed")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	return newHTTPError(resp)
+}
+
+// parseOTLPHeaders parses a comma-separated list of key=value pairs,
+// as defined for OTEL_EXPORTER_OTLP_HEADERS. Values are URL-decoded.
+func parseOTLPHeaders(s string) (http.Header, error) {
+	headers := make(http.Header)
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		i := strings.IndexByte(field, '=')
+		if i <= 0 {
+			return nil, errors.Errorf("invalid header %q, expected key=value", field)
+		}
+		value, err := url.QueryUnescape(strings.TrimSpace(field[i+1:]))
+		if err != nil {
+			return nil, err
+		}
+		headers.Add(strings.TrimSpace(field[:i]), value)
+	}
+	return headers, nil
+}
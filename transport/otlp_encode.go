@@ -0,0 +1,546 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package transport // import "go.elastic.co/apm/v2/transport"
+
+import (
+	"compress/zlib"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"go.elastic.co/apm/v2/internal/apmversion"
+	"go.elastic.co/apm/v2/model"
+)
+
+// OTLP span kinds, as defined by opentelemetry.proto.trace.v1.Span.SpanKind.
+const (
+	otlpSpanKindInternal = 1
+	otlpSpanKindServer   = 2
+	otlpSpanKindClient   = 3
+	otlpSpanKindProducer = 4
+	otlpSpanKindConsumer = 5
+)
+
+const (
+	otlpStatusCodeError = 2
+	otlpSeverityError   = 17
+	otlpScopeName       = "go.elastic.co/apm/v2"
+)
+
+// otlpBatch holds the events decoded from a single intake stream.
+type otlpBatch struct {
+	metadata     otlpMetadata
+	transactions []model.Transaction
+	spans        []model.Span
+	errors       []model.Error
+	metrics      []model.Metrics
+}
+
+// otlpMetadata holds the metadata object at the head of an intake stream.
+type otlpMetadata struct {
+	System  model.System    `json:"system"`
+	Process model.Process   `json:"process"`
+	Service model.Service   `json:"service"`
+	Cloud   *model.Cloud    `json:"cloud"`
+	Labels  model.StringMap `json:"labels"`
+}
+
+// decodeOTLPBatch decodes the zlib-compressed ndjson intake stream
+// produced by the tracer.
+func decodeOTLPBatch(r io.Reader) (*otlpBatch, error) {
+	zr, err := zlib.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	decoder := json.NewDecoder(zr)
+	decoder.UseNumber()
+
+	var batch otlpBatch
+	var metadataPayload struct {
+		Metadata *otlpMetadata `json:"metadata"`
+	}
+	metadataPayload.Metadata = &batch.metadata
+	if err := decoder.Decode(&metadataPayload); err != nil {
+		return nil, errors.Wrap(err, "failed to decode metadata")
+	}
+	for {
+		var payload struct {
+			Error       *model.Error       `json:"error"`
+			Metrics     *model.Metrics     `json:"metricset"`
+			Span        *model.Span        `json:"span"`
+			Transaction *model.Transaction `json:"transaction"`
+		}
+		if err := decoder.Decode(&payload); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		switch {
+		case payload.Error != nil:
+			batch.errors = append(batch.errors, *payload.Error)
+		case payload.Metrics != nil:
+			batch.metrics = append(batch.metrics, *payload.Metrics)
+		case payload.Span != nil:
+			batch.spans = append(batch.spans, *payload.Span)
+		case payload.Transaction != nil:
+			batch.transactions = append(batch.transactions, *payload.Transaction)
+		}
+	}
+	return &batch, nil
+}
+
+// encodeTraces encodes an opentelemetry.proto.collector.trace.v1.ExportTraceServiceRequest.
+func (b *otlpBatch) encodeTraces(buf *protoBuffer) {
+	buf.message(1, func() { // resource_spans
+		buf.message(1, func() { b.encodeResource(buf) })
+		buf.message(2, func() { // scope_spans
+			buf.message(1, func() { encodeOTLPScope(buf) })
+			for i := range b.transactions {
+				buf.message(2, func() { encodeOTLPTransaction(buf, &b.transactions[i]) })
+			}
+			for i := range b.spans {
+				buf.message(2, func() { encodeOTLPSpan(buf, &b.spans[i]) })
+			}
+		})
+	})
+}
+
+// encodeMetrics encodes an opentelemetry.proto.collector.metrics.v1.ExportMetricsServiceRequest.
+func (b *otlpBatch) encodeMetrics(buf *protoBuffer) {
+	buf.message(1, func() { // resource_metrics
+		buf.message(1, func() { b.encodeResource(buf) })
+		buf.message(2, func() { // scope_metrics
+			buf.message(1, func() { encodeOTLPScope(buf) })
+			for i := range b.metrics {
+				encodeOTLPMetrics(buf, &b.metrics[i])
+			}
+		})
+	})
+}
+
+// encodeLogs encodes an opentelemetry.proto.collector.logs.v1.ExportLogsServiceRequest.
+func (b *otlpBatch) encodeLogs(buf *protoBuffer) {
+	buf.message(1, func() { // resource_logs
+		buf.message(1, func() { b.encodeResource(buf) })
+		buf.message(2, func() { // scope_logs
+			buf.message(1, func() { encodeOTLPScope(buf) })
+			for i := range b.errors {
+				buf.message(2, func() { encodeOTLPError(buf, &b.errors[i]) })
+			}
+		})
+	})
+}
+
+// encodeResource encodes the fields of an opentelemetry.proto.resource.v1.Resource,
+// translating the stream metadata to resource attributes.
+func (b *otlpBatch) encodeResource(buf *protoBuffer) {
+	m := &b.metadata
+	attr := func(k string, v interface{}) {
+		if s, ok := v.(string); ok && s == "" {
+			return
+		}
+		buf.keyValue(1, k, v)
+	}
+
+	attr("service.name", m.Service.Name)
+	attr("service.version", m.Service.Version)
+	attr("deployment.environment", m.Service.Environment)
+	if m.Service.Node != nil {
+		attr("service.instance.id", m.Service.Node.ConfiguredName)
+	}
+	attr("telemetry.sdk.name", "ElasticAPM")
+	attr("telemetry.sdk.language", "go")
+	attr("telemetry.sdk.version", apmversion.AgentVersion)
+	if m.Service.Runtime != nil {
+		attr("process.runtime.name", m.Service.Runtime.Name)
+		attr("process.runtime.version", m.Service.Runtime.Version)
+	}
+
+	attr("host.name", m.System.Hostname)
+	attr("host.arch", m.System.Architecture)
+	attr("os.type", m.System.Platform)
+	if m.System.Container != nil {
+		attr("container.id", m.System.Container.ID)
+	}
+	if k8s := m.System.Kubernetes; k8s != nil {
+		attr("k8s.namespace.name", k8s.Namespace)
+		if k8s.Node != nil {
+			attr("k8s.node.name", k8s.Node.Name)
+		}
+		if k8s.Pod != nil {
+			attr("k8s.pod.name", k8s.Pod.Name)
+			attr("k8s.pod.uid", k8s.Pod.UID)
+		}
+	}
+
+	if m.Process.Pid != 0 {
+		attr("process.pid", m.Process.Pid)
+	}
+	if m.Process.Ppid != nil {
+		attr("process.parent_pid", *m.Process.Ppid)
+	}
+	attr("process.executable.name", m.Process.Title)
+
+	if cloud := m.Cloud; cloud != nil {
+		attr("cloud.provider", cloud.Provider)
+		attr("cloud.region", cloud.Region)
+		attr("cloud.availability_zone", cloud.AvailabilityZone)
+		if cloud.Account != nil {
+			attr("cloud.account.id", cloud.Account.ID)
+		}
+		if cloud.Instance != nil {
+			attr("host.id", cloud.Instance.ID)
+		}
+		if cloud.Machine != nil {
+			attr("host.type", cloud.Machine.Type)
+		}
+	}
+
+	for _, label := range m.Labels {
+		attr(label.Key, label.Value)
+	}
+}
+
+// encodeOTLPScope encodes the fields of an
+// opentelemetry.proto.common.v1.InstrumentationScope.
+func encodeOTLPScope(buf *protoBuffer) {
+	buf.string(1, otlpScopeName)
+	buf.string(2, apmversion.AgentVersion)
+}
+
+// encodeOTLPTransaction encodes the fields of an opentelemetry.proto.trace.v1.Span
+// for a transaction.
+func encodeOTLPTransaction(buf *protoBuffer, tx *model.Transaction) {
+	buf.rawBytes(1, tx.TraceID[:])
+	buf.rawBytes(2, tx.ID[:])
+	if tx.ParentID != (model.SpanID{}) {
+		buf.rawBytes(4, tx.ParentID[:])
+	}
+	buf.string(5, tx.Name)
+
+	kind := otlpSpanKindInternal
+	switch tx.Type {
+	case "request":
+		kind = otlpSpanKindServer
+	case "messaging":
+		kind = otlpSpanKindConsumer
+	}
+	if tx.OTel != nil {
+		kind = parseOTLPSpanKind(tx.OTel.SpanKind, kind)
+	}
+	buf.uvarint(6, uint64(kind))
+	encodeOTLPTimes(buf, tx.Timestamp, tx.Duration)
+
+	if tx.OTel != nil {
+		encodeOTLPAttributes(buf, 9, tx.OTel.Attributes)
+	} else if c := tx.Context; c != nil {
+		if c.Request != nil {
+			buf.keyValue(9, "http.request.method", c.Request.Method)
+			if c.Request.URL.Full != "" {
+				buf.keyValue(9, "url.full", c.Request.URL.Full)
+			}
+		}
+		if c.Response != nil && c.Response.StatusCode != 0 {
+			buf.keyValue(9, "http.response.status_code", c.Response.StatusCode)
+		}
+	}
+	if tx.Context != nil {
+		encodeOTLPLabels(buf, 9, tx.Context.Tags)
+	}
+	encodeOTLPLinks(buf, tx.Links)
+	encodeOTLPStatus(buf, tx.Outcome)
+}
+
+// encodeOTLPSpan encodes the fields of an opentelemetry.proto.trace.v1.Span
+// for a span.
+func encodeOTLPSpan(buf *protoBuffer, span *model.Span) {
+	buf.rawBytes(1, span.TraceID[:])
+	buf.rawBytes(2, span.ID[:])
+	buf.rawBytes(4, span.ParentID[:])
+	buf.string(5, span.Name)
+
+	kind := otlpSpanKindInternal
+	switch {
+	case span.Type == "messaging":
+		kind = otlpSpanKindProducer
+	case span.Type == "db" || span.Type == "external" || span.Type == "storage":
+		kind = otlpSpanKindClient
+	case span.Context != nil && (span.Context.Destination != nil || span.Context.Service != nil):
+		kind = otlpSpanKindClient
+	}
+	if span.OTel != nil {
+		kind = parseOTLPSpanKind(span.OTel.SpanKind, kind)
+	}
+	buf.uvarint(6, uint64(kind))
+	encodeOTLPTimes(buf, span.Timestamp, span.Duration)
+
+	if span.OTel != nil {
+		encodeOTLPAttributes(buf, 9, span.OTel.Attributes)
+	} else if c := span.Context; c != nil {
+		attr := func(k string, v interface{}) {
+			if s, ok := v.(string); ok && s == "" {
+				return
+			}
+			buf.keyValue(9, k, v)
+		}
+		if c.Database != nil {
+			attr("db.system", span.Subtype)
+			attr("db.name", c.Database.Instance)
+			attr("db.statement", c.Database.Statement)
+			attr("db.user", c.Database.User)
+		}
+		if c.HTTP != nil {
+			if c.HTTP.URL != nil {
+				attr("url.full", c.HTTP.URL.String())
+			}
+			if c.HTTP.StatusCode != 0 {
+				attr("http.response.status_code", c.HTTP.StatusCode)
+			}
+		}
+		if c.Message != nil {
+			attr("messaging.system", span.Subtype)
+			if c.Message.Queue != nil {
+				attr("messaging.destination.name", c.Message.Queue.Name)
+			}
+		}
+		if c.Destination != nil {
+			attr("server.address", c.Destination.Address)
+			if c.Destination.Port != 0 {
+				attr("server.port", c.Destination.Port)
+			}
+		}
+	}
+	if span.Context != nil {
+		encodeOTLPLabels(buf, 9, span.Context.Tags)
+	}
+	encodeOTLPLinks(buf, span.Links)
+	encodeOTLPStatus(buf, span.Outcome)
+}
+
+func encodeOTLPTimes(buf *protoBuffer, timestamp model.Time, durationMillis float64) {
+	start := time.Time(timestamp)
+	end := start.Add(time.Duration(durationMillis * float64(time.Millisecond)))
+	buf.fixed64(7, uint64(start.UnixNano()))
+	buf.fixed64(8, uint64(end.UnixNano()))
+}
+
+func encodeOTLPLinks(buf *protoBuffer, links []model.SpanLink) {
+	for _, link := range links {
+		buf.message(13, func() {
+			buf.rawBytes(1, link.TraceID[:])
+			buf.rawBytes(2, link.SpanID[:])
+		})
+	}
+}
+
+func encodeOTLPStatus(buf *protoBuffer, outcome string) {
+	if outcome == "failure" {
+		buf.message(15, func() { buf.uvarint(3, otlpStatusCodeError) })
+	}
+}
+
+// encodeOTLPError encodes the fields of an opentelemetry.proto.logs.v1.LogRecord
+// for an error, following the semantic conventions for exceptions.
+func encodeOTLPError(buf *protoBuffer, e *model.Error) {
+	buf.fixed64(1, uint64(time.Time(e.Timestamp).UnixNano()))
+	buf.uvarint(2, otlpSeverityError)
+	buf.string(3, "ERROR")
+
+	message := e.Exception.Message
+	if message == "" {
+		message = e.Log.Message
+	}
+	buf.message(5, func() { buf.anyValue(message) })
+
+	if e.Exception.Message != "" || e.Exception.Type != "" {
+		exceptionType := e.Exception.Type
+		if e.Exception.Module != "" {
+			exceptionType = e.Exception.Module + "." + exceptionType
+		}
+		if exceptionType != "" {
+			buf.keyValue(6, "exception.type", exceptionType)
+		}
+		buf.keyValue(6, "exception.message", e.Exception.Message)
+	}
+	stacktrace := e.Exception.Stacktrace
+	if len(stacktrace) == 0 {
+		stacktrace = e.Log.Stacktrace
+	}
+	if len(stacktrace) > 0 {
+		buf.keyValue(6, "exception.stacktrace", formatOTLPStacktrace(stacktrace))
+	}
+	if e.Log.LoggerName != "" {
+		buf.keyValue(6, "log.logger", e.Log.LoggerName)
+	}
+	if e.Context != nil {
+		encodeOTLPLabels(buf, 6, e.Context.Tags)
+	}
+
+	if e.TraceID != (model.TraceID{}) {
+		buf.rawBytes(9, e.TraceID[:])
+	}
+	if e.ParentID != (model.SpanID{}) {
+		buf.rawBytes(10, e.ParentID[:])
+	}
+}
+
+// encodeOTLPMetrics encodes an opentelemetry.proto.metrics.v1.Metric for
+// each sample in the metricset. The metricset labels, and transaction and
+// span dimensions, are recorded as data point attributes.
+//
+// Samples with a single value are encoded as gauges, and histogram samples
+// are encoded as explicit bucket histograms with delta temporality.
+func encodeOTLPMetrics(buf *protoBuffer, m *model.Metrics) {
+	names := make([]string, 0, len(m.Samples))
+	for name := range m.Samples {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	timestamp := uint64(time.Time(m.Timestamp).UnixNano())
+	attrs := func(field int) {
+		attr := func(k, v string) {
+			if v != "" {
+				buf.keyValue(field, k, v)
+			}
+		}
+		attr("transaction.name", m.Transaction.Name)
+		attr("transaction.type", m.Transaction.Type)
+		attr("span.type", m.Span.Type)
+		attr("span.subtype", m.Span.Subtype)
+		for _, label := range m.Labels {
+			attr(label.Key, label.Value)
+		}
+	}
+	for _, name := range names {
+		sample := m.Samples[name]
+		buf.message(2, func() {
+			buf.string(1, name)
+			if len(sample.Values) > 0 || len(sample.Counts) > 0 {
+				buf.message(9, func() { // histogram
+					buf.message(1, func() { // data_points
+						encodeOTLPHistogramDataPoint(buf, sample, timestamp, attrs)
+					})
+					buf.uvarint(2, 1) // AGGREGATION_TEMPORALITY_DELTA
+				})
+				return
+			}
+			buf.message(5, func() { // gauge
+				buf.message(1, func() { // data_points
+					attrs(7)
+					buf.fixed64(3, timestamp)
+					// as_double is a oneof, so must be encoded even if zero.
+					buf.tag(4, protoFixed64)
+					buf.buf = appendFixed64(buf.buf, math.Float64bits(sample.Value))
+				})
+			})
+		})
+	}
+}
+
+// encodeOTLPHistogramDataPoint encodes the fields of an
+// opentelemetry.proto.metrics.v1.HistogramDataPoint for an Elastic APM
+// histogram. Each of the histogram's values, which are in ascending order,
+// is used as the upper bound of a bucket holding the value's count; the
+// final (overflow) bucket is empty. The sum is approximated from the values.
+func encodeOTLPHistogramDataPoint(buf *protoBuffer, sample model.Metric, timestamp uint64, attrs func(field int)) {
+	n := len(sample.Values)
+	if len(sample.Counts) < n {
+		n = len(sample.Counts)
+	}
+	var count uint64
+	var sum float64
+	bucketCounts := make([]uint64, n+1)
+	for i := 0; i < n; i++ {
+		bucketCounts[i] = sample.Counts[i]
+		count += sample.Counts[i]
+		sum += sample.Values[i] * float64(sample.Counts[i])
+	}
+	buf.fixed64(3, timestamp)
+	buf.fixed64(4, count)
+	// sum is optional, so must be encoded even if zero.
+	buf.tag(5, protoFixed64)
+	buf.buf = appendFixed64(buf.buf, math.Float64bits(sum))
+	buf.packedFixed64(6, bucketCounts)
+	bounds := make([]uint64, n)
+	for i, v := range sample.Values[:n] {
+		bounds[i] = math.Float64bits(v)
+	}
+	buf.packedFixed64(7, bounds)
+	attrs(9)
+}
+
+func encodeOTLPAttributes(buf *protoBuffer, field int, attrs map[string]interface{}) {
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		buf.keyValue(field, k, attrs[k])
+	}
+}
+
+func encodeOTLPLabels(buf *protoBuffer, field int, labels model.IfaceMap) {
+	for _, label := range labels {
+		buf.keyValue(field, label.Key, label.Value)
+	}
+}
+
+// parseOTLPSpanKind parses an OpenTelemetry span kind as recorded in
+// model.OTel, returning def if the span kind is unknown.
+func parseOTLPSpanKind(kind string, def int) int {
+	switch strings.ToUpper(kind) {
+	case "INTERNAL":
+		return otlpSpanKindInternal
+	case "SERVER":
+		return otlpSpanKindServer
+	case "CLIENT":
+		return otlpSpanKindClient
+	case "PRODUCER":
+		return otlpSpanKindProducer
+	case "CONSUMER":
+		return otlpSpanKindConsumer
+	}
+	return def
+}
+
+// formatOTLPStacktrace formats stack frames similar to a Go panic,
+// with each frame's function on one line, and its location on the next.
+func formatOTLPStacktrace(frames []model.StacktraceFrame) string {
+	var sb strings.Builder
+	for _, frame := range frames {
+		function := frame.Function
+		if frame.Module != "" {
+			function = frame.Module + "." + function
+		}
+		file := frame.AbsolutePath
+		if file == "" {
+			file = frame.File
+		}
+		fmt.Fprintf(&sb, "%s()\n\t%s:%d\n", function, file, frame.Line)
+	}
+	return sb.String()
+}
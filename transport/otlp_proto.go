@@ -0,0 +1,216 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package transport // import "go.elastic.co/apm/v2/transport"
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"sort"
+)
+
+// Protocol Buffers wire types.
+const (
+	protoVarint  = 0
+	protoFixed64 = 1
+	protoBytes   = 2
+)
+
+// protoBuffer is a minimal Protocol Buffers encoder, sufficient for
+// encoding OTLP messages without depending on generated code.
+//
+// Fields with zero values are omitted, consistent with proto3.
+type protoBuffer struct {
+	buf []byte
+}
+
+func (b *protoBuffer) reset() {
+	b.buf = b.buf[:0]
+}
+
+func (b *protoBuffer) bytes() []byte {
+	return b.buf
+}
+
+func (b *protoBuffer) tag(field, wireType int) {
+	b.buf = appendUvarint(b.buf, uint64(field)<<3|uint64(wireType))
+}
+
+func (b *protoBuffer) uvarint(field int, v uint64) {
+	if v == 0 {
+		return
+	}
+	b.tag(field, protoVarint)
+	b.buf = appendUvarint(b.buf, v)
+}
+
+func (b *protoBuffer) int64(field int, v int64) {
+	b.uvarint(field, uint64(v))
+}
+
+func (b *protoBuffer) bool(field int, v bool) {
+	if v {
+		b.uvarint(field, 1)
+	}
+}
+
+func (b *protoBuffer) fixed64(field int, v uint64) {
+	if v == 0 {
+		return
+	}
+	b.tag(field, protoFixed64)
+	b.buf = appendFixed64(b.buf, v)
+}
+
+// packedFixed64 encodes a packed repeated fixed64 or double field.
+func (b *protoBuffer) packedFixed64(field int, v []uint64) {
+	if len(v) == 0 {
+		return
+	}
+	b.tag(field, protoBytes)
+	b.buf = appendUvarint(b.buf, uint64(len(v)*8))
+	for _, v := range v {
+		b.buf = appendFixed64(b.buf, v)
+	}
+}
+
+func (b *protoBuffer) double(field int, v float64) {
+	b.fixed64(field, math.Float64bits(v))
+}
+
+func (b *protoBuffer) string(field int, v string) {
+	if v == "" {
+		return
+	}
+	b.tag(field, protoBytes)
+	b.buf = appendUvarint(b.buf, uint64(len(v)))
+	b.buf = append(b.buf, v...)
+}
+
+func (b *protoBuffer) rawBytes(field int, v []byte) {
+	if len(v) == 0 {
+		return
+	}
+	b.tag(field, protoBytes)
+	b.buf = appendUvarint(b.buf, uint64(len(v)))
+	b.buf = append(b.buf, v...)
+}
+
+// message encodes an embedded message in field, calling f to encode
+// the message's fields. Embedded messages are always encoded, even if
+// empty, as their presence may be significant.
+func (b *protoBuffer) message(field int, f func()) {
+	b.tag(field, protoBytes)
+	// Reserve a single byte for the length, which is sufficient
+	// for small messages; move the contents along if not.
+	lenOffset := len(b.buf)
+	b.buf = append(b.buf, 0)
+	f()
+	n := len(b.buf) - lenOffset - 1
+	if n < 0x80 {
+		b.buf[lenOffset] = byte(n)
+		return
+	}
+	var lenBuf [binary.MaxVarintLen64]byte
+	lenSize := binary.PutUvarint(lenBuf[:], uint64(n))
+	b.buf = append(b.buf, lenBuf[1:lenSize]...)
+	copy(b.buf[lenOffset+lenSize:], b.buf[lenOffset+1:lenOffset+1+n])
+	copy(b.buf[lenOffset:], lenBuf[:lenSize])
+}
+
+// keyValue encodes an opentelemetry.proto.common.v1.KeyValue message.
+func (b *protoBuffer) keyValue(field int, key string, value interface{}) {
+	b.message(field, func() {
+		b.string(1, key)
+		b.message(2, func() { b.anyValue(value) })
+	})
+}
+
+// anyValue encodes the fields of an opentelemetry.proto.common.v1.AnyValue
+// message. Values of unknown types are encoded as an empty AnyValue.
+func (b *protoBuffer) anyValue(value interface{}) {
+	// AnyValue is a oneof, so we must encode zero values explicitly.
+	switch value := value.(type) {
+	case string:
+		b.tag(1, protoBytes)
+		b.buf = appendUvarint(b.buf, uint64(len(value)))
+		b.buf = append(b.buf, value...)
+	case bool:
+		b.tag(2, protoVarint)
+		if value {
+			b.buf = append(b.buf, 1)
+		} else {
+			b.buf = append(b.buf, 0)
+		}
+	case int:
+		b.anyInt(int64(value))
+	case int64:
+		b.anyInt(value)
+	case float64:
+		b.anyDouble(value)
+	case json.Number:
+		if i, err := value.Int64(); err == nil {
+			b.anyInt(i)
+		} else if f, err := value.Float64(); err == nil {
+			b.anyDouble(f)
+		} else {
+			b.anyValue(value.String())
+		}
+	case []interface{}:
+		b.message(5, func() {
+			for _, elem := range value {
+				b.message(1, func() { b.anyValue(elem) })
+			}
+		})
+	case map[string]interface{}:
+		b.message(6, func() {
+			keys := make([]string, 0, len(value))
+			for k := range value {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				b.keyValue(1, k, value[k])
+			}
+		})
+	}
+}
+
+func (b *protoBuffer) anyInt(v int64) {
+	b.tag(3, protoVarint)
+	b.buf = appendUvarint(b.buf, uint64(v))
+}
+
+func (b *protoBuffer) anyDouble(v float64) {
+	b.tag(4, protoFixed64)
+	b.buf = appendFixed64(b.buf, math.Float64bits(v))
+}
+
+func appendUvarint(buf []byte, v uint64) []byte {
+	for v >= 0x80 {
+		buf = append(buf, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(buf, byte(v))
+}
+
+func appendFixed64(buf []byte, v uint64) []byte {
+	var tmp [8]byte
+	binary.LittleEndian.PutUint64(tmp[:], v)
+	return append(buf, tmp[:]...)
+}
@@ -0,0 +1,443 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package transport_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.elastic.co/apm/v2"
+	"go.elastic.co/apm/v2/transport"
+)
+
+func TestOTLPTransportTraces(t *testing.T) {
+	collector, tracer := newOTLPTestTracer(t, transport.OTLPTransportOptions{})
+	tx := tracer.StartTransaction("GET /", "request")
+	tx.Context.SetLabel("foo", "bar")
+	span := tx.StartSpan("SELECT FROM foo", "db.postgresql.query", nil)
+	span.Context.SetDatabase(apm.DatabaseSpanContext{
+		Instance:  "test_db",
+		Statement: "SELECT * FROM foo",
+	})
+	span.End()
+	tx.Outcome = "failure"
+	tx.End()
+	tracer.Flush(nil)
+
+	requests := collector.requests(otlpTracesPath)
+	require.Len(t, requests, 1)
+	assert.Equal(t, "application/x-protobuf", requests[0].header.Get("Content-Type"))
+
+	resourceSpans := requests[0].body.message(1)
+	resourceAttrs := resourceSpans.message(1).attributes(1)
+	assert.Equal(t, "otlp_test", resourceAttrs["service.name"])
+	assert.Equal(t, "go", resourceAttrs["telemetry.sdk.language"])
+
+	scopeSpans := resourceSpans.message(2)
+	assert.Equal(t, "go.elastic.co/apm/v2", scopeSpans.message(1).string(1))
+	spans := scopeSpans.messages(2)
+	require.Len(t, spans, 2)
+
+	otlpTx, otlpSpan := spans[0], spans[1]
+	txTraceContext := tx.TraceContext()
+	assert.Equal(t, txTraceContext.Trace[:], otlpTx.bytes(1))
+	assert.Equal(t, txTraceContext.Span[:], otlpTx.bytes(2))
+	assert.Nil(t, otlpTx.bytes(4))
+	assert.Equal(t, "GET /", otlpTx.string(5))
+	assert.Equal(t, uint64(2), otlpTx.uint(6)) // SERVER
+	assert.True(t, otlpTx.uint(8) >= otlpTx.uint(7))
+	assert.Equal(t, map[string]interface{}{"foo": "bar"}, otlpTx.attributes(9))
+	assert.Equal(t, uint64(2), otlpTx.message(15).uint(3)) // STATUS_CODE_ERROR
+
+	assert.Equal(t, txTraceContext.Trace[:], otlpSpan.bytes(1))
+	assert.Equal(t, txTraceContext.Span[:], otlpSpan.bytes(4))
+	assert.Equal(t, "SELECT FROM foo", otlpSpan.string(5))
+	assert.Equal(t, uint64(3), otlpSpan.uint(6)) // CLIENT
+	assert.Equal(t, map[string]interface{}{
+		"db.system":    "postgresql",
+		"db.name":      "test_db",
+		"db.statement": "SELECT * FROM foo",
+	}, otlpSpan.attributes(9))
+	assert.Nil(t, otlpSpan.message(15))
+
+	assert.Empty(t, collector.requests(otlpLogsPath))
+}
+
+func TestOTLPTransportErrors(t *testing.T) {
+	collector, tracer := newOTLPTestTracer(t, transport.OTLPTransportOptions{})
+	tx := tracer.StartTransaction("name", "type")
+	e := tracer.NewError(errors.New("boom"))
+	e.SetTransaction(tx)
+	e.Send()
+	tx.End()
+	tracer.Flush(nil)
+
+	requests := collector.requests(otlpLogsPath)
+	require.Len(t, requests, 1)
+	logRecords := requests[0].body.message(1).message(2).messages(2)
+	require.Len(t, logRecords, 1)
+
+	logRecord := logRecords[0]
+	assert.Equal(t, uint64(17), logRecord.uint(2))
+	assert.Equal(t, "ERROR", logRecord.string(3))
+	assert.Equal(t, "boom", logRecord.message(5).string(1))
+	attrs := logRecord.attributes(6)
+	assert.Equal(t, "boom", attrs["exception.message"])
+	assert.Equal(t, "errors.errorString", attrs["exception.type"])
+	assert.Contains(t, attrs["exception.stacktrace"], "TestOTLPTransportErrors")
+
+	txTraceContext := tx.TraceContext()
+	assert.Equal(t, txTraceContext.Trace[:], logRecord.bytes(9))
+	assert.Equal(t, txTraceContext.Span[:], logRecord.bytes(10))
+}
+
+func TestOTLPTransportMetrics(t *testing.T) {
+	collector, tracer := newOTLPTestTracer(t, transport.OTLPTransportOptions{})
+	tracer.RegisterMetricsGatherer(apm.GatherMetricsFunc(func(ctx context.Context, m *apm.Metrics) error {
+		m.Add("custom.gauge", []apm.MetricLabel{{Name: "k", Value: "v"}}, 123.5)
+		return nil
+	}))
+	tracer.SendMetrics(nil)
+	tracer.Flush(nil)
+
+	requests := collector.requests(otlpMetricsPath)
+	require.NotEmpty(t, requests)
+	var found bool
+	for _, req := range requests {
+		for _, metric := range req.body.message(1).message(2).messages(2) {
+			if metric.string(1) != "custom.gauge" {
+				continue
+			}
+			found = true
+			dataPoint := metric.message(5).message(1)
+			assert.Equal(t, map[string]interface{}{"k": "v"}, dataPoint.attributes(7))
+			assert.NotZero(t, dataPoint.uint(3))
+			assert.Equal(t, 123.5, math.Float64frombits(dataPoint.uint(4)))
+		}
+	}
+	assert.True(t, found, "custom.gauge not found")
+}
+
+func TestOTLPTransportHistogramMetrics(t *testing.T) {
+	collector, tracer := newOTLPTestTracer(t, transport.OTLPTransportOptions{})
+	tracer.RegisterMetricsGatherer(apm.GatherMetricsFunc(func(ctx context.Context, m *apm.Metrics) error {
+		m.AddHistogram("custom.histogram", nil, []float64{1.5, 10, 100}, []uint64{2, 0, 1})
+		return nil
+	}))
+	tracer.SendMetrics(nil)
+	tracer.Flush(nil)
+
+	fixed64s := func(data []byte) []uint64 {
+		out := make([]uint64, len(data)/8)
+		for i := range out {
+			out[i] = binary.LittleEndian.Uint64(data[i*8:])
+		}
+		return out
+	}
+
+	var found bool
+	for _, req := range collector.requests(otlpMetricsPath) {
+		for _, metric := range req.body.message(1).message(2).messages(2) {
+			if metric.string(1) != "custom.histogram" {
+				continue
+			}
+			found = true
+			histogram := metric.message(9)
+			assert.Equal(t, uint64(1), histogram.uint(2)) // AGGREGATION_TEMPORALITY_DELTA
+			dataPoint := histogram.message(1)
+			assert.NotZero(t, dataPoint.uint(3))
+			assert.Equal(t, uint64(3), dataPoint.uint(4))
+			assert.Equal(t, 103.0, math.Float64frombits(dataPoint.uint(5)))
+			assert.Equal(t, []uint64{2, 0, 1, 0}, fixed64s(dataPoint.bytes(6)))
+			var bounds []float64
+			for _, v := range fixed64s(dataPoint.bytes(7)) {
+				bounds = append(bounds, math.Float64frombits(v))
+			}
+			assert.Equal(t, []float64{1.5, 10, 100}, bounds)
+		}
+	}
+	assert.True(t, found, "custom.histogram not found")
+}
+
+func TestOTLPTransportPartialError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == otlpMetricsPath {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+	serverURL, _ := url.Parse(server.URL)
+
+	otlpTransport, err := transport.NewOTLPTransport(transport.OTLPTransportOptions{Endpoint: serverURL})
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	zw.Write([]byte(`{"metadata":{"service":{"name":"otlp_test"}}}
+{"transaction":{"name":"name","type":"type","timestamp":1}}
+{"metricset":{"timestamp":1,"samples":{"gauge":{"value":1}}}}
+`))
+	zw.Close()
+
+	// The traces request succeeds, so only the metricset
+	// events are reported as not having been sent.
+	err = otlpTransport.SendStream(context.Background(), &buf)
+	var partialErr *transport.PartialSendError
+	require.True(t, errors.As(err, &partialErr), "%v", err)
+	assert.Equal(t, []string{"metricset"}, partialErr.EventTypes)
+	assert.EqualError(t, err, "failed to send metricset events: request failed with 503 Service Unavailable: unavailable")
+}
+
+func TestOTLPTransportHeaders(t *testing.T) {
+	os.Setenv("OTEL_EXPORTER_OTLP_HEADERS", "Authorization=Bearer%20abc, x-foo = bar")
+	defer os.Unsetenv("OTEL_EXPORTER_OTLP_HEADERS")
+	os.Setenv("OTEL_EXPORTER_OTLP_COMPRESSION", "gzip")
+	defer os.Unsetenv("OTEL_EXPORTER_OTLP_COMPRESSION")
+
+	collector, tracer := newOTLPTestTracer(t, transport.OTLPTransportOptions{UserAgent: "otlp-agent"})
+	tracer.StartTransaction("name", "type").End()
+	tracer.Flush(nil)
+
+	requests := collector.requests(otlpTracesPath)
+	require.Len(t, requests, 1)
+	assert.Equal(t, "Bearer abc", requests[0].header.Get("Authorization"))
+	assert.Equal(t, "bar", requests[0].header.Get("X-Foo"))
+	assert.Equal(t, "otlp-agent", requests[0].header.Get("User-Agent"))
+	assert.Equal(t, "gzip", requests[0].header.Get("Content-Encoding"))
+	assert.Equal(t, "name", requests[0].body.message(1).message(2).message(2).string(5))
+}
+
+func TestOTLPTransportHTTPError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	serverURL, _ := url.Parse(server.URL)
+
+	otlpTransport, err := transport.NewOTLPTransport(transport.OTLPTransportOptions{Endpoint: serverURL})
+	require.NoError(t, err)
+	tracer, err := apm.NewTracerOptions(apm.TracerOptions{Transport: otlpTransport})
+	require.NoError(t, err)
+	defer tracer.Close()
+
+	tracer.StartTransaction("name", "type").End()
+	tracer.Flush(nil)
+	assert.Equal(t, uint64(1), tracer.Stats().Errors.SendStream)
+}
+
+func TestNewOTLPTransportOptionsValidation(t *testing.T) {
+	_, err := transport.NewOTLPTransport(transport.OTLPTransportOptions{Timeout: -1})
+	assert.EqualError(t, err, "apm transport options: Timeout must be greater or equal to 0")
+
+	_, err = transport.NewOTLPTransport(transport.OTLPTransportOptions{Compression: "zstd"})
+	assert.EqualError(t, err, `apm transport options: unsupported Compression "zstd"`)
+
+	os.Setenv("OTEL_EXPORTER_OTLP_HEADERS", "invalid")
+	defer os.Unsetenv("OTEL_EXPORTER_OTLP_HEADERS")
+	_, err = transport.NewOTLPTransport(transport.OTLPTransportOptions{})
+	assert.EqualError(t, err, `failed to parse OTEL_EXPORTER_OTLP_HEADERS: invalid header "invalid", expected key=value`)
+}
+
+const (
+	otlpTracesPath  = "/v1/traces"
+	otlpMetricsPath = "/v1/metrics"
+	otlpLogsPath    = "/v1/logs"
+)
+
+func newOTLPTestTracer(t *testing.T, opts transport.OTLPTransportOptions) (*otlpCollector, *apm.Tracer) {
+	collector := &otlpCollector{t: t}
+	server := httptest.NewServer(collector)
+	t.Cleanup(server.Close)
+
+	opts.Endpoint, _ = url.Parse(server.URL)
+	otlpTransport, err := transport.NewOTLPTransport(opts)
+	require.NoError(t, err)
+	tracer, err := apm.NewTracerOptions(apm.TracerOptions{
+		ServiceName: "otlp_test",
+		Transport:   otlpTransport,
+	})
+	require.NoError(t, err)
+	t.Cleanup(tracer.Close)
+	return collector, tracer
+}
+
+// otlpCollector is an http.Handler standing in for an OTLP/HTTP
+// receiver, recording the protobuf-encoded requests it receives.
+type otlpCollector struct {
+	t        *testing.T
+	mu       sync.Mutex
+	received map[string][]otlpRequest
+}
+
+type otlpRequest struct {
+	header http.Header
+	body   protoMessage
+}
+
+func (c *otlpCollector) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body := req.Body
+	if req.Header.Get("Content-Encoding") == "gzip" {
+		zr, err := gzip.NewReader(req.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		body = zr
+	}
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	msg, err := decodeProtoMessage(data)
+	if err != nil {
+		c.t.Errorf("failed to decode %s request: %v", req.URL.Path, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.received == nil {
+		c.received = make(map[string][]otlpRequest)
+	}
+	c.received[req.URL.Path] = append(c.received[req.URL.Path], otlpRequest{
+		header: req.Header,
+		body:   msg,
+	})
+}
+
+func (c *otlpCollector) requests(path string) []otlpRequest {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.received[path]
+}
+
+// protoMessage holds the fields of a decoded protobuf message, keyed by
+// field number. Varint and fixed64 values are held as uint64, and
+// length-delimited values as []byte.
+type protoMessage map[int][]interface{}
+
+func decodeProtoMessage(data []byte) (protoMessage, error) {
+	msg := make(protoMessage)
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			return nil, errors.New("invalid field key")
+		}
+		data = data[n:]
+		field := int(key >> 3)
+		switch key & 7 {
+		case 0:
+			v, n := binary.Uvarint(data)
+			if n <= 0 {
+				return nil, errors.New("invalid varint")
+			}
+			data = data[n:]
+			msg[field] = append(msg[field], v)
+		case 1:
+			if len(data) < 8 {
+				return nil, errors.New("invalid fixed64")
+			}
+			msg[field] = append(msg[field], binary.LittleEndian.Uint64(data))
+			data = data[8:]
+		case 2:
+			size, n := binary.Uvarint(data)
+			if n <= 0 || uint64(len(data)-n) < size {
+				return nil, errors.New("invalid length-delimited field")
+			}
+			msg[field] = append(msg[field], data[n:n+int(size)])
+			data = data[n+int(size):]
+		default:
+			return nil, errors.New("unsupported wire type")
+		}
+	}
+	return msg, nil
+}
+
+func (m protoMessage) messages(field int) []protoMessage {
+	var out []protoMessage
+	for _, v := range m[field] {
+		msg, err := decodeProtoMessage(v.([]byte))
+		if err != nil {
+			panic(err)
+		}
+		out = append(out, msg)
+	}
+	return out
+}
+
+func (m protoMessage) message(field int) protoMessage {
+	if msgs := m.messages(field); len(msgs) > 0 {
+		return msgs[0]
+	}
+	return nil
+}
+
+func (m protoMessage) bytes(field int) []byte {
+	if v := m[field]; len(v) > 0 {
+		return v[0].([]byte)
+	}
+	return nil
+}
+
+func (m protoMessage) string(field int) string {
+	return string(m.bytes(field))
+}
+
+func (m protoMessage) uint(field int) uint64 {
+	if v := m[field]; len(v) > 0 {
+		return v[0].(uint64)
+	}
+	return 0
+}
+
+// attributes decodes the KeyValue messages in the given field.
+func (m protoMessage) attributes(field int) map[string]interface{} {
+	out := make(map[string]interface{})
+	for _, kv := range m.messages(field) {
+		value := kv.message(2)
+		switch {
+		case value[1] != nil:
+			out[kv.string(1)] = value.string(1)
+		case value[2] != nil:
+			out[kv.string(1)] = value.uint(2) != 0
+		case value[3] != nil:
+			out[kv.string(1)] = int64(value.uint(3))
+		case value[4] != nil:
+			out[kv.string(1)] = math.Float64frombits(value.uint(4))
+		default:
+			out[kv.string(1)] = nil
+		}
+	}
+	return out
+}
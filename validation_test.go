@@ -29,6 +29,7 @@ import (
 	"net/url"
 	"strings"
 	"testing"
+	"time"
 	"unicode/utf8"
 
 	"github.com/pkg/errors"
@@ -117,6 +118,15 @@ func TestValidateDatabaseSpanContext(t *testing.T) {
 	})
 }
 
+func TestValidateMessageSpanContext(t *testing.T) {
+	validateSpan(t, func(s *apm.Span) {
+		s.Context.SetMessage(apm.MessageSpanContext{
+			QueueName:  strings.Repeat("x", 1025),
+			RoutingKey: strings.Repeat("x", 1025),
+		})
+	})
+}
+
 func TestValidateDestinationSpanContext(t *testing.T) {
 	overlong := strings.Repeat("x", 1025)
 	for _, name := range []string{"", overlong} {
@@ -140,6 +150,30 @@ func TestValidateContextUser(t *testing.T) {
 	})
 }
 
+func TestValidateContextMessage(t *testing.T) {
+	validateTransaction(t, func(tx *apm.Transaction) {
+		tx.Context.SetMessage(apm.MessageContext{
+			QueueName:  strings.Repeat("x", 1025),
+			RoutingKey: "key",
+			Age:        time.Second,
+		})
+	})
+}
+
+func TestValidateContextFAAS(t *testing.T) {
+	validateTransaction(t, func(tx *apm.Transaction) {
+		tx.Context.SetFAAS(apm.FAASContext{
+			ID:               strings.Repeat("x", 1025),
+			Name:             "fn",
+			Version:          "$LATEST",
+			Execution:        "execution",
+			Coldstart:        true,
+			TriggerType:      "http",
+			TriggerRequestID: "request",
+		})
+	})
+}
+
 func TestValidateContextUserBasicAuth(t *testing.T) {
 	validateTransaction(t, func(tx *apm.Transaction) {
 		req, err := http.NewRequest("GET", "/", nil)